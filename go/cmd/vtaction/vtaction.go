@@ -26,6 +26,7 @@ var (
 	actionNode = flag.String("action-node", "", "path to zk node representing the action")
 	actionGuid = flag.String("action-guid", "", "a label to help track processes")
 	force      = flag.Bool("force", false, "force an action to rerun")
+	dryRun     = flag.Bool("dry-run", false, "replay the action at action-node (which can be in the actionlog) without side effects, and print what happened")
 
 	mycnfFile = flag.String("mycnf-file", "/etc/my.cnf", "path to my.cnf")
 )
@@ -72,7 +73,18 @@ func main() {
 		}
 	}()
 
+	if *dryRun {
+		report, err := actor.DryRunAction(*actionNode, *actionGuid)
+		fmt.Print(report)
+		if err != nil {
+			log.Fatalf("dry run error: %v", err)
+		}
+		log.Infof("finished vtaction dry run %v", os.Args)
+		return
+	}
+
 	actionErr := actor.HandleAction(*actionNode, *action, *actionGuid, *force)
+	tabletmanager.WaitForActionCallbacks()
 	if actionErr != nil {
 		log.Fatalf("action error: %v", actionErr)
 	}
@@ -176,6 +176,9 @@ var commands = []commandGroup{
 			command{"SetKeyspaceShardingInfo", commandSetKeyspaceShardingInfo,
 				"[-force] <keyspace name|zk keyspace path> [<column name>] [<column type>]",
 				"Updates the sharding info for a keyspace"},
+			command{"SetKeyspaceMigratingTables", commandSetKeyspaceMigratingTables,
+				"[-ttl=<duration>] <keyspace name|zk keyspace path> [<table1>,<table2>,...]",
+				"Makes vtgate reject the queries on the given tables with a retryable error while they go through a schema change, for up to ttl if set. Without tables, clears the list. Will also rebuild the serving graph."},
 			command{"RebuildKeyspaceGraph", commandRebuildKeyspaceGraph,
 				"[-cells=a,b] <zk keyspace path> ... (/zk/global/vt/keyspaces/<keyspace>)",
 				"Rebuild the serving data for all shards in this keyspace. This may trigger an update to all connected clients."},
@@ -195,6 +198,9 @@ var commands = []commandGroup{
 			command{"WaitForAction", commandWaitForAction,
 				"<zk action path> (/zk/global/vt/keyspaces/<keyspace>/shards/<shard>/action/<action id>)",
 				"Watch an action node, printing updates, until the action is complete."},
+			command{"CancelAction", commandCancelAction,
+				"<tablet alias|zk tablet path> <action guid>",
+				"Stops the vtaction running the action with the given guid on the tablet. The action is marked failed."},
 			command{"Resolve", commandResolve,
 				"<keyspace>.<shard>.<db type>:<port name>",
 				"Read a list of addresses that can answer this query. The port name is usually _mysql or _vtocc."},
@@ -236,8 +242,8 @@ var commands = []commandGroup{
 				"[-force] {-sql=<sql> || -sql-file=<filename>} [-simple] [-new-parent=<zk tablet path>] <keyspace/shard|zk shard path>",
 				"Apply the schema change to the specified shard. If simple is specified, we just apply on the live master. Otherwise we will need to do the shell game. So we will apply the schema change to every single slave. if new_parent is set, we will also reparent (otherwise the master won't be touched at all). Using the force flag will cause a bunch of checks to be ignored, use with care."},
 			command{"ApplySchemaKeyspace", commandApplySchemaKeyspace,
-				"[-force] {-sql=<sql> || -sql-file=<filename>} [-simple] <keyspace|zk keyspace path>",
-				"Apply the schema change to the specified keyspace. If simple is specified, we just apply on the live masters. Otherwise we will need to do the shell game on each shard. So we will apply the schema change to every single slave (running in parallel on all shards, but on one host at a time in a given shard). We will not reparent at the end, so the masters won't be touched at all. Using the force flag will cause a bunch of checks to be ignored, use with care."},
+				"[-force] {-sql=<sql> || -sql-file=<filename>} [-simple] [-block_tables_ttl=<duration>] <keyspace|zk keyspace path>",
+				"Apply the schema change to the specified keyspace. If simple is specified, we just apply on the live masters. Otherwise we will need to do the shell game on each shard. So we will apply the schema change to every single slave (running in parallel on all shards, but on one host at a time in a given shard). We will not reparent at the end, so the masters won't be touched at all. If block_tables_ttl is set, vtgate rejects the queries on the changed tables with a retryable error during the change, for up to that long (see SetKeyspaceMigratingTables). Using the force flag will cause a bunch of checks to be ignored, use with care."},
 
 			command{"ValidateVersionShard", commandValidateVersionShard,
 				"<keyspace/shard|zk shard path>",
@@ -1273,6 +1279,21 @@ func commandSetKeyspaceShardingInfo(wr *wrangler.Wrangler, subFlags *flag.FlagSe
 	return "", wr.SetKeyspaceShardingInfo(keyspace, columnName, kit, *force)
 }
 
+func commandSetKeyspaceMigratingTables(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	ttl := subFlags.Duration("ttl", 0, "how long vtgate rejects the queries on the tables, 0 until they are cleared")
+	subFlags.Parse(args)
+	if subFlags.NArg() > 2 || subFlags.NArg() < 1 {
+		log.Fatalf("action SetKeyspaceMigratingTables requires <keyspace name|zk keyspace path> [<table1>,<table2>,...]")
+	}
+
+	keyspace := keyspaceParamToKeyspace(subFlags.Arg(0))
+	var tables []string
+	if subFlags.NArg() == 2 {
+		tables = strings.Split(subFlags.Arg(1), ",")
+	}
+	return "", wr.SetKeyspaceMigratingTables(keyspace, tables, *ttl)
+}
+
 func commandRebuildKeyspaceGraph(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
 	cells := subFlags.String("cells", "", "comma separated list of cells to update")
 	subFlags.Parse(args)
@@ -1337,6 +1358,15 @@ func commandWaitForAction(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []
 	return subFlags.Arg(0), nil
 }
 
+func commandCancelAction(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
+	subFlags.Parse(args)
+	if subFlags.NArg() != 2 {
+		log.Fatalf("action CancelAction requires <tablet alias|zk tablet path> <action guid>")
+	}
+	tabletAlias := tabletParamToTabletAlias(subFlags.Arg(0))
+	return "", wr.TopoServer().CancelTabletAction(tabletAlias, subFlags.Arg(1))
+}
+
 func commandResolve(wr *wrangler.Wrangler, subFlags *flag.FlagSet, args []string) (string, error) {
 	subFlags.Parse(args)
 	if subFlags.NArg() != 1 {
@@ -1562,6 +1592,7 @@ func commandApplySchemaKeyspace(wr *wrangler.Wrangler, subFlags *flag.FlagSet, a
 	sql := subFlags.String("sql", "", "sql command")
 	sqlFile := subFlags.String("sql-file", "", "file containing the sql commands")
 	simple := subFlags.Bool("simple", false, "just apply change on master and let replication do the rest")
+	blockTablesTTL := subFlags.Duration("block_tables_ttl", 0, "if set, vtgate rejects the queries on the changed tables during the change, for up to that long")
 	subFlags.Parse(args)
 	if subFlags.NArg() != 1 {
 		log.Fatalf("action ApplySchemaKeyspace requires <keyspace|zk keyspace path>")
@@ -1569,7 +1600,7 @@ func commandApplySchemaKeyspace(wr *wrangler.Wrangler, subFlags *flag.FlagSet, a
 
 	keyspace := keyspaceParamToKeyspace(subFlags.Arg(0))
 	change := getFileParam(*sql, *sqlFile, "sql")
-	scr, err := wr.ApplySchemaKeyspace(keyspace, change, *simple, *force)
+	scr, err := wr.ApplySchemaKeyspace(keyspace, change, *simple, *force, *blockTablesTTL)
 	if err == nil {
 		log.Infof(scr.String())
 	}
@@ -15,4 +15,7 @@ import (
 func init() {
 	zkoccconn := zk.NewMetaConn(true)
 	topo.RegisterServer("zkocc", zktopo.NewServer(zkoccconn))
+	topoReloaders["zkocc"] = func() topo.Server {
+		return zktopo.NewServer(zk.NewMetaConn(true))
+	}
 }
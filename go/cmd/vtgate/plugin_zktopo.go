@@ -7,5 +7,13 @@ package main
 // Imports and register the Zookeeper TopologyServer
 
 import (
-	_ "github.com/youtube/vitess/go/vt/zktopo"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/zktopo"
+	"github.com/youtube/vitess/go/zk"
 )
+
+func init() {
+	topoReloaders["zookeeper"] = func() topo.Server {
+		return zktopo.NewServer(zk.NewMetaConn(false))
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate"
+)
+
+// topoReloaders create a new topo.Server, with new connections, for
+// the topo.Server implementation they're registered under. Plugins
+// register them for the implementations that can be reloaded.
+var topoReloaders = make(map[string]func() topo.Server)
+
+var (
+	// reloadMu serializes the reloads, and protects the variables below.
+	reloadMu   sync.Mutex
+	currentTs  topo.Server
+	currentRts *vtgate.ResilientSrvTopoServer
+)
+
+// reloadTopo replaces the topo.Server used by vtgate and the topo reader
+// with a fresh one. The old one is closed once the queries and topo
+// reader requests using it are done, unless it's the one of
+// topo.GetServer: topo.CloseServers closes that one, and it may be used
+// elsewhere.
+func reloadTopo() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	var reloader func() topo.Server
+	for name, f := range topoReloaders {
+		if topo.GetServerByName(name) == topo.GetServer() {
+			reloader = f
+		}
+	}
+	if reloader == nil {
+		return fmt.Errorf("the topo.Server implementation doesn't support reloading")
+	}
+
+	oldTs := currentTs
+	currentTs = reloader()
+	currentRts = currentRts.Renew(currentTs)
+	readers := topoReader.setServer(currentRts)
+	vtgate.RpcVTGate.ReloadTopo(currentRts, func() {
+		readers.Wait()
+		closeReloadedTopo(oldTs)
+	})
+	return nil
+}
+
+// closeReloadedTopo closes ts if a reload created it.
+func closeReloadedTopo(ts topo.Server) {
+	if ts != topo.GetServer() {
+		ts.Close()
+	}
+}
+
+// closeCurrentTopo closes the topo.Server created by the last reload, if any.
+func closeCurrentTopo() {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	closeReloadedTopo(currentTs)
+}
+
+func init() {
+	stats.Publish("ResilientSrvTopoServerEndPointsCacheAgeMs", stats.CountersFunc(func() map[string]int64 {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		if currentRts == nil {
+			return nil
+		}
+		return currentRts.EndPointsCacheAges()
+	}))
+	http.HandleFunc("/debug/reload_topo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "reload_topo requires a POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := reloadTopo(); err != nil {
+			log.Errorf("reload_topo failed: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "reloaded")
+	})
+}
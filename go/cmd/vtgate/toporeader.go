@@ -1,6 +1,8 @@
 package main
 
 import (
+	"sync"
+
 	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/vt/topo"
@@ -8,8 +10,11 @@ import (
 )
 
 type TopoReader struct {
-	// the server to get data from
-	ts vtgate.SrvTopoServer
+	// the server to get data from, replaced by setServer, and
+	// the requests using it
+	mu       sync.Mutex
+	ts       vtgate.SrvTopoServer
+	inFlight *sync.WaitGroup
 
 	// stats
 	queryCount *stats.Counters
@@ -19,15 +24,38 @@ type TopoReader struct {
 func NewTopoReader(ts vtgate.SrvTopoServer) *TopoReader {
 	return &TopoReader{
 		ts:         ts,
+		inFlight:   new(sync.WaitGroup),
 		queryCount: stats.NewCounters("TopoReaderRpcQueryCount"),
 		errorCount: stats.NewCounters("TopoReaderRpcErrorCount"),
 	}
 }
 
+// server returns the server to use, which setServer won't let
+// be closed until the caller calls done.
+func (tr *TopoReader) server() (ts vtgate.SrvTopoServer, done func()) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.inFlight.Add(1)
+	return tr.ts, tr.inFlight.Done
+}
+
+// setServer replaces the server, and returns the requests still
+// using the old one.
+func (tr *TopoReader) setServer(ts vtgate.SrvTopoServer) *sync.WaitGroup {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	old := tr.inFlight
+	tr.ts = ts
+	tr.inFlight = new(sync.WaitGroup)
+	return old
+}
+
 func (tr *TopoReader) GetSrvKeyspaceNames(req topo.GetSrvKeyspaceNamesArgs, reply *topo.SrvKeyspaceNames) error {
 	tr.queryCount.Add(req.Cell, 1)
 	var err error
-	reply.Entries, err = tr.ts.GetSrvKeyspaceNames(req.Cell)
+	ts, done := tr.server()
+	defer done()
+	reply.Entries, err = ts.GetSrvKeyspaceNames(req.Cell)
 	if err != nil {
 		log.Warningf("GetSrvKeyspaceNames(%v) failed: %v", req.Cell, err)
 		tr.errorCount.Add(req.Cell, 1)
@@ -38,7 +66,9 @@ func (tr *TopoReader) GetSrvKeyspaceNames(req topo.GetSrvKeyspaceNamesArgs, repl
 
 func (tr *TopoReader) GetSrvKeyspace(req topo.GetSrvKeyspaceArgs, reply *topo.SrvKeyspace) (err error) {
 	tr.queryCount.Add(req.Cell, 1)
-	keyspace, err := tr.ts.GetSrvKeyspace(req.Cell, req.Keyspace)
+	ts, done := tr.server()
+	defer done()
+	keyspace, err := ts.GetSrvKeyspace(req.Cell, req.Keyspace)
 	if err != nil {
 		log.Warningf("GetSrvKeyspace(%v,%v) failed: %v", req.Cell, req.Keyspace, err)
 		tr.errorCount.Add(req.Cell, 1)
@@ -50,7 +80,9 @@ func (tr *TopoReader) GetSrvKeyspace(req topo.GetSrvKeyspaceArgs, reply *topo.Sr
 
 func (tr *TopoReader) GetEndPoints(req topo.GetEndPointsArgs, reply *topo.EndPoints) (err error) {
 	tr.queryCount.Add(req.Cell, 1)
-	addrs, err := tr.ts.GetEndPoints(req.Cell, req.Keyspace, req.Shard, req.TabletType)
+	ts, done := tr.server()
+	defer done()
+	addrs, err := ts.GetEndPoints(req.Cell, req.Keyspace, req.Shard, req.TabletType)
 	if err != nil {
 		log.Warningf("GetEndPoints(%v,%v,%v,%v) failed: %v", req.Cell, req.Keyspace, req.Shard, req.TabletType, err)
 		tr.errorCount.Add(req.Cell, 1)
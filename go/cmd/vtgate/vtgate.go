@@ -6,6 +6,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"time"
 
 	"github.com/youtube/vitess/go/vt/servenv"
@@ -19,6 +20,8 @@ var (
 	retryDelay = flag.Duration("retry-delay", 200*time.Millisecond, "retry delay")
 	retryCount = flag.Int("retry-count", 10, "retry count")
 	timeout    = flag.Duration("timeout", 5*time.Second, "connection and call timeout")
+
+	drainTimeout = flag.Duration("drain-timeout", 10*time.Second, "on exit, how long to wait for the queries and transactions in flight before rolling back the transactions")
 )
 
 var topoReader *TopoReader
@@ -34,10 +37,21 @@ func main() {
 	defer topo.CloseServers()
 
 	rts := vtgate.NewResilientSrvTopoServer(ts)
+	currentTs, currentRts = ts, rts
+	defer closeCurrentTopo()
 
 	topoReader = NewTopoReader(rts)
 	topo.RegisterTopoReader(topoReader)
 
 	vtgate.Init(rts, *cell, *retryDelay, *retryCount, *timeout)
+	servenv.OnClose(func() {
+		vtgate.RpcVTGate.Drain(*drainTimeout)
+	})
+	servenv.AddStatusSection("Topology", func() string {
+		return fmt.Sprintf("Last topo refresh: %v (POST /debug/reload_topo to reload)", vtgate.RpcVTGate.TopoRefreshTime())
+	})
+	servenv.AddStatusPart("Keyspaces", `<table>{{range $keyspace, $status := .}}<tr><td>{{$keyspace}}</td><td>{{$status}}</td></tr>{{end}}</table>`, func() interface{} {
+		return vtgate.RpcVTGate.KeyspaceAvailability()
+	})
 	servenv.Run()
 }
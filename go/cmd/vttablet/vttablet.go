@@ -66,8 +66,10 @@ func main() {
 		time.Sleep(5 * time.Millisecond)
 		ts.DisallowQueries()
 		binlog.DisableUpdateStreamService()
-		topo.CloseServers()
+		// The agent writes its last action results, and
+		// cleans up, in the topology servers.
 		agent.Stop()
+		topo.CloseServers()
 	})
 	servenv.Run()
 }
@@ -149,6 +149,7 @@ func (conn *Connection) ExecuteFetch(query string, maxrows int, wantfields bool)
 	qr = &proto.QueryResult{}
 	qr.RowsAffected = uint64(conn.c.affected_rows)
 	qr.InsertId = uint64(conn.c.insert_id)
+	qr.ConnectionId = conn.Id()
 	if conn.c.num_fields == 0 {
 		return qr, nil
 	}
@@ -244,6 +245,12 @@ func (conn *Connection) CloseResult() {
 	C.vt_close_result(&conn.c)
 }
 
+// Abort closes the connection without reading the rest of the
+// current result, which CloseResult would do.
+func (conn *Connection) Abort() {
+	C.vt_abort(&conn.c)
+}
+
 func (conn *Connection) Id() int64 {
 	if conn.c.mysql == nil {
 		return 0
@@ -49,6 +49,9 @@ func (qr *QueryResult) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 	bson.EncodeUint64(buf, "RowsAffected", qr.RowsAffected)
 	bson.EncodeUint64(buf, "InsertId", qr.InsertId)
 	EncodeRowsBson(qr.Rows, "Rows", buf)
+	if qr.ConnectionId != 0 {
+		bson.EncodeInt64(buf, "ConnectionId", qr.ConnectionId)
+	}
 
 	buf.WriteByte(0)
 	lenWriter.RecordLen()
@@ -104,6 +107,8 @@ func (qr *QueryResult) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			qr.InsertId = bson.DecodeUint64(buf, kind)
 		case "Rows":
 			qr.Rows = DecodeRowsBson(buf, kind)
+		case "ConnectionId":
+			qr.ConnectionId = bson.DecodeInt64(buf, kind)
 		default:
 			bson.Skip(buf, kind)
 		}
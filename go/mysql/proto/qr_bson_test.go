@@ -66,6 +66,14 @@ var testcases = []TestCase{
 		},
 		encoded: "",
 	},
+	// connection id
+	{
+		qr: QueryResult{
+			RowsAffected: 1,
+			ConnectionId: 1234,
+		},
+		encoded: "[\x00\x00\x00\x04Fields\x00\x05\x00\x00\x00\x00?RowsAffected\x00\x01\x00\x00\x00\x00\x00\x00\x00?InsertId\x00\x00\x00\x00\x00\x00\x00\x00\x00\x04Rows\x00\x05\x00\x00\x00\x00\x12ConnectionId\x00\xd2\x04\x00\x00\x00\x00\x00\x00\x00",
+	},
 }
 
 func TestRun(t *testing.T) {
@@ -105,6 +113,9 @@ func compare(t *testing.T, caseno int, original, newqr QueryResult) {
 	if original.RowsAffected != newqr.RowsAffected {
 		goto mismatch
 	}
+	if original.ConnectionId != newqr.ConnectionId {
+		goto mismatch
+	}
 	if len(original.Rows) != len(newqr.Rows) {
 		goto mismatch
 	}
@@ -56,6 +56,10 @@ type QueryResult struct {
 	RowsAffected uint64
 	InsertId     uint64
 	Rows         [][]sqltypes.Value
+
+	// ConnectionId is the id of the MySQL connection that ran
+	// the query, 0 if not known.
+	ConnectionId int64
 }
 
 // Convert takes a type and a value, and returns the type:
@@ -118,6 +118,22 @@ void vt_close_result(VT_CONN *conn) {
   }
 }
 
+void vt_abort(VT_CONN *conn) {
+  if(conn->result) {
+    my_thread_init();
+    // Detach the result from the connection first, so
+    // mysql_free_result doesn't read the rest of the rows,
+    // and mysql_close doesn't touch the freed result.
+    if(conn->mysql && conn->mysql->unbuffered_fetch_owner == &conn->result->unbuffered_fetch_cancelled) {
+      conn->mysql->unbuffered_fetch_owner = 0;
+    }
+    conn->result->handle = 0;
+    mysql_free_result(conn->result);
+    clear_result(conn);
+  }
+  vt_close(conn);
+}
+
 void clear_result(VT_CONN *conn) {
   conn->affected_rows = 0;
   conn->insert_id = 0;
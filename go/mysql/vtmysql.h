@@ -46,6 +46,10 @@ extern VT_ROW vt_fetch_next(VT_CONN *conn);
 // vt_close_result: If vt_execute has results, you must call this before the next invocation.
 extern void vt_close_result(VT_CONN *conn);
 
+// vt_abort: Frees the current result without reading the rows left in it,
+// and closes the connection. Use it to stop streaming a result early.
+extern void vt_abort(VT_CONN *conn);
+
 // Pass-through to mysql
 extern unsigned long vt_thread_id(VT_CONN *conn);
 extern unsigned int vt_errno(VT_CONN *conn);
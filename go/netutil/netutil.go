@@ -73,15 +73,16 @@ func SortRfc2782(srvs []*net.SRV) {
 }
 
 // SplitHostPort is an extension to net.SplitHostPort that also parses the
-// integer port
+// integer port. IPv6 hosts are returned without their brackets, as in
+// "[::1]:3306".
 func SplitHostPort(addr string) (string, int, error) {
 	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		return "", 0, err
 	}
-	p, err := strconv.ParseInt(port, 10, 16)
+	p, err := strconv.ParseUint(port, 10, 16)
 	if err != nil {
-		return "", 0, err
+		return "", 0, fmt.Errorf("invalid port in address %v: %v", addr, err)
 	}
 	return host, int(p), nil
 }
@@ -110,20 +111,60 @@ func FullyQualifiedHostnameOrPanic() string {
 	return hostname
 }
 
+// HostPort is an address parsed by SplitHostPortList.
+type HostPort struct {
+	Host string
+	Port int
+}
+
+// String returns the address as host:port, with brackets around
+// IPv6 hosts.
+func (hp HostPort) String() string {
+	return net.JoinHostPort(hp.Host, strconv.Itoa(hp.Port))
+}
+
+// SplitHostPortList parses a comma-separated list of host:port
+// addresses with SplitHostPort. Spaces around the addresses and empty
+// entries are ignored. It returns an error for the first invalid
+// address, or if there is no address at all.
+func SplitHostPortList(addrs string) ([]HostPort, error) {
+	var result []HostPort
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		host, port, err := SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, HostPort{Host: host, Port: port})
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no address in %q", addrs)
+	}
+	return result, nil
+}
+
 // ResolveAddr can resolve an address where the host has been left
-// blank, like ":3306"
+// blank, like ":3306". It also accepts a comma-separated list of
+// addresses, see SplitHostPortList, and resolves each of them.
 func ResolveAddr(addr string) (string, error) {
-	host, port, err := SplitHostPort(addr)
+	hostPorts, err := SplitHostPortList(addr)
 	if err != nil {
 		return "", err
 	}
-	if host == "" {
-		host, err = FullyQualifiedHostname()
-		if err != nil {
-			return "", err
+	resolved := make([]string, len(hostPorts))
+	for i, hp := range hostPorts {
+		if hp.Host == "" {
+			hp.Host, err = FullyQualifiedHostname()
+			if err != nil {
+				return "", err
+			}
 		}
+		resolved[i] = hp.String()
 	}
-	return fmt.Sprintf("%v:%v", host, port), nil
+	return strings.Join(resolved, ","), nil
 }
 
 // ResolveIpAddr resolves the address:port part into an IP address:port pair
@@ -0,0 +1,97 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package netutil
+
+import (
+	"testing"
+)
+
+func TestSplitHostPort(t *testing.T) {
+	for _, c := range []struct {
+		addr string
+		host string
+		port int
+	}{
+		{"localhost:3306", "localhost", 3306},
+		{"10.0.0.1:65535", "10.0.0.1", 65535},
+		{":3306", "", 3306},
+		{"[::1]:3306", "::1", 3306},
+		{"[fe80::1%eth0]:15001", "fe80::1%eth0", 15001},
+		{"[2001:db8::68]:443", "2001:db8::68", 443},
+	} {
+		host, port, err := SplitHostPort(c.addr)
+		if err != nil {
+			t.Errorf("SplitHostPort(%v): %v", c.addr, err)
+			continue
+		}
+		if host != c.host || port != c.port {
+			t.Errorf("SplitHostPort(%v) = %v, %v, want %v, %v", c.addr, host, port, c.host, c.port)
+		}
+	}
+
+	for _, addr := range []string{
+		"localhost",
+		"::1:3306",
+		"[::1]",
+		"[::1]:port",
+		"localhost:65536",
+		"localhost:-1",
+	} {
+		if host, port, err := SplitHostPort(addr); err == nil {
+			t.Errorf("SplitHostPort(%v) = %v, %v, want an error", addr, host, port)
+		}
+	}
+}
+
+func TestResolveAddr(t *testing.T) {
+	for _, c := range []struct {
+		addr string
+		want string
+	}{
+		{"localhost:3306", "localhost:3306"},
+		{"[::1]:3306", "[::1]:3306"},
+	} {
+		got, err := ResolveAddr(c.addr)
+		if err != nil {
+			t.Errorf("ResolveAddr(%v): %v", c.addr, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("ResolveAddr(%v) = %v, want %v", c.addr, got, c.want)
+		}
+	}
+	if _, err := ResolveAddr("[::1]:port"); err == nil {
+		t.Errorf("ResolveAddr with a bad port: want an error")
+	}
+}
+
+func TestSplitHostPortList(t *testing.T) {
+	got, err := SplitHostPortList("localhost:3306, [::1]:15001,,:6700")
+	if err != nil {
+		t.Fatalf("SplitHostPortList: %v", err)
+	}
+	want := []HostPort{{"localhost", 3306}, {"::1", 15001}, {"", 6700}}
+	if len(got) != len(want) {
+		t.Fatalf("SplitHostPortList = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("SplitHostPortList[%v] = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if got[1].String() != "[::1]:15001" {
+		t.Errorf("String() = %v, want [::1]:15001", got[1].String())
+	}
+
+	for _, addrs := range []string{"", " , ", "localhost:3306,localhost"} {
+		if got, err := SplitHostPortList(addrs); err == nil {
+			t.Errorf("SplitHostPortList(%q) = %v, want an error", addrs, got)
+		}
+	}
+
+	if got, err := ResolveAddr("localhost:3306,[::1]:3306"); err != nil || got != "localhost:3306,[::1]:3306" {
+		t.Errorf("ResolveAddr of a list = %v, %v", got, err)
+	}
+}
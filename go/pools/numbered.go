@@ -10,37 +10,85 @@ import (
 	"time"
 )
 
+// NUMBERED_TIMEOUT_ERR is returned by Register when a Numbered
+// stayed at capacity for its whole timeout.
+var NUMBERED_TIMEOUT_ERR = fmt.Errorf("numbered pool timed out")
+
 // Numbered allows you to manage resources by tracking them with numbers.
 // There are no interface restrictions on what you can track.
 type Numbered struct {
 	mu        sync.Mutex
 	empty     *sync.Cond // Broadcast when pool becomes empty
 	resources map[int64]*numberedWrapper
+
+	// capacity bounds the number of resources, if not 0: Register
+	// waits for timeout at most for one to be unregistered. freed
+	// is closed, and replaced, when one is.
+	capacity int
+	timeout  time.Duration
+	freed    chan struct{}
+
+	// isValid, if set, tells the resources that can still be used
+	// from those to discard, see SetIsValid. discarded counts them.
+	// onDiscard, if set, closes them once mu is released, see
+	// SetOnDiscard: toClose are the ones waiting for it.
+	isValid   func(val interface{}) bool
+	discarded int64
+	onDiscard func(val interface{})
+	toClose   []interface{}
+
+	// gets, puts and timeouts count the successful Gets, the Puts,
+	// and the Registers that timed out, see Stats.
+	gets     int64
+	puts     int64
+	timeouts int64
 }
 
 type numberedWrapper struct {
 	val         interface{}
 	inUse       bool
+	broken      bool
 	purpose     string
 	timeCreated time.Time
 	timeUsed    time.Time
 }
 
+// NewNumbered creates a Numbered with no capacity limit.
 func NewNumbered() *Numbered {
-	n := &Numbered{resources: make(map[int64]*numberedWrapper)}
+	return NewNumberedWithCapacity(0, 0)
+}
+
+// NewNumberedWithCapacity creates a Numbered that tracks capacity
+// resources at most, or any number if capacity is 0. Past it,
+// Register waits for timeout at most.
+func NewNumberedWithCapacity(capacity int, timeout time.Duration) *Numbered {
+	n := &Numbered{
+		resources: make(map[int64]*numberedWrapper),
+		capacity:  capacity,
+		timeout:   timeout,
+		freed:     make(chan struct{}),
+	}
 	n.empty = sync.NewCond(&n.mu)
 	return n
 }
 
 // Register starts tracking a resource by the supplied id.
 // It does not lock the object.
-// It returns an error if the id already exists.
+// It returns an error if the id already exists. At capacity, it
+// waits for a resource to be unregistered, and returns
+// NUMBERED_TIMEOUT_ERR if none is within the timeout.
 func (nu *Numbered) Register(id int64, val interface{}) error {
 	nu.mu.Lock()
 	defer nu.mu.Unlock()
 	if _, ok := nu.resources[id]; ok {
 		return fmt.Errorf("already present")
 	}
+	if err := nu.waitForRoom(); err != nil {
+		return err
+	}
+	if _, ok := nu.resources[id]; ok {
+		return fmt.Errorf("already present")
+	}
 	now := time.Now()
 	nu.resources[id] = &numberedWrapper{
 		val:         val,
@@ -55,18 +103,53 @@ func (nu *Numbered) Register(id int64, val interface{}) error {
 func (nu *Numbered) Unregister(id int64) {
 	nu.mu.Lock()
 	defer nu.mu.Unlock()
+	nu.unregister(id)
+}
+
+// unregister is Unregister with mu held.
+func (nu *Numbered) unregister(id int64) {
+	if _, ok := nu.resources[id]; !ok {
+		return
+	}
 	delete(nu.resources, id)
+	close(nu.freed)
+	nu.freed = make(chan struct{})
 	if len(nu.resources) == 0 {
 		nu.empty.Broadcast()
 	}
 }
 
+// waitForRoom waits until the pool is under capacity, for timeout
+// at most. mu must be held, it's released while waiting.
+func (nu *Numbered) waitForRoom() error {
+	if nu.capacity == 0 || len(nu.resources) < nu.capacity {
+		return nil
+	}
+	timer := time.NewTimer(nu.timeout)
+	defer timer.Stop()
+	for len(nu.resources) >= nu.capacity {
+		freed := nu.freed
+		nu.mu.Unlock()
+		select {
+		case <-freed:
+			nu.mu.Lock()
+		case <-timer.C:
+			nu.mu.Lock()
+			if len(nu.resources) >= nu.capacity {
+				nu.timeouts++
+				return NUMBERED_TIMEOUT_ERR
+			}
+		}
+	}
+	return nil
+}
+
 // Get locks the resource for use. It accepts a purpose as a string.
 // If it cannot be found, it returns a "not found" error. If in use,
 // it returns a "in use: purpose" error.
 func (nu *Numbered) Get(id int64, purpose string) (val interface{}, err error) {
 	nu.mu.Lock()
-	defer nu.mu.Unlock()
+	defer nu.unlock()
 	nw, ok := nu.resources[id]
 	if !ok {
 		return nil, fmt.Errorf("not found")
@@ -74,22 +157,92 @@ func (nu *Numbered) Get(id int64, purpose string) (val interface{}, err error) {
 	if nw.inUse {
 		return nil, fmt.Errorf("in use: %s", nw.purpose)
 	}
+	if !nu.valid(nw) {
+		nu.discard(id)
+		return nil, fmt.Errorf("not found")
+	}
 	nw.inUse = true
 	nw.purpose = purpose
+	nu.gets++
 	return nw.val, nil
 }
 
-// Put unlocks a resource for someone else to use.
+// Put unlocks a resource for someone else to use. A resource marked
+// broken, or that isn't valid anymore, is discarded instead.
 func (nu *Numbered) Put(id int64) {
 	nu.mu.Lock()
-	defer nu.mu.Unlock()
+	defer nu.unlock()
+	nu.puts++
 	if nw, ok := nu.resources[id]; ok {
+		if !nu.valid(nw) {
+			nu.discard(id)
+			return
+		}
 		nw.inUse = false
 		nw.purpose = ""
 		nw.timeUsed = time.Now()
 	}
 }
 
+// SetIsValid sets the function that tells if a resource can still
+// be used: Get and Put discard those that can't. It's called with
+// the Numbered locked, so it has to be quick and can't use it.
+func (nu *Numbered) SetIsValid(isValid func(val interface{}) bool) {
+	nu.mu.Lock()
+	defer nu.mu.Unlock()
+	nu.isValid = isValid
+}
+
+// SetOnDiscard sets the function that closes the resources Get, Put
+// and MarkBroken discard: nobody can Get them anymore to do it. It's
+// called with the Numbered unlocked.
+func (nu *Numbered) SetOnDiscard(onDiscard func(val interface{})) {
+	nu.mu.Lock()
+	defer nu.mu.Unlock()
+	nu.onDiscard = onDiscard
+}
+
+// MarkBroken marks a resource as not to be used anymore: it's
+// discarded when put back, or right away if it's not in use.
+func (nu *Numbered) MarkBroken(id int64) {
+	nu.mu.Lock()
+	defer nu.unlock()
+	nw, ok := nu.resources[id]
+	if !ok {
+		return
+	}
+	nw.broken = true
+	if !nw.inUse {
+		nu.discard(id)
+	}
+}
+
+// valid returns false if nw is broken or not valid. mu must be held.
+func (nu *Numbered) valid(nw *numberedWrapper) bool {
+	return !nw.broken && (nu.isValid == nil || nu.isValid(nw.val))
+}
+
+// discard unregisters a resource that can't be used anymore, to be
+// closed by unlock. mu must be held.
+func (nu *Numbered) discard(id int64) {
+	if nu.onDiscard != nil {
+		nu.toClose = append(nu.toClose, nu.resources[id].val)
+	}
+	nu.unregister(id)
+	nu.discarded++
+}
+
+// unlock releases mu, then closes the resources discarded while it
+// was held.
+func (nu *Numbered) unlock() {
+	toClose, onDiscard := nu.toClose, nu.onDiscard
+	nu.toClose = nil
+	nu.mu.Unlock()
+	for _, val := range toClose {
+		onDiscard(val)
+	}
+}
+
 // GetOutdated returns a list of resources that are older than age, and locks them.
 // It does not return any resources that are already locked.
 func (nu *Numbered) GetOutdated(age time.Duration, purpose string) (vals []interface{}) {
@@ -138,8 +291,37 @@ func (nu *Numbered) WaitForEmpty() {
 	}
 }
 
+// Capacity returns the max number of resources, 0 if there's no limit.
+func (nu *Numbered) Capacity() int {
+	return nu.capacity
+}
+
+// Discarded returns the number of resources discarded
+// for being broken or not valid.
+func (nu *Numbered) Discarded() int64 {
+	nu.mu.Lock()
+	defer nu.mu.Unlock()
+	return nu.discarded
+}
+
 func (nu *Numbered) StatsJSON() string {
-	return fmt.Sprintf("{\"Size\": %v}", nu.Size())
+	size, inUse, available, gets, puts, timeouts := nu.Stats()
+	return fmt.Sprintf("{\"Size\": %v, \"InUse\": %v, \"Available\": %v, \"Gets\": %v, \"Puts\": %v, \"Timeouts\": %v, \"Discarded\": %v}", size, inUse, available, gets, puts, timeouts, nu.Discarded())
+}
+
+// Stats returns the number of resources, split in those in use and
+// those available, and the number of successful Gets, of Puts, and
+// of Registers that timed out.
+func (nu *Numbered) Stats() (size, inUse, available, gets, puts, timeouts int64) {
+	nu.mu.Lock()
+	defer nu.mu.Unlock()
+	for _, nw := range nu.resources {
+		if nw.inUse {
+			inUse++
+		}
+	}
+	size = int64(len(nu.resources))
+	return size, inUse, size - inUse, nu.gets, nu.puts, nu.timeouts
 }
 
 func (nu *Numbered) Size() (size int64) {
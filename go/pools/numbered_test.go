@@ -5,6 +5,7 @@
 package pools
 
 import (
+	"reflect"
 	"testing"
 	"time"
 )
@@ -82,3 +83,96 @@ func TestNumbered(t *testing.T) {
 	}()
 	p.WaitForEmpty()
 }
+
+func TestNumberedCapacity(t *testing.T) {
+	p := NewNumberedWithCapacity(2, 50*time.Millisecond)
+	if err := p.Register(0, 0); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if err := p.Register(1, 1); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+
+	// At capacity, Register times out.
+	start := time.Now()
+	if err := p.Register(2, 2); err != NUMBERED_TIMEOUT_ERR {
+		t.Errorf("want %v, got %v", NUMBERED_TIMEOUT_ERR, err)
+	}
+	if d := time.Now().Sub(start); d < 50*time.Millisecond {
+		t.Errorf("want >50ms, got %v", d)
+	}
+
+	// Or gets the room of an unregistered resource.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		p.Unregister(0)
+	}()
+	if err := p.Register(2, 2); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if p.Size() != 2 {
+		t.Errorf("want 2, got %v", p.Size())
+	}
+}
+
+func TestNumberedDiscard(t *testing.T) {
+	p := NewNumbered()
+	valid := map[int64]bool{0: true, 1: true, 2: true}
+	p.SetIsValid(func(val interface{}) bool {
+		return valid[val.(int64)]
+	})
+	var closed []int64
+	p.SetOnDiscard(func(val interface{}) {
+		closed = append(closed, val.(int64))
+	})
+	for id := int64(0); id < 3; id++ {
+		p.Register(id, id)
+	}
+
+	// A broken resource in use is discarded when put back.
+	if _, err := p.Get(0, "test"); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	p.MarkBroken(0)
+	p.Put(0)
+	if _, err := p.Get(0, "test"); err == nil || err.Error() != "not found" {
+		t.Errorf("want 'not found', got '%v'", err)
+	}
+
+	// A broken resource not in use is discarded right away.
+	p.MarkBroken(1)
+	if p.Size() != 1 {
+		t.Errorf("want 1, got %v", p.Size())
+	}
+
+	// An invalid resource is never handed out.
+	valid[2] = false
+	if _, err := p.Get(2, "test"); err == nil || err.Error() != "not found" {
+		t.Errorf("want 'not found', got '%v'", err)
+	}
+	if p.Size() != 0 || p.Discarded() != 3 {
+		t.Errorf("want 0 and 3 discarded, got %v, %v", p.Size(), p.Discarded())
+	}
+	// They were all closed.
+	if want := []int64{0, 1, 2}; !reflect.DeepEqual(closed, want) {
+		t.Errorf("want %v closed, got %v", want, closed)
+	}
+}
+
+func TestNumberedStats(t *testing.T) {
+	p := NewNumberedWithCapacity(2, time.Millisecond)
+	p.Register(0, 0)
+	p.Register(1, 1)
+	p.Register(2, 2)
+	p.Get(0, "test")
+	p.Get(0, "test")
+	size, inUse, available, gets, puts, timeouts := p.Stats()
+	if size != 2 || inUse != 1 || available != 1 || gets != 1 || puts != 0 || timeouts != 1 {
+		t.Errorf("want 2, 1, 1, 1, 0, 1, got %v, %v, %v, %v, %v, %v", size, inUse, available, gets, puts, timeouts)
+	}
+	p.Put(0)
+	want := `{"Size": 2, "InUse": 0, "Available": 2, "Gets": 1, "Puts": 1, "Timeouts": 1, "Discarded": 0}`
+	if got := p.StatsJSON(); got != want {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
@@ -14,7 +14,8 @@ import (
 )
 
 var (
-	CLOSED_ERR = fmt.Errorf("ResourcePool is closed")
+	CLOSED_ERR  = fmt.Errorf("ResourcePool is closed")
+	TIMEOUT_ERR = fmt.Errorf("resource pool timed out")
 )
 
 // Factory is a function that can be used to create a resource.
@@ -81,17 +82,24 @@ func (rp *ResourcePool) IsClosed() (closed bool) {
 // has not been reached, it will create a new one using the factory. Otherwise,
 // it will indefinitely wait till the next resource becomes available.
 func (rp *ResourcePool) Get() (resource Resource, err error) {
-	return rp.get(true)
+	return rp.get(true, 0)
+}
+
+// GetWithTimeout behaves like Get, except that it gives up waiting
+// after timeout and returns TIMEOUT_ERR. A timeout of 0 means that
+// it will wait indefinitely.
+func (rp *ResourcePool) GetWithTimeout(timeout time.Duration) (resource Resource, err error) {
+	return rp.get(true, timeout)
 }
 
 // TryGet will return the next available resource. If none is available, and capacity
 // has not been reached, it will create a new one using the factory. Otherwise,
 // it will return nil with no error.
 func (rp *ResourcePool) TryGet() (resource Resource, err error) {
-	return rp.get(false)
+	return rp.get(false, 0)
 }
 
-func (rp *ResourcePool) get(wait bool) (resource Resource, err error) {
+func (rp *ResourcePool) get(wait bool, timeout time.Duration) (resource Resource, err error) {
 	// Fetch
 	var wrapper resourceWrapper
 	var ok bool
@@ -102,7 +110,18 @@ func (rp *ResourcePool) get(wait bool) (resource Resource, err error) {
 			return nil, nil
 		}
 		startTime := time.Now()
-		wrapper, ok = <-rp.resources
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			select {
+			case wrapper, ok = <-rp.resources:
+				timer.Stop()
+			case <-timer.C:
+				rp.recordWait(startTime)
+				return nil, TIMEOUT_ERR
+			}
+		} else {
+			wrapper, ok = <-rp.resources
+		}
 		rp.recordWait(startTime)
 	}
 	if !ok {
@@ -110,8 +129,8 @@ func (rp *ResourcePool) get(wait bool) (resource Resource, err error) {
 	}
 
 	// Unwrap
-	timeout := rp.idleTimeout.Get()
-	if wrapper.resource != nil && timeout > 0 && wrapper.timeUsed.Add(timeout).Sub(time.Now()) < 0 {
+	idleTimeout := rp.idleTimeout.Get()
+	if wrapper.resource != nil && idleTimeout > 0 && wrapper.timeUsed.Add(idleTimeout).Sub(time.Now()) < 0 {
 		wrapper.resource.Close()
 		wrapper.resource = nil
 	}
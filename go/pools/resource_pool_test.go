@@ -485,3 +485,28 @@ func TestSlowCreateFail(t *testing.T) {
 		t.Errorf("Expecting 2, received %d", available)
 	}
 }
+
+func TestGetWithTimeout(t *testing.T) {
+	lastId.Set(0)
+	count.Set(0)
+	p := NewResourcePool(PoolFactory, 1, 1, time.Second)
+	defer p.Close()
+	r, err := p.GetWithTimeout(10 * time.Millisecond)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	// The pool is exhausted: the second Get must time out.
+	if _, err := p.GetWithTimeout(10 * time.Millisecond); err != TIMEOUT_ERR {
+		t.Errorf("Expecting %v, received %v", TIMEOUT_ERR, err)
+	}
+	if p.WaitCount() != 1 {
+		t.Errorf("Expecting 1, received %d", p.WaitCount())
+	}
+	p.Put(r)
+	// Once the resource is back, it should be handed out again.
+	r, err = p.GetWithTimeout(10 * time.Millisecond)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	p.Put(r)
+}
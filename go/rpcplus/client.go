@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"reflect"
 	"sync"
+	"time"
 )
 
 // ServerError represents an error that has been returned from
@@ -28,13 +29,14 @@ var ErrShutdown = errors.New("connection is shut down")
 
 // Call represents an active RPC.
 type Call struct {
-	ServiceMethod string      // The name of the service and method to call.
-	Args          interface{} // The argument to the function (*struct).
-	Reply         interface{} // The reply from the function (*struct for single, chan * struct for streaming).
-	Error         error       // After completion, the error status.
-	Done          chan *Call  // Strobes when call is complete (nil for streaming RPCs)
-	Stream        bool        // True for a streaming RPC call, false otherwise
-	Subseq        uint64      // The next expected subseq in the packets
+	ServiceMethod string        // The name of the service and method to call.
+	Args          interface{}   // The argument to the function (*struct).
+	Reply         interface{}   // The reply from the function (*struct for single, chan * struct for streaming).
+	Error         error         // After completion, the error status.
+	Done          chan *Call    // Strobes when call is complete (nil for streaming RPCs)
+	Stream        bool          // True for a streaming RPC call, false otherwise
+	Subseq        uint64        // The next expected subseq in the packets
+	Timeout       time.Duration // Sent with the request: how long the server has to answer, 0 for ever
 }
 
 // Client represents an RPC Client.
@@ -88,6 +90,7 @@ func (client *Client) send(call *Call) {
 	// Encode and send the request.
 	client.request.Seq = seq
 	client.request.ServiceMethod = call.ServiceMethod
+	client.request.Timeout = call.Timeout
 	err := client.codec.WriteRequest(&client.request, call.Args)
 	if err != nil {
 		client.mutex.Lock()
@@ -362,3 +365,19 @@ func (client *Client) Call(serviceMethod string, args interface{}, reply interfa
 	call := <-client.Go(serviceMethod, args, reply, make(chan *Call, 1)).Done
 	return call.Error
 }
+
+// CallWithTimeout is like Call, but the server is told to give up
+// on the call after timeout (see ContextWithDeadline). The client
+// still waits for the reply.
+func (client *Client) CallWithTimeout(serviceMethod string, args interface{}, reply interface{}, timeout time.Duration) error {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          make(chan *Call, 1),
+		Timeout:       timeout,
+	}
+	client.send(call)
+	call = <-call.Done
+	return call.Error
+}
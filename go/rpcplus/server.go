@@ -135,6 +135,7 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 	"unicode/utf8"
 )
@@ -174,9 +175,10 @@ type service struct {
 // but documented here as an aid to debugging, such as when analyzing
 // network traffic.
 type Request struct {
-	ServiceMethod string   // format: "Service.Method"
-	Seq           uint64   // sequence number chosen by client
-	next          *Request // for free list in Server
+	ServiceMethod string        // format: "Service.Method"
+	Seq           uint64        // sequence number chosen by client
+	Timeout       time.Duration // how long the client waits for the reply, 0 for ever
+	next          *Request      // for free list in Server
 }
 
 // Response is a header written before every RPC return.  It is used internally
@@ -399,10 +401,23 @@ func (m *methodType) NumCalls() (n uint) {
 	return n
 }
 
+// ContextWithDeadline is implemented by the connection contexts
+// that can carry the deadline of a call: if the request has a
+// Timeout, the method gets the context returned by WithDeadline
+// instead of the one of the connection.
+type ContextWithDeadline interface {
+	WithDeadline(deadline time.Time) interface{}
+}
+
 func (s *service) call(server *Server, sending *sync.Mutex, mtype *methodType, req *Request, argv, replyv reflect.Value, codec ServerCodec, context interface{}) {
 	mtype.Lock()
 	mtype.numCalls++
 	mtype.Unlock()
+	if req.Timeout > 0 {
+		if c, ok := context.(ContextWithDeadline); ok {
+			context = c.WithDeadline(time.Now().Add(req.Timeout))
+		}
+	}
 	function := mtype.method.Func
 	var returnValues []reflect.Value
 
@@ -435,6 +435,45 @@ func testSendDeadlock(client *Client) {
 	client.Call("Arith.Add", args, reply)
 }
 
+type deadlineContext struct {
+	deadline time.Time
+}
+
+func (c *deadlineContext) WithDeadline(deadline time.Time) interface{} {
+	return &deadlineContext{deadline: deadline}
+}
+
+type Deadline int
+
+func (t *Deadline) Get(context interface{}, args string, reply *time.Time) error {
+	*reply = context.(*deadlineContext).deadline
+	return nil
+}
+
+func TestCallWithTimeout(t *testing.T) {
+	server := NewServer()
+	server.Register(new(Deadline))
+	cli, srv := net.Pipe()
+	go server.ServeConnWithContext(srv, &deadlineContext{})
+	client := NewClient(cli)
+	defer client.Close()
+
+	var deadline time.Time
+	if err := client.Call("Deadline.Get", "", &deadline); err != nil {
+		t.Fatalf("Get: expected no error but got string %q", err.Error())
+	}
+	if !deadline.IsZero() {
+		t.Errorf("Get: expected no deadline, got %v", deadline)
+	}
+	before := time.Now()
+	if err := client.CallWithTimeout("Deadline.Get", "", &deadline, time.Minute); err != nil {
+		t.Fatalf("Get: expected no error but got string %q", err.Error())
+	}
+	if deadline.Before(before.Add(time.Minute)) || deadline.After(time.Now().Add(time.Minute)) {
+		t.Errorf("Get: expected a deadline in a minute, got %v", deadline)
+	}
+}
+
 func dialDirect() (*Client, error) {
 	return Dial("tcp", serverAddr)
 }
@@ -6,6 +6,7 @@ package bsonrpc
 
 import (
 	"bytes"
+	"time"
 
 	"github.com/youtube/vitess/go/bson"
 	"github.com/youtube/vitess/go/bytes2"
@@ -22,6 +23,7 @@ func (req *RequestBson) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 
 	bson.EncodeString(buf, "ServiceMethod", req.ServiceMethod)
 	bson.EncodeUint64(buf, "Seq", req.Seq)
+	bson.EncodeInt64(buf, "Timeout", int64(req.Timeout))
 
 	buf.WriteByte(0)
 	lenWriter.RecordLen()
@@ -39,6 +41,8 @@ func (req *RequestBson) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			req.ServiceMethod = bson.DecodeString(buf, kind)
 		case "Seq":
 			req.Seq = bson.DecodeUint64(buf, kind)
+		case "Timeout":
+			req.Timeout = time.Duration(bson.DecodeInt64(buf, kind))
 		default:
 			bson.Skip(buf, kind)
 		}
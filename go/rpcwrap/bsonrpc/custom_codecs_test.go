@@ -6,6 +6,7 @@ package bsonrpc
 
 import (
 	"testing"
+	"time"
 
 	"github.com/youtube/vitess/go/bson"
 	rpc "github.com/youtube/vitess/go/rpcplus"
@@ -14,18 +15,21 @@ import (
 type reflectRequestBson struct {
 	ServiceMethod string
 	Seq           uint64
+	Timeout       int64
 }
 
 type extraRequestBson struct {
 	Extra         int
 	ServiceMethod string
 	Seq           uint64
+	Timeout       int64
 }
 
 func TestRequestBson(t *testing.T) {
 	reflected, err := bson.Marshal(&reflectRequestBson{
 		ServiceMethod: "aa",
 		Seq:           1,
+		Timeout:       int64(time.Second),
 	})
 	if err != nil {
 		t.Error(err)
@@ -36,6 +40,7 @@ func TestRequestBson(t *testing.T) {
 		&rpc.Request{
 			ServiceMethod: "aa",
 			Seq:           1,
+			Timeout:       time.Second,
 		},
 	}
 	encoded, err := bson.Marshal(&custom)
@@ -58,6 +63,9 @@ func TestRequestBson(t *testing.T) {
 	if custom.Seq != unmarshalled.Seq {
 		t.Errorf("want %v, got %#v", custom.Seq, unmarshalled.Seq)
 	}
+	if custom.Timeout != unmarshalled.Timeout {
+		t.Errorf("want %v, got %#v", custom.Timeout, unmarshalled.Timeout)
+	}
 
 	extra, err := bson.Marshal(&extraRequestBson{})
 	if err != nil {
@@ -1,6 +1,22 @@
 package proto
 
+import (
+	"time"
+)
+
 type Context struct {
 	RemoteAddr string
 	Username   string
+	// Deadline, if set, is when the caller stops waiting for the
+	// reply: servers should give up on the call by then.
+	Deadline time.Time
+}
+
+// WithDeadline returns a copy of the context with deadline set.
+// The server calls it for the requests that have a timeout: the
+// context of the connection is shared by all its calls.
+func (c *Context) WithDeadline(deadline time.Time) interface{} {
+	copy := *c
+	copy.Deadline = deadline
+	return &copy
 }
@@ -171,6 +171,8 @@ func (h *rpcHandler) ServeHTTP(c http.ResponseWriter, req *http.Request) {
 	}
 	io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
 	codec := h.cFactory(NewBufferedConnection(conn))
+	// The calls that have a Timeout in their request header get a
+	// copy of context with the Deadline set, see Context.WithDeadline.
 	context := &proto.Context{RemoteAddr: req.RemoteAddr}
 	if h.useAuth {
 		if authenticated, err := auth.Authenticate(codec, context); !authenticated {
@@ -144,7 +144,7 @@ func (conn *Conn) Exec(query string, bindVars map[string]interface{}) (db.Result
 		return &StreamResult{errFunc, sr, cols, nil, 0, nil}, nil
 	}
 
-	qr, err := conn.tabletConn.Execute(nil, query, bindVars, conn.TransactionId)
+	qr, err := conn.tabletConn.Execute(nil, query, bindVars, conn.TransactionId, 0, 0)
 	if err != nil {
 		return nil, conn.fmtErr(err)
 	}
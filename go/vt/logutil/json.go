@@ -0,0 +1,107 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package logutil
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+)
+
+var logJSON = flag.Bool("log_json", false, "write the logs that go to stderr as JSON objects, with level, time, thread, caller and message fields")
+
+// JSONEntry is a log line, as -log_json writes it.
+type JSONEntry struct {
+	Level   string    `json:"level"`
+	Time    time.Time `json:"time"`
+	Thread  string    `json:"thread,omitempty"`
+	Caller  string    `json:"caller,omitempty"`
+	Message string    `json:"message"`
+}
+
+var glogLevels = map[byte]string{
+	'I': "INFO",
+	'W': "WARNING",
+	'E': "ERROR",
+	'F': "FATAL",
+}
+
+// glogHeader matches the header glog prefixes its lines with:
+// Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg
+var glogHeader = regexp.MustCompile(`^([IWEF])(\d{4} \d{2}:\d{2}:\d{2}\.\d{6}) +(\d+) ([^ \]]+:\d+)\] (.*)$`)
+
+// parseGlogLine returns the entry of a line written by glog. The lines
+// with no header, which are the continuations of a multi-line message,
+// get the level of prev and the current time. glog leaves the year out,
+// it's taken from now.
+func parseGlogLine(line string, prev *JSONEntry, now time.Time) *JSONEntry {
+	match := glogHeader.FindStringSubmatch(line)
+	if match == nil {
+		entry := &JSONEntry{Level: "INFO", Time: now, Message: line}
+		if prev != nil {
+			entry.Level = prev.Level
+			entry.Thread = prev.Thread
+			entry.Caller = prev.Caller
+		}
+		return entry
+	}
+	t, err := time.ParseInLocation("0102 15:04:05.000000", match[2], now.Location())
+	if err != nil {
+		t = now
+	} else {
+		t = t.AddDate(now.Year(), 0, 0)
+	}
+	return &JSONEntry{
+		Level:   glogLevels[match[1][0]],
+		Time:    t,
+		Thread:  match[3],
+		Caller:  match[4],
+		Message: match[5],
+	}
+}
+
+// convertLogs reads the lines glog writes from r, and writes them as
+// JSON objects to w, one per line.
+func convertLogs(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	encoder := json.NewEncoder(w)
+	var prev *JSONEntry
+	for scanner.Scan() {
+		prev = parseGlogLine(scanner.Text(), prev, time.Now())
+		if err := encoder.Encode(prev); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// StartJSONLogs makes the logs written to stderr JSON objects if
+// -log_json is set: the format calls (log.Infof, log.Errorf, ...) are
+// unchanged, only the output is. It must be called after flag.Parse.
+// The log files, if any, keep the glog format.
+// The logs of a log.Fatal may be cut short, as the process exits
+// before they're all converted.
+func StartJSONLogs() error {
+	if !*logJSON {
+		return nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("cannot start the JSON logs: %v", err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	go func() {
+		if err := convertLogs(r, stderr); err != nil {
+			fmt.Fprintf(stderr, "JSON logs failed: %v\n", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,48 @@
+package logutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGlogLine(t *testing.T) {
+	now := time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)
+	entry := parseGlogLine("W0228 15:10:06.123456 10530 agent.go:42] action failed: bad", nil, now)
+	want := &JSONEntry{
+		Level:   "WARNING",
+		Time:    time.Date(2014, 2, 28, 15, 10, 6, 123456000, time.UTC),
+		Thread:  "10530",
+		Caller:  "agent.go:42",
+		Message: "action failed: bad",
+	}
+	if *entry != *want {
+		t.Errorf("want %+v, got %+v", want, entry)
+	}
+
+	// The continuations of a message keep its level.
+	entry = parseGlogLine("second line", entry, now)
+	if entry.Level != "WARNING" || entry.Message != "second line" || entry.Time != now {
+		t.Errorf("unexpected continuation: %+v", entry)
+	}
+}
+
+func TestConvertLogs(t *testing.T) {
+	in := "I0228 15:10:06.123456 10530 agent.go:42] started\nE0228 15:10:07.000000 10530 agent.go:43] failed\n"
+	out := new(bytes.Buffer)
+	if err := convertLogs(strings.NewReader(in), out); err != nil {
+		t.Fatalf("convertLogs: %v", err)
+	}
+	decoder := json.NewDecoder(out)
+	for _, level := range []string{"INFO", "ERROR"} {
+		var entry JSONEntry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if entry.Level != level {
+			t.Errorf("want level %v, got %v", level, entry.Level)
+		}
+	}
+}
@@ -2,6 +2,10 @@ package logutil
 
 import (
 	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 func init() {
@@ -14,3 +18,76 @@ func init() {
 	}
 	threshold.DefValue = "WARNING"
 }
+
+// Level is the minimum level of the logs written to stderr.
+// DEBUG is INFO, plus the log.V(1) logs.
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARNING
+	ERROR
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARNING", "ERROR"}
+
+func (level Level) String() string {
+	if level < DEBUG || level > ERROR {
+		return fmt.Sprintf("Level(%d)", int(level))
+	}
+	return levelNames[level]
+}
+
+// ParseLevel returns the Level named s, in any case.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, want one of %v", s, strings.Join(levelNames, ", "))
+}
+
+var levelMu sync.Mutex
+
+// SetLevel changes the minimum level of the logs written to stderr, at
+// runtime: it sets -stderrthreshold, and -v to 1 for DEBUG, 0 for the
+// other levels. The log.V(1) calls check -v before they format their
+// message, so the debug logs cost little when they're off.
+func SetLevel(level Level) error {
+	if level < DEBUG || level > ERROR {
+		return fmt.Errorf("invalid log level %v", level)
+	}
+	levelMu.Lock()
+	defer levelMu.Unlock()
+	threshold, verbosity := level.String(), "0"
+	if level == DEBUG {
+		threshold, verbosity = INFO.String(), "1"
+	}
+	if err := flag.Set("stderrthreshold", threshold); err != nil {
+		return err
+	}
+	return flag.Set("v", verbosity)
+}
+
+// GetLevel returns the minimum level of the logs written to stderr.
+func GetLevel() Level {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+	// glog gives the threshold as a number, from 0 for INFO.
+	threshold := flag.Lookup("stderrthreshold").Value.String()
+	level := WARNING
+	if n, err := strconv.Atoi(threshold); err == nil {
+		level = INFO + Level(n)
+	} else if l, err := ParseLevel(threshold); err == nil {
+		level = l
+	}
+	if level > ERROR {
+		level = ERROR
+	}
+	if level == INFO && flag.Lookup("v").Value.String() != "0" {
+		return DEBUG
+	}
+	return level
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -59,3 +60,27 @@ func TestPurge(t *testing.T) {
 	}
 
 }
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{DEBUG, INFO, WARNING, ERROR} {
+		got, err := ParseLevel(strings.ToLower(level.String()))
+		if err != nil || got != level {
+			t.Errorf("ParseLevel(%v): want %v, got %v, %v", level, level, got, err)
+		}
+	}
+	if _, err := ParseLevel("FATAL"); err == nil {
+		t.Errorf("ParseLevel(FATAL): want an error")
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	defer SetLevel(WARNING)
+	for _, level := range []Level{DEBUG, INFO, ERROR, WARNING} {
+		if err := SetLevel(level); err != nil {
+			t.Fatalf("SetLevel(%v): %v", level, err)
+		}
+		if got := GetLevel(); got != level {
+			t.Errorf("GetLevel: want %v, got %v", level, got)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxMycnfIncludeDepth bounds the nesting of !include directives,
+// so a file including itself doesn't loop forever.
+const maxMycnfIncludeDepth = 10
+
+// MycnfAddr is what MycnfFromFile reads from a my.cnf: where mysqld
+// listens, and where its data is. The fields the file doesn't set
+// are left empty.
+type MycnfAddr struct {
+	Port    int
+	Socket  string
+	DataDir string
+}
+
+// MycnfFromFile reads the port, socket and datadir options of mysqld
+// from the my.cnf at path. Unlike ReadMycnf, it requires none of them.
+// It follows the !include and !includedir directives, and only reads
+// the options of the [mysqld] and [server] groups, or outside of any
+// group, as the my.cnf files vitess generates have them. As in mysqld,
+// the last value of an option wins.
+func MycnfFromFile(path string) (*MycnfAddr, error) {
+	options := make(map[string]string)
+	if err := readMycnfOptions(path, options, 0); err != nil {
+		return nil, err
+	}
+	addr := &MycnfAddr{
+		Socket:  options["socket"],
+		DataDir: options["datadir"],
+	}
+	if port, ok := options["port"]; ok {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q in %v: %v", port, path, err)
+		}
+		addr.Port = int(p)
+	}
+	return addr, nil
+}
+
+// readMycnfOptions adds the mysqld options of the file at path to
+// options, with their keys normalized by normKey.
+func readMycnfOptions(path string, options map[string]string, depth int) error {
+	if depth > maxMycnfIncludeDepth {
+		return fmt.Errorf("too many nested includes at %v", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	inMysqld := true
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case strings.HasPrefix(line, "!includedir"):
+			dir := resolveMycnfPath(path, strings.TrimSpace(strings.TrimPrefix(line, "!includedir")))
+			files, err := filepath.Glob(filepath.Join(dir, "*.cnf"))
+			if err != nil {
+				return err
+			}
+			sort.Strings(files)
+			for _, file := range files {
+				if err := readMycnfOptions(file, options, depth+1); err != nil {
+					return err
+				}
+			}
+			continue
+		case strings.HasPrefix(line, "!include"):
+			file := resolveMycnfPath(path, strings.TrimSpace(strings.TrimPrefix(line, "!include")))
+			if err := readMycnfOptions(file, options, depth+1); err != nil {
+				return err
+			}
+			continue
+		case line[0] == '[':
+			group := strings.TrimSpace(strings.Trim(line, "[]"))
+			inMysqld = group == "mysqld" || group == "server"
+			continue
+		}
+		if !inMysqld {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) < 2 {
+			// Boolean options, like skip-name-resolve.
+			continue
+		}
+		value := strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+		options[normKey([]byte(parts[0]))] = value
+	}
+	return scanner.Err()
+}
+
+// resolveMycnfPath returns the path of an include of the file at
+// path, relative to its directory if it's not absolute.
+func resolveMycnfPath(path, include string) string {
+	if filepath.IsAbs(include) {
+		return include
+	}
+	return filepath.Join(filepath.Dir(path), include)
+}
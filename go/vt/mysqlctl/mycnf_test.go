@@ -6,6 +6,7 @@ package mysqlctl
 
 import (
 	"io/ioutil"
+	"os"
 	"path"
 	"testing"
 
@@ -54,3 +55,49 @@ func TestMycnf(t *testing.T) {
 		t.Logf("socket file %v", mycnf.SocketFile)
 	}
 }
+
+func TestMycnfFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mycnf")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"my.cnf":       "[client]\nport = 1\n\n[mysqld]\n# comment\nport = 3306\nskip-name-resolve\n!include extra.cnf\n!includedir conf.d\n",
+		"extra.cnf":    "[mysqld]\nsocket = \"/tmp/mysql.sock\"\n",
+		"conf.d/a.cnf": "[mysqld]\nport = 3307\n",
+		"conf.d/b.cnf": "[mysqld]\ndatadir = /data\n",
+	}
+	if err := os.Mkdir(path.Join(dir, "conf.d"), 0777); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(path.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	mycnf, err := MycnfFromFile(path.Join(dir, "my.cnf"))
+	if err != nil {
+		t.Fatalf("MycnfFromFile: %v", err)
+	}
+	want := MycnfAddr{Port: 3307, Socket: "/tmp/mysql.sock", DataDir: "/data"}
+	if *mycnf != want {
+		t.Errorf("want %+v, got %+v", want, *mycnf)
+	}
+
+	// Missing keys are left empty.
+	if err := ioutil.WriteFile(path.Join(dir, "empty.cnf"), []byte("[mysqld]\n"), 0666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if mycnf, err := MycnfFromFile(path.Join(dir, "empty.cnf")); err != nil || *mycnf != (MycnfAddr{}) {
+		t.Errorf("want an empty MycnfAddr, got %+v, %v", mycnf, err)
+	}
+
+	// A file that includes itself fails.
+	if err := ioutil.WriteFile(path.Join(dir, "loop.cnf"), []byte("!include loop.cnf\n"), 0666); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := MycnfFromFile(path.Join(dir, "loop.cnf")); err == nil {
+		t.Errorf("want an error for an include loop")
+	}
+}
@@ -0,0 +1,14 @@
+package servenv
+
+import (
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/logutil"
+)
+
+func init() {
+	onInit(func() {
+		if err := logutil.StartJSONLogs(); err != nil {
+			log.Errorf("%v", err)
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package servenv
+
+import (
+	"fmt"
+	"net/http"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/logutil"
+)
+
+func init() {
+	onInit(func() {
+		// /debug/log_level shows the minimum level of the logs written
+		// to stderr, and a POST with level=DEBUG|INFO|WARNING|ERROR changes it.
+		http.HandleFunc("/debug/log_level", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "POST" {
+				level, err := logutil.ParseLevel(r.FormValue("level"))
+				if err == nil {
+					err = logutil.SetLevel(level)
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				log.Infof("log level set to %v", level)
+			}
+			fmt.Fprint(w, logutil.GetLevel())
+		})
+	})
+}
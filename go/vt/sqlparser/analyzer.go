@@ -39,3 +39,49 @@ func extractDBName(node *Node) string {
 	}
 	return string(node.At(0).Value)
 }
+
+// GetTableNames parses sql and returns the names of the tables it
+// uses, without their db name, in the order they appear. Unlike the
+// identifiers of the query, they don't include the column names.
+// It returns an error if parsing fails.
+func GetTableNames(sql string) ([]string, error) {
+	rootNode, err := Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	switch rootNode.Type {
+	case CREATE, ALTER, DROP, RENAME:
+		for _, sub := range rootNode.Sub {
+			names = append(names, string(sub.Value))
+		}
+	default:
+		rootNode.collectTableNames(&names)
+	}
+	return names, nil
+}
+
+func (node *Node) collectTableNames(names *[]string) {
+	var table *Node
+	switch node.Type {
+	case INSERT:
+		table = node.At(INSERT_TABLE_OFFSET)
+	case UPDATE:
+		table = node.At(UPDATE_TABLE_OFFSET)
+	case DELETE:
+		table = node.At(DELETE_TABLE_OFFSET)
+	case TABLE_EXPR:
+		table = node.At(0)
+	}
+	if table != nil {
+		if table.Type == '.' {
+			table = table.At(1)
+		}
+		if table.Type == ID {
+			*names = append(*names, string(table.Value))
+		}
+	}
+	for _, sub := range node.Sub {
+		sub.collectTableNames(names)
+	}
+}
@@ -4,7 +4,10 @@
 
 package sqlparser
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestGetDBName(t *testing.T) {
 	wantYes := []string{
@@ -51,3 +54,35 @@ func TestGetDBName(t *testing.T) {
 		t.Logf("expected error: %v", err)
 	}
 }
+
+func TestGetTableNames(t *testing.T) {
+	for _, tc := range []struct {
+		sql  string
+		want []string
+	}{
+		{"select a, b from t1 where c = 1", []string{"t1"}},
+		{"select t1.a from db.t1 join t2 on t1.a = t2.a", []string{"t1", "t2"}},
+		{"select a from t1 where b in (select t2 from t2 where t3 = 1)", []string{"t1", "t2"}},
+		{"insert into t1(t2) values (1)", []string{"t1"}},
+		{"update db.t1 set t2 = 1", []string{"t1"}},
+		{"delete from t1 where t2 = 1", []string{"t1"}},
+		{"create table t1 (t2 int)", []string{"t1"}},
+		{"alter table t1 add column t2 int", []string{"t1"}},
+		{"create index i on t1 (t2)", []string{"t1"}},
+		{"drop table if exists t1", []string{"t1"}},
+		{"rename table t1 to t2", []string{"t1", "t2"}},
+		{"select 1 from dual", []string{"dual"}},
+	} {
+		got, err := GetTableNames(tc.sql)
+		if err != nil {
+			t.Errorf("GetTableNames(%q): %v", tc.sql, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("GetTableNames(%q) = %v, want %v", tc.sql, got, tc.want)
+		}
+	}
+	if _, err := GetTableNames("not sql"); err == nil {
+		t.Errorf("GetTableNames: want an error for invalid sql")
+	}
+}
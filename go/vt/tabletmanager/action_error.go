@@ -0,0 +1,71 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// The kinds of ActionError.
+var (
+	// ErrActionBinaryMissing is returned when the vtaction binary
+	// can't be found or run.
+	ErrActionBinaryMissing = errors.New("vtaction binary missing")
+
+	// ErrActionTimeout is returned when vtaction was killed after
+	// running for longer than ActionTimeout.
+	ErrActionTimeout = errors.New("action timed out")
+
+	// ErrActionCancelled is returned when vtaction was stopped by
+	// a CancelAction.
+	ErrActionCancelled = errors.New("action cancelled")
+
+	// ErrActionFailed is returned when vtaction exited with an
+	// error, usually after recording it in the action node.
+	ErrActionFailed = errors.New("action failed")
+)
+
+// ActionError is the error returned when the agent couldn't run an
+// action to completion.
+type ActionError struct {
+	// Kind is one of the ErrAction* errors above.
+	Kind error
+	// ExitStatus is the exit status of vtaction, -1 if it didn't
+	// run or was killed by a signal.
+	ExitStatus int
+	Message    string
+}
+
+func (e *ActionError) Error() string {
+	return e.Message
+}
+
+func newActionError(kind error, exitStatus int, format string, args ...interface{}) *ActionError {
+	return &ActionError{Kind: kind, ExitStatus: exitStatus, Message: fmt.Sprintf(format, args...)}
+}
+
+// IsActionError returns true if err is an ActionError of the given kind.
+func IsActionError(err error, kind error) bool {
+	ae, ok := err.(*ActionError)
+	return ok && ae.Kind == kind
+}
+
+// actionExitError returns the ActionError for the error returned by
+// cmd.Wait, or nil if it is nil.
+func actionExitError(err error) error {
+	if err == nil {
+		return nil
+	}
+	exitStatus := -1
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			exitStatus = status.ExitStatus()
+		}
+	}
+	return newActionError(ErrActionFailed, exitStatus, "%v: %v", ErrActionFailed, err)
+}
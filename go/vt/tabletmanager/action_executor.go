@@ -0,0 +1,88 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+import (
+	"os/exec"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/dbconfigs"
+	"github.com/youtube/vitess/go/vt/logutil"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// ActionExecutor runs the actions the agent dispatches from its
+// action queue.
+type ActionExecutor interface {
+	// Execute runs actionNode, at actionNode.Path in the queue. It
+	// returns how the action ran, also when it failed, with
+	// Interrupted set if the action was stopped before it could
+	// record its failure in the action node.
+	Execute(actionNode *actionnode.ActionNode) (*actionnode.ActionResult, error)
+}
+
+// vtActionExecutor is the default ActionExecutor, it runs each action
+// in a vtaction process.
+type vtActionExecutor struct {
+	agent *ActionAgent
+}
+
+// Execute runs a vtaction process for actionNode. If the process
+// dies before recording a result, it is retried up to
+// ActionRetryCount times, unless the action is NonIdempotent or
+// vtaction can't be run at all. Errors are ActionErrors.
+func (vae *vtActionExecutor) Execute(actionNode *actionnode.ActionNode) (*actionnode.ActionResult, error) {
+	agent := vae.agent
+	cmd, priority := agent.vtActionCommand(actionNode)
+
+	var vtActionCmd *exec.Cmd
+	var output *actionOutput
+	var interrupted bool
+	var err error
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		if attempt == 0 {
+			log.Infof("action launch %v with priority %v", cmd, priority)
+		} else {
+			if attempt == 1 {
+				// The previous vtaction may have claimed the node
+				// before dying: -force makes the next one take it over.
+				cmd = append(cmd, "-force")
+			}
+			log.Infof("action retry %v of %v: launch %v with priority %v", attempt, agent.ActionRetryCount, cmd, priority)
+		}
+		vtActionCmd = exec.Command(cmd[0], cmd[1:]...)
+		output, interrupted, err = agent.runAction(vtActionCmd, priority, actionNode.ActionGuid)
+		if err == nil || interrupted || IsActionError(err, ErrActionBinaryMissing) || actionNode.NonIdempotent || attempt >= agent.ActionRetryCount || !actionUnfinished(agent.TopoServer, actionNode.Path) {
+			break
+		}
+		delay := retryDelay(agent.ActionRetryBackoff, attempt)
+		log.Warningf("action attempt %v failed: %v %v, retrying in %v\n%s", attempt+1, actionNode.Path, err, delay, output)
+		actionRetries.Add(actionNode.Action, 1)
+		time.Sleep(delay)
+	}
+	result := newActionResult(vtActionCmd, output, start, time.Now(), err)
+	result.Interrupted = interrupted
+	return result, err
+}
+
+// vtActionCommand returns the vtaction command line that runs
+// actionNode, and the priority it runs with.
+func (agent *ActionAgent) vtActionCommand(actionNode *actionnode.ActionNode) ([]string, actionPriority) {
+	cmd := []string{
+		agent.vtActionBinFile,
+		"-action", actionNode.Action,
+		"-action-node", actionNode.Path,
+		"-action-guid", actionNode.ActionGuid,
+		"-mycnf-file", agent.Mysqld.MycnfPath(),
+	}
+	cmd = append(cmd, logutil.GetSubprocessFlags()...)
+	cmd = append(cmd, topo.GetSubprocessFlags()...)
+	cmd = append(cmd, dbconfigs.GetSubprocessFlags()...)
+	priority := actionPriorityFor(actionNode.Action)
+	return priority.command(cmd), priority
+}
@@ -0,0 +1,80 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+
+	"github.com/youtube/vitess/go/flagutil"
+)
+
+var (
+	vtActionNice        = flag.Int("vtaction_nice", 0, "nice increment to run vtaction with, so heavy actions like snapshots don't starve query serving of CPU (0 to keep the agent's)")
+	vtActionIoniceClass = flag.Int("vtaction_ionice_class", 0, "ionice scheduling class to run vtaction with: 1 for realtime, 2 for best-effort, 3 for idle (0 to keep the agent's)")
+	vtActionIoniceLevel = flag.Int("vtaction_ionice_level", 7, "ionice priority in the realtime and best-effort classes, from 0 (highest) to 7")
+	vtActionCgroup      = flag.String("vtaction_cgroup", "", "cgroup directory vtaction is moved to when it starts, e.g. /sys/fs/cgroup/cpu/vtaction")
+	vtActionUrgent      flagutil.StringListValue
+)
+
+func init() {
+	flag.Var(&vtActionUrgent, "vtaction_urgent_actions", "comma separated list of actions that run with the agent's priority and cgroup, ignoring -vtaction_nice, -vtaction_ionice_class and -vtaction_cgroup")
+}
+
+// actionPriority is the CPU and IO priority a vtaction runs with.
+// The zero value runs it like the agent.
+type actionPriority struct {
+	nice        int
+	ioniceClass int
+	ioniceLevel int
+	cgroup      string
+}
+
+func (p actionPriority) String() string {
+	return fmt.Sprintf("nice %v, ionice class %v level %v, cgroup %q", p.nice, p.ioniceClass, p.ioniceLevel, p.cgroup)
+}
+
+// actionPriorityFor returns the priority to run action with.
+func actionPriorityFor(action string) actionPriority {
+	for _, urgent := range vtActionUrgent {
+		if urgent == action {
+			return actionPriority{}
+		}
+	}
+	return actionPriority{
+		nice:        *vtActionNice,
+		ioniceClass: *vtActionIoniceClass,
+		ioniceLevel: *vtActionIoniceLevel,
+		cgroup:      *vtActionCgroup,
+	}
+}
+
+// command returns the command line running cmd with the priority,
+// through nice and ionice. Both exec the command, so it keeps their pid.
+func (p actionPriority) command(cmd []string) []string {
+	if p.ioniceClass != 0 {
+		prefix := []string{"ionice", "-c", strconv.Itoa(p.ioniceClass)}
+		// The idle class has no levels.
+		if p.ioniceClass != 3 {
+			prefix = append(prefix, "-n", strconv.Itoa(p.ioniceLevel))
+		}
+		cmd = append(prefix, cmd...)
+	}
+	if p.nice != 0 {
+		cmd = append([]string{"nice", "-n", strconv.Itoa(p.nice)}, cmd...)
+	}
+	return cmd
+}
+
+// joinCgroup moves the process pid to the cgroup, if there is one.
+func (p actionPriority) joinCgroup(pid int) error {
+	if p.cgroup == "" {
+		return nil
+	}
+	return ioutil.WriteFile(path.Join(p.cgroup, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+}
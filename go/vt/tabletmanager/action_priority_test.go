@@ -0,0 +1,41 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+)
+
+func TestActionPriority(t *testing.T) {
+	defer func(nice, class int) {
+		*vtActionNice, *vtActionIoniceClass, vtActionUrgent = nice, class, nil
+	}(*vtActionNice, *vtActionIoniceClass)
+	cmd := []string{"vtaction", "-action", actionnode.TABLET_ACTION_SNAPSHOT}
+
+	if got := actionPriorityFor(actionnode.TABLET_ACTION_SNAPSHOT).command(cmd); !reflect.DeepEqual(got, cmd) {
+		t.Errorf("want %v by default, got %v", cmd, got)
+	}
+
+	*vtActionNice = 10
+	*vtActionIoniceClass = 2
+	vtActionUrgent.Set(actionnode.TABLET_ACTION_SET_RDONLY + "," + actionnode.TABLET_ACTION_SCRAP)
+	want := []string{"nice", "-n", "10", "ionice", "-c", "2", "-n", "7", "vtaction", "-action", actionnode.TABLET_ACTION_SNAPSHOT}
+	if got := actionPriorityFor(actionnode.TABLET_ACTION_SNAPSHOT).command(cmd); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if got := actionPriorityFor(actionnode.TABLET_ACTION_SCRAP).command(cmd); !reflect.DeepEqual(got, cmd) {
+		t.Errorf("want %v for an urgent action, got %v", cmd, got)
+	}
+
+	// The idle class has no levels.
+	*vtActionIoniceClass = 3
+	want = []string{"nice", "-n", "10", "ionice", "-c", "3", "vtaction", "-action", actionnode.TABLET_ACTION_SNAPSHOT}
+	if got := actionPriorityFor(actionnode.TABLET_ACTION_SNAPSHOT).command(cmd); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
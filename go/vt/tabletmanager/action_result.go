@@ -0,0 +1,223 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"syscall"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// actionOutput captures the output of a vtaction process. Only its
+// first and last max bytes are kept, so a chatty action can't use up
+// the agent memory.
+type actionOutput struct {
+	max     int // 0 for no limit
+	head    []byte
+	tail    []byte
+	dropped int64
+}
+
+func newActionOutput(max int) *actionOutput {
+	return &actionOutput{max: max}
+}
+
+func (ao *actionOutput) Write(p []byte) (int, error) {
+	n := len(p)
+	if ao.max <= 0 {
+		ao.head = append(ao.head, p...)
+		return n, nil
+	}
+	if len(ao.head) < ao.max {
+		keep := ao.max - len(ao.head)
+		if keep > len(p) {
+			keep = len(p)
+		}
+		ao.head = append(ao.head, p[:keep]...)
+		p = p[keep:]
+	}
+	ao.tail = append(ao.tail, p...)
+	if len(ao.tail) > 2*ao.max {
+		ao.dropped += int64(len(ao.tail) - ao.max)
+		ao.tail = append([]byte(nil), ao.tail[len(ao.tail)-ao.max:]...)
+	}
+	return n, nil
+}
+
+// Truncated returns true if part of the output was dropped.
+func (ao *actionOutput) Truncated() bool {
+	return ao.dropped > 0 || len(ao.tail) > ao.max
+}
+
+// Bytes returns the kept output, with a marker where
+// the dropped part was.
+func (ao *actionOutput) Bytes() []byte {
+	result := append([]byte(nil), ao.head...)
+	tail := ao.tail
+	if ao.Truncated() {
+		dropped := ao.dropped + int64(len(tail)-ao.max)
+		tail = tail[len(tail)-ao.max:]
+		result = append(result, fmt.Sprintf("\n[... %v bytes of output truncated ...]\n", dropped)...)
+	}
+	return append(result, tail...)
+}
+
+func (ao *actionOutput) String() string {
+	return string(ao.Bytes())
+}
+
+// maxActionLogLine is the longest vtaction output line actionLogger
+// logs at once, longer ones are split.
+const maxActionLogLine = 4096
+
+// actionLogger logs the output of a vtaction process as it runs, one
+// line at a time prefixed with the action guid, so operators can
+// follow long actions. Everything is also written to output.
+type actionLogger struct {
+	actionGuid string
+	output     io.Writer
+	line       []byte
+}
+
+func newActionLogger(actionGuid string, output io.Writer) *actionLogger {
+	return &actionLogger{actionGuid: actionGuid, output: output}
+}
+
+func (al *actionLogger) Write(p []byte) (int, error) {
+	if _, err := al.output.Write(p); err != nil {
+		return 0, err
+	}
+	al.line = append(al.line, p...)
+	for {
+		i := bytes.IndexByte(al.line, '\n')
+		if i < 0 {
+			break
+		}
+		log.Infof("action %v: %s", al.actionGuid, al.line[:i])
+		al.line = al.line[i+1:]
+	}
+	if len(al.line) >= maxActionLogLine {
+		al.Flush()
+	}
+	return len(p), nil
+}
+
+// Flush logs the last line, if it didn't end with a newline.
+func (al *actionLogger) Flush() {
+	if len(al.line) > 0 {
+		log.Infof("action %v: %s", al.actionGuid, al.line)
+		al.line = nil
+	}
+}
+
+// newActionResult returns the result of the vtaction process cmd,
+// which ran from start to end and returned err.
+func newActionResult(cmd *exec.Cmd, output *actionOutput, start, end time.Time, err error) *actionnode.ActionResult {
+	result := &actionnode.ActionResult{
+		ExitStatus: -1,
+		Start:      start,
+		End:        end,
+	}
+	if cmd.ProcessState != nil {
+		if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+			result.ExitStatus = status.ExitStatus()
+		}
+	}
+	if output != nil {
+		result.Output = output.String()
+		result.OutputTruncated = output.Truncated()
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// WriteActionResult records result in the action at actionPath. If the
+// action is still queued (its vtaction died before completing it),
+// the action node is updated, otherwise its completed copy is, where
+// the client that queued it reads it from.
+func WriteActionResult(ts topo.Server, actionPath string, result *actionnode.ActionResult) error {
+	_, data, _, err := ts.ReadTabletActionPath(actionPath)
+	queued := err == nil
+	if err == topo.ErrNoNode {
+		// vtaction stores the completed copy before removing
+		// the action node, so it is there already.
+		data, err = ts.WaitForTabletAction(actionPath, 10*time.Second, nil)
+	}
+	if err != nil {
+		return err
+	}
+	actionNode, err := actionnode.ActionNodeFromJson(data, actionPath)
+	if err != nil {
+		return err
+	}
+	actionNode.Result = result
+	if queued {
+		return ts.UpdateTabletAction(actionPath, actionNode.ToJson(), -1)
+	}
+	return ts.StoreTabletActionResult(actionPath, actionNode.ToJson())
+}
+
+// resultRetryInterval is how long flushResults waits between two
+// attempts at writing the pending results.
+const resultRetryInterval = 100 * time.Millisecond
+
+// pendingResult is an action result write that failed, usually on a
+// topology server blip, to be retried.
+type pendingResult struct {
+	actionPath string
+	write      func() error
+}
+
+// writeResult runs write, which writes the result of the action at
+// actionPath. If it fails, it's kept to be retried by flushResults,
+// so the result isn't lost: the client that queued the action is
+// waiting for it.
+func (agent *ActionAgent) writeResult(actionPath string, write func() error) {
+	if err := write(); err != nil {
+		log.Errorf("cannot write the result of action %v, will retry: %v", actionPath, err)
+		agent.resultsMu.Lock()
+		agent.pendingResults = append(agent.pendingResults, pendingResult{actionPath, write})
+		agent.resultsMu.Unlock()
+	}
+}
+
+// flushResults retries the pending result writes until they all
+// succeed, or for up to timeout. Each one is tried at least once. It
+// returns how many are still pending.
+func (agent *ActionAgent) flushResults(timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		agent.resultsMu.Lock()
+		pending := agent.pendingResults
+		agent.pendingResults = nil
+		agent.resultsMu.Unlock()
+
+		var failed []pendingResult
+		for _, pr := range pending {
+			if err := pr.write(); err != nil {
+				log.Warningf("cannot write the result of action %v: %v", pr.actionPath, err)
+				failed = append(failed, pr)
+			}
+		}
+
+		agent.resultsMu.Lock()
+		agent.pendingResults = append(agent.pendingResults, failed...)
+		count := len(agent.pendingResults)
+		agent.resultsMu.Unlock()
+		if count == 0 || !time.Now().Add(resultRetryInterval).Before(deadline) {
+			return count
+		}
+		time.Sleep(resultRetryInterval)
+	}
+}
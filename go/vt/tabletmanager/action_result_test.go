@@ -0,0 +1,178 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+)
+
+func TestActionOutput(t *testing.T) {
+	output := newActionOutput(0)
+	fmt.Fprintf(output, "no limit")
+	if output.String() != "no limit" || output.Truncated() {
+		t.Errorf("unexpected output: %q %v", output, output.Truncated())
+	}
+
+	output = newActionOutput(4)
+	fmt.Fprintf(output, "1234")
+	fmt.Fprintf(output, "5678")
+	if output.String() != "12345678" || output.Truncated() {
+		t.Errorf("unexpected output: %q %v", output, output.Truncated())
+	}
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(output, "%v", i)
+	}
+	if want := "1234\n[... 10 bytes of output truncated ...]\n6789"; output.String() != want || !output.Truncated() {
+		t.Errorf("want %q, got %q %v", want, output, output.Truncated())
+	}
+
+	// one big write
+	output = newActionOutput(2)
+	fmt.Fprintf(output, "abcdefgh")
+	if want := "ab\n[... 4 bytes of output truncated ...]\ngh"; output.String() != want {
+		t.Errorf("want %q, got %q", want, output)
+	}
+}
+
+func TestActionLogger(t *testing.T) {
+	output := newActionOutput(0)
+	logger := newActionLogger("guid", output)
+	fmt.Fprintf(logger, "line1\nline2\nli")
+	if string(logger.line) != "li" {
+		t.Errorf("pending line: %q", logger.line)
+	}
+	fmt.Fprintf(logger, "ne3\n")
+	if len(logger.line) != 0 {
+		t.Errorf("pending line: %q", logger.line)
+	}
+	fmt.Fprintf(logger, "%v", strings.Repeat("x", maxActionLogLine))
+	if len(logger.line) != 0 {
+		t.Errorf("long line wasn't logged: %v pending bytes", len(logger.line))
+	}
+	fmt.Fprintf(logger, "last")
+	logger.Flush()
+	if len(logger.line) != 0 {
+		t.Errorf("pending line after Flush: %q", logger.line)
+	}
+	if want := "line1\nline2\nline3\n" + strings.Repeat("x", maxActionLogLine) + "last"; output.String() != want {
+		t.Errorf("output = %q", output)
+	}
+}
+
+func TestNewActionResult(t *testing.T) {
+	start := time.Now()
+	cmd := exec.Command("sh", "-c", "echo failing; exit 3")
+	output := newActionOutput(1024)
+	cmd.Stdout = output
+	err := cmd.Run()
+	result := newActionResult(cmd, output, start, time.Now(), err)
+	if result.ExitStatus != 3 || result.Output != "failing\n" || result.OutputTruncated || result.Error != "exit status 3" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// didn't start
+	result = newActionResult(exec.Command("sh"), nil, start, time.Now(), fmt.Errorf("no sh"))
+	if result.ExitStatus != -1 || result.Error != "no sh" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// truncated output
+	output = newActionOutput(2)
+	fmt.Fprintf(output, "123456")
+	result = newActionResult(exec.Command("sh"), output, start, time.Now(), nil)
+	if !strings.HasPrefix(result.Output, "12\n[...") || !result.OutputTruncated || result.Error != "" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestWriteActionResult(t *testing.T) {
+	ts, tabletAlias := createTestTablet(t, nil)
+	node := &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING}
+	actionPath, err := ts.WriteTabletAction(tabletAlias, node.ToJson())
+	if err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	readResult := func(data string) *actionnode.ActionResult {
+		node, err := actionnode.ActionNodeFromJson(data, actionPath)
+		if err != nil {
+			t.Fatalf("ActionNodeFromJson: %v", err)
+		}
+		return node.Result
+	}
+
+	// vtaction died before completing the action
+	result := &actionnode.ActionResult{ExitStatus: 1, Output: "cannot connect", Error: "exit status 1"}
+	if err := WriteActionResult(ts, actionPath, result); err != nil {
+		t.Fatalf("WriteActionResult: %v", err)
+	}
+	_, data, _, err := ts.ReadTabletActionPath(actionPath)
+	if err != nil {
+		t.Fatalf("ReadTabletActionPath: %v", err)
+	}
+	if got := readResult(data); got == nil || got.Output != "cannot connect" {
+		t.Errorf("queued action has result %+v", got)
+	}
+
+	// vtaction completed the action, the client reads the result
+	if err := StoreActionResponse(ts, node, actionPath, nil); err != nil {
+		t.Fatalf("StoreActionResponse: %v", err)
+	}
+	if err := ts.UnblockTabletAction(actionPath); err != nil {
+		t.Fatalf("UnblockTabletAction: %v", err)
+	}
+	result = &actionnode.ActionResult{ExitStatus: 0, Output: "pong"}
+	if err := WriteActionResult(ts, actionPath, result); err != nil {
+		t.Fatalf("WriteActionResult: %v", err)
+	}
+	data, err = ts.WaitForTabletAction(actionPath, time.Second, nil)
+	if err != nil {
+		t.Fatalf("WaitForTabletAction: %v", err)
+	}
+	if got := readResult(data); got == nil || got.Output != "pong" || !strings.Contains(data, `"State": "Done"`) {
+		t.Errorf("completed action has result %+v: %v", got, data)
+	}
+}
+
+func TestFlushResults(t *testing.T) {
+	agent := &ActionAgent{}
+	if lost := agent.flushResults(time.Second); lost != 0 {
+		t.Errorf("flushResults with nothing pending = %v, want 0", lost)
+	}
+
+	// The first write and a retry fail, the next retry succeeds.
+	attempts := 0
+	agent.writeResult("path1", func() error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("topology server blip")
+		}
+		return nil
+	})
+	if lost := agent.flushResults(0); lost != 1 {
+		t.Errorf("flushResults(0) = %v, want 1", lost)
+	}
+	if lost := agent.flushResults(time.Second); lost != 0 {
+		t.Errorf("flushResults = %v, want 0", lost)
+	}
+	if attempts != 3 {
+		t.Errorf("got %v attempts, want 3", attempts)
+	}
+
+	// A write that always fails is given up on after the timeout.
+	agent.writeResult("path2", func() error { return fmt.Errorf("topology server down") })
+	start := time.Now()
+	if lost := agent.flushResults(300 * time.Millisecond); lost != 1 {
+		t.Errorf("flushResults = %v, want 1", lost)
+	}
+	if elapsed := time.Now().Sub(start); elapsed > time.Second {
+		t.Errorf("flushResults took %v, want at most the timeout", elapsed)
+	}
+}
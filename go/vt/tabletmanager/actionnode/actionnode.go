@@ -11,8 +11,10 @@ package actionnode
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"os/user"
+	"regexp"
 	"strings"
 	"time"
 
@@ -54,6 +56,11 @@ const (
 	// topology record.
 	TABLET_ACTION_SLAVE_WAS_RESTARTED = "SlaveWasRestarted"
 
+	// CheckReplication compares the tablet's parent and replication
+	// graph entry with the master MySQL is actually replicating
+	// from. It only reports discrepancies, unless asked to repair them.
+	TABLET_ACTION_CHECK_REPLICATION = "CheckReplication"
+
 	// StopSlave will stop MySQL replication.
 	TABLET_ACTION_STOP_SLAVE = "StopSlave"
 
@@ -120,6 +127,7 @@ const (
 	KEYSPACE_ACTION_APPLY_SCHEMA        = "ApplySchemaKeyspace"
 	KEYSPACE_ACTION_SET_SHARDING_INFO   = "SetKeyspaceShardingInfo"
 	KEYSPACE_ACTION_MIGRATE_SERVED_FROM = "MigrateServedFrom"
+	KEYSPACE_ACTION_SET_MIGRATING       = "SetKeyspaceMigratingTables"
 
 	ACTION_STATE_QUEUED  = ActionState("")        // All actions are queued initially
 	ACTION_STATE_RUNNING = ActionState("Running") // Running inside vtaction process
@@ -139,6 +147,29 @@ type ActionNode struct {
 	State      ActionState
 	Pid        int // only != 0 if State == ACTION_STATE_RUNNING
 
+	// CallbackUrl, if set, is where the agent POSTs the result
+	// of the action when it completes.
+	CallbackUrl string `json:",omitempty"`
+
+	// NonIdempotent actions are never retried by the agent when
+	// their vtaction dies, as running them twice is not safe.
+	NonIdempotent bool `json:",omitempty"`
+
+	// ParallelSafe actions only read the tablet state, so the agent
+	// can run them at the same time as other ParallelSafe ones.
+	ParallelSafe bool `json:",omitempty"`
+
+	// ExpireTime, in unix nanoseconds, is when the action expires
+	// if it's still queued: the agent then completes it with an
+	// Expired result instead of running it, so the actions queued
+	// by clients that went away don't linger. 0 for never, see
+	// SetExpiry.
+	ExpireTime int64 `json:",omitempty"`
+
+	// Result is how the vtaction process running the action did,
+	// as recorded by the agent once it exits.
+	Result *ActionResult `json:",omitempty"`
+
 	// do not serialize the next fields
 	// path in topology server representing this action
 	Path  string      `json:"-"`
@@ -146,6 +177,35 @@ type ActionNode struct {
 	Reply interface{} `json:"-"`
 }
 
+// ActionResult is how the vtaction process running an action did.
+type ActionResult struct {
+	// ExitStatus is the exit status of vtaction, -1 if it
+	// didn't start or was killed by a signal.
+	ExitStatus int
+
+	// Output is the combined stdout and stderr of vtaction.
+	// OutputTruncated is set if only its beginning and end were
+	// kept.
+	Output          string
+	OutputTruncated bool `json:",omitempty"`
+
+	Start time.Time
+	End   time.Time
+	Error string `json:",omitempty"`
+
+	// Interrupted is set if the action was stopped before it
+	// completed, on timeout or cancellation.
+	Interrupted bool `json:",omitempty"`
+
+	// DryRun is set if the agent was in dry-run mode: it only
+	// logged the action, which didn't run.
+	DryRun bool `json:",omitempty"`
+
+	// Expired is set if the action expired before the agent got
+	// to it, so it didn't run (see ActionNode.ExpireTime).
+	Expired bool `json:",omitempty"`
+}
+
 // ActionNodeFromJson interprets the data from JSON.
 func ActionNodeFromJson(data, path string) (*ActionNode, error) {
 	decoder := json.NewDecoder(strings.NewReader(data))
@@ -176,6 +236,9 @@ func ActionNodeFromJson(data, path string) (*ActionNode, error) {
 		node.Args = &RestartSlaveData{}
 	case TABLET_ACTION_SLAVE_WAS_RESTARTED:
 		node.Args = &SlaveWasRestartedArgs{}
+	case TABLET_ACTION_CHECK_REPLICATION:
+		node.Args = &CheckReplicationArgs{}
+		node.Reply = &CheckReplicationReply{}
 	case TABLET_ACTION_BREAK_SLAVES:
 	case TABLET_ACTION_REPARENT_POSITION:
 		node.Args = &myproto.ReplicationPosition{}
@@ -228,6 +291,7 @@ func ActionNodeFromJson(data, path string) (*ActionNode, error) {
 	case KEYSPACE_ACTION_SET_SHARDING_INFO:
 	case KEYSPACE_ACTION_MIGRATE_SERVED_FROM:
 		node.Args = &MigrateServedFromArgs{}
+	case KEYSPACE_ACTION_SET_MIGRATING:
 
 	case TABLET_ACTION_SET_BLACKLISTED_TABLES, TABLET_ACTION_GET_SCHEMA,
 		TABLET_ACTION_RELOAD_SCHEMA, TABLET_ACTION_GET_PERMISSIONS,
@@ -268,6 +332,79 @@ func ActionNodeFromJson(data, path string) (*ActionNode, error) {
 	return node, nil
 }
 
+// actionGuidRegexp matches the guids SetGuid generates:
+// <RFC3339 time>-<user>-<host>.
+var actionGuidRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(Z|[+-]\d{2}:\d{2})-[^\s]+-[^\s]+$`)
+
+// Validate checks a tablet action decoded by ActionNodeFromJson can
+// be run: it is one vtaction knows, has a guid made by SetGuid, and
+// the arguments it can't run without.
+func (n *ActionNode) Validate() error {
+	if !actionGuidRegexp.MatchString(n.ActionGuid) {
+		return fmt.Errorf("invalid action guid %q", n.ActionGuid)
+	}
+	switch n.Action {
+	case TABLET_ACTION_PING, TABLET_ACTION_SLEEP, TABLET_ACTION_SET_RDONLY,
+		TABLET_ACTION_SET_RDWR, TABLET_ACTION_DEMOTE_MASTER,
+		TABLET_ACTION_PROMOTE_SLAVE, TABLET_ACTION_SLAVE_WAS_PROMOTED,
+		TABLET_ACTION_RESTART_SLAVE, TABLET_ACTION_SLAVE_WAS_RESTARTED,
+		TABLET_ACTION_CHECK_REPLICATION, TABLET_ACTION_BREAK_SLAVES,
+		TABLET_ACTION_REPARENT_POSITION, TABLET_ACTION_SCRAP,
+		TABLET_ACTION_SNAPSHOT, TABLET_ACTION_SNAPSHOT_SOURCE_END:
+	case TABLET_ACTION_CHANGE_TYPE:
+		if *n.Args.(*topo.TabletType) == "" {
+			return fmt.Errorf("%v action without a tablet type", n.Action)
+		}
+	case TABLET_ACTION_PREFLIGHT_SCHEMA:
+		if *n.Args.(*string) == "" {
+			return fmt.Errorf("%v action without a schema change", n.Action)
+		}
+	case TABLET_ACTION_APPLY_SCHEMA:
+		if n.Args.(*myproto.SchemaChange).Sql == "" {
+			return fmt.Errorf("%v action without a schema change", n.Action)
+		}
+	case TABLET_ACTION_EXECUTE_HOOK:
+		if n.Args.(*hook.Hook).Name == "" {
+			return fmt.Errorf("%v action without a hook name", n.Action)
+		}
+	case TABLET_ACTION_RESERVE_FOR_RESTORE:
+		if n.Args.(*ReserveForRestoreArgs).SrcTabletAlias.Cell == "" {
+			return fmt.Errorf("%v action without a source tablet", n.Action)
+		}
+	case TABLET_ACTION_RESTORE:
+		args := n.Args.(*RestoreArgs)
+		if args.SrcTabletAlias.Cell == "" || args.SrcFilePath == "" {
+			return fmt.Errorf("%v action without a source tablet and file", n.Action)
+		}
+	case TABLET_ACTION_MULTI_SNAPSHOT:
+		if len(n.Args.(*MultiSnapshotArgs).KeyRanges) == 0 {
+			return fmt.Errorf("%v action without key ranges", n.Action)
+		}
+	case TABLET_ACTION_MULTI_RESTORE:
+		if len(n.Args.(*MultiRestoreArgs).SrcTabletAliases) == 0 {
+			return fmt.Errorf("%v action without source tablets", n.Action)
+		}
+	default:
+		return fmt.Errorf("%v is not a tablet action", n.Action)
+	}
+	return nil
+}
+
+// MutatesTablet returns false for the tablet actions that only read
+// the tablet and mysql state, after which the agent doesn't need to
+// reload its tablet record.
+func (n *ActionNode) MutatesTablet() bool {
+	switch n.Action {
+	case TABLET_ACTION_PING, TABLET_ACTION_SLEEP,
+		TABLET_ACTION_REPARENT_POSITION, TABLET_ACTION_PREFLIGHT_SCHEMA:
+		return false
+	case TABLET_ACTION_CHECK_REPLICATION:
+		args, ok := n.Args.(*CheckReplicationArgs)
+		return !ok || args.Repair
+	}
+	return true
+}
+
 // ToJson returns a JSON representation of the object.
 func (n *ActionNode) ToJson() string {
 	result := jscfg.ToJson(n) + "\n"
@@ -284,6 +421,19 @@ func (n *ActionNode) ToJson() string {
 	return result
 }
 
+// ValidateCallbackUrl returns an error if callbackUrl can't be used
+// as an ActionNode CallbackUrl.
+func ValidateCallbackUrl(callbackUrl string) error {
+	u, err := url.Parse(callbackUrl)
+	if err != nil {
+		return fmt.Errorf("invalid callback url %v: %v", callbackUrl, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback url %v: need an http or https url with a host", callbackUrl)
+	}
+	return nil
+}
+
 // SetGuid will set the ActionGuid field for the action node
 // and return the action node.
 func (n *ActionNode) SetGuid() *ActionNode {
@@ -300,6 +450,78 @@ func (n *ActionNode) SetGuid() *ActionNode {
 	return n
 }
 
+// SetExpiry makes the action expire after ttl, as measured by the
+// local clock.
+func (n *ActionNode) SetExpiry(ttl time.Duration) *ActionNode {
+	n.ExpireTime = time.Now().Add(ttl).UnixNano()
+	return n
+}
+
+// Expired returns true if the action expired by now, on the clock of
+// the agent. The expiry time was set on the clock of the client that
+// queued the action, which may be up to maxClockSkew ahead, so the
+// action only expires once now is that much past it.
+func (n *ActionNode) Expired(now time.Time, maxClockSkew time.Duration) bool {
+	return n.Header().Expired(now, maxClockSkew)
+}
+
+// Header returns what the action queue implementations need to know
+// about the action.
+func (n *ActionNode) Header() *topo.ActionHeader {
+	return &topo.ActionHeader{
+		Action:       n.Action,
+		ActionGuid:   n.ActionGuid,
+		Done:         n.State == ACTION_STATE_DONE,
+		Failed:       n.State == ACTION_STATE_FAILED,
+		Error:        n.Error,
+		ParallelSafe: n.ParallelSafe,
+		ExpireTime:   n.ExpireTime,
+	}
+}
+
+// QueueDecoder implements topo.ActionNodeDecoder for the action nodes
+// of the tablet queues.
+type QueueDecoder struct{}
+
+// decodeActionNode decodes the ActionNode in data, without the
+// arguments and reply that follow it, so it works even for the
+// actions ActionNodeFromJson doesn't know about.
+func decodeActionNode(data string) (*ActionNode, error) {
+	node := &ActionNode{}
+	if err := json.NewDecoder(strings.NewReader(data)).Decode(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// DecodeActionHeader is part of the topo.ActionNodeDecoder interface.
+func (QueueDecoder) DecodeActionHeader(data string) (*topo.ActionHeader, error) {
+	node, err := decodeActionNode(data)
+	if err != nil {
+		return nil, err
+	}
+	return node.Header(), nil
+}
+
+// ExpireActionNode is part of the topo.ActionNodeDecoder interface.
+// Only the ActionNode of data is kept, without its arguments.
+func (QueueDecoder) ExpireActionNode(data string, now time.Time) (string, error) {
+	node, err := decodeActionNode(data)
+	if err != nil {
+		return "", err
+	}
+	node.State = ACTION_STATE_FAILED
+	node.Error = fmt.Sprintf("expired at %v", time.Unix(0, node.ExpireTime))
+	node.Result = &ActionResult{
+		ExitStatus: -1,
+		Start:      now,
+		End:        now,
+		Error:      node.Error,
+		Expired:    true,
+	}
+	return node.ToJson(), nil
+}
+
 // ActionNodeCanBePurged returns true if that ActionNode can be purged
 // from the topology server.
 func ActionNodeCanBePurged(data string) bool {
@@ -0,0 +1,79 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package actionnode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/hook"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+func TestValidate(t *testing.T) {
+	tabletType := topo.TYPE_REPLICA
+	noTabletType := topo.TabletType("")
+	for _, c := range []struct {
+		node  *ActionNode
+		valid bool
+	}{
+		{(&ActionNode{Action: TABLET_ACTION_PING}).SetGuid(), true},
+		{&ActionNode{Action: TABLET_ACTION_PING, ActionGuid: "2014-05-06T10:11:12-07:00-user-host.domain"}, true},
+		{&ActionNode{Action: TABLET_ACTION_PING, ActionGuid: "2014-05-06T17:11:12Z-user-host"}, true},
+		{&ActionNode{Action: TABLET_ACTION_PING}, false},
+		{&ActionNode{Action: TABLET_ACTION_PING, ActionGuid: "guid"}, false},
+		{&ActionNode{Action: TABLET_ACTION_PING, ActionGuid: "2014-05-06T17:11:12Z-user name-host"}, false},
+		{(&ActionNode{Action: TABLET_ACTION_CHANGE_TYPE, Args: &tabletType}).SetGuid(), true},
+		{(&ActionNode{Action: TABLET_ACTION_CHANGE_TYPE, Args: &noTabletType}).SetGuid(), false},
+		{(&ActionNode{Action: TABLET_ACTION_EXECUTE_HOOK, Args: &hook.Hook{Name: "test"}}).SetGuid(), true},
+		{(&ActionNode{Action: TABLET_ACTION_EXECUTE_HOOK, Args: &hook.Hook{}}).SetGuid(), false},
+		{(&ActionNode{Action: TABLET_ACTION_MULTI_RESTORE, Args: &MultiRestoreArgs{}}).SetGuid(), false},
+		{(&ActionNode{Action: SHARD_ACTION_REBUILD}).SetGuid(), false},
+	} {
+		err := c.node.Validate()
+		if c.valid && err != nil {
+			t.Errorf("%v %v: %v", c.node.Action, c.node.ActionGuid, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%v %v: want an error", c.node.Action, c.node.ActionGuid)
+		}
+	}
+}
+
+func TestMutatesTablet(t *testing.T) {
+	tabletType := topo.TYPE_REPLICA
+	for _, c := range []struct {
+		node    *ActionNode
+		mutates bool
+	}{
+		{&ActionNode{Action: TABLET_ACTION_PING}, false},
+		{&ActionNode{Action: TABLET_ACTION_PREFLIGHT_SCHEMA}, false},
+		{&ActionNode{Action: TABLET_ACTION_CHECK_REPLICATION, Args: &CheckReplicationArgs{}}, false},
+		{&ActionNode{Action: TABLET_ACTION_CHECK_REPLICATION, Args: &CheckReplicationArgs{Repair: true}}, true},
+		{&ActionNode{Action: TABLET_ACTION_CHANGE_TYPE, Args: &tabletType}, true},
+		{&ActionNode{Action: TABLET_ACTION_APPLY_SCHEMA}, true},
+	} {
+		if got := c.node.MutatesTablet(); got != c.mutates {
+			t.Errorf("%v MutatesTablet() = %v, want %v", c.node.Action, got, c.mutates)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	if (&ActionNode{}).Expired(now, 0) {
+		t.Errorf("an action without expiry time expired")
+	}
+	node := &ActionNode{ExpireTime: now.Add(-2 * time.Minute).UnixNano()}
+	if !node.Expired(now, time.Minute) {
+		t.Errorf("action 2m past its expiry time with 1m of skew didn't expire")
+	}
+	if node.Expired(now, 5*time.Minute) {
+		t.Errorf("action 2m past its expiry time with 5m of skew expired")
+	}
+	if node := (&ActionNode{}).SetExpiry(time.Hour); node.Expired(now, 0) {
+		t.Errorf("action expiring in 1h expired")
+	}
+}
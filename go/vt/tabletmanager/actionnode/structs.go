@@ -47,6 +47,34 @@ type SlaveWasRestartedArgs struct {
 	// ContinueOnUnexpectedMaster bool
 }
 
+type CheckReplicationArgs struct {
+	// Repair will fix the tablet record and the replication graph
+	// to match the actual MySQL replication state.
+	Repair bool
+}
+
+type CheckReplicationReply struct {
+	// MasterAddr is the address MySQL is replicating from.
+	MasterAddr string
+
+	// Parent is the parent in the tablet record, ReplicationParent
+	// the parent in the replication graph (zero if the entry is
+	// missing), and ActualParent the tablet matching MasterAddr
+	// (zero if it couldn't be found).
+	Parent            topo.TabletAlias
+	ReplicationParent topo.TabletAlias
+	ActualParent      topo.TabletAlias
+
+	// Discrepancies lists what was found to be inconsistent.
+	Discrepancies []string
+
+	// Unresolved lists the discrepancies a repair couldn't fix.
+	Unresolved []string
+
+	// Repaired is set if a repair fixed all the discrepancies.
+	Repaired bool
+}
+
 type SnapshotArgs struct {
 	Concurrency int
 	ServerMode  bool
@@ -213,6 +241,12 @@ func SetKeyspaceShardingInfo() *ActionNode {
 	}).SetGuid()
 }
 
+func SetKeyspaceMigratingTables() *ActionNode {
+	return (&ActionNode{
+		Action: KEYSPACE_ACTION_SET_MIGRATING,
+	}).SetGuid()
+}
+
 func ApplySchemaKeyspace(change string, simple bool) *ActionNode {
 	return (&ActionNode{
 		Action: KEYSPACE_ACTION_APPLY_SCHEMA,
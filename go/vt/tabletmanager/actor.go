@@ -5,6 +5,7 @@
 package tabletmanager
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -19,6 +20,8 @@ import (
 	"time"
 
 	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/jscfg"
+	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/tb"
 	"github.com/youtube/vitess/go/vt/concurrency"
 	"github.com/youtube/vitess/go/vt/hook"
@@ -41,6 +44,10 @@ import (
 
 type TabletActorError string
 
+// actionDryRuns counts the actions replayed by DryRunAction, by action.
+// Dry runs are never counted as, or logged like, real dispatches.
+var actionDryRuns = stats.NewCounters("ActionDryRuns")
+
 func (e TabletActorError) Error() string {
 	return string(e)
 }
@@ -145,9 +152,92 @@ func (ta *TabletActor) HandleAction(actionPath, action, actionGuid string, force
 		log.Errorf("HandleAction failed unblocking: %v", err)
 		return err
 	}
+
+	// The action queue is unblocked, but the agent waits for the
+	// vtaction process to exit, and it waits for the callback (see
+	// WaitForActionCallbacks): it can't take long.
+	if actionNode.CallbackUrl != "" {
+		startActionCallback(actionNode)
+	}
 	return actionErr
 }
 
+// DryRunAction replays the action at actionPath (usually a failed
+// one, from the actionlog) without side effects, to help root-cause
+// its failure. The action node is not claimed or updated. Actions
+// that only read state are run for real (CheckReplication never
+// repairs), the others only have their preconditions checked.
+// It returns the full report, and the error the replay ran into.
+func (ta *TabletActor) DryRunAction(actionPath, actionGuid string) (string, error) {
+	tabletAlias, data, _, err := ta.ts.ReadTabletActionPath(actionPath)
+	if err != nil {
+		return "", err
+	}
+	ta.tabletAlias = tabletAlias
+	actionNode, err := actionnode.ActionNodeFromJson(data, actionPath)
+	if err != nil {
+		return "", err
+	}
+	if actionNode.ActionGuid != actionGuid {
+		return "", TabletActorError("invalid action guid: " + actionGuid + " != " + actionNode.ActionGuid)
+	}
+	actionDryRuns.Add(actionNode.Action, 1)
+	log.Infof("DRY RUN: %v %v", actionPath, data)
+
+	report := new(bytes.Buffer)
+	fmt.Fprintf(report, "DRY RUN of %v (%v) on %v\n", actionNode.Action, actionNode.ActionGuid, tabletAlias)
+	fmt.Fprintf(report, "recorded state: %v\n", actionNode.State)
+	if actionNode.Error != "" {
+		fmt.Fprintf(report, "recorded error: %v\n", actionNode.Error)
+	}
+	if actionNode.Args != nil {
+		fmt.Fprintf(report, "args: %v\n", jscfg.ToJson(actionNode.Args))
+	}
+
+	err = ta.dryRunAction(actionNode, report)
+	if err != nil {
+		fmt.Fprintf(report, "replay error: %v\n", err)
+	} else {
+		fmt.Fprintf(report, "replay succeeded\n")
+	}
+	log.Infof("DRY RUN report for %v:\n%v", actionPath, report.String())
+	return report.String(), err
+}
+
+// dryRunAction is the side-effect free version of dispatchAction.
+func (ta *TabletActor) dryRunAction(actionNode *actionnode.ActionNode, report *bytes.Buffer) (err error) {
+	defer func() {
+		if x := recover(); x != nil {
+			err = tb.Errorf("dryRunAction panic %v", x)
+		}
+	}()
+
+	tablet, err := ta.ts.GetTablet(ta.tabletAlias)
+	if err != nil {
+		return fmt.Errorf("cannot read tablet: %v", err)
+	}
+	fmt.Fprintf(report, "tablet: type %v, keyspace %v, shard %v, parent %v\n", tablet.Type, tablet.Keyspace, tablet.Shard, tablet.Parent)
+	port, err := ta.mysqlDaemon.GetMysqlPort()
+	if err != nil {
+		return fmt.Errorf("mysql is not reachable: %v", err)
+	}
+	fmt.Fprintf(report, "mysql port: %v\n", port)
+
+	switch actionNode.Action {
+	case actionnode.TABLET_ACTION_PING:
+		return nil
+	case actionnode.TABLET_ACTION_CHECK_REPLICATION:
+		reply, err := CheckReplication(ta.ts, ta.mysqlDaemon, ta.tabletAlias, false)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(report, "reply: %v\n", jscfg.ToJson(reply))
+		return nil
+	}
+	fmt.Fprintf(report, "not executed: %v has side effects, only its preconditions were checked\n", actionNode.Action)
+	return nil
+}
+
 func (ta *TabletActor) dispatchAction(actionNode *actionnode.ActionNode) (err error) {
 	defer func() {
 		if x := recover(); x != nil {
@@ -177,6 +267,8 @@ func (ta *TabletActor) dispatchAction(actionNode *actionnode.ActionNode) (err er
 		err = ta.restartSlave(actionNode)
 	case actionnode.TABLET_ACTION_SLAVE_WAS_RESTARTED:
 		err = SlaveWasRestarted(ta.ts, ta.mysqlDaemon, ta.tabletAlias, actionNode.Args.(*actionnode.SlaveWasRestartedArgs))
+	case actionnode.TABLET_ACTION_CHECK_REPLICATION:
+		err = ta.checkReplication(actionNode)
 	case actionnode.TABLET_ACTION_RESERVE_FOR_RESTORE:
 		err = ta.reserveForRestore(actionNode)
 	case actionnode.TABLET_ACTION_RESTORE:
@@ -241,7 +333,18 @@ func StoreActionResponse(ts topo.Server, actionNode *actionnode.ActionNode, acti
 	// Write the data first to our action node, then to the log.
 	// In the error case, this node will be left behind to debug.
 	data := actionNode.ToJson()
-	return ts.StoreTabletActionResponse(actionPath, data)
+	if err := ts.StoreTabletActionResponse(actionPath, data); err != nil {
+		return err
+	}
+
+	// Record the success before the action is unblocked, so a crash
+	// in between doesn't run it again. It's only a safety net.
+	if actionErr == nil {
+		if err := ts.RecordCompletedAction(actionPath, actionNode.ActionGuid); err != nil {
+			log.Warningf("cannot record completed action %v: %v", actionPath, err)
+		}
+	}
+	return nil
 }
 
 func (ta *TabletActor) sleep(actionNode *actionnode.ActionNode) error {
@@ -489,6 +592,115 @@ func SlaveWasRestarted(ts topo.Server, mysqlDaemon mysqlctl.MysqlDaemon, tabletA
 	return nil
 }
 
+func (ta *TabletActor) checkReplication(actionNode *actionnode.ActionNode) error {
+	args := actionNode.Args.(*actionnode.CheckReplicationArgs)
+	reply, err := CheckReplication(ta.ts, ta.mysqlDaemon, ta.tabletAlias, args.Repair)
+	if err != nil {
+		return err
+	}
+	actionNode.Reply = reply
+	return nil
+}
+
+// CheckReplication compares the tablet record and its replication
+// graph entry with the master MySQL is replicating from. If repair
+// is set, the records are updated to match what MySQL is doing.
+func CheckReplication(ts topo.Server, mysqlDaemon mysqlctl.MysqlDaemon, tabletAlias topo.TabletAlias, repair bool) (*actionnode.CheckReplicationReply, error) {
+	tablet, err := ts.GetTablet(tabletAlias)
+	if err != nil {
+		return nil, err
+	}
+	reply := &actionnode.CheckReplicationReply{
+		Parent: tablet.Parent,
+	}
+	if tablet.Type == topo.TYPE_MASTER || !tablet.IsInReplicationGraph() {
+		return reply, nil
+	}
+
+	reply.MasterAddr, err = mysqlDaemon.GetMasterAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	// find out which tablet MySQL is really replicating from,
+	// trying the recorded parent first, then the shard master
+	candidates := []topo.TabletAlias{tablet.Parent}
+	si, err := ts.GetShard(tablet.Keyspace, tablet.Shard)
+	if err != nil {
+		return nil, err
+	}
+	if !si.MasterAlias.IsZero() && si.MasterAlias != tablet.Parent {
+		candidates = append(candidates, si.MasterAlias)
+	}
+	for _, alias := range candidates {
+		if alias.IsZero() {
+			continue
+		}
+		ti, err := ts.GetTablet(alias)
+		if err != nil {
+			log.Warningf("CheckReplication cannot read tablet %v: %v", alias, err)
+			continue
+		}
+		if reply.MasterAddr == ti.GetMysqlAddr() || reply.MasterAddr == ti.GetMysqlIpAddr() {
+			reply.ActualParent = alias
+			break
+		}
+	}
+	masterUnknown := ""
+	if reply.ActualParent.IsZero() {
+		masterUnknown = fmt.Sprintf("no known tablet matches master address %v", reply.MasterAddr)
+		reply.Discrepancies = append(reply.Discrepancies, masterUnknown)
+	} else if reply.ActualParent != tablet.Parent {
+		reply.Discrepancies = append(reply.Discrepancies, fmt.Sprintf("tablet parent is %v but MySQL replicates from %v", tablet.Parent, reply.ActualParent))
+	}
+
+	// and check the replication graph agrees with the tablet record
+	sri, err := ts.GetShardReplication(tablet.Alias.Cell, tablet.Keyspace, tablet.Shard)
+	if err != nil && err != topo.ErrNoNode {
+		return nil, err
+	}
+	if err == nil {
+		if rl, err := sri.GetReplicationLink(tablet.Alias); err == nil {
+			reply.ReplicationParent = rl.Parent
+		}
+	}
+	graphWrong := ""
+	if reply.ReplicationParent.IsZero() {
+		graphWrong = "tablet is missing from the replication graph"
+	} else if reply.ReplicationParent != tablet.Parent {
+		graphWrong = fmt.Sprintf("replication graph parent is %v but tablet parent is %v", reply.ReplicationParent, tablet.Parent)
+	}
+	if graphWrong != "" {
+		reply.Discrepancies = append(reply.Discrepancies, graphWrong)
+	}
+
+	if len(reply.Discrepancies) == 0 || !repair {
+		return reply, nil
+	}
+
+	// we can only fix the parent if we know who it really is,
+	// the replication graph is always rebuilt from the tablet record
+	if masterUnknown != "" {
+		reply.Unresolved = append(reply.Unresolved, masterUnknown)
+	} else if reply.ActualParent != tablet.Parent {
+		tablet.Parent = reply.ActualParent
+		if err := topo.UpdateTablet(ts, tablet); err != nil {
+			return nil, err
+		}
+	}
+	if tablet.Parent.IsZero() {
+		// the replication graph cannot record a link
+		// without a parent
+		if graphWrong != "" {
+			reply.Unresolved = append(reply.Unresolved, graphWrong)
+		}
+	} else if err := topo.CreateTabletReplicationData(ts, tablet.Tablet); err != nil && err != topo.ErrNodeExists {
+		return nil, err
+	}
+	reply.Repaired = len(reply.Unresolved) == 0
+	return reply, nil
+}
+
 func (ta *TabletActor) scrap() error {
 	return Scrap(ta.ts, ta.tabletAlias, false)
 }
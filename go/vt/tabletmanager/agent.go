@@ -11,7 +11,8 @@ It has two execution models:
   uses the actor code). We usually use this model for long-running
   queries where an RPC would time out.
 
-  All vtaction calls lock the actionMutex.
+  All vtaction calls lock the actionMutex, except the ParallelSafe
+  ones, that can run at the same time (see ActionConcurrency).
 
   After executing vtaction, we always call the ChangeCallbacks.
   Additionnally, for TABLET_ACTION_APPLY_SCHEMA, we will force a schema
@@ -33,30 +34,68 @@ It has two execution models:
 package tabletmanager
 
 import (
+	"flag"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/jscfg"
 	"github.com/youtube/vitess/go/netutil"
-	"github.com/youtube/vitess/go/vt/dbconfigs"
+	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/vt/env"
-	"github.com/youtube/vitess/go/vt/logutil"
 	"github.com/youtube/vitess/go/vt/mysqlctl"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
 	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
 	"github.com/youtube/vitess/go/vt/tabletserver"
 	"github.com/youtube/vitess/go/vt/topo"
 )
 
+var (
+	servingAddrsCheckInterval = flag.Duration("serving_addrs_check_interval", 1*time.Minute, "how often to check the serving graph still has the tablet addresses and health score, and fix it if not (0 to disable)")
+	vtActionPath              = flag.String("vtaction_path", "", "path to the vtaction binary, instead of looking for it in $VTROOT/bin then $PATH")
+	vtActionTimeout           = flag.Duration("vtaction_timeout", 0, "how long a vtaction can run before it is killed, so a wedged action doesn't block the others (0 for no limit)")
+	vtActionRetryCount        = flag.Int("vtaction_retry_count", 0, "how many times a vtaction that died before recording a result (e.g. on a topology server or mysql connection blip) is run again")
+	actionConcurrency         = flag.Int("action_concurrency", 1, "how many ParallelSafe (read-only) actions the agent can run at the same time, the other actions always run alone")
+	extraPorts                = flag.String("extra_ports", "", "comma-separated name:port list of the other services of the tablet, published in its record and the serving graph next to vt and mysql (as _name)")
+	actionDryRun              = flag.Bool("action_dry_run", false, "only log the actions and the vtaction command lines that would run them, and complete them without running them (to rehearse an operation)")
+	actionMinInterval         = flag.Duration("action_min_interval", 0, "minimum time between two action launches, so a flood of actions doesn't hammer mysql (0 for no limit)")
+	maxActionOutput           = flag.Int("max_action_output", 64*1024, "how many bytes of the beginning and of the end of the vtaction output are kept, to log it and store it in the action result (0 for no limit)")
+	vtActionRetryBackoff      = flag.Duration("vtaction_retry_backoff", 1*time.Second, "how long to wait before the first vtaction retry, doubled for each of the next ones")
+	healthMaxReplicationLag   = flag.Duration("health_max_replication_lag", 30*time.Second, "replication lag at which a tablet advertises the lowest health score in the serving graph")
+	resultFlushTimeout        = flag.Duration("action_result_flush_timeout", 5*time.Second, "how long the agent keeps retrying, when it stops, the action results it could not write to the topology server")
+
+	actionRetries = stats.NewCounters("ActionRetries")
+
+	// actionCounts counts the actions dispatched to vtaction by
+	// outcome: Dispatched, then Succeeded or Failed. Failed ones
+	// can also be TimedOut or Cancelled. Invalid ones are rejected
+	// before being dispatched, and DryRun ones are only logged.
+	actionCounts = stats.NewCounters("ActionCounts")
+	// actionTimings are the durations of the actions, by action.
+	actionTimings = stats.NewTimings("ActionTimings")
+	// tabletTypeChanges counts the changes of the tablet type made
+	// by actions, by "oldType->newType".
+	tabletTypeChanges = stats.NewCounters("TabletTypeChanges")
+)
+
 // Each TabletChangeCallback must be idempotent and "threadsafe".  The
 // agent will execute these in a new goroutine each time a change is
 // triggered. We won't run two in parallel.
 type TabletChangeCallback func(oldTablet, newTablet topo.Tablet)
 
+// TabletTypeChangeCallback is called when an action changed the type
+// of the tablet, with the context of the action. The agent runs each
+// of them in a new goroutine, so they don't hold the next actions.
+type TabletTypeChangeCallback func(oldType, newType topo.TabletType, context string)
+
 type tabletChangeItem struct {
 	oldTablet  topo.Tablet
 	newTablet  topo.Tablet
@@ -70,30 +109,140 @@ type ActionAgent struct {
 	vtActionBinFile string // path to vtaction binary
 	Mysqld          *mysqlctl.Mysqld
 	BinlogPlayerMap *BinlogPlayerMap // optional
+	// Executor runs the dispatched actions. It defaults to running
+	// them in vtaction processes.
+	Executor ActionExecutor
+	// ActionTimeout is how long a vtaction can run before it is
+	// killed, 0 for no limit. It defaults to -vtaction_timeout.
+	ActionTimeout time.Duration
+	// ActionRetryCount and ActionRetryBackoff control the retries of
+	// failed actions, see dispatchAction. They default to
+	// -vtaction_retry_count and -vtaction_retry_backoff.
+	ActionRetryCount   int
+	ActionRetryBackoff time.Duration
+	// ActionConcurrency is how many ParallelSafe actions can run at
+	// the same time. It defaults to -action_concurrency.
+	ActionConcurrency int
+	// ActionMinInterval is the minimum time between two action
+	// launches, 0 for no limit. The actions still run in queue
+	// order. It defaults to -action_min_interval.
+	ActionMinInterval time.Duration
+	// ExtraPorts are the ports of the other services of the tablet,
+	// by name. Start adds them to the tablet Portmap, so they are
+	// published in the serving graph too. It defaults to
+	// -extra_ports.
+	ExtraPorts map[string]int
+	// DryRun makes dispatchAction only log the actions, and
+	// complete them with a DryRun result, without running vtaction
+	// or reloading the tablet. It defaults to -action_dry_run.
+	DryRun bool
+	// MaxActionOutput is how many bytes of the beginning and of the
+	// end of the vtaction output are kept, 0 for no limit. It
+	// defaults to -max_action_output.
+	MaxActionOutput int
+	// ResultFlushTimeout is how long Stop keeps retrying the action
+	// results that could not be written, see flushResults. It
+	// defaults to -action_result_flush_timeout.
+	ResultFlushTimeout time.Duration
+	// ForcePidNode makes Start take over the pid node of the tablet
+	// when it was created by an agent on another host, instead of
+	// failing. vttablet sets it with -force.
+	ForcePidNode bool
 
 	done chan struct{} // closed when we are done.
 
+	// actionLoopWg is done when the action event loop returned,
+	// after the actions it was dispatching completed.
+	actionLoopWg sync.WaitGroup
+
 	// actionMutex is there to run only one action at a time. If
 	// both agent.actionMutex and agent.mutex needs to be taken,
 	// take actionMutex first.
 	actionMutex sync.Mutex // to run only one action at a time
 
+	// runningMu protects runningActions, the vtaction processes
+	// being run by ActionGuid, so they can be cancelled.
+	runningMu      sync.Mutex
+	runningActions map[string]*runningAction
+
+	// resultsMu protects pendingResults, the action result writes
+	// that failed, to be retried (see writeResult).
+	resultsMu      sync.Mutex
+	pendingResults []pendingResult
+
 	// mutex is protecting the rest of the members
 	mutex           sync.Mutex
 	changeCallbacks []TabletChangeCallback
+	typeCallbacks   []TabletTypeChangeCallback
 	changeItems     chan tabletChangeItem
 	_tablet         *topo.TabletInfo
+	// _currentAction is the action holding actionMutex, nil if
+	// there is none (see CurrentAction).
+	_currentAction *CurrentAction
+}
+
+// CurrentAction describes the action the agent is running. RPCs
+// have no ActionGuid, and their Action is RPC(<name>).
+type CurrentAction struct {
+	ActionGuid string
+	Action     string
+	Start      time.Time
+}
+
+// runningAction is a vtaction process being run.
+type runningAction struct {
+	cmd       *exec.Cmd
+	cancelled bool
 }
 
 func NewActionAgent(topoServer topo.Server, tabletAlias topo.TabletAlias, mysqld *mysqlctl.Mysqld) (*ActionAgent, error) {
-	return &ActionAgent{
-		TopoServer:      topoServer,
-		TabletAlias:     tabletAlias,
-		Mysqld:          mysqld,
-		done:            make(chan struct{}),
-		changeCallbacks: make([]TabletChangeCallback, 0, 8),
-		changeItems:     make(chan tabletChangeItem, 100),
-	}, nil
+	agent := &ActionAgent{
+		TopoServer:         topoServer,
+		TabletAlias:        tabletAlias,
+		Mysqld:             mysqld,
+		ActionTimeout:      *vtActionTimeout,
+		ActionRetryCount:   *vtActionRetryCount,
+		ActionRetryBackoff: *vtActionRetryBackoff,
+		ActionConcurrency:  *actionConcurrency,
+		ActionMinInterval:  *actionMinInterval,
+		DryRun:             *actionDryRun,
+		MaxActionOutput:    *maxActionOutput,
+		ResultFlushTimeout: *resultFlushTimeout,
+		done:               make(chan struct{}),
+		runningActions:     make(map[string]*runningAction),
+		changeCallbacks:    make([]TabletChangeCallback, 0, 8),
+		changeItems:        make(chan tabletChangeItem, 100),
+	}
+	agent.Executor = &vtActionExecutor{agent}
+	var err error
+	if agent.ExtraPorts, err = parseExtraPorts(*extraPorts); err != nil {
+		return nil, err
+	}
+	return agent, nil
+}
+
+// parseExtraPorts parses the value of -extra_ports.
+func parseExtraPorts(value string) (map[string]int, error) {
+	ports := make(map[string]int)
+	if value == "" {
+		return ports, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid -extra_ports entry %q, expected name:port", entry)
+		}
+		switch parts[0] {
+		case "vt", "vts", "mysql":
+			return nil, fmt.Errorf("invalid -extra_ports entry %q, %v is not an extra port", entry, parts[0])
+		}
+		port, err := strconv.ParseUint(parts[1], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in -extra_ports entry %q: %v", entry, err)
+		}
+		ports[parts[0]] = int(port)
+	}
+	return ports, nil
 }
 
 func (agent *ActionAgent) AddChangeCallback(f TabletChangeCallback) {
@@ -102,6 +251,30 @@ func (agent *ActionAgent) AddChangeCallback(f TabletChangeCallback) {
 	agent.mutex.Unlock()
 }
 
+// OnTabletTypeChange registers f to be called each time an action
+// changes the type of the tablet.
+func (agent *ActionAgent) OnTabletTypeChange(f TabletTypeChangeCallback) {
+	agent.mutex.Lock()
+	agent.typeCallbacks = append(agent.typeCallbacks, f)
+	agent.mutex.Unlock()
+}
+
+// runTypeChangeCallbacks counts and logs the type change made by the
+// action of context, if any, and calls the TabletTypeChangeCallbacks.
+func (agent *ActionAgent) runTypeChangeCallbacks(oldType, newType topo.TabletType, context string) {
+	if oldType == newType {
+		return
+	}
+	log.Infof("Tablet type changed from %v to %v after %v", oldType, newType, context)
+	tabletTypeChanges.Add(fmt.Sprintf("%v->%v", oldType, newType), 1)
+	agent.mutex.Lock()
+	callbacks := agent.typeCallbacks
+	agent.mutex.Unlock()
+	for _, f := range callbacks {
+		go f(oldType, newType, context)
+	}
+}
+
 func (agent *ActionAgent) runChangeCallbacks(oldTablet *topo.Tablet, context string) {
 	agent.mutex.Lock()
 	// Access directly since we have the lock.
@@ -151,56 +324,281 @@ func (agent *ActionAgent) Tablet() *topo.TabletInfo {
 	return tablet
 }
 
+// resolvePaths finds the vtaction binary: -vtaction_path if set,
+// otherwise $VTROOT/bin/vtaction, otherwise vtaction in $PATH.
+// (my.cnf is given to vttablet with -mycnf-file.)
 func (agent *ActionAgent) resolvePaths() error {
-	vtroot, err := env.VtRoot()
-	if err != nil {
-		return err
+	if *vtActionPath != "" {
+		if _, err := os.Stat(*vtActionPath); err != nil {
+			return newActionError(ErrActionBinaryMissing, -1, "vtaction binary %v from -vtaction_path not found: %v", *vtActionPath, err)
+		}
+		agent.vtActionBinFile = *vtActionPath
+		return nil
 	}
-	path := path.Join(vtroot, "bin/vtaction")
-	if _, err := os.Stat(path); err != nil {
-		return fmt.Errorf("vtaction binary %s not found: %v", path, err)
+
+	if vtroot, err := env.VtRoot(); err == nil {
+		vtActionBinFile := path.Join(vtroot, "bin/vtaction")
+		if _, err := os.Stat(vtActionBinFile); err == nil {
+			agent.vtActionBinFile = vtActionBinFile
+			return nil
+		}
 	}
-	agent.vtActionBinFile = path
+	vtActionBinFile, err := exec.LookPath("vtaction")
+	if err != nil {
+		return newActionError(ErrActionBinaryMissing, -1, "vtaction binary not found in $VTROOT/bin or $PATH, set -vtaction_path: %v", err)
+	}
+	agent.vtActionBinFile = vtActionBinFile
 	return nil
 }
 
-// A non-nil return signals that event processing should stop.
+// setCurrentAction records action as the one holding actionMutex,
+// nil once it's released.
+func (agent *ActionAgent) setCurrentAction(action *CurrentAction) {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+	agent._currentAction = action
+}
+
+// CurrentAction returns the action the agent is running alone, or
+// nil if it's idle. The read-only actions running concurrently with
+// -action_concurrency are not reported.
+func (agent *ActionAgent) CurrentAction() *CurrentAction {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+	if agent._currentAction == nil {
+		return nil
+	}
+	action := *agent._currentAction
+	return &action
+}
+
+// RegisterCurrentAction publishes the action agent is running as the
+// CurrentAction variable, null when it's idle, so operators can see
+// what a stuck tablet is doing.
+func RegisterCurrentAction(agent *ActionAgent) {
+	stats.PublishJSONFunc("CurrentAction", func() string {
+		return jscfg.ToJson(agent.CurrentAction())
+	})
+}
+
+// A non-nil return signals that event processing should stop. It is
+// an ActionError if vtaction couldn't run the action to completion.
 func (agent *ActionAgent) dispatchAction(actionPath, data string) error {
-	agent.actionMutex.Lock()
-	defer agent.actionMutex.Unlock()
+	// The topology server is likely back, retry the results of the
+	// previous actions it didn't take.
+	agent.flushResults(0)
 
-	log.Infof("action dispatch %v", actionPath)
 	actionNode, err := actionnode.ActionNodeFromJson(data, actionPath)
 	if err != nil {
 		log.Errorf("action decode failed: %v %v", actionPath, err)
 		return nil
 	}
+	if err := actionNode.Validate(); err != nil {
+		// The action never runs, so the queue can go on.
+		log.Errorf("invalid action %v: %v", actionPath, err)
+		actionCounts.Add("Invalid", 1)
+		agent.failAction(actionPath, fmt.Errorf("invalid action: %v", err))
+		return nil
+	}
+	if agent.DryRun {
+		agent.dryRunAction(actionNode)
+		return nil
+	}
+	if !actionNode.ParallelSafe || agent.ActionConcurrency <= 1 {
+		agent.actionMutex.Lock()
+		defer agent.actionMutex.Unlock()
+		agent.setCurrentAction(&CurrentAction{actionNode.ActionGuid, actionNode.Action, time.Now()})
+		defer agent.setCurrentAction(nil)
+	}
 
-	cmd := []string{
-		agent.vtActionBinFile,
-		"-action", actionNode.Action,
-		"-action-node", actionPath,
-		"-action-guid", actionNode.ActionGuid,
-		"-mycnf-file", agent.Mysqld.MycnfPath(),
+	log.Infof("action dispatch %v", actionPath)
+	actionCounts.Add("Dispatched", 1)
+	start := time.Now()
+	result, actionErr := agent.Executor.Execute(actionNode)
+	actionTimings.Record(actionNode.Action, start)
+	interrupted := result != nil && result.Interrupted
+	if actionErr != nil {
+		actionCounts.Add("Failed", 1)
+		if interrupted {
+			agent.failAction(actionPath, actionErr)
+		}
+	} else {
+		actionCounts.Add("Succeeded", 1)
+		log.Infof("Agent action completed %v", actionPath)
+	}
+	if result != nil {
+		agent.writeResult(actionPath, func() error {
+			return WriteActionResult(agent.TopoServer, actionPath, result)
+		})
 	}
-	cmd = append(cmd, logutil.GetSubprocessFlags()...)
-	cmd = append(cmd, topo.GetSubprocessFlags()...)
-	cmd = append(cmd, dbconfigs.GetSubprocessFlags()...)
-	log.Infof("action launch %v", cmd)
-	vtActionCmd := exec.Command(cmd[0], cmd[1:]...)
 
-	stdOut, vtActionErr := vtActionCmd.CombinedOutput()
-	if vtActionErr != nil {
-		log.Errorf("agent action failed: %v %v\n%s", actionPath, vtActionErr, stdOut)
+	// Read-only actions can't have changed the tablet, so there is
+	// no need to reload it from the topology.
+	mutated := actionNode.MutatesTablet()
+	if actionErr != nil {
+		if interrupted && mutated {
+			// The action may have changed the tablet before it was stopped.
+			agent.afterAction(actionPath, actionNode.Action == actionnode.TABLET_ACTION_APPLY_SCHEMA)
+		}
 		// If the action failed, preserve single execution path semantics.
-		return vtActionErr
+		return actionErr
 	}
+	if mutated {
+		agent.afterAction(actionPath, actionNode.Action == actionnode.TABLET_ACTION_APPLY_SCHEMA)
+	}
+	return nil
+}
 
-	log.Infof("Agent action completed %v %s", actionPath, stdOut)
-	agent.afterAction(actionPath, actionNode.Action == actionnode.TABLET_ACTION_APPLY_SCHEMA)
+// actionUnfinished returns true if the action at actionPath is still
+// queued without a result, i.e. its vtaction died before it could run
+// it to completion (vtaction records its failures, and removes the
+// action from the queue). Only those actions are retried: the others
+// may have changed the tablet before failing.
+func actionUnfinished(ts topo.Server, actionPath string) bool {
+	_, data, _, err := ts.ReadTabletActionPath(actionPath)
+	if err != nil {
+		return false
+	}
+	actionNode, err := actionnode.ActionNodeFromJson(data, actionPath)
+	if err != nil {
+		return false
+	}
+	return actionNode.State == actionnode.ACTION_STATE_QUEUED || actionNode.State == actionnode.ACTION_STATE_RUNNING
+}
+
+// retryDelay returns how long to wait before the retry following
+// attempt (0 for the first one): backoff, doubled for every attempt.
+func retryDelay(backoff time.Duration, attempt int) time.Duration {
+	return backoff << uint(attempt)
+}
+
+// runAction runs the vtaction process cmd with priority, and returns
+// its output, capped by MaxActionOutput. The output is also logged as
+// it comes (see actionLogger). After ActionTimeout, it kills
+// the process and returns an ErrActionTimeout error, so a wedged
+// action doesn't block the action queue. With a timeout, the process
+// runs in its own process group, which is killed as a whole: its
+// children would otherwise keep the output pipe open, and Wait with
+// it. While it runs, cancelAction can stop it with actionGuid.
+// interrupted is true if the process was killed for either reason.
+// Errors are ActionErrors.
+func (agent *ActionAgent) runAction(cmd *exec.Cmd, priority actionPriority, actionGuid string) (output *actionOutput, interrupted bool, err error) {
+	output = newActionOutput(agent.MaxActionOutput)
+	// exec copies the output to the logger as it comes,
+	// and Wait waits for the copy to be done.
+	logger := newActionLogger(actionGuid, output)
+	cmd.Stdout = logger
+	cmd.Stderr = logger
+	if agent.ActionTimeout != 0 {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	if err := cmd.Start(); err != nil {
+		return output, false, newActionError(ErrActionBinaryMissing, -1, "cannot run %v: %v", cmd.Path, err)
+	}
+	if err := priority.joinCgroup(cmd.Process.Pid); err != nil {
+		log.Warningf("cannot move vtaction to cgroup %v, it runs in the agent's: %v", priority.cgroup, err)
+	}
+	running := &runningAction{cmd: cmd}
+	agent.runningMu.Lock()
+	agent.runningActions[actionGuid] = running
+	agent.runningMu.Unlock()
+	defer func() {
+		agent.runningMu.Lock()
+		delete(agent.runningActions, actionGuid)
+		agent.runningMu.Unlock()
+	}()
+	var timer *time.Timer
+	if agent.ActionTimeout != 0 {
+		pid := cmd.Process.Pid
+		timer = time.AfterFunc(agent.ActionTimeout, func() {
+			log.Warningf("action pid %v timed out after %v, killing it", pid, agent.ActionTimeout)
+			syscall.Kill(-pid, syscall.SIGKILL)
+		})
+	}
+	err = cmd.Wait()
+	logger.Flush()
+	if timer != nil && !timer.Stop() {
+		actionCounts.Add("TimedOut", 1)
+		return output, true, newActionError(ErrActionTimeout, -1, "action timed out after %v: %v", agent.ActionTimeout, err)
+	}
+	agent.runningMu.Lock()
+	cancelled := running.cancelled
+	agent.runningMu.Unlock()
+	if cancelled {
+		actionCounts.Add("Cancelled", 1)
+		return output, true, newActionError(ErrActionCancelled, -1, "action cancelled: %v", err)
+	}
+	return output, false, actionExitError(err)
+}
+
+// cancelAction sends SIGTERM to the vtaction process running the action
+// with actionGuid, if there is one (see topo.Server.CancelTabletAction).
+func (agent *ActionAgent) cancelAction(actionGuid string) {
+	agent.runningMu.Lock()
+	defer agent.runningMu.Unlock()
+	running, ok := agent.runningActions[actionGuid]
+	if !ok {
+		log.Warningf("cannot cancel action %v, it is not running", actionGuid)
+		return
+	}
+	log.Infof("cancelling action %v, pid %v", actionGuid, running.cmd.Process.Pid)
+	running.cancelled = true
+	if err := running.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		log.Warningf("cannot signal action %v: %v", actionGuid, err)
+	}
+}
+
+// failAction records actionErr as the result of the action at
+// actionPath, which couldn't record one itself (its vtaction process
+// was killed, or it was never run), and removes the action from the
+// queue like a completed one.
+func (agent *ActionAgent) failAction(actionPath string, actionErr error) {
+	_, data, _, err := agent.TopoServer.ReadTabletActionPath(actionPath)
+	if err != nil {
+		log.Errorf("cannot read failed action %v: %v", actionPath, err)
+		return
+	}
+	actionNode, err := actionnode.ActionNodeFromJson(data, actionPath)
+	if err != nil {
+		log.Errorf("cannot decode failed action %v: %v", actionPath, err)
+		return
+	}
+	agent.writeResult(actionPath, func() error {
+		return agent.completeAction(actionNode, actionPath, actionErr)
+	})
+}
+
+// completeAction stores the result of actionNode, then removes it
+// from the queue at actionPath.
+func (agent *ActionAgent) completeAction(actionNode *actionnode.ActionNode, actionPath string, actionErr error) error {
+	if err := StoreActionResponse(agent.TopoServer, actionNode, actionPath, actionErr); err != nil {
+		return fmt.Errorf("cannot store the result: %v", err)
+	}
+	if err := agent.TopoServer.UnblockTabletAction(actionPath); err != nil {
+		return fmt.Errorf("cannot unblock the action: %v", err)
+	}
 	return nil
 }
 
+// dryRunAction logs what running actionNode would do, and completes
+// it with a DryRun result instead.
+func (agent *ActionAgent) dryRunAction(actionNode *actionnode.ActionNode) {
+	cmd, priority := agent.vtActionCommand(actionNode)
+	log.Warningf("DRY RUN, NOT running action %v: would launch %v with priority %v for %v", actionNode.Path, cmd, priority, actionNode.ToJson())
+	actionCounts.Add("DryRun", 1)
+
+	now := time.Now()
+	actionNode.Result = &actionnode.ActionResult{
+		Output: "dry run, would have run: " + strings.Join(cmd, " "),
+		Start:  now,
+		End:    now,
+		DryRun: true,
+	}
+	agent.writeResult(actionNode.Path, func() error {
+		return agent.completeAction(actionNode, actionNode.Path, nil)
+	})
+}
+
 // ChecktabletMysqlPort will check the mysql port for the tablet is good,
 // and if not will try to update it
 func CheckTabletMysqlPort(ts topo.Server, mysqlDaemon mysqlctl.MysqlDaemon, tablet *topo.TabletInfo) *topo.TabletInfo {
@@ -243,6 +641,7 @@ func (agent *ActionAgent) afterAction(context string, reloadSchema bool) {
 			agent.mutex.Unlock()
 		}
 
+		agent.runTypeChangeCallbacks(oldTablet.Type, agent.Tablet().Type, context)
 		agent.runChangeCallbacks(oldTablet, context)
 	}
 
@@ -283,30 +682,181 @@ func (agent *ActionAgent) verifyServingAddrs() error {
 	return agent.TopoServer.UpdateTabletEndpoint(agent.Tablet().Tablet.Alias.Cell, agent.Tablet().Keyspace, agent.Tablet().Shard, agent.Tablet().Type, addr)
 }
 
+// removeServingAddrs is the opposite of verifyServingAddrs: it removes
+// our address from the serving graph, so clients stop using the
+// tablet before it goes away.
+func (agent *ActionAgent) removeServingAddrs() error {
+	tablet := agent.Tablet()
+	if tablet == nil || !tablet.IsRunningQueryService() {
+		return nil
+	}
+	return agent.TopoServer.RemoveTabletEndpoint(tablet.Alias.Cell, tablet.Keyspace, tablet.Shard, tablet.Type, tablet.Alias.Uid)
+}
+
+// CheckServingAddrs compares the serving graph entry of the tablet
+// with the addresses in its tablet record, its health score and its
+// health map, and fixes the serving graph if they diverged. It returns
+// true if it had to fix it. A serving graph that wasn't built yet is
+// left alone.
+func CheckServingAddrs(ts topo.Server, tablet *topo.TabletInfo, health int, healthMap map[string]string) (bool, error) {
+	if !tablet.IsRunningQueryService() {
+		return false, nil
+	}
+	addr, err := EndPointForTablet(tablet.Tablet)
+	if err != nil {
+		return false, err
+	}
+	addr.Health = health
+	addr.HealthMap = healthMap
+	addrs, err := ts.GetEndPoints(tablet.Alias.Cell, tablet.Keyspace, tablet.Shard, tablet.Type)
+	if err != nil {
+		if err == topo.ErrNoNode {
+			return false, nil
+		}
+		return false, err
+	}
+	var entry *topo.EndPoint
+	for i := range addrs.Entries {
+		if addrs.Entries[i].Uid == addr.Uid {
+			entry = &addrs.Entries[i]
+			break
+		}
+	}
+	if entry != nil && topo.EndPointEquality(entry, addr) {
+		return false, nil
+	}
+	if entry == nil {
+		log.Warningf("Serving graph entry for %v is missing, re-inserting %+v", tablet.Alias, addr)
+	} else {
+		log.Infof("Serving graph entry for %v is %+v, want %+v, updating it", tablet.Alias, entry, addr)
+	}
+	if err := ts.UpdateTabletEndpoint(tablet.Alias.Cell, tablet.Keyspace, tablet.Shard, tablet.Type, addr); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// servingAddrsLoop periodically runs CheckServingAddrs, so drift
+// between the tablet record and the serving graph heals without
+// waiting for an action, and the health score stays current.
+func (agent *ActionAgent) servingAddrsLoop() {
+	if *servingAddrsCheckInterval == 0 {
+		return
+	}
+	ticker := time.NewTicker(*servingAddrsCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-agent.done:
+			return
+		case <-ticker.C:
+		}
+		// Don't race with actions changing the tablet type,
+		// or with Stop removing our addresses.
+		agent.actionMutex.Lock()
+		select {
+		case <-agent.done:
+			agent.actionMutex.Unlock()
+			return
+		default:
+		}
+		tablet := agent.Tablet()
+		health, healthMap := agent.health(tablet)
+		if _, err := CheckServingAddrs(agent.TopoServer, tablet, health, healthMap); err != nil {
+			if err == topo.ErrRetriesExhausted {
+				// Other writers are busy with the node, the next check will do.
+				log.Infof("Serving graph addresses not updated, will retry: %v", err)
+			} else {
+				log.Warningf("Cannot check serving graph addresses: %v", err)
+			}
+		}
+		agent.actionMutex.Unlock()
+	}
+}
+
+// HealthScore returns the health score a tablet advertises in the
+// serving graph (see topo.EndPoint.Health). It is 1 if the tablet is
+// unhealthy, and otherwise decreases with the replication lag, by
+// steps of a tenth, down to 1 at -health_max_replication_lag.
+func HealthScore(healthErr error, lag time.Duration) int {
+	if healthErr != nil || lag >= *healthMaxReplicationLag {
+		return 1
+	}
+	steps := int(10 * lag / *healthMaxReplicationLag)
+	return topo.MAX_HEALTH - steps*topo.MAX_HEALTH/10
+}
+
+// health computes the HealthScore of the tablet from the query
+// service health and, for slaves, the replication lag. Broken
+// replication has an infinite lag. It also returns the health map
+// of the tablet, with the replication lag when it's known.
+func (agent *ActionAgent) health(tablet *topo.TabletInfo) (int, map[string]string) {
+	healthErr := tabletserver.IsHealthy()
+	healthMap := map[string]string{
+		topo.HEALTH_SERVING: strconv.FormatBool(healthErr == nil),
+	}
+	var lag time.Duration
+	if healthErr == nil && tablet.Type != topo.TYPE_MASTER {
+		pos, err := agent.Mysqld.SlaveStatus()
+		if err != nil {
+			healthErr = err
+		} else {
+			lag = time.Duration(pos.SecondsBehindMaster) * time.Second
+			if pos.SecondsBehindMaster != myproto.InvalidLagSeconds {
+				healthMap[topo.HEALTH_REPLICATION_LAG] = strconv.FormatUint(uint64(pos.SecondsBehindMaster), 10)
+			}
+		}
+	}
+	if healthErr != nil {
+		log.Warningf("Tablet is unhealthy: %v", healthErr)
+	}
+	return HealthScore(healthErr, lag), healthMap
+}
+
 func EndPointForTablet(tablet *topo.Tablet) (*topo.EndPoint, error) {
 	entry := topo.NewAddr(tablet.Alias.Uid, tablet.Hostname)
 	if err := tablet.ValidatePortmap(); err != nil {
 		return nil, err
 	}
 
+	// All the ports are published, named after their Portmap name
+	// with a leading underscore.
 	// TODO(szopa): Rename _vtocc to vt.
-	entry.NamedPortMap = map[string]int{
-		"_vtocc": tablet.Portmap["vt"],
-		"_mysql": tablet.Portmap["mysql"],
+	entry.NamedPortMap = make(map[string]int, len(tablet.Portmap))
+	for name, port := range tablet.Portmap {
+		if name == "vt" {
+			name = "vtocc"
+		}
+		entry.NamedPortMap["_"+name] = port
 	}
-	if port, ok := tablet.Portmap["vts"]; ok {
-		entry.NamedPortMap["_vts"] = port
+	entry.Workload = tablet.Tags[topo.WORKLOAD_TAG]
+	if value, ok := tablet.Tags[topo.WEIGHT_TAG]; ok {
+		// A bad weight shouldn't keep the tablet out of the
+		// serving graph: it just gets the default one.
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight <= 0 {
+			log.Warningf("ignoring invalid %v tag %q of tablet %v", topo.WEIGHT_TAG, value, tablet.Alias)
+		} else {
+			entry.Weight = weight
+		}
 	}
 	return entry, nil
 }
 
 // bindAddr: the address for the query service advertised by this agent
+// A mysqlPort of 0 is read from the my.cnf of the tablet.
 func (agent *ActionAgent) Start(mysqlPort, vtPort, vtsPort int) error {
 	var err error
 	if err = agent.readTablet(); err != nil {
 		return err
 	}
 
+	if mysqlPort == 0 {
+		if mysqlPort, err = agent.mycnfMysqlPort(); err != nil {
+			return err
+		}
+	}
+
 	if err = agent.resolvePaths(); err != nil {
 		return err
 	}
@@ -345,6 +895,9 @@ func (agent *ActionAgent) Start(mysqlPort, vtPort, vtsPort int) error {
 		} else {
 			delete(tablet.Portmap, "vts")
 		}
+		for name, port := range agent.ExtraPorts {
+			tablet.Portmap[name] = port
+		}
 		return nil
 	}
 	if err := agent.TopoServer.UpdateTabletFields(agent.Tablet().Alias, f); err != nil {
@@ -356,6 +909,9 @@ func (agent *ActionAgent) Start(mysqlPort, vtPort, vtsPort int) error {
 		return err
 	}
 
+	if err := agent.checkPidNode(hostname); err != nil {
+		return err
+	}
 	data := fmt.Sprintf("host:%v\npid:%v\n", hostname, os.Getpid())
 
 	if err := agent.TopoServer.CreateTabletPidNode(agent.TabletAlias, data, agent.done); err != nil {
@@ -373,21 +929,109 @@ func (agent *ActionAgent) Start(mysqlPort, vtPort, vtsPort int) error {
 	oldTablet := &topo.Tablet{}
 	agent.runChangeCallbacks(oldTablet, "Start")
 
+	agent.actionLoopWg.Add(1)
 	go agent.actionEventLoop()
+	go agent.TopoServer.ActionCancelLoop(agent.TabletAlias, agent.cancelAction, agent.done)
 	go agent.executeCallbacksLoop()
+	go agent.servingAddrsLoop()
 	return nil
 }
 
+// mycnfMysqlPort returns the mysql port set in the my.cnf of the
+// tablet, for a Start that wasn't given one.
+func (agent *ActionAgent) mycnfMysqlPort() (int, error) {
+	if agent.Mysqld == nil || agent.Mysqld.MycnfPath() == "" {
+		return 0, fmt.Errorf("no mysql port given, and no my.cnf to read it from")
+	}
+	mycnf, err := mysqlctl.MycnfFromFile(agent.Mysqld.MycnfPath())
+	if err != nil {
+		return 0, fmt.Errorf("no mysql port given, and cannot read my.cnf: %v", err)
+	}
+	if mycnf.Port == 0 {
+		return 0, fmt.Errorf("no mysql port given, and none in %v", agent.Mysqld.MycnfPath())
+	}
+	log.Infof("Using mysql port %v from %v", mycnf.Port, agent.Mysqld.MycnfPath())
+	return mycnf.Port, nil
+}
+
+// checkPidNode looks at the pid node another agent may have left for
+// our tablet. If that agent is dead, the node is removed. If it is
+// still running on this host, checkPidNode panics: two agents must
+// never manage the same tablet. We can't tell if an agent on another
+// host is alive, so checkPidNode fails unless ForcePidNode is set.
+func (agent *ActionAgent) checkPidNode(hostname string) error {
+	data, err := agent.TopoServer.GetTabletPidNode(agent.TabletAlias)
+	if err != nil {
+		if err == topo.ErrNoNode {
+			return nil
+		}
+		return err
+	}
+	host, pid, err := parsePidNode(data)
+	switch {
+	case err != nil:
+		log.Warningf("removing invalid pid node of %v: %v", agent.TabletAlias, err)
+	case host != hostname:
+		if !agent.ForcePidNode {
+			return fmt.Errorf("tablet %v has a pid node from agent %v on %v, we are on %v: stop that agent, or use -force to take over", agent.TabletAlias, pid, host, hostname)
+		}
+		log.Warningf("taking over pid node of %v from agent %v on %v, we are on %v", agent.TabletAlias, pid, host, hostname)
+	case pid != os.Getpid() && processAlive(pid):
+		panic(fmt.Errorf("another agent already running for tablet %v: pid %v on %v", agent.TabletAlias, pid, host))
+	default:
+		log.Infof("removing stale pid node of %v from dead agent %v", agent.TabletAlias, pid)
+	}
+	return agent.TopoServer.DeleteTabletPidNode(agent.TabletAlias)
+}
+
+// parsePidNode parses the contents of a pid node written by Start.
+func parsePidNode(data string) (host string, pid int, err error) {
+	if _, err := fmt.Sscanf(data, "host:%s\npid:%d\n", &host, &pid); err != nil {
+		return "", 0, fmt.Errorf("cannot parse pid node %q: %v", data, err)
+	}
+	return host, pid, nil
+}
+
+// processAlive returns true if process pid is running on this host.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// Stop stops the agent loops. It waits for the actions being
+// dispatched to complete, and for their results to be written for
+// up to ResultFlushTimeout, removes the tablet from the serving graph,
+// and removes the pid node, so a new agent can start right away. The
+// topology server must still be open.
 func (agent *ActionAgent) Stop() {
 	close(agent.done)
+	agent.actionLoopWg.Wait()
+	if lost := agent.flushResults(agent.ResultFlushTimeout); lost > 0 {
+		log.Errorf("%v action results could not be written before stopping", lost)
+	}
 	if agent.BinlogPlayerMap != nil {
 		agent.BinlogPlayerMap.StopAllPlayersAndReset()
 	}
+	agent.actionMutex.Lock()
+	if err := agent.removeServingAddrs(); err != nil {
+		log.Warningf("cannot remove %v from the serving graph: %v", agent.TabletAlias, err)
+	}
+	agent.actionMutex.Unlock()
+	if err := agent.TopoServer.DeleteTabletPidNode(agent.TabletAlias); err != nil {
+		log.Warningf("cannot remove pid node of %v: %v", agent.TabletAlias, err)
+	}
 }
 
 func (agent *ActionAgent) actionEventLoop() {
+	defer agent.actionLoopWg.Done()
 	f := func(actionPath, data string) error {
-		return agent.dispatchAction(actionPath, data)
+		err := agent.dispatchAction(actionPath, data)
+		if ae, ok := err.(*ActionError); ok {
+			log.Errorf("agent action failed: %v %v (exit status %v): %v", actionPath, ae.Kind, ae.ExitStatus, ae)
+		} else if err != nil {
+			log.Errorf("agent action failed: %v %v", actionPath, err)
+		}
+		return err
 	}
-	agent.TopoServer.ActionEventLoop(agent.TabletAlias, f, agent.done)
+	agent.TopoServer.ActionEventLoop(agent.TabletAlias, f, actionnode.QueueDecoder{}, agent.ActionConcurrency, agent.ActionMinInterval, agent.done)
 }
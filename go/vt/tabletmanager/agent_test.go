@@ -0,0 +1,744 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/mysql"
+	"github.com/youtube/vitess/go/vt/hook"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/zktopo"
+)
+
+func TestCheckServingAddrs(t *testing.T) {
+	ts := zktopo.NewTestServer(t, []string{"cell1"})
+	tablet := topo.NewTabletInfo(&topo.Tablet{
+		Alias:    topo.TabletAlias{Cell: "cell1", Uid: 1},
+		Hostname: "newhost",
+		Portmap: map[string]int{
+			"vt":    8101,
+			"mysql": 3301,
+		},
+		Keyspace: "test_keyspace",
+		Shard:    "0",
+		Type:     topo.TYPE_REPLICA,
+	}, 0)
+
+	// no serving graph yet: nothing to do
+	if fixed, err := CheckServingAddrs(ts, tablet, topo.MAX_HEALTH, nil); err != nil || fixed {
+		t.Fatalf("CheckServingAddrs without serving graph = %v, %v", fixed, err)
+	}
+
+	// the serving graph still has the old host
+	other := topo.NewAddr(2, "otherhost")
+	stale := topo.NewAddr(1, "oldhost")
+	stale.NamedPortMap = map[string]int{"_vtocc": 8101, "_mysql": 3301}
+	addrs := topo.NewEndPoints()
+	addrs.Entries = append(addrs.Entries, *other, *stale)
+	if err := ts.UpdateEndPoints("cell1", "test_keyspace", "0", topo.TYPE_REPLICA, addrs); err != nil {
+		t.Fatalf("UpdateEndPoints failed: %v", err)
+	}
+	if fixed, err := CheckServingAddrs(ts, tablet, topo.MAX_HEALTH, nil); err != nil || !fixed {
+		t.Fatalf("CheckServingAddrs with drift = %v, %v", fixed, err)
+	}
+	addrs, err := ts.GetEndPoints("cell1", "test_keyspace", "0", topo.TYPE_REPLICA)
+	if err != nil {
+		t.Fatalf("GetEndPoints failed: %v", err)
+	}
+	if len(addrs.Entries) != 2 || addrs.Entries[0].Host != "otherhost" || addrs.Entries[1].Host != "newhost" {
+		t.Errorf("unexpected serving graph: %+v", addrs.Entries)
+	}
+
+	// now in sync
+	if fixed, err := CheckServingAddrs(ts, tablet, topo.MAX_HEALTH, nil); err != nil || fixed {
+		t.Errorf("CheckServingAddrs in sync = %v, %v", fixed, err)
+	}
+
+	// the health score changed
+	if fixed, err := CheckServingAddrs(ts, tablet, 50, nil); err != nil || !fixed {
+		t.Errorf("CheckServingAddrs with a new health score = %v, %v", fixed, err)
+	}
+	addrs, err = ts.GetEndPoints("cell1", "test_keyspace", "0", topo.TYPE_REPLICA)
+	if err != nil {
+		t.Fatalf("GetEndPoints failed: %v", err)
+	}
+	if addrs.Entries[1].Health != 50 {
+		t.Errorf("want health 50, got %+v", addrs.Entries[1])
+	}
+
+	// the replication lag changed
+	healthMap := map[string]string{topo.HEALTH_SERVING: "true", topo.HEALTH_REPLICATION_LAG: "12"}
+	if fixed, err := CheckServingAddrs(ts, tablet, 50, healthMap); err != nil || !fixed {
+		t.Errorf("CheckServingAddrs with a new health map = %v, %v", fixed, err)
+	}
+	addrs, err = ts.GetEndPoints("cell1", "test_keyspace", "0", topo.TYPE_REPLICA)
+	if err != nil {
+		t.Fatalf("GetEndPoints failed: %v", err)
+	}
+	if lag, ok := addrs.Entries[1].ReplicationLag(); !ok || lag != 12*time.Second {
+		t.Errorf("want a replication lag of 12s, got %v, %v", lag, ok)
+	}
+	if fixed, err := CheckServingAddrs(ts, tablet, 50, healthMap); err != nil || fixed {
+		t.Errorf("CheckServingAddrs in sync = %v, %v", fixed, err)
+	}
+}
+
+func TestHealthScore(t *testing.T) {
+	for _, tc := range []struct {
+		healthErr error
+		lag       time.Duration
+		want      int
+	}{
+		{nil, 0, topo.MAX_HEALTH},
+		{nil, 2 * time.Second, topo.MAX_HEALTH},
+		{nil, 3 * time.Second, 90},
+		{nil, 15 * time.Second, 50},
+		{nil, 29 * time.Second, 10},
+		{nil, 30 * time.Second, 1},
+		{nil, time.Hour, 1},
+		{fmt.Errorf("mysql is down"), 0, 1},
+	} {
+		if got := HealthScore(tc.healthErr, tc.lag); got != tc.want {
+			t.Errorf("HealthScore(%v, %v) = %v, want %v", tc.healthErr, tc.lag, got, tc.want)
+		}
+	}
+}
+
+func TestRunActionTimeout(t *testing.T) {
+	agent := &ActionAgent{
+		ActionTimeout:  100 * time.Millisecond,
+		runningActions: make(map[string]*runningAction),
+	}
+
+	output, timedOut, err := agent.runAction(exec.Command("sh", "-c", "echo done"), actionPriority{}, "guid1")
+	if err != nil || timedOut || output.String() != "done\n" {
+		t.Errorf("runAction = %q, %v, %v", output, timedOut, err)
+	}
+
+	// The background sleep holds the output pipe: it has to be
+	// killed too for runAction to return.
+	timedOutBefore := actionCounts.Counts()["TimedOut"]
+	start := time.Now()
+	_, timedOut, err = agent.runAction(exec.Command("sh", "-c", "sleep 10 & sleep 10"), actionPriority{}, "guid2")
+	if !timedOut || !IsActionError(err, ErrActionTimeout) || !strings.HasPrefix(err.Error(), "action timed out after 100ms") {
+		t.Errorf("want a timeout, got %v, %v", timedOut, err)
+	}
+	if got := actionCounts.Counts()["TimedOut"]; got != timedOutBefore+1 {
+		t.Errorf("TimedOut count = %v, want %v", got, timedOutBefore+1)
+	}
+	if elapsed := time.Now().Sub(start); elapsed > 5*time.Second {
+		t.Errorf("runAction took %v", elapsed)
+	}
+}
+
+func TestRunActionErrors(t *testing.T) {
+	agent := &ActionAgent{runningActions: make(map[string]*runningAction)}
+
+	_, _, err := agent.runAction(exec.Command("sh", "-c", "exit 3"), actionPriority{}, "guid1")
+	if ae, ok := err.(*ActionError); !ok || ae.Kind != ErrActionFailed || ae.ExitStatus != 3 {
+		t.Errorf("want a failed action with exit status 3, got %#v", err)
+	}
+
+	_, _, err = agent.runAction(exec.Command("/nonexistent/vtaction"), actionPriority{}, "guid2")
+	if !IsActionError(err, ErrActionBinaryMissing) {
+		t.Errorf("want a missing binary, got %#v", err)
+	}
+}
+
+func TestRunActionCancel(t *testing.T) {
+	agent := &ActionAgent{runningActions: make(map[string]*runningAction)}
+
+	// Cancelling an action that isn't running does nothing.
+	agent.cancelAction("guid1")
+
+	go func() {
+		for {
+			agent.runningMu.Lock()
+			_, ok := agent.runningActions["guid1"]
+			agent.runningMu.Unlock()
+			if ok {
+				agent.cancelAction("guid1")
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	cancelledBefore := actionCounts.Counts()["Cancelled"]
+	start := time.Now()
+	_, cancelled, err := agent.runAction(exec.Command("sleep", "10"), actionPriority{}, "guid1")
+	if !cancelled || err == nil || !strings.HasPrefix(err.Error(), "action cancelled") {
+		t.Errorf("want a cancelled action, got %v, %v", cancelled, err)
+	}
+	if got := actionCounts.Counts()["Cancelled"]; got != cancelledBefore+1 {
+		t.Errorf("Cancelled count = %v, want %v", got, cancelledBefore+1)
+	}
+	if elapsed := time.Now().Sub(start); elapsed > 5*time.Second {
+		t.Errorf("runAction took %v", elapsed)
+	}
+	if len(agent.runningActions) != 0 {
+		t.Errorf("runningActions = %v, want empty", agent.runningActions)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+		if got := retryDelay(time.Second, attempt); got != want {
+			t.Errorf("retryDelay(1s, %v) = %v, want %v", attempt, got, want)
+		}
+	}
+}
+
+// createTestTablet returns a test topo server with tablet in it, ready
+// to queue actions. A nil tablet is cell1-1 on localhost in
+// test_keyspace.
+func createTestTablet(t *testing.T, tablet *topo.Tablet) (topo.Server, topo.TabletAlias) {
+	if tablet == nil {
+		tablet = &topo.Tablet{Alias: topo.TabletAlias{Cell: "cell1", Uid: 1}, Hostname: "localhost", Keyspace: "test_keyspace"}
+	}
+	ts := zktopo.NewTestServer(t, []string{tablet.Alias.Cell})
+	if err := ts.CreateTablet(tablet); err != nil {
+		t.Fatalf("CreateTablet: %v", err)
+	}
+	if err := ts.ValidateTabletActions(tablet.Alias); err != nil {
+		t.Fatalf("ValidateTabletActions: %v", err)
+	}
+	return ts, tablet.Alias
+}
+
+func newTestAgent(t *testing.T, ts topo.Server, tabletAlias topo.TabletAlias, mysqld *mysqlctl.Mysqld) *ActionAgent {
+	agent, err := NewActionAgent(ts, tabletAlias, mysqld)
+	if err != nil {
+		t.Fatalf("NewActionAgent: %v", err)
+	}
+	return agent
+}
+
+func TestActionUnfinished(t *testing.T) {
+	ts, tabletAlias := createTestTablet(t, nil)
+
+	node := &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING}
+	actionPath, err := ts.WriteTabletAction(tabletAlias, node.ToJson())
+	if err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	if !actionUnfinished(ts, actionPath) {
+		t.Errorf("queued action should be unfinished")
+	}
+
+	// vtaction died while running it
+	node.State = actionnode.ACTION_STATE_RUNNING
+	if err := ts.UpdateTabletAction(actionPath, node.ToJson(), -1); err != nil {
+		t.Fatalf("UpdateTabletAction: %v", err)
+	}
+	if !actionUnfinished(ts, actionPath) {
+		t.Errorf("running action should be unfinished")
+	}
+
+	// vtaction recorded its failure
+	if err := StoreActionResponse(ts, node, actionPath, fmt.Errorf("mysql is down")); err != nil {
+		t.Fatalf("StoreActionResponse: %v", err)
+	}
+	if actionUnfinished(ts, actionPath) {
+		t.Errorf("failed action should not be unfinished")
+	}
+	if err := ts.UnblockTabletAction(actionPath); err != nil {
+		t.Fatalf("UnblockTabletAction: %v", err)
+	}
+	if actionUnfinished(ts, actionPath) {
+		t.Errorf("removed action should not be unfinished")
+	}
+}
+
+func TestActionQueueVerify(t *testing.T) {
+	flag.Set("action_queue_verify", "true")
+	defer flag.Set("action_queue_verify", "false")
+	ts, tabletAlias := createTestTablet(t, nil)
+
+	// vtaction completes the action, and dies before unblocking it.
+	node := (&actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING}).SetGuid()
+	actionPath, err := ts.WriteTabletAction(tabletAlias, node.ToJson())
+	if err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	if err := StoreActionResponse(ts, node, actionPath, nil); err != nil {
+		t.Fatalf("StoreActionResponse: %v", err)
+	}
+	completed, _, err := ts.(zktopo.TestServer).Server.(*zktopo.Server).GetZConn().Get(path.Join(zktopo.TabletPathForAlias(tabletAlias), "actioncompleted"))
+	if err != nil || !strings.Contains(completed, node.ActionGuid) {
+		t.Fatalf("action %v wasn't recorded as completed: %q, %v", node.ActionGuid, completed, err)
+	}
+
+	// The restarted agent removes it without running it again.
+	agent := newTestAgent(t, ts, tabletAlias, nil)
+	executor := &fakeExecutor{}
+	agent.Executor = executor
+	if err := agent.readTablet(); err != nil {
+		t.Fatalf("readTablet: %v", err)
+	}
+	agent.actionLoopWg.Add(1)
+	go agent.actionEventLoop()
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		if _, _, _, err := ts.ReadTabletActionPath(actionPath); err == topo.ErrNoNode {
+			break
+		}
+		if time.Now().Sub(start) > 5*time.Second {
+			t.Fatalf("completed action %v is still queued", actionPath)
+		}
+	}
+	agent.Stop()
+	if len(executor.executed) != 0 {
+		t.Errorf("completed action was run again: %v", executor.executed)
+	}
+}
+
+func TestStop(t *testing.T) {
+	ts, tabletAlias := createTestTablet(t, &topo.Tablet{Alias: topo.TabletAlias{Cell: "cell1", Uid: 1}, Hostname: "localhost", Keyspace: "test_keyspace", Shard: "0", Type: topo.TYPE_REPLICA})
+	addrs := topo.NewEndPoints()
+	addrs.Entries = append(addrs.Entries, topo.EndPoint{Uid: 1, Host: "localhost"}, topo.EndPoint{Uid: 2, Host: "otherhost"})
+	if err := ts.UpdateEndPoints("cell1", "test_keyspace", "0", topo.TYPE_REPLICA, addrs); err != nil {
+		t.Fatalf("UpdateEndPoints: %v", err)
+	}
+	agent := newTestAgent(t, ts, tabletAlias, nil)
+	if err := agent.readTablet(); err != nil {
+		t.Fatalf("readTablet: %v", err)
+	}
+	if err := ts.CreateTabletPidNode(tabletAlias, "pid", agent.done); err != nil {
+		t.Fatalf("CreateTabletPidNode: %v", err)
+	}
+	agent.actionLoopWg.Add(1)
+	go agent.actionEventLoop()
+
+	stopped := make(chan struct{})
+	go func() {
+		agent.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Stop didn't return")
+	}
+	if err := ts.ValidateTabletPidNode(tabletAlias); err == nil {
+		t.Errorf("pid node is still there")
+	}
+	addrs, err := ts.GetEndPoints("cell1", "test_keyspace", "0", topo.TYPE_REPLICA)
+	if err != nil {
+		t.Fatalf("GetEndPoints: %v", err)
+	}
+	if len(addrs.Entries) != 1 || addrs.Entries[0].Uid != 2 {
+		t.Errorf("tablet is still in the serving graph: %v", addrs.Entries)
+	}
+}
+
+func TestResolvePaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resolve_paths")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	for _, d := range []string{"root/bin", "path"} {
+		if err := os.MkdirAll(path.Join(dir, d), 0755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, d, "vtaction"), nil, 0755); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	defer os.Setenv("VTROOT", os.Getenv("VTROOT"))
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", path.Join(dir, "path"))
+	agent := &ActionAgent{}
+
+	// $VTROOT/bin first, then $PATH
+	os.Setenv("VTROOT", path.Join(dir, "root"))
+	if err := agent.resolvePaths(); err != nil || agent.vtActionBinFile != path.Join(dir, "root/bin/vtaction") {
+		t.Errorf("resolvePaths with $VTROOT = %v, %v", agent.vtActionBinFile, err)
+	}
+	os.Setenv("VTROOT", path.Join(dir, "path"))
+	if err := agent.resolvePaths(); err != nil || agent.vtActionBinFile != path.Join(dir, "path/vtaction") {
+		t.Errorf("resolvePaths with $PATH = %v, %v", agent.vtActionBinFile, err)
+	}
+
+	// -vtaction_path wins, and has to exist
+	defer func() { *vtActionPath = "" }()
+	*vtActionPath = path.Join(dir, "path/vtaction")
+	os.Setenv("VTROOT", path.Join(dir, "root"))
+	if err := agent.resolvePaths(); err != nil || agent.vtActionBinFile != *vtActionPath {
+		t.Errorf("resolvePaths with -vtaction_path = %v, %v", agent.vtActionBinFile, err)
+	}
+	*vtActionPath = path.Join(dir, "missing/vtaction")
+	if err := agent.resolvePaths(); err == nil || !strings.Contains(err.Error(), "-vtaction_path") {
+		t.Errorf("resolvePaths with a missing -vtaction_path: %v", err)
+	}
+}
+
+// fakeExecutor is an ActionExecutor that runs nothing.
+type fakeExecutor struct {
+	executed []string
+	err      error
+}
+
+func (fe *fakeExecutor) Execute(actionNode *actionnode.ActionNode) (*actionnode.ActionResult, error) {
+	fe.executed = append(fe.executed, actionNode.Path)
+	if fe.err == nil {
+		return &actionnode.ActionResult{Output: "fake output"}, nil
+	}
+	return &actionnode.ActionResult{ExitStatus: 1, Output: "fake output", Error: fe.err.Error()}, fe.err
+}
+
+func TestDispatchActionExecutor(t *testing.T) {
+	ts, tabletAlias := createTestTablet(t, nil)
+	agent := newTestAgent(t, ts, tabletAlias, nil)
+	executor := &fakeExecutor{err: fmt.Errorf("cannot connect")}
+	agent.Executor = executor
+
+	data := (&actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING}).SetGuid().ToJson()
+	actionPath, err := ts.WriteTabletAction(tabletAlias, data)
+	if err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	failedBefore := actionCounts.Counts()["Failed"]
+	if err := agent.dispatchAction(actionPath, data); err != executor.err {
+		t.Errorf("dispatchAction = %v, want %v", err, executor.err)
+	}
+	if len(executor.executed) != 1 || executor.executed[0] != actionPath {
+		t.Errorf("executed %v, want [%v]", executor.executed, actionPath)
+	}
+	if got := actionCounts.Counts()["Failed"]; got != failedBefore+1 {
+		t.Errorf("Failed count = %v, want %v", got, failedBefore+1)
+	}
+
+	// the result is in the action node
+	_, data, _, err = ts.ReadTabletActionPath(actionPath)
+	if err != nil {
+		t.Fatalf("ReadTabletActionPath: %v", err)
+	}
+	actionNode, err := actionnode.ActionNodeFromJson(data, actionPath)
+	if err != nil {
+		t.Fatalf("ActionNodeFromJson: %v", err)
+	}
+	if actionNode.Result == nil || actionNode.Result.Output != "fake output" {
+		t.Errorf("unexpected result: %+v", actionNode.Result)
+	}
+}
+
+func TestDispatchInvalidAction(t *testing.T) {
+	ts, tabletAlias := createTestTablet(t, nil)
+	agent := newTestAgent(t, ts, tabletAlias, nil)
+	executor := &fakeExecutor{}
+	agent.Executor = executor
+
+	data := (&actionnode.ActionNode{Action: actionnode.TABLET_ACTION_EXECUTE_HOOK, Args: &hook.Hook{}}).SetGuid().ToJson()
+	actionPath, err := ts.WriteTabletAction(tabletAlias, data)
+	if err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	if err := agent.dispatchAction(actionPath, data); err != nil {
+		t.Errorf("dispatchAction = %v, want nil so the queue goes on", err)
+	}
+	if len(executor.executed) != 0 {
+		t.Errorf("invalid action was executed")
+	}
+
+	// the action was completed, as a failure
+	data, err = ts.WaitForTabletAction(actionPath, time.Second, nil)
+	if err != nil {
+		t.Fatalf("WaitForTabletAction: %v", err)
+	}
+	actionNode, err := actionnode.ActionNodeFromJson(data, actionPath)
+	if err != nil {
+		t.Fatalf("ActionNodeFromJson: %v", err)
+	}
+	if actionNode.State != actionnode.ACTION_STATE_FAILED || !strings.HasPrefix(actionNode.Error, "invalid action: ") {
+		t.Errorf("unexpected action: %v %v", actionNode.State, actionNode.Error)
+	}
+}
+
+func TestDispatchReadOnlyAction(t *testing.T) {
+	ts, tabletAlias := createTestTablet(t, nil)
+	agent := newTestAgent(t, ts, tabletAlias, nil)
+	executor := &fakeExecutor{}
+	agent.Executor = executor
+	if err := agent.readTablet(); err != nil {
+		t.Fatalf("readTablet: %v", err)
+	}
+
+	// change the tablet behind the agent's back: a read-only
+	// action doesn't reload it (and doesn't need a Mysqld to do so)
+	tablet, err := ts.GetTablet(tabletAlias)
+	if err != nil {
+		t.Fatalf("GetTablet: %v", err)
+	}
+	tablet.Hostname = "otherhost"
+	if err := topo.UpdateTablet(ts, tablet); err != nil {
+		t.Fatalf("UpdateTablet: %v", err)
+	}
+
+	data := (&actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING}).SetGuid().ToJson()
+	actionPath, err := ts.WriteTabletAction(tabletAlias, data)
+	if err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	if err := agent.dispatchAction(actionPath, data); err != nil {
+		t.Errorf("dispatchAction: %v", err)
+	}
+	if got := agent.Tablet().Hostname; got != "localhost" {
+		t.Errorf("tablet was reloaded after a read-only action: hostname = %v", got)
+	}
+}
+
+func TestDispatchDryRun(t *testing.T) {
+	ts, tabletAlias := createTestTablet(t, nil)
+	mysqld := mysqlctl.NewMysqld(mysqlctl.NewMycnf(1, 3306, mysqlctl.VtReplParams{}), &mysql.ConnectionParams{}, &mysql.ConnectionParams{})
+	agent := newTestAgent(t, ts, tabletAlias, mysqld)
+	executor := &fakeExecutor{}
+	agent.Executor = executor
+	agent.vtActionBinFile = "/bin/vtaction"
+	agent.DryRun = true
+
+	tabletType := topo.TYPE_SPARE
+	data := (&actionnode.ActionNode{Action: actionnode.TABLET_ACTION_CHANGE_TYPE, Args: &tabletType}).SetGuid().ToJson()
+	actionPath, err := ts.WriteTabletAction(tabletAlias, data)
+	if err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	dryRunBefore := actionCounts.Counts()["DryRun"]
+	if err := agent.dispatchAction(actionPath, data); err != nil {
+		t.Errorf("dispatchAction: %v", err)
+	}
+	if len(executor.executed) != 0 {
+		t.Errorf("dry run action was executed")
+	}
+	if got := actionCounts.Counts()["DryRun"]; got != dryRunBefore+1 {
+		t.Errorf("DryRun count = %v, want %v", got, dryRunBefore+1)
+	}
+
+	// the action was completed, with a dry run result
+	data, err = ts.WaitForTabletAction(actionPath, time.Second, nil)
+	if err != nil {
+		t.Fatalf("WaitForTabletAction: %v", err)
+	}
+	actionNode, err := actionnode.ActionNodeFromJson(data, actionPath)
+	if err != nil {
+		t.Fatalf("ActionNodeFromJson: %v", err)
+	}
+	if actionNode.State != actionnode.ACTION_STATE_DONE || actionNode.Result == nil || !actionNode.Result.DryRun {
+		t.Errorf("unexpected action: %v %+v", actionNode.State, actionNode.Result)
+	}
+	if !strings.Contains(actionNode.Result.Output, "/bin/vtaction -action ChangeType") {
+		t.Errorf("unexpected dry run output: %v", actionNode.Result.Output)
+	}
+}
+
+func TestCheckPidNode(t *testing.T) {
+	ts, tabletAlias := createTestTablet(t, nil)
+	agent := newTestAgent(t, ts, tabletAlias, nil)
+
+	// no pid node
+	if err := agent.checkPidNode("localhost"); err != nil {
+		t.Errorf("checkPidNode without a pid node: %v", err)
+	}
+
+	// the pid of a dead process
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("cannot run true: %v", err)
+	}
+	deadPid := cmd.Process.Pid
+
+	for _, data := range []string{
+		fmt.Sprintf("host:localhost\npid:%v\n", deadPid),
+		"garbage",
+	} {
+		done := make(chan struct{})
+		if err := ts.CreateTabletPidNode(tabletAlias, data, done); err != nil {
+			t.Fatalf("CreateTabletPidNode: %v", err)
+		}
+		// so the node isn't recreated once removed
+		close(done)
+		if err := agent.checkPidNode("localhost"); err != nil {
+			t.Errorf("checkPidNode(%q): %v", data, err)
+		}
+		if _, err := ts.GetTabletPidNode(tabletAlias); err != topo.ErrNoNode {
+			t.Errorf("pid node %q wasn't removed: %v", data, err)
+		}
+	}
+
+	// an agent on another host is only taken over with ForcePidNode
+	done := make(chan struct{})
+	if err := ts.CreateTabletPidNode(tabletAlias, fmt.Sprintf("host:otherhost\npid:%v\n", os.Getppid()), done); err != nil {
+		t.Fatalf("CreateTabletPidNode: %v", err)
+	}
+	close(done)
+	if err := agent.checkPidNode("localhost"); err == nil || !strings.Contains(err.Error(), "use -force to take over") {
+		t.Errorf("checkPidNode with an agent on another host: want an error, got %v", err)
+	}
+	if _, err := ts.GetTabletPidNode(tabletAlias); err != nil {
+		t.Errorf("pid node of the other host was removed: %v", err)
+	}
+	agent.ForcePidNode = true
+	if err := agent.checkPidNode("localhost"); err != nil {
+		t.Errorf("checkPidNode with ForcePidNode: %v", err)
+	}
+	if _, err := ts.GetTabletPidNode(tabletAlias); err != topo.ErrNoNode {
+		t.Errorf("pid node of the other host wasn't removed with ForcePidNode: %v", err)
+	}
+	agent.ForcePidNode = false
+
+	// a live agent on this host
+	done = make(chan struct{})
+	defer close(done)
+	if err := ts.CreateTabletPidNode(tabletAlias, fmt.Sprintf("host:localhost\npid:%v\n", os.Getppid()), done); err != nil {
+		t.Fatalf("CreateTabletPidNode: %v", err)
+	}
+	defer func() {
+		if x := recover(); x == nil || !strings.Contains(fmt.Sprint(x), "another agent already running") {
+			t.Errorf("checkPidNode with a live agent: want a panic, got %v", x)
+		}
+	}()
+	agent.checkPidNode("localhost")
+}
+
+func TestEndPointForTablet(t *testing.T) {
+	tablet := &topo.Tablet{
+		Alias:    topo.TabletAlias{Cell: "cell1", Uid: 1},
+		Hostname: "host",
+		Portmap:  map[string]int{"vt": 8101, "vts": 8102, "mysql": 3301, "grpc": 8103},
+	}
+	entry, err := EndPointForTablet(tablet)
+	if err != nil {
+		t.Fatalf("EndPointForTablet: %v", err)
+	}
+	want := map[string]int{"_vtocc": 8101, "_vts": 8102, "_mysql": 3301, "_grpc": 8103}
+	if !reflect.DeepEqual(entry.NamedPortMap, want) {
+		t.Errorf("NamedPortMap = %v, want %v", entry.NamedPortMap, want)
+	}
+
+	tablet.Tags = map[string]string{topo.WEIGHT_TAG: "50"}
+	if entry, err = EndPointForTablet(tablet); err != nil || entry.Weight != 50 {
+		t.Errorf("EndPointForTablet with a weight: want 50, got %v, %v", entry, err)
+	}
+	tablet.Tags[topo.WEIGHT_TAG] = "heavy"
+	if entry, err = EndPointForTablet(tablet); err != nil || entry.Weight != 0 {
+		t.Errorf("EndPointForTablet with a bad weight: want 0, got %v, %v", entry, err)
+	}
+
+	delete(tablet.Portmap, "mysql")
+	if _, err := EndPointForTablet(tablet); err == nil {
+		t.Errorf("EndPointForTablet without a mysql port: want an error")
+	}
+}
+
+func TestParseExtraPorts(t *testing.T) {
+	ports, err := parseExtraPorts("grpc:8103,status:8104")
+	if err != nil {
+		t.Fatalf("parseExtraPorts: %v", err)
+	}
+	if want := map[string]int{"grpc": 8103, "status": 8104}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("parseExtraPorts = %v, want %v", ports, want)
+	}
+	if ports, err := parseExtraPorts(""); err != nil || len(ports) != 0 {
+		t.Errorf("parseExtraPorts(\"\") = %v, %v", ports, err)
+	}
+	for _, value := range []string{"grpc", "grpc:", ":8103", "grpc:port", "grpc:70000", "vt:8101"} {
+		if _, err := parseExtraPorts(value); err == nil {
+			t.Errorf("parseExtraPorts(%q): want an error", value)
+		}
+	}
+}
+
+func TestRunTypeChangeCallbacks(t *testing.T) {
+	agent := &ActionAgent{}
+	type change struct {
+		oldType, newType topo.TabletType
+		context          string
+	}
+	changes := make(chan change, 10)
+	agent.OnTabletTypeChange(func(oldType, newType topo.TabletType, context string) {
+		changes <- change{oldType, newType, context}
+	})
+
+	agent.runTypeChangeCallbacks(topo.TYPE_REPLICA, topo.TYPE_REPLICA, "Ping")
+	agent.runTypeChangeCallbacks(topo.TYPE_REPLICA, topo.TYPE_MASTER, "ReparentShard")
+	select {
+	case got := <-changes:
+		if want := (change{topo.TYPE_REPLICA, topo.TYPE_MASTER, "ReparentShard"}); got != want {
+			t.Errorf("type change callback got %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("type change callback not called")
+	}
+	select {
+	case got := <-changes:
+		t.Errorf("unexpected type change callback: %v", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+	if got := tabletTypeChanges.Counts()["replica->master"]; got != 1 {
+		t.Errorf("TabletTypeChanges[replica->master] = %v, want 1", got)
+	}
+}
+
+// currentActionExecutor records the CurrentAction of the agent while
+// it executes an action.
+type currentActionExecutor struct {
+	agent *ActionAgent
+	seen  *CurrentAction
+}
+
+func (cae *currentActionExecutor) Execute(actionNode *actionnode.ActionNode) (*actionnode.ActionResult, error) {
+	cae.seen = cae.agent.CurrentAction()
+	return &actionnode.ActionResult{}, nil
+}
+
+func TestCurrentAction(t *testing.T) {
+	ts, tabletAlias := createTestTablet(t, nil)
+	agent := newTestAgent(t, ts, tabletAlias, nil)
+	executor := &currentActionExecutor{agent: agent}
+	agent.Executor = executor
+	if current := agent.CurrentAction(); current != nil {
+		t.Errorf("idle agent has a current action: %+v", current)
+	}
+
+	actionNode := (&actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING}).SetGuid()
+	data := actionNode.ToJson()
+	actionPath, err := ts.WriteTabletAction(tabletAlias, data)
+	if err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	before := time.Now()
+	if err := agent.dispatchAction(actionPath, data); err != nil {
+		t.Fatalf("dispatchAction: %v", err)
+	}
+	if seen := executor.seen; seen == nil || seen.ActionGuid != actionNode.ActionGuid || seen.Action != actionnode.TABLET_ACTION_PING || seen.Start.Before(before) {
+		t.Errorf("unexpected current action while running: %+v", seen)
+	}
+	if current := agent.CurrentAction(); current != nil {
+		t.Errorf("idle agent has a current action: %+v", current)
+	}
+
+	// The RPCs taking the action lock are reported too.
+	var seen *CurrentAction
+	if err := agent.RpcWrapLock("test", "Test", nil, nil, func() error {
+		seen = agent.CurrentAction()
+		return nil
+	}); err != nil {
+		t.Fatalf("RpcWrapLock: %v", err)
+	}
+	if seen == nil || seen.Action != "RPC(Test)" {
+		t.Errorf("unexpected current action in RPC: %+v", seen)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+)
+
+// maxCallbackPayload is the max size of the result POSTed to an
+// action callback url. Bigger replies are left out.
+const maxCallbackPayload = 64 * 1024
+
+var (
+	actionCallbackRetries  = flag.Int("action_callback_retries", 3, "how many times to retry POSTing an action result to its callback url")
+	actionCallbackTimeout  = flag.Duration("action_callback_timeout", 5*time.Second, "timeout of each POST of an action result to its callback url")
+	actionCallbackDeadline = flag.Duration("action_callback_deadline", 5*time.Second, "how long POSTing an action result to its callback url can take overall, retries included: vtaction waits for it before exiting")
+
+	// actionCallbackRetryDelay is the delay between two tries.
+	actionCallbackRetryDelay = 1 * time.Second
+
+	actionCallbacks = stats.NewCounters("ActionCallbacks")
+
+	// pendingCallbacks are the callbacks startActionCallback started.
+	pendingCallbacks sync.WaitGroup
+)
+
+// ActionResult is what is POSTed to the CallbackUrl of an ActionNode.
+type ActionResult struct {
+	Action     string
+	ActionGuid string
+	Path       string
+	State      actionnode.ActionState
+	Error      string
+	Reply      interface{} `json:",omitempty"`
+
+	// ReplyTruncated is set if Reply was left out
+	// because it was too big.
+	ReplyTruncated bool `json:",omitempty"`
+}
+
+// callbackPayload returns the JSON ActionResult for actionNode.
+func callbackPayload(actionNode *actionnode.ActionNode) ([]byte, error) {
+	result := &ActionResult{
+		Action:     actionNode.Action,
+		ActionGuid: actionNode.ActionGuid,
+		Path:       actionNode.Path,
+		State:      actionNode.State,
+		Error:      actionNode.Error,
+		Reply:      actionNode.Reply,
+	}
+	data, err := json.Marshal(result)
+	if err != nil || len(data) <= maxCallbackPayload {
+		return data, err
+	}
+	result.Reply = nil
+	result.ReplyTruncated = true
+	if len(result.Error) > maxCallbackPayload/2 {
+		result.Error = result.Error[:maxCallbackPayload/2]
+	}
+	return json.Marshal(result)
+}
+
+// startActionCallback POSTs the result of actionNode to its
+// CallbackUrl in the background, for -action_callback_deadline at
+// most. WaitForActionCallbacks waits for it.
+func startActionCallback(actionNode *actionnode.ActionNode) {
+	deadline := time.Now().Add(*actionCallbackDeadline)
+	pendingCallbacks.Add(1)
+	go func() {
+		defer pendingCallbacks.Done()
+		notifyActionCallback(actionNode, deadline)
+	}()
+}
+
+// WaitForActionCallbacks waits for the callbacks in progress,
+// which are bounded by -action_callback_deadline. vtaction calls it
+// before exiting.
+func WaitForActionCallbacks() {
+	pendingCallbacks.Wait()
+}
+
+// notifyActionCallback POSTs the result of actionNode to its
+// CallbackUrl. Failures are retried -action_callback_retries times
+// until deadline, then logged: the action itself is complete by then.
+func notifyActionCallback(actionNode *actionnode.ActionNode, deadline time.Time) {
+	if err := actionnode.ValidateCallbackUrl(actionNode.CallbackUrl); err != nil {
+		log.Errorf("not notifying action %v: %v", actionNode.ActionGuid, err)
+		actionCallbacks.Add("Failed", 1)
+		return
+	}
+	data, err := callbackPayload(actionNode)
+	if err != nil {
+		log.Errorf("not notifying action %v: cannot encode result: %v", actionNode.ActionGuid, err)
+		actionCallbacks.Add("Failed", 1)
+		return
+	}
+
+	for i := 0; ; i++ {
+		timeout := deadline.Sub(time.Now())
+		if timeout > *actionCallbackTimeout {
+			timeout = *actionCallbackTimeout
+		}
+		err = postCallback(&http.Client{Timeout: timeout}, actionNode.CallbackUrl, data)
+		if err == nil {
+			actionCallbacks.Add("Sent", 1)
+			return
+		}
+		if i >= *actionCallbackRetries || !time.Now().Add(actionCallbackRetryDelay).Before(deadline) {
+			break
+		}
+		log.Warningf("action %v callback to %v failed, retrying: %v", actionNode.ActionGuid, actionNode.CallbackUrl, err)
+		actionCallbacks.Add("Retried", 1)
+		time.Sleep(actionCallbackRetryDelay)
+	}
+	log.Errorf("action %v callback to %v failed: %v", actionNode.ActionGuid, actionNode.CallbackUrl, err)
+	actionCallbacks.Add("Failed", 1)
+}
+
+func postCallback(client *http.Client, callbackUrl string, data []byte) error {
+	resp, err := client.Post(callbackUrl, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+)
+
+func TestNotifyActionCallback(t *testing.T) {
+	actionCallbackRetryDelay = time.Millisecond
+	failures := 2
+	var received []ActionResult
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			http.Error(w, "not yet", http.StatusServiceUnavailable)
+			return
+		}
+		result := ActionResult{}
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			t.Errorf("cannot decode callback: %v", err)
+		}
+		received = append(received, result)
+	}))
+	defer server.Close()
+
+	actionNode := &actionnode.ActionNode{
+		Action:      actionnode.TABLET_ACTION_PING,
+		ActionGuid:  "guid",
+		State:       actionnode.ACTION_STATE_FAILED,
+		Error:       "some error",
+		CallbackUrl: server.URL,
+	}
+	notifyActionCallback(actionNode, time.Now().Add(time.Minute))
+	if len(received) != 1 {
+		t.Fatalf("want 1 callback, got %v", len(received))
+	}
+	if r := received[0]; r.ActionGuid != "guid" || r.State != actionnode.ACTION_STATE_FAILED || r.Error != "some error" {
+		t.Errorf("unexpected callback: %+v", r)
+	}
+
+	// Retries are bounded.
+	failures = *actionCallbackRetries + 1
+	notifyActionCallback(actionNode, time.Now().Add(time.Minute))
+	if len(received) != 1 || failures != 0 {
+		t.Errorf("want no new callback after %v tries, got %v, %v failures left", *actionCallbackRetries+1, len(received), failures)
+	}
+
+	// So is the time they take.
+	defer func(retries int) { *actionCallbackRetries = retries }(*actionCallbackRetries)
+	*actionCallbackRetries = 1000000
+	failures = *actionCallbackRetries
+	start := time.Now()
+	notifyActionCallback(actionNode, start.Add(50*time.Millisecond))
+	if elapsed := time.Now().Sub(start); elapsed > time.Second || len(received) != 1 {
+		t.Errorf("want no new callback after 50ms, got %v after %v", len(received), elapsed)
+	}
+	failures = 0
+
+	// Big replies are left out.
+	actionNode.Reply = strings.Repeat("x", maxCallbackPayload)
+	notifyActionCallback(actionNode, time.Now().Add(time.Minute))
+	if len(received) != 2 || received[1].Reply != nil || !received[1].ReplyTruncated {
+		t.Errorf("want a truncated callback, got %+v", received)
+	}
+}
+
+func TestStartActionCallback(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := ActionResult{}
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			t.Errorf("cannot decode callback: %v", err)
+		}
+		received <- result.ActionGuid
+	}))
+	defer server.Close()
+
+	startActionCallback(&actionnode.ActionNode{ActionGuid: "guid", CallbackUrl: server.URL})
+	WaitForActionCallbacks()
+	select {
+	case guid := <-received:
+		if guid != "guid" {
+			t.Errorf("want callback for guid, got %v", guid)
+		}
+	default:
+		t.Errorf("callback wasn't sent when WaitForActionCallbacks returned")
+	}
+}
+
+func TestValidateCallbackUrl(t *testing.T) {
+	for _, u := range []string{"http://host:8080/path", "https://host/"} {
+		if err := actionnode.ValidateCallbackUrl(u); err != nil {
+			t.Errorf("ValidateCallbackUrl(%v): %v", u, err)
+		}
+	}
+	for _, u := range []string{"", "host:8080", "ftp://host/", "http:///path"} {
+		if err := actionnode.ValidateCallbackUrl(u); err == nil {
+			t.Errorf("ValidateCallbackUrl(%v) succeeded", u)
+		}
+	}
+}
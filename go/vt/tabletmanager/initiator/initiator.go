@@ -13,6 +13,7 @@
 package initiator
 
 import (
+	"flag"
 	"fmt"
 	"sync"
 	"time"
@@ -32,6 +33,10 @@ import (
 // Errors are written to the action node and must (currently) be resolved by
 // hand using zk tools.
 
+var actionCallbackUrl = flag.String("action_callback_url", "", "if set, the agents POST the result of the actions to this url when they complete")
+
+var actionTTL = flag.Duration("action_ttl", 0, "if set, the actions queued for the tablets expire if they didn't start after that long, e.g. because this process died and nobody waits for them anymore")
+
 var interrupted = make(chan struct{})
 var once sync.Once
 
@@ -62,12 +67,30 @@ func NewActionInitiator(ts topo.Server, tabletManagerProtocol string) *ActionIni
 }
 
 func (ai *ActionInitiator) writeTabletAction(tabletAlias topo.TabletAlias, node *actionnode.ActionNode) (actionPath string, err error) {
+	if err := setCallbackUrl(node); err != nil {
+		return "", err
+	}
+	if *actionTTL > 0 {
+		node.SetExpiry(*actionTTL)
+	}
 	data := node.SetGuid().ToJson()
 	return ai.ts.WriteTabletAction(tabletAlias, data)
 }
 
+// setCallbackUrl sets the CallbackUrl of the node to -action_callback_url.
+func setCallbackUrl(node *actionnode.ActionNode) error {
+	if *actionCallbackUrl == "" {
+		return nil
+	}
+	if err := actionnode.ValidateCallbackUrl(*actionCallbackUrl); err != nil {
+		return err
+	}
+	node.CallbackUrl = *actionCallbackUrl
+	return nil
+}
+
 func (ai *ActionInitiator) Ping(tabletAlias topo.TabletAlias) (actionPath string, err error) {
-	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING})
+	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING, ParallelSafe: true})
 }
 
 func (ai *ActionInitiator) RpcPing(tabletAlias topo.TabletAlias, waitTime time.Duration) error {
@@ -120,7 +143,7 @@ func (ai *ActionInitiator) MultiSnapshot(tabletAlias topo.TabletAlias, args *act
 }
 
 func (ai *ActionInitiator) MultiRestore(tabletAlias topo.TabletAlias, args *actionnode.MultiRestoreArgs) (actionPath string, err error) {
-	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_MULTI_RESTORE, Args: args})
+	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_MULTI_RESTORE, Args: args, NonIdempotent: true})
 }
 
 func (ai *ActionInitiator) BreakSlaves(tabletAlias topo.TabletAlias) (actionPath string, err error) {
@@ -151,8 +174,12 @@ func (ai *ActionInitiator) RpcSlaveWasRestarted(tablet *topo.TabletInfo, args *a
 	return ai.rpc.SlaveWasRestarted(tablet, args, waitTime)
 }
 
+func (ai *ActionInitiator) CheckReplication(tabletAlias topo.TabletAlias, args *actionnode.CheckReplicationArgs) (actionPath string, err error) {
+	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_CHECK_REPLICATION, Args: args, ParallelSafe: !args.Repair})
+}
+
 func (ai *ActionInitiator) ReparentPosition(tabletAlias topo.TabletAlias, slavePos *myproto.ReplicationPosition) (actionPath string, err error) {
-	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_REPARENT_POSITION, Args: slavePos})
+	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_REPARENT_POSITION, Args: slavePos, ParallelSafe: true})
 }
 
 func (ai *ActionInitiator) MasterPosition(tablet *topo.TabletInfo, waitTime time.Duration) (*myproto.ReplicationPosition, error) {
@@ -230,7 +257,7 @@ func (ai *ActionInitiator) ReserveForRestore(dstTabletAlias topo.TabletAlias, ar
 }
 
 func (ai *ActionInitiator) Restore(dstTabletAlias topo.TabletAlias, args *actionnode.RestoreArgs) (actionPath string, err error) {
-	return ai.writeTabletAction(dstTabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_RESTORE, Args: args})
+	return ai.writeTabletAction(dstTabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_RESTORE, Args: args, NonIdempotent: true})
 }
 
 func (ai *ActionInitiator) Scrap(tabletAlias topo.TabletAlias) (actionPath string, err error) {
@@ -246,7 +273,7 @@ func (ai *ActionInitiator) PreflightSchema(tabletAlias topo.TabletAlias, change
 }
 
 func (ai *ActionInitiator) ApplySchema(tabletAlias topo.TabletAlias, sc *myproto.SchemaChange) (actionPath string, err error) {
-	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_APPLY_SCHEMA, Args: sc})
+	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_APPLY_SCHEMA, Args: sc, NonIdempotent: true})
 }
 
 func (ai *ActionInitiator) ReloadSchema(tablet *topo.TabletInfo, waitTime time.Duration) error {
@@ -263,7 +290,7 @@ func (ai *ActionInitiator) GetPermissions(tabletAlias topo.TabletAlias, waitTime
 }
 
 func (ai *ActionInitiator) ExecuteHook(tabletAlias topo.TabletAlias, _hook *hook.Hook) (actionPath string, err error) {
-	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_EXECUTE_HOOK, Args: _hook})
+	return ai.writeTabletAction(tabletAlias, &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_EXECUTE_HOOK, Args: _hook, NonIdempotent: true})
 }
 
 func (ai *ActionInitiator) GetSlaves(tablet *topo.TabletInfo, waitTime time.Duration) ([]string, error) {
@@ -43,6 +43,8 @@ func (agent *ActionAgent) rpcWrapper(from, name string, args, reply interface{},
 		if time.Now().Sub(beforeLock) > rpcTimeout {
 			return fmt.Errorf("server timeout for " + name)
 		}
+		agent.setCurrentAction(&CurrentAction{Action: "RPC(" + name + ")", Start: time.Now()})
+		defer agent.setCurrentAction(nil)
 	}
 
 	if err = f(); err != nil {
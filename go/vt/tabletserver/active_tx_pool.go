@@ -40,8 +40,21 @@ const (
 	TX_COMMIT   = "commit"
 	TX_ROLLBACK = "rollback"
 	TX_KILL     = "kill"
+	TX_SHUTDOWN = "shutdown"
 )
 
+// txEndings maps a transaction conclusion to how it ended for the
+// TransactionLifetimes stats: closed normally by the client, reaped
+// by the transaction killer, lost with its connection after an error,
+// or closed when shutting down.
+var txEndings = map[string]string{
+	TX_COMMIT:   "Normal",
+	TX_ROLLBACK: "Normal",
+	TX_KILL:     "Reaped",
+	TX_CLOSE:    "Error",
+	TX_SHUTDOWN: "Shutdown",
+}
+
 type ActiveTxPool struct {
 	pool            *pools.Numbered
 	lastId          sync2.AtomicInt64
@@ -49,6 +62,11 @@ type ActiveTxPool struct {
 	ticks           *timer.Timer
 	txStats         *stats.Timings
 	completionStats *stats.Timings
+	lifetimeStats   *stats.Timings
+
+	// tabletType is used to label lifetimeStats, so read and
+	// write transaction patterns can be told apart.
+	tabletType sync2.AtomicString
 }
 
 func NewActiveTxPool(name string, timeout time.Duration) *ActiveTxPool {
@@ -59,6 +77,7 @@ func NewActiveTxPool(name string, timeout time.Duration) *ActiveTxPool {
 		ticks:           timer.NewTimer(timeout / 10),
 		txStats:         stats.NewTimings("Transactions"),
 		completionStats: stats.NewTimings("TransactionCompletion"),
+		lifetimeStats:   stats.NewTimings("TransactionLifetimes"),
 	}
 	stats.Publish(name+"Size", stats.IntFunc(axp.pool.Size))
 	stats.Publish(
@@ -78,7 +97,7 @@ func (axp *ActiveTxPool) Close() {
 	for _, v := range axp.pool.GetOutdated(time.Duration(0), "for closing") {
 		conn := v.(*TxConnection)
 		conn.Close()
-		conn.discard(TX_CLOSE)
+		conn.discard(TX_SHUTDOWN)
 	}
 }
 
@@ -143,6 +162,26 @@ func (axp *ActiveTxPool) Timeout() time.Duration {
 	return axp.timeout.Get()
 }
 
+// SetTabletType sets the tablet type used to label the transaction
+// lifetime stats.
+func (axp *ActiveTxPool) SetTabletType(tabletType string) {
+	axp.tabletType.Set(tabletType)
+}
+
+// TabletType returns the tablet type set by SetTabletType, "" if
+// it wasn't set.
+func (axp *ActiveTxPool) TabletType() string {
+	return axp.tabletType.Get()
+}
+
+func (axp *ActiveTxPool) recordLifetime(conclusion string, lifetime time.Duration) {
+	tabletType := axp.tabletType.Get()
+	if tabletType == "" {
+		tabletType = "unknown"
+	}
+	axp.lifetimeStats.Add(tabletType+"."+txEndings[conclusion], lifetime)
+}
+
 func (axp *ActiveTxPool) SetTimeout(timeout time.Duration) {
 	axp.timeout.Set(timeout)
 	axp.ticks.SetInterval(timeout / 10)
@@ -204,6 +243,7 @@ func (txc *TxConnection) RecordQuery(query string) {
 func (txc *TxConnection) discard(conclusion string) {
 	txc.conclusion = conclusion
 	txc.endTime = time.Now()
+	txc.pool.recordLifetime(conclusion, txc.endTime.Sub(txc.startTime))
 	txc.pool.pool.Unregister(txc.transactionId)
 	txc.PoolConnection.Recycle()
 	// Ensure PoolConnection won't be accessed after Recycle.
@@ -0,0 +1,124 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/pools"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/sync2"
+	"github.com/youtube/vitess/go/timer"
+)
+
+// fakeTxConn is a PoolConnection that accepts any query.
+type fakeTxConn struct {
+	closed bool
+}
+
+func (fc *fakeTxConn) ExecuteFetch(query string, maxrows int, wantfields bool) (*mproto.QueryResult, error) {
+	return &mproto.QueryResult{}, nil
+}
+
+func (fc *fakeTxConn) ExecuteStreamFetch(query string, callback func(*mproto.QueryResult) error, streamBufferSize int) error {
+	return nil
+}
+
+func (fc *fakeTxConn) VerifyStrict() bool { return true }
+func (fc *fakeTxConn) Id() int64          { return 1 }
+func (fc *fakeTxConn) Close()             { fc.closed = true }
+func (fc *fakeTxConn) IsClosed() bool     { return fc.closed }
+func (fc *fakeTxConn) Recycle()           {}
+
+// newTestActiveTxPool returns an ActiveTxPool that doesn't publish
+// its stats, so tests can create as many as they need.
+func newTestActiveTxPool(timeout time.Duration) *ActiveTxPool {
+	if killStats == nil {
+		// normally created by NewQueryEngine
+		killStats = stats.NewCounters("")
+	}
+	return &ActiveTxPool{
+		pool:            pools.NewNumbered(),
+		lastId:          sync2.AtomicInt64(time.Now().UnixNano()),
+		timeout:         sync2.AtomicDuration(timeout),
+		ticks:           timer.NewTimer(timeout / 10),
+		txStats:         stats.NewTimings(""),
+		completionStats: stats.NewTimings(""),
+		lifetimeStats:   stats.NewTimings(""),
+	}
+}
+
+func beginTestTx(t *testing.T, axp *ActiveTxPool) int64 {
+	transactionId, err := axp.SafeBegin(&fakeTxConn{})
+	if err != nil {
+		t.Fatalf("SafeBegin: %v", err)
+	}
+	return transactionId
+}
+
+func checkLifetimes(t *testing.T, axp *ActiveTxPool, want map[string]int64) {
+	counts := axp.lifetimeStats.Counts()
+	for key, count := range want {
+		if counts[key] != count {
+			t.Errorf("TransactionLifetimes[%v] = %v, want %v (all: %v)", key, counts[key], count, counts)
+		}
+	}
+	// Counts also has the total under "All"
+	if len(counts) != len(want)+1 {
+		t.Errorf("unexpected TransactionLifetimes: %v, want %v", counts, want)
+	}
+}
+
+func TestRecordLifetime(t *testing.T) {
+	axp := newTestActiveTxPool(time.Minute)
+	axp.recordLifetime(TX_COMMIT, time.Second)
+	axp.SetTabletType("replica")
+	axp.recordLifetime(TX_ROLLBACK, time.Second)
+	axp.recordLifetime(TX_KILL, time.Second)
+	checkLifetimes(t, axp, map[string]int64{
+		"unknown.Normal": 1,
+		"replica.Normal": 1,
+		"replica.Reaped": 1,
+	})
+}
+
+func TestTransactionLifetimes(t *testing.T) {
+	axp := newTestActiveTxPool(time.Minute)
+	axp.SetTabletType("master")
+
+	// committed and rolled back by the client
+	if _, err := axp.SafeCommit(beginTestTx(t, axp)); err != nil {
+		t.Fatalf("SafeCommit: %v", err)
+	}
+	axp.Rollback(beginTestTx(t, axp))
+
+	// connection lost after an error in the transaction
+	conn := axp.Get(beginTestTx(t, axp))
+	conn.Close()
+	conn.Recycle()
+
+	// reaped by the transaction killer
+	beginTestTx(t, axp)
+	axp.timeout.Set(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	axp.TransactionKiller()
+	axp.timeout.Set(time.Minute)
+
+	// still open when shutting down
+	beginTestTx(t, axp)
+	axp.Close()
+
+	checkLifetimes(t, axp, map[string]int64{
+		"master.Normal":   2,
+		"master.Error":    1,
+		"master.Reaped":   1,
+		"master.Shutdown": 1,
+	})
+	if size := axp.pool.Size(); size != 0 {
+		t.Errorf("want empty pool, got %v transactions", size)
+	}
+}
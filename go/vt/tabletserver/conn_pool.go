@@ -10,6 +10,7 @@ import (
 
 	"github.com/youtube/vitess/go/pools"
 	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/sync2"
 )
 
 var (
@@ -19,13 +20,23 @@ var (
 // ConnectionPool re-exposes ResourcePool as a pool of DBConnection objects
 type ConnectionPool struct {
 	mu          sync.Mutex
+	name        string
 	connections *pools.ResourcePool
 	capacity    int
 	idleTimeout time.Duration
+
+	// failFast and waitTimeout decide what Get and SafeGet do
+	// when the pool is exhausted. See SetWaitPolicy.
+	failFast    bool
+	waitTimeout time.Duration
+
+	// stats
+	waiters   sync2.AtomicInt64
+	exhausted sync2.AtomicInt64
 }
 
 func NewConnectionPool(name string, capacity int, idleTimeout time.Duration) *ConnectionPool {
-	cp := &ConnectionPool{capacity: capacity, idleTimeout: idleTimeout}
+	cp := &ConnectionPool{name: name, capacity: capacity, idleTimeout: idleTimeout}
 	if name == "" {
 		return cp
 	}
@@ -35,6 +46,8 @@ func NewConnectionPool(name string, capacity int, idleTimeout time.Duration) *Co
 	stats.Publish(name+"WaitCount", stats.IntFunc(cp.WaitCount))
 	stats.Publish(name+"WaitTime", stats.DurationFunc(cp.WaitTime))
 	stats.Publish(name+"IdleTimeout", stats.DurationFunc(cp.IdleTimeout))
+	stats.Publish(name+"Waiters", stats.IntFunc(cp.waiters.Get))
+	stats.Publish(name+"Exhausted", stats.IntFunc(cp.exhausted.Get))
 	return cp
 }
 
@@ -71,32 +84,69 @@ func (cp *ConnectionPool) Close() {
 	cp.mu.Unlock()
 }
 
+// SetWaitPolicy sets what Get and SafeGet do when all connections
+// are in use. If failFast is set, they return an error right away.
+// Otherwise they wait for a connection to be returned, giving up
+// after waitTimeout. A waitTimeout of 0 means they wait indefinitely.
+func (cp *ConnectionPool) SetWaitPolicy(failFast bool, waitTimeout time.Duration) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.failFast = failFast
+	cp.waitTimeout = waitTimeout
+}
+
 // You must call Recycle on the PoolConnection once done.
 func (cp *ConnectionPool) Get() PoolConnection {
-	p := cp.pool()
-	if p == nil {
-		panic(CONN_POOL_CLOSED_ERR)
-	}
-	r, err := p.Get()
+	conn, err := cp.SafeGet()
 	if err != nil {
+		if _, ok := err.(*TabletError); ok {
+			panic(err)
+		}
 		panic(NewTabletErrorSql(FATAL, err))
 	}
-	return r.(*pooledConnection)
+	return conn
 }
 
+// SafeGet is like Get, but returns errors instead of panicking.
+// If the pool is exhausted and the wait policy doesn't allow waiting
+// any longer, it returns a RETRY error.
 // You must call Recycle on the PoolConnection once done.
 func (cp *ConnectionPool) SafeGet() (PoolConnection, error) {
-	p := cp.pool()
+	cp.mu.Lock()
+	p, failFast, waitTimeout := cp.connections, cp.failFast, cp.waitTimeout
+	cp.mu.Unlock()
 	if p == nil {
 		return nil, CONN_POOL_CLOSED_ERR
 	}
-	r, err := p.Get()
+	var r pools.Resource
+	var err error
+	if failFast {
+		r, err = p.TryGet()
+		if err == nil && r == nil {
+			err = pools.TIMEOUT_ERR
+		}
+	} else {
+		cp.waiters.Add(1)
+		r, err = p.GetWithTimeout(waitTimeout)
+		cp.waiters.Add(-1)
+	}
+	if err == pools.TIMEOUT_ERR {
+		cp.exhausted.Add(1)
+		return nil, NewTabletError(RETRY, "%s exhausted", cp.poolName())
+	}
 	if err != nil {
 		return nil, err
 	}
 	return r.(*pooledConnection), nil
 }
 
+func (cp *ConnectionPool) poolName() string {
+	if cp.name == "" {
+		return "connection pool"
+	}
+	return cp.name
+}
+
 // You must call Recycle on the PoolConnection once done.
 func (cp *ConnectionPool) TryGet() PoolConnection {
 	p := cp.pool()
@@ -191,6 +241,18 @@ func (cp *ConnectionPool) WaitTime() time.Duration {
 	return p.WaitTime()
 }
 
+// Waiters returns the number of callers currently waiting
+// for a connection.
+func (cp *ConnectionPool) Waiters() int64 {
+	return cp.waiters.Get()
+}
+
+// Exhausted returns the number of times a caller gave up
+// getting a connection because the pool was exhausted.
+func (cp *ConnectionPool) Exhausted() int64 {
+	return cp.exhausted.Get()
+}
+
 func (cp *ConnectionPool) IdleTimeout() time.Duration {
 	p := cp.pool()
 	if p == nil {
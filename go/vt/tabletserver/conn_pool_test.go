@@ -0,0 +1,99 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeConnFactory hands out connections that are never used to
+// talk to mysql. They must be returned with Put(nil).
+func fakeConnFactory() (*DBConnection, error) {
+	return &DBConnection{}, nil
+}
+
+func checkExhausted(t *testing.T, cp *ConnectionPool, err error) {
+	terr, ok := err.(*TabletError)
+	if !ok || terr.ErrorType != RETRY {
+		t.Fatalf("want RETRY error, got %v", err)
+	}
+	if !strings.Contains(terr.Error(), "TestPool exhausted") {
+		t.Errorf("want 'TestPool exhausted' error, got %v", err)
+	}
+}
+
+func TestConnectionPoolFailFast(t *testing.T) {
+	cp := NewConnectionPool("", 1, time.Minute)
+	cp.name = "TestPool"
+	cp.SetWaitPolicy(true, 0)
+	cp.Open(fakeConnFactory)
+	defer cp.Close()
+
+	if _, err := cp.SafeGet(); err != nil {
+		t.Fatalf("SafeGet: %v", err)
+	}
+	start := time.Now()
+	_, err := cp.SafeGet()
+	checkExhausted(t, cp, err)
+	if d := time.Now().Sub(start); d > 100*time.Millisecond {
+		t.Errorf("SafeGet waited %v on an exhausted pool with fail fast", d)
+	}
+	if cp.Exhausted() != 1 {
+		t.Errorf("want 1 exhaustion, got %v", cp.Exhausted())
+	}
+
+	func() {
+		defer func() {
+			checkExhausted(t, cp, recover().(error))
+		}()
+		cp.Get()
+		t.Errorf("Get should have panicked")
+	}()
+	if cp.Exhausted() != 2 {
+		t.Errorf("want 2 exhaustions, got %v", cp.Exhausted())
+	}
+	cp.Put(nil)
+}
+
+func TestConnectionPoolWaitTimeout(t *testing.T) {
+	cp := NewConnectionPool("", 1, time.Minute)
+	cp.name = "TestPool"
+	cp.SetWaitPolicy(false, 50*time.Millisecond)
+	cp.Open(fakeConnFactory)
+	defer cp.Close()
+
+	if _, err := cp.SafeGet(); err != nil {
+		t.Fatalf("SafeGet: %v", err)
+	}
+	start := time.Now()
+	_, err := cp.SafeGet()
+	checkExhausted(t, cp, err)
+	if d := time.Now().Sub(start); d < 50*time.Millisecond {
+		t.Errorf("SafeGet only waited %v, want at least 50ms", d)
+	}
+	if cp.Exhausted() != 1 {
+		t.Errorf("want 1 exhaustion, got %v", cp.Exhausted())
+	}
+
+	// A waiter should get the connection once it's returned.
+	done := make(chan error)
+	go func() {
+		_, err := cp.SafeGet()
+		done <- err
+	}()
+	for cp.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cp.Put(nil)
+	if err := <-done; err != nil {
+		t.Errorf("SafeGet: %v", err)
+	}
+	if cp.Waiters() != 0 {
+		t.Errorf("want 0 waiters, got %v", cp.Waiters())
+	}
+	cp.Put(nil)
+}
@@ -71,12 +71,29 @@ func (conn *DBConnection) ExecuteStreamFetch(query string, callback func(*proto.
 		conn.handleError(err)
 		return err
 	}
-	defer conn.CloseResult()
 
+	cancelled, err := streamResult(conn.Fields(), conn.FetchNext, callback, streamBufferSize)
+	if cancelled {
+		// The stream was cancelled: abort the result instead of
+		// reading the rest of it from MySQL just to throw it away.
+		// This also closes the connection.
+		conn.Abort()
+	} else {
+		conn.CloseResult()
+	}
+	return err
+}
+
+// streamResult calls the callback with the fields first, and then
+// with the rows as they are fetched, in batches of about
+// streamBufferSize bytes. Rows are never kept past that, so the
+// memory used doesn't depend on the size of the result.
+// cancelled is true if the callback returned an error.
+func streamResult(fields []proto.Field, fetchNext func() ([]sqltypes.Value, error), callback func(*proto.QueryResult) error, streamBufferSize int) (cancelled bool, err error) {
 	// first call the callback with the fields
-	err = callback(&proto.QueryResult{Fields: conn.Fields()})
+	err = callback(&proto.QueryResult{Fields: fields})
 	if err != nil {
-		return err
+		return true, err
 	}
 
 	// then get all the rows, sending them as we reach a decent packet size
@@ -84,9 +101,9 @@ func (conn *DBConnection) ExecuteStreamFetch(query string, callback func(*proto.
 	qr := &proto.QueryResult{Rows: make([][]sqltypes.Value, 0, 256)}
 	byteCount := 0
 	for {
-		row, err := conn.FetchNext()
+		row, err := fetchNext()
 		if err != nil {
-			return err
+			return false, err
 		}
 		if row == nil {
 			break
@@ -99,7 +116,7 @@ func (conn *DBConnection) ExecuteStreamFetch(query string, callback func(*proto.
 		if byteCount >= streamBufferSize {
 			err = callback(qr)
 			if err != nil {
-				return err
+				return true, err
 			}
 			// empty the rows so we start over, but we keep the
 			// same capacity
@@ -111,11 +128,11 @@ func (conn *DBConnection) ExecuteStreamFetch(query string, callback func(*proto.
 	if len(qr.Rows) > 0 {
 		err = callback(qr)
 		if err != nil {
-			return err
+			return true, err
 		}
 	}
 
-	return nil
+	return false, nil
 }
 
 var getModeSql = "select @@global.sql_mode"
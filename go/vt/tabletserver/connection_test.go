@@ -0,0 +1,74 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+)
+
+// fakeResult returns rowCount rows of one 10 bytes column.
+type fakeResult struct {
+	rowCount int
+	fetched  int
+}
+
+func (fr *fakeResult) fetchNext() ([]sqltypes.Value, error) {
+	if fr.fetched == fr.rowCount {
+		return nil, nil
+	}
+	fr.fetched++
+	return []sqltypes.Value{sqltypes.MakeString([]byte("0123456789"))}, nil
+}
+
+func TestStreamResult(t *testing.T) {
+	fr := &fakeResult{rowCount: 1000}
+	sent := 0
+	calls := 0
+	cancelled, err := streamResult(nil, fr.fetchNext, func(qr *proto.QueryResult) error {
+		calls++
+		// rows are sent as they are fetched, never more
+		// than what fits in the buffer
+		if len(qr.Rows) > 10 {
+			t.Errorf("want at most 10 rows, got %v", len(qr.Rows))
+		}
+		sent += len(qr.Rows)
+		if fr.fetched != sent {
+			t.Errorf("want %v fetched rows, got %v", sent, fr.fetched)
+		}
+		return nil
+	}, 100)
+	if cancelled || err != nil {
+		t.Errorf("want false, nil, got %v, %v", cancelled, err)
+	}
+	if sent != 1000 {
+		t.Errorf("want 1000, got %v", sent)
+	}
+	if calls != 101 {
+		t.Errorf("want 101, got %v", calls)
+	}
+}
+
+func TestStreamResultCancelled(t *testing.T) {
+	fr := &fakeResult{rowCount: 1000}
+	calls := 0
+	cancelled, err := streamResult(nil, fr.fetchNext, func(qr *proto.QueryResult) error {
+		calls++
+		if calls == 3 {
+			return fmt.Errorf("client went away")
+		}
+		return nil
+	}, 100)
+	if !cancelled || err == nil {
+		t.Errorf("want true, error, got %v, %v", cancelled, err)
+	}
+	// the rest of the result is not fetched
+	if fr.fetched != 20 {
+		t.Errorf("want 20, got %v", fr.fetched)
+	}
+}
@@ -6,12 +6,16 @@ package gorpctabletconn
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 	"sync"
 	"time"
 
+	log "github.com/golang/glog"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/rpcplus"
 	"github.com/youtube/vitess/go/rpcwrap/bsonrpc"
@@ -25,8 +29,81 @@ var (
 	tabletBsonUsername  = flag.String("tablet-bson-username", "", "user to use for bson rpc connections")
 	tabletBsonPassword  = flag.String("tablet-bson-password", "", "password to use for bson rpc connections (ignored if username is empty)")
 	tabletBsonEncrypted = flag.Bool("tablet-bson-encrypted", false, "use encryption to talk to vttablet")
+	tabletBsonCert      = flag.String("tablet-bson-cert", "", "client cert file to use for encrypted bson rpc connections (needs tablet-bson-key)")
+	tabletBsonKey       = flag.String("tablet-bson-key", "", "client key file to use for encrypted bson rpc connections (needs tablet-bson-cert)")
+	tabletBsonCACert    = flag.String("tablet-bson-ca-cert", "", "ca cert file to verify the vttablet certs of encrypted bson rpc connections (they're not verified if empty)")
 )
 
+// The certs of the -tablet-bson-cert, -tablet-bson-key and
+// -tablet-bson-ca-cert files, loaded by the first encrypted dial.
+var (
+	tlsOnce    sync.Once
+	tlsCerts   []tls.Certificate
+	tlsRootCAs *x509.CertPool
+	tlsErr     error
+)
+
+// checkTLSFlags returns an error if the flags of the encrypted
+// connections don't make sense together: the cert and the key go
+// together, and the files are only used if encrypted.
+func checkTLSFlags(encrypted bool, certFile, keyFile, caCertFile string) error {
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("tablet-bson-cert and tablet-bson-key must be set together, got %q and %q", certFile, keyFile)
+	}
+	if !encrypted && (certFile != "" || caCertFile != "") {
+		return fmt.Errorf("tablet-bson-cert, tablet-bson-key and tablet-bson-ca-cert need tablet-bson-encrypted")
+	}
+	return nil
+}
+
+// loadTLSFiles loads the client cert of certFile and keyFile, and
+// the ca certs of caCertFile. Each is optional.
+func loadTLSFiles(certFile, keyFile, caCertFile string) (certs []tls.Certificate, rootCAs *x509.CertPool, err error) {
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot load tablet-bson-cert %v and tablet-bson-key %v: %v", certFile, keyFile, err)
+		}
+		certs = []tls.Certificate{cert}
+	}
+	if caCertFile != "" {
+		pemCerts, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot read tablet-bson-ca-cert %v: %v", caCertFile, err)
+		}
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(pemCerts) {
+			return nil, nil, fmt.Errorf("no cert found in tablet-bson-ca-cert %v", caCertFile)
+		}
+	}
+	return certs, rootCAs, nil
+}
+
+// newTLSConfig returns the config of an encrypted connection to host.
+// Without rootCAs, the cert of host isn't verified.
+func newTLSConfig(host string, certs []tls.Certificate, rootCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates:       certs,
+		RootCAs:            rootCAs,
+		ServerName:         host,
+		InsecureSkipVerify: rootCAs == nil,
+	}
+}
+
+// tlsConfig is newTLSConfig with the certs of the flags.
+func tlsConfig(host string) (*tls.Config, error) {
+	tlsOnce.Do(func() {
+		tlsCerts, tlsRootCAs, tlsErr = loadTLSFiles(*tabletBsonCert, *tabletBsonKey, *tabletBsonCACert)
+		if tlsErr == nil && tlsRootCAs == nil {
+			log.Warningf("no tablet-bson-ca-cert: the vttablet certs of the encrypted bson rpc connections are not verified")
+		}
+	})
+	if tlsErr != nil {
+		return nil, tlsErr
+	}
+	return newTLSConfig(host, tlsCerts, tlsRootCAs), nil
+}
+
 func init() {
 	tabletconn.RegisterDialer("gorpc", DialTablet)
 }
@@ -40,12 +117,17 @@ type TabletBson struct {
 }
 
 func DialTablet(context interface{}, endPoint topo.EndPoint, keyspace, shard string, timeout time.Duration) (tabletconn.TabletConn, error) {
+	if err := checkTLSFlags(*tabletBsonEncrypted, *tabletBsonCert, *tabletBsonKey, *tabletBsonCACert); err != nil {
+		return nil, err
+	}
 	var addr string
 	var config *tls.Config
 	if *tabletBsonEncrypted {
 		addr = fmt.Sprintf("%v:%v", endPoint.Host, endPoint.NamedPortMap["_vts"])
-		config = &tls.Config{}
-		config.InsecureSkipVerify = true
+		var err error
+		if config, err = tlsConfig(endPoint.Host); err != nil {
+			return nil, err
+		}
 	} else {
 		addr = fmt.Sprintf("%v:%v", endPoint.Host, endPoint.NamedPortMap["_vtocc"])
 	}
@@ -70,7 +152,7 @@ func DialTablet(context interface{}, endPoint topo.EndPoint, keyspace, shard str
 	return conn, nil
 }
 
-func (conn *TabletBson) Execute(context interface{}, query string, bindVars map[string]interface{}, transactionId int64) (*mproto.QueryResult, error) {
+func (conn *TabletBson) Execute(context interface{}, query string, bindVars map[string]interface{}, transactionId int64, maxRows, maxBytes int64) (*mproto.QueryResult, error) {
 	conn.mu.RLock()
 	defer conn.mu.RUnlock()
 	if conn.rpcClient == nil {
@@ -82,6 +164,8 @@ func (conn *TabletBson) Execute(context interface{}, query string, bindVars map[
 		BindVariables: bindVars,
 		TransactionId: transactionId,
 		SessionId:     conn.sessionId,
+		MaxRows:       maxRows,
+		MaxBytes:      maxBytes,
 	}
 	qr := new(mproto.QueryResult)
 	if err := conn.rpcClient.Call("SqlQuery.Execute", req, qr); err != nil {
@@ -90,7 +174,7 @@ func (conn *TabletBson) Execute(context interface{}, query string, bindVars map[
 	return qr, nil
 }
 
-func (conn *TabletBson) ExecuteBatch(context interface{}, queries []tproto.BoundQuery, transactionId int64) (*tproto.QueryResultList, error) {
+func (conn *TabletBson) ExecuteBatch(context interface{}, queries []tproto.BoundQuery, transactionId int64, maxRows, maxBytes int64) (*tproto.QueryResultList, error) {
 	conn.mu.RLock()
 	defer conn.mu.RUnlock()
 	if conn.rpcClient == nil {
@@ -101,6 +185,8 @@ func (conn *TabletBson) ExecuteBatch(context interface{}, queries []tproto.Bound
 		Queries:       queries,
 		TransactionId: transactionId,
 		SessionId:     conn.sessionId,
+		MaxRows:       maxRows,
+		MaxBytes:      maxBytes,
 	}
 	qrs := new(tproto.QueryResultList)
 	if err := conn.rpcClient.Call("SqlQuery.ExecuteBatch", req, qrs); err != nil {
@@ -195,6 +281,10 @@ func tabletError(err error) error {
 	if err == nil {
 		return nil
 	}
+	if err == rpcplus.ErrShutdown || err == io.ErrUnexpectedEOF {
+		// The tablet closed the connection, or went away.
+		return tabletconn.CONN_CLOSED
+	}
 	if _, ok := err.(rpcplus.ServerError); ok {
 		var code int
 		errStr := err.Error()
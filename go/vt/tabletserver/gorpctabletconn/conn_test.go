@@ -0,0 +1,114 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gorpctabletconn
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed cert and its key in dir, and returns
+// their file names.
+func writeCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "vttablet"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	certFile = path.Join(dir, "cert.pem")
+	keyFile = path.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestCheckTLSFlags(t *testing.T) {
+	testCases := []struct {
+		encrypted         bool
+		cert, key, caCert string
+		wantErr           bool
+	}{
+		{encrypted: false},
+		{encrypted: true},
+		{encrypted: true, cert: "cert", key: "key"},
+		{encrypted: true, caCert: "ca"},
+		{encrypted: true, cert: "cert", key: "key", caCert: "ca"},
+		{encrypted: true, cert: "cert", wantErr: true},
+		{encrypted: true, key: "key", wantErr: true},
+		{encrypted: false, cert: "cert", key: "key", wantErr: true},
+		{encrypted: false, caCert: "ca", wantErr: true},
+	}
+	for _, tc := range testCases {
+		err := checkTLSFlags(tc.encrypted, tc.cert, tc.key, tc.caCert)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("checkTLSFlags(%v, %q, %q, %q): want error %v, got %v", tc.encrypted, tc.cert, tc.key, tc.caCert, tc.wantErr, err)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gorpctabletconn")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeCert(t, dir)
+
+	// Without ca cert, the tablet cert isn't verified.
+	certs, rootCAs, err := loadTLSFiles(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("loadTLSFiles: %v", err)
+	}
+	config := newTLSConfig("host", certs, rootCAs)
+	if len(config.Certificates) != 1 || config.RootCAs != nil || !config.InsecureSkipVerify || config.ServerName != "host" {
+		t.Errorf("unexpected config without ca cert: %+v", config)
+	}
+
+	// With one, it is.
+	certs, rootCAs, err = loadTLSFiles("", "", certFile)
+	if err != nil {
+		t.Fatalf("loadTLSFiles: %v", err)
+	}
+	config = newTLSConfig("host", certs, rootCAs)
+	if len(config.Certificates) != 0 || config.RootCAs == nil || config.InsecureSkipVerify {
+		t.Errorf("unexpected config with ca cert: %+v", config)
+	}
+
+	// Files that can't be loaded.
+	for _, files := range [][3]string{
+		{certFile, path.Join(dir, "missing"), ""},
+		{keyFile, keyFile, ""},
+		{"", "", path.Join(dir, "missing")},
+		{"", "", keyFile},
+	} {
+		if _, _, err := loadTLSFiles(files[0], files[1], files[2]); err == nil {
+			t.Errorf("loadTLSFiles(%q, %q, %q): want error, got nil", files[0], files[1], files[2])
+		}
+	}
+}
@@ -23,6 +23,8 @@ func (query *Query) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 	EncodeBindVariablesBson(buf, "BindVariables", query.BindVariables)
 	bson.EncodeInt64(buf, "TransactionId", query.TransactionId)
 	bson.EncodeInt64(buf, "SessionId", query.SessionId)
+	bson.EncodeInt64(buf, "MaxRows", query.MaxRows)
+	bson.EncodeInt64(buf, "MaxBytes", query.MaxBytes)
 
 	buf.WriteByte(0)
 	lenWriter.RecordLen()
@@ -54,6 +56,10 @@ func (query *Query) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			query.TransactionId = bson.DecodeInt64(buf, kind)
 		case "SessionId":
 			query.SessionId = bson.DecodeInt64(buf, kind)
+		case "MaxRows":
+			query.MaxRows = bson.DecodeInt64(buf, kind)
+		case "MaxBytes":
+			query.MaxBytes = bson.DecodeInt64(buf, kind)
 		default:
 			bson.Skip(buf, kind)
 		}
@@ -232,6 +238,8 @@ func (ql *QueryList) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 	EncodeQueriesBson(ql.Queries, "Queries", buf)
 	bson.EncodeInt64(buf, "TransactionId", ql.TransactionId)
 	bson.EncodeInt64(buf, "SessionId", ql.SessionId)
+	bson.EncodeInt64(buf, "MaxRows", ql.MaxRows)
+	bson.EncodeInt64(buf, "MaxBytes", ql.MaxBytes)
 
 	buf.WriteByte(0)
 	lenWriter.RecordLen()
@@ -251,6 +259,10 @@ func (ql *QueryList) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			ql.TransactionId = bson.DecodeInt64(buf, kind)
 		case "SessionId":
 			ql.SessionId = bson.DecodeInt64(buf, kind)
+		case "MaxRows":
+			ql.MaxRows = bson.DecodeInt64(buf, kind)
+		case "MaxBytes":
+			ql.MaxBytes = bson.DecodeInt64(buf, kind)
 		default:
 			bson.Skip(buf, kind)
 		}
@@ -15,6 +15,8 @@ type reflectQuery struct {
 	BindVariables map[string]interface{}
 	TransactionId int64
 	SessionId     int64
+	MaxRows       int64
+	MaxBytes      int64
 }
 
 type extraQuery struct {
@@ -23,6 +25,8 @@ type extraQuery struct {
 	BindVariables map[string]interface{}
 	TransactionId int64
 	SessionId     int64
+	MaxRows       int64
+	MaxBytes      int64
 }
 
 func TestQuery(t *testing.T) {
@@ -31,6 +35,8 @@ func TestQuery(t *testing.T) {
 		BindVariables: map[string]interface{}{"val": int64(1)},
 		TransactionId: 1,
 		SessionId:     2,
+		MaxRows:       3,
+		MaxBytes:      4,
 	})
 	if err != nil {
 		t.Error(err)
@@ -42,6 +48,8 @@ func TestQuery(t *testing.T) {
 		BindVariables: map[string]interface{}{"val": int64(1)},
 		TransactionId: 1,
 		SessionId:     2,
+		MaxRows:       3,
+		MaxBytes:      4,
 	}
 	encoded, err := bson.Marshal(&custom)
 	if err != nil {
@@ -66,6 +74,9 @@ func TestQuery(t *testing.T) {
 	if custom.SessionId != unmarshalled.SessionId {
 		t.Errorf("want %v, got %v", custom.SessionId, unmarshalled.SessionId)
 	}
+	if custom.MaxRows != unmarshalled.MaxRows || custom.MaxBytes != unmarshalled.MaxBytes {
+		t.Errorf("want %v, %v, got %v, %v", custom.MaxRows, custom.MaxBytes, unmarshalled.MaxRows, unmarshalled.MaxBytes)
+	}
 	if custom.BindVariables["val"].(int64) != unmarshalled.BindVariables["val"].(int64) {
 		t.Errorf("want %v, got %v", custom.BindVariables["val"], unmarshalled.BindVariables["val"])
 	}
@@ -193,6 +204,8 @@ type reflectQueryList struct {
 	Queries       []BoundQuery
 	TransactionId int64
 	SessionId     int64
+	MaxRows       int64
+	MaxBytes      int64
 }
 
 type extraQueryList struct {
@@ -200,6 +213,8 @@ type extraQueryList struct {
 	Queries       []BoundQuery
 	TransactionId int64
 	SessionId     int64
+	MaxRows       int64
+	MaxBytes      int64
 }
 
 func TestQueryList(t *testing.T) {
@@ -210,6 +225,8 @@ func TestQueryList(t *testing.T) {
 		}},
 		TransactionId: 1,
 		SessionId:     2,
+		MaxRows:       3,
+		MaxBytes:      4,
 	})
 	if err != nil {
 		t.Error(err)
@@ -223,6 +240,8 @@ func TestQueryList(t *testing.T) {
 		}},
 		TransactionId: 1,
 		SessionId:     2,
+		MaxRows:       3,
+		MaxBytes:      4,
 	}
 	encoded, err := bson.Marshal(&custom)
 	if err != nil {
@@ -244,6 +263,9 @@ func TestQueryList(t *testing.T) {
 	if custom.SessionId != unmarshalled.SessionId {
 		t.Errorf("want %v, got %v", custom.SessionId, unmarshalled.SessionId)
 	}
+	if custom.MaxRows != unmarshalled.MaxRows || custom.MaxBytes != unmarshalled.MaxBytes {
+		t.Errorf("want %v, %v, got %v, %v", custom.MaxRows, custom.MaxBytes, unmarshalled.MaxRows, unmarshalled.MaxBytes)
+	}
 	if custom.Queries[0].Sql != unmarshalled.Queries[0].Sql {
 		t.Errorf("want %v, got %v", custom.Queries[0].Sql, unmarshalled.Queries[0].Sql)
 	}
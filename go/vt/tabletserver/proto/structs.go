@@ -11,10 +11,21 @@ import (
 type SessionParams struct {
 	Keyspace string
 	Shard    string
+	// TabletType is the type of tablet the client expects to talk
+	// to. It is optional, the capabilities of the SessionInfo are
+	// then those of the actual type of the tablet.
+	TabletType string
 }
 
 type SessionInfo struct {
 	SessionId int64
+	// Writable is true if the session can run DMLs, i.e. if the
+	// tablet type is master. Clients can reject writes to read-only
+	// sessions without a round-trip.
+	Writable bool
+	// SupportsStreaming is true if the session can run
+	// StreamExecute.
+	SupportsStreaming bool
 }
 
 type Query struct {
@@ -22,6 +33,11 @@ type Query struct {
 	BindVariables map[string]interface{}
 	SessionId     int64
 	TransactionId int64
+	// MaxRows and MaxBytes, if set, bound the number of rows of
+	// the result and the size of its values: the tablet fails
+	// the query instead of sending back a larger result.
+	MaxRows  int64
+	MaxBytes int64
 }
 
 type BoundQuery struct {
@@ -33,6 +49,10 @@ type QueryList struct {
 	Queries       []BoundQuery
 	SessionId     int64
 	TransactionId int64
+	// MaxRows and MaxBytes bound the result of each query, see
+	// Query.
+	MaxRows  int64
+	MaxBytes int64
 }
 
 type QueryResultList struct {
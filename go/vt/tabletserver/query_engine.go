@@ -5,6 +5,8 @@
 package tabletserver
 
 import (
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -90,6 +92,9 @@ func NewQueryEngine(config Config) *QueryEngine {
 	qe.connPool = NewConnectionPool("ConnPool", config.PoolSize, time.Duration(config.IdleTimeout*1e9))
 	qe.streamConnPool = NewConnectionPool("StreamConnPool", config.StreamPoolSize, time.Duration(config.IdleTimeout*1e9))
 	qe.txPool = NewConnectionPool("TransactionPool", config.TransactionCap, time.Duration(config.IdleTimeout*1e9)) // connections in pool has to be > transactionCap
+	for _, pool := range []*ConnectionPool{qe.connPool, qe.streamConnPool, qe.txPool} {
+		pool.SetWaitPolicy(config.PoolFailFast, time.Duration(config.PoolWaitTimeout*1e9))
+	}
 	qe.activeTxPool = NewActiveTxPool("ActiveTransactionPool", time.Duration(config.TransactionTimeout*1e9))
 	qe.activePool = NewActivePool("ActivePool", time.Duration(config.QueryTimeout*1e9), time.Duration(config.IdleTimeout*1e9))
 	qe.consolidator = NewConsolidator()
@@ -216,6 +221,12 @@ func (qe *QueryEngine) Execute(logStats *sqlQueryStats, query *proto.Query) (rep
 	logStats.OriginalSql = query.Sql
 	// cheap hack: strip trailing comment into a special bind var
 	stripTrailing(query)
+	if sql, ok := explainTarget(query.Sql); ok {
+		return qe.execExplain(logStats, sql, query.BindVariables)
+	}
+	if savepointRegexp.MatchString(query.Sql) {
+		return qe.execSavepoint(logStats, query)
+	}
 	basePlan := qe.schemaInfo.GetPlan(logStats, query.Sql)
 	planName := basePlan.PlanId.String()
 	logStats.PlanType = planName
@@ -568,6 +579,64 @@ func (qe *QueryEngine) execDirect(logStats *sqlQueryStats, plan *CompiledPlan, c
 	return
 }
 
+// savepointRegexp matches the savepoint statements, which
+// the parser doesn't know about.
+var savepointRegexp = regexp.MustCompile(`(?i)^\s*(savepoint|release\s+savepoint|rollback\s+to\s+savepoint)\s+\w+\s*$`)
+
+// execSavepoint runs a savepoint statement in its transaction. Rolling
+// back to a savepoint doesn't restore the dirty keys of the transaction:
+// the rows it wrote are still invalidated on commit.
+func (qe *QueryEngine) execSavepoint(logStats *sqlQueryStats, query *proto.Query) *mproto.QueryResult {
+	if query.TransactionId == 0 {
+		panic(NewTabletError(FAIL, "Savepoints need a transaction: %s", query.Sql))
+	}
+	logStats.PlanType = "SAVEPOINT"
+	defer queryStats.Record("SAVEPOINT", time.Now())
+	conn := qe.activeTxPool.Get(query.TransactionId)
+	defer conn.Recycle()
+	conn.RecordQuery(query.Sql)
+	result, err := qe.executeSql(logStats, conn, query.Sql, false)
+	if err != nil {
+		panic(NewTabletErrorSql(FAIL, err))
+	}
+	return result
+}
+
+// explainTarget returns the statement of an EXPLAIN query.
+func explainTarget(sql string) (string, bool) {
+	sql = strings.TrimSpace(sql)
+	if len(sql) < 8 || !strings.EqualFold(sql[:8], "explain ") {
+		return "", false
+	}
+	return strings.TrimSpace(sql[8:]), true
+}
+
+// execExplain returns the MySQL plan of the query the tablet would
+// send for sql, without running it. Only selects can be explained,
+// and the query rules apply as if sql was executed.
+func (qe *QueryEngine) execExplain(logStats *sqlQueryStats, sql string, bindVars map[string]interface{}) (result *mproto.QueryResult) {
+	plan := qe.schemaInfo.GetPlan(logStats, sql)
+	if !plan.PlanId.IsSelect() {
+		panic(NewTabletError(FAIL, "EXPLAIN is only supported for selects: %s", sql))
+	}
+	logStats.PlanType = "EXPLAIN"
+	defer queryStats.Record("EXPLAIN", time.Now())
+	action, desc := plan.Rules.getAction(logStats.RemoteAddr(), logStats.Username(), bindVars)
+	if action == QR_FAIL_QUERY {
+		panic(NewTabletError(FAIL, "Query disallowed due to rule: %s", desc))
+	}
+
+	waitingForConnectionStart := time.Now()
+	conn := qe.connPool.Get()
+	logStats.WaitingForConnection += time.Now().Sub(waitingForConnectionStart)
+	defer conn.Recycle()
+	result, err := qe.executeSql(logStats, conn, "explain "+qe.generateFinalSql(plan.FullQuery, bindVars, nil, nil), true)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
 // execSelect sends a query to mysql only if another identical query is not running. Otherwise, it waits and
 // reuses the result. If the plan is missng field info, it sends the query to mysql requesting full info.
 func (qe *QueryEngine) execSelect(logStats *sqlQueryStats, plan *CompiledPlan) (result *mproto.QueryResult) {
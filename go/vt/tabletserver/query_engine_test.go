@@ -0,0 +1,45 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"testing"
+)
+
+func TestExplainTarget(t *testing.T) {
+	cases := []struct {
+		in, want string
+		ok       bool
+	}{
+		{in: "explain select a from b", want: "select a from b", ok: true},
+		{in: "  EXPLAIN  select a from b ", want: "select a from b", ok: true},
+		{in: "select explain from b"},
+		{in: "explain"},
+	}
+	for _, tcase := range cases {
+		if got, ok := explainTarget(tcase.in); got != tcase.want || ok != tcase.ok {
+			t.Errorf("explainTarget(%q) = %q, %v, want %q, %v", tcase.in, got, ok, tcase.want, tcase.ok)
+		}
+	}
+}
+
+func TestSavepointRegexp(t *testing.T) {
+	cases := []struct {
+		in   string
+		want bool
+	}{
+		{"savepoint a", true},
+		{" RELEASE  SAVEPOINT a_1 ", true},
+		{"rollback to savepoint a", true},
+		{"savepoint", false},
+		{"savepoint a; drop table b", false},
+		{"select savepoint from b", false},
+	}
+	for _, tcase := range cases {
+		if got := savepointRegexp.MatchString(tcase.in); got != tcase.want {
+			t.Errorf("savepointRegexp.MatchString(%q) = %v, want %v", tcase.in, got, tcase.want)
+		}
+	}
+}
@@ -36,6 +36,8 @@ func init() {
 	flag.Float64Var(&qsConfig.IdleTimeout, "queryserver-config-idle-timeout", DefaultQsConfig.IdleTimeout, "query server idle timeout")
 	flag.Float64Var(&qsConfig.SpotCheckRatio, "queryserver-config-spot-check-ratio", DefaultQsConfig.SpotCheckRatio, "query server rowcache spot check frequency")
 	flag.Float64Var(&qsConfig.StreamWaitTimeout, "queryserver-config-stream-exec-timeout", DefaultQsConfig.StreamWaitTimeout, "Timeout for stream-exec-throttle")
+	flag.Float64Var(&qsConfig.PoolWaitTimeout, "queryserver-config-pool-wait-timeout", DefaultQsConfig.PoolWaitTimeout, "how long to wait for a connection when a pool is exhausted (0 means forever)")
+	flag.BoolVar(&qsConfig.PoolFailFast, "queryserver-config-pool-fail-fast", DefaultQsConfig.PoolFailFast, "fail right away instead of waiting when a pool is exhausted")
 	flag.StringVar(&qsConfig.RowCache.Binary, "rowcache-bin", DefaultQsConfig.RowCache.Binary, "rowcache binary file")
 	flag.IntVar(&qsConfig.RowCache.Memory, "rowcache-memory", DefaultQsConfig.RowCache.Memory, "rowcache max memory usage in MB")
 	flag.StringVar(&qsConfig.RowCache.Socket, "rowcache-socket", DefaultQsConfig.RowCache.Socket, "rowcache socket path to listen on")
@@ -97,6 +99,8 @@ type Config struct {
 	RowCache           RowCacheConfig
 	SpotCheckRatio     float64
 	StreamWaitTimeout  float64
+	PoolWaitTimeout    float64
+	PoolFailFast       bool
 }
 
 // DefaultQSConfig is the default value for the query service config.
@@ -121,6 +125,8 @@ var DefaultQsConfig = Config{
 	RowCache:           RowCacheConfig{Memory: -1, TcpPort: -1, Connections: -1, Threads: -1},
 	SpotCheckRatio:     0,
 	StreamWaitTimeout:  4 * 60,
+	PoolWaitTimeout:    0,
+	PoolFailFast:       false,
 }
 
 var qsConfig Config
@@ -152,6 +158,12 @@ func AllowQueries(dbconfig *dbconfigs.DBConfig, schemaOverrides []SchemaOverride
 	SqlQueryRpcService.allowQueries(dbconfig, schemaOverrides, qrs, mysqld)
 }
 
+// SetTabletType sets the tablet type the query service is running
+// as. It is only used to label stats.
+func SetTabletType(tabletType string) {
+	SqlQueryRpcService.qe.activeTxPool.SetTabletType(tabletType)
+}
+
 // DisallowQueries can take a long time to return (not indefinite) because
 // it has to wait for queries & transactions to be completed or killed,
 // and also for house keeping goroutines to be terminated.
@@ -212,6 +212,12 @@ func (sq *SqlQuery) checkState(sessionId int64, allowShutdown bool) {
 	}
 }
 
+// GetSessionId returns the session id of the query service. There is
+// only one, shared by all the clients, and it changes when the service
+// restarts. Nothing is allocated per call, so clients can call it any
+// number of times. The SessionInfo also has the capabilities of the
+// session, for the requested tablet type if any, or the one set by
+// SetTabletType.
 func (sq *SqlQuery) GetSessionId(sessionParams *proto.SessionParams, sessionInfo *proto.SessionInfo) error {
 	if sq.state.Get() != SERVING {
 		return NewTabletError(RETRY, "Query server is in %s state", stateName[sq.state.Get()])
@@ -223,9 +229,28 @@ func (sq *SqlQuery) GetSessionId(sessionParams *proto.SessionParams, sessionInfo
 		return NewTabletError(FATAL, "Shard mismatch, expecting %v, received %v", sq.dbconfig.Shard, sessionParams.Shard)
 	}
 	sessionInfo.SessionId = sq.sessionId
+	tabletType := sessionParams.TabletType
+	if tabletType == "" {
+		tabletType = sq.qe.activeTxPool.TabletType()
+	}
+	sessionInfo.Writable, sessionInfo.SupportsStreaming = sessionCapabilities(tabletType)
 	return nil
 }
 
+// sessionCapabilities returns what a session on a tablet of type
+// tabletType can do. Only masters take writes, and all the serving
+// types can stream. An unknown type gets no capability, so clients
+// don't rely on one that may be missing.
+func sessionCapabilities(tabletType string) (writable, supportsStreaming bool) {
+	switch tabletType {
+	case "":
+		return false, false
+	case "master":
+		return true, true
+	}
+	return false, true
+}
+
 func (sq *SqlQuery) Begin(context *Context, session *proto.Session, txInfo *proto.TransactionInfo) (err error) {
 	logStats := newSqlQueryStats("Begin", context)
 	logStats.OriginalSql = "begin"
@@ -316,9 +341,31 @@ func (sq *SqlQuery) Execute(context *Context, query *proto.Query, reply *mproto.
 	sq.checkState(query.SessionId, allowShutdown)
 
 	*reply = *sq.qe.Execute(logStats, query)
+	checkResultLimits(reply, query.MaxRows, query.MaxBytes)
 	return nil
 }
 
+// checkResultLimits panics with a TabletError if result has more
+// than maxRows rows, or more than maxBytes bytes of values (0 for
+// no limit), so it's never sent to a caller that would reject it.
+func checkResultLimits(result *mproto.QueryResult, maxRows, maxBytes int64) {
+	if maxRows > 0 && int64(len(result.Rows)) > maxRows {
+		panic(NewTabletError(FAIL, "result too large: %v rows, the limit is %v", len(result.Rows), maxRows))
+	}
+	if maxBytes <= 0 {
+		return
+	}
+	var size int64
+	for _, row := range result.Rows {
+		for _, value := range row {
+			size += int64(len(value.Raw()))
+		}
+	}
+	if size > maxBytes {
+		panic(NewTabletError(FAIL, "result too large: %v bytes, the limit is %v", size, maxBytes))
+	}
+}
+
 // the first QueryResult will have Fields set (and Rows nil)
 // the subsequent QueryResult will have Rows set (and Fields nil)
 func (sq *SqlQuery) StreamExecute(context *Context, query *proto.Query, sendReply func(*mproto.QueryResult) error) (err error) {
@@ -377,6 +424,8 @@ func (sq *SqlQuery) ExecuteBatch(context *Context, queryList *proto.QueryList, r
 				BindVariables: bound.BindVariables,
 				TransactionId: session.TransactionId,
 				SessionId:     session.SessionId,
+				MaxRows:       queryList.MaxRows,
+				MaxBytes:      queryList.MaxBytes,
 			}
 			var localReply mproto.QueryResult
 			if err = sq.Execute(context, &query, &localReply); err != nil {
@@ -0,0 +1,69 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"strings"
+	"testing"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+)
+
+func TestSessionCapabilities(t *testing.T) {
+	testcases := []struct {
+		tabletType                  string
+		writable, supportsStreaming bool
+	}{
+		{"master", true, true},
+		{"replica", false, true},
+		{"rdonly", false, true},
+		{"", false, false},
+	}
+	for _, tc := range testcases {
+		writable, supportsStreaming := sessionCapabilities(tc.tabletType)
+		if writable != tc.writable || supportsStreaming != tc.supportsStreaming {
+			t.Errorf("sessionCapabilities(%q) = %v, %v, want %v, %v", tc.tabletType, writable, supportsStreaming, tc.writable, tc.supportsStreaming)
+		}
+	}
+}
+
+func TestCheckResultLimits(t *testing.T) {
+	result := &mproto.QueryResult{
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeString([]byte("ab"))},
+			{sqltypes.MakeString([]byte("cd"))},
+		},
+	}
+	testcases := []struct {
+		maxRows, maxBytes int64
+		wantErr           string
+	}{
+		{0, 0, ""},
+		{2, 4, ""},
+		{1, 0, "result too large: 2 rows, the limit is 1"},
+		{0, 3, "result too large: 4 bytes, the limit is 3"},
+	}
+	for _, tc := range testcases {
+		err := func() (err error) {
+			defer func() {
+				if x := recover(); x != nil {
+					err = x.(*TabletError)
+				}
+			}()
+			checkResultLimits(result, tc.maxRows, tc.maxBytes)
+			return nil
+		}()
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("checkResultLimits(%v, %v): %v", tc.maxRows, tc.maxBytes, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("checkResultLimits(%v, %v) = %v, want %v", tc.maxRows, tc.maxBytes, err, tc.wantErr)
+		}
+	}
+}
@@ -58,10 +58,13 @@ type TabletDialer func(context interface{}, endPoint topo.EndPoint, keyspace, sh
 // not be concurrently used across goroutines.
 type TabletConn interface {
 	// Execute executes a non-streaming query on vttablet.
-	Execute(context interface{}, query string, bindVars map[string]interface{}, transactionId int64) (*mproto.QueryResult, error)
+	// vttablet fails it if its result has more than maxRows rows,
+	// or more than maxBytes bytes of values (0 for no limit).
+	Execute(context interface{}, query string, bindVars map[string]interface{}, transactionId int64, maxRows, maxBytes int64) (*mproto.QueryResult, error)
 
-	// ExecuteBatch executes a group of queries.
-	ExecuteBatch(context interface{}, queries []tproto.BoundQuery, transactionId int64) (*tproto.QueryResultList, error)
+	// ExecuteBatch executes a group of queries. maxRows and
+	// maxBytes bound the result of each query, as in Execute.
+	ExecuteBatch(context interface{}, queries []tproto.BoundQuery, transactionId int64, maxRows, maxBytes int64) (*tproto.QueryResultList, error)
 
 	// StreamExecute exectutes a streaming query on vttablet. It returns a channel that will stream results.
 	// It also returns an ErrFunc that can be called to check if there were any errors. ErrFunc can be called
@@ -0,0 +1,56 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package topo
+
+import (
+	"time"
+)
+
+// ActionHeader is what the ActionEventLoop implementations need to
+// know about an action node. They store the action nodes as opaque
+// data, an ActionNodeDecoder extracts it.
+type ActionHeader struct {
+	Action     string
+	ActionGuid string
+
+	// Done is set once the action completed successfully, and
+	// Failed once it failed with Error. Neither is set while the
+	// action is queued or running.
+	Done   bool
+	Failed bool
+	Error  string
+
+	// ParallelSafe actions can run at the same time as other
+	// ParallelSafe ones.
+	ParallelSafe bool
+
+	// ExpireTime, in unix nanoseconds, is when the action expires,
+	// 0 if it doesn't.
+	ExpireTime int64
+}
+
+// Expired returns true if the action expired by now, on the clock of
+// the agent. The expiry time was set on the clock of the client that
+// queued the action, which may be up to maxClockSkew ahead, so the
+// action only expires once now is that much past it.
+func (ah *ActionHeader) Expired(now time.Time, maxClockSkew time.Duration) bool {
+	if ah.ExpireTime == 0 {
+		return false
+	}
+	return now.After(time.Unix(0, ah.ExpireTime).Add(maxClockSkew))
+}
+
+// ActionNodeDecoder decodes the action nodes for ActionEventLoop.
+// The format of the action nodes belongs to the tabletmanager, which
+// implements it.
+type ActionNodeDecoder interface {
+	// DecodeActionHeader returns the header of the action node
+	// in data.
+	DecodeActionHeader(data string) (*ActionHeader, error)
+
+	// ExpireActionNode returns the action node in data completed
+	// with a failed result at now, flagged as expired.
+	ExpireActionNode(data string, now time.Time) (string, error)
+}
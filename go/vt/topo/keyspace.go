@@ -22,6 +22,17 @@ type Keyspace struct {
 	// ServedFrom will redirect the appropriate traffic to
 	// another keyspace
 	ServedFrom map[TabletType]string
+
+	// MigratingTables are the tables going through a schema
+	// change. vtgate rejects the queries using them with a
+	// retryable error until the change is done, or until
+	// MigratingTablesExpireTime if it is set.
+	MigratingTables []string
+
+	// MigratingTablesExpireTime, in unix nanoseconds, is when
+	// MigratingTables stop applying, so a change that didn't
+	// get to clear them doesn't block the tables forever.
+	MigratingTablesExpireTime int64
 }
 
 // KeyspaceInfo is a meta struct that contains metadata to give the
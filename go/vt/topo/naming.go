@@ -21,6 +21,8 @@ In zk, this is in /zk/local/vt/ns/<keyspace>/<shard>/<db type>
 import (
 	"fmt"
 	"net"
+	"strconv"
+	"time"
 
 	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/netutil"
@@ -30,12 +32,75 @@ const (
 	// DefaultPortName is the port named used by SrvEntries
 	// if "" is given as the named port.
 	DefaultPortName = "_vtocc"
+
+	// MAX_HEALTH is the health score of a fully healthy tablet
+	// (see EndPoint.Health).
+	MAX_HEALTH = 100
+
+	// DEFAULT_WEIGHT is the weight of the tablets that don't set
+	// one (see EndPoint.Weight).
+	DEFAULT_WEIGHT = 100
+
+	// HEALTH_REPLICATION_LAG is the key of EndPoint.HealthMap for
+	// the replication lag of the tablet, in seconds. Masters don't
+	// report it.
+	HEALTH_REPLICATION_LAG = "replication_lag"
+
+	// HEALTH_SERVING is the key of EndPoint.HealthMap for whether
+	// the query service of the tablet is healthy, "true" or "false".
+	HEALTH_SERVING = "serving"
 )
 
 type EndPoint struct {
 	Uid          uint32         `json:"uid"` // Keep track of which tablet this corresponds to.
 	Host         string         `json:"host"`
 	NamedPortMap map[string]int `json:"named_port_map"`
+	Workload     string         `json:"workload,omitempty"` // The tablet's WORKLOAD_TAG, if any.
+	// Health is the health score of the tablet, from 1 (barely
+	// serving) to MAX_HEALTH, kept up to date by its agent.
+	// 0 means unknown, and counts as healthy (see HealthScore).
+	Health int `json:"health,omitempty"`
+	// Weight is the capacity of the tablet relative to the others,
+	// from its WEIGHT_TAG. Balancers that honor it send it traffic
+	// in proportion. 0 means unset, and counts as DEFAULT_WEIGHT
+	// (see WeightScore).
+	Weight int `json:"weight,omitempty"`
+	// HealthMap details the health of the tablet, kept up to date
+	// by its agent along with Health: see the HEALTH_* keys. It's
+	// nil if the agent doesn't report it.
+	HealthMap map[string]string `json:"health_map,omitempty"`
+}
+
+// HealthScore returns the health score of the end point,
+// MAX_HEALTH if it's unknown.
+func (ep *EndPoint) HealthScore() int {
+	if ep.Health <= 0 {
+		return MAX_HEALTH
+	}
+	return ep.Health
+}
+
+// ReplicationLag returns the replication lag the tablet reported
+// in HealthMap, and false if it didn't report one.
+func (ep *EndPoint) ReplicationLag() (time.Duration, bool) {
+	value, ok := ep.HealthMap[HEALTH_REPLICATION_LAG]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// WeightScore returns the weight of the end point,
+// DEFAULT_WEIGHT if it's unset.
+func (ep *EndPoint) WeightScore() int {
+	if ep.Weight <= 0 {
+		return DEFAULT_WEIGHT
+	}
+	return ep.Weight
 }
 
 type EndPoints struct {
@@ -53,6 +118,23 @@ func EndPointEquality(left, right *EndPoint) bool {
 	if left.Host != right.Host {
 		return false
 	}
+	if left.Workload != right.Workload {
+		return false
+	}
+	if left.Health != right.Health {
+		return false
+	}
+	if left.Weight != right.Weight {
+		return false
+	}
+	if len(left.HealthMap) != len(right.HealthMap) {
+		return false
+	}
+	for key, lvalue := range left.HealthMap {
+		if rvalue, ok := right.HealthMap[key]; !ok || lvalue != rvalue {
+			return false
+		}
+	}
 	if len(left.NamedPortMap) != len(right.NamedPortMap) {
 		return false
 	}
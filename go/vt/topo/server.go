@@ -41,6 +41,23 @@ var (
 	// ErrPartialResult is returned by a function that could only
 	// get a subset of its results
 	ErrPartialResult = errors.New("partial result")
+
+	// ErrWatchNotSupported is returned by a watch function of an
+	// implementation that can't watch the nodes.
+	ErrWatchNotSupported = errors.New("watch not supported")
+
+	// ErrRetriesExhausted is returned by an update function that
+	// gave up on a change that kept conflicting with concurrent
+	// ones: it can be tried again later.
+	ErrRetriesExhausted = errors.New("retries exhausted")
+)
+
+// Tablet action priorities, for WriteTabletActionWithPriority.
+// Actions with a lower priority value run first.
+const (
+	ACTION_PRIORITY_HIGHEST = 0
+	ACTION_PRIORITY_DEFAULT = 5
+	ACTION_PRIORITY_LOWEST  = 9
 )
 
 // topo.Server is the interface used to talk to a persistent
@@ -242,6 +259,13 @@ type Server interface {
 	// If the node doesn't exist, it is not updated, this is not an error.
 	UpdateTabletEndpoint(cell, keyspace, shard string, tabletType TabletType, addr *EndPoint) error
 
+	// RemoveTabletEndpoint removes the record of tablet uid from the
+	// already computed serving graph, with the same atomicity as
+	// UpdateTabletEndpoint. If it was the last record, the node is
+	// left with no entries rather than deleted.
+	// If the node or the record doesn't exist, this is not an error.
+	RemoveTabletEndpoint(cell, keyspace, shard string, tabletType TabletType, uid uint32) error
+
 	//
 	// Keyspace and Shard locks for actions, global.
 	//
@@ -277,12 +301,22 @@ type Server interface {
 	// action is identified by the returned string, actionPath.
 	WriteTabletAction(tabletAlias TabletAlias, contents string) (string, error)
 
+	// WriteTabletActionWithPriority is like WriteTabletAction,
+	// but queued actions with a lower priority value are run
+	// first. WriteTabletAction uses ACTION_PRIORITY_DEFAULT.
+	WriteTabletActionWithPriority(tabletAlias TabletAlias, contents string, priority int) (string, error)
+
 	// WaitForTabletAction waits for a tablet action to complete. It
 	// will wait for the result for at most duration. The wait can
 	// be interrupted if the interrupted channel is closed.
 	// Can return ErrTimeout or ErrInterrupted
 	WaitForTabletAction(actionPath string, waitTime time.Duration, interrupted chan struct{}) (string, error)
 
+	// CancelTabletAction asks the agent of the tablet to cancel
+	// the action with actionGuid, if it is running it. It doesn't
+	// wait for the action to stop.
+	CancelTabletAction(tabletAlias TabletAlias, actionGuid string) error
+
 	// PurgeTabletActions removes all queued actions for a tablet.
 	// This might break the locking mechanism of the remote action
 	// queue, used with caution.
@@ -300,9 +334,18 @@ type Server interface {
 	// this tablet's current PID, until 'done' is closed.
 	CreateTabletPidNode(tabletAlias TabletAlias, contents string, done chan struct{}) error
 
+	// DeleteTabletPidNode removes the PID node, once the 'done'
+	// channel given to CreateTabletPidNode is closed.
+	DeleteTabletPidNode(tabletAlias TabletAlias) error
+
 	// ValidateTabletPidNode makes sure a PID file exists for the tablet
 	ValidateTabletPidNode(tabletAlias TabletAlias) error
 
+	// GetTabletPidNode returns the contents of the PID node of the
+	// tablet, as given to CreateTabletPidNode.
+	// Can return ErrNoNode.
+	GetTabletPidNode(tabletAlias TabletAlias) (string, error)
+
 	// GetSubprocessFlags returns the flags required to run a
 	// subprocess that uses the same Server parameters as
 	// this process.
@@ -313,7 +356,18 @@ type Server interface {
 	// If dispatchAction returns an error, we'll wait a bit before trying
 	// again.
 	// If 'done' is closed, the loop returns.
-	ActionEventLoop(tabletAlias TabletAlias, dispatchAction func(actionPath, data string) error, done chan struct{})
+	// Up to concurrency ParallelSafe actions are dispatched at the
+	// same time, the others are dispatched alone, in queue order.
+	// Two actions are never launched less than minInterval apart.
+	// The action nodes are decoded by decoder, which can be nil
+	// when they are not actual action nodes: then all the actions
+	// are dispatched alone, and none of them expire.
+	ActionEventLoop(tabletAlias TabletAlias, dispatchAction func(actionPath, data string) error, decoder ActionNodeDecoder, concurrency int, minInterval time.Duration, done chan struct{})
+
+	// ActionCancelLoop calls cancelAction with the guid passed
+	// to every CancelTabletAction call for the tablet.
+	// If 'done' is closed, the loop returns.
+	ActionCancelLoop(tabletAlias TabletAlias, cancelAction func(actionGuid string), done chan struct{})
 
 	// ReadTabletActionPath reads the actionPath and returns the
 	// associated TabletAlias, the data (originally written by
@@ -330,9 +384,21 @@ type Server interface {
 	// This will not unblock the caller yet.
 	StoreTabletActionResponse(actionPath, data string) error
 
+	// StoreTabletActionResult replaces the data of an action that
+	// is complete (StoreTabletActionResponse and UnblockTabletAction
+	// were called) with data, to add details on how it ran.
+	StoreTabletActionResult(actionPath, data string) error
+
 	// UnblockTabletAction will let the client continue.
 	// StoreTabletActionResponse must have been called already.
 	UnblockTabletAction(actionPath string) error
+
+	// RecordCompletedAction records that the action at actionPath,
+	// with actionGuid, completed successfully. It's called between
+	// StoreTabletActionResponse and UnblockTabletAction, so an agent
+	// that dies in between doesn't run the action again when it
+	// restarts. Implementations can ignore it.
+	RecordCompletedAction(actionPath, actionGuid string) error
 }
 
 // Registry for Server implementations.
@@ -223,9 +223,11 @@ type SrvKeyspace struct {
 	TabletTypes []TabletType
 
 	// Copied from Keyspace
-	ShardingColumnName string
-	ShardingColumnType key.KeyspaceIdType
-	ServedFrom         map[TabletType]string
+	ShardingColumnName        string
+	ShardingColumnType        key.KeyspaceIdType
+	ServedFrom                map[TabletType]string
+	MigratingTables           []string
+	MigratingTablesExpireTime int64
 
 	// For atomic updates
 	version int64
@@ -321,6 +323,8 @@ func (sk *SrvKeyspace) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 	bson.EncodeString(buf, "ShardingColumnName", sk.ShardingColumnName)
 	bson.EncodeString(buf, "ShardingColumnType", string(sk.ShardingColumnType))
 	EncodeServedFrom(buf, "ServedFrom", sk.ServedFrom)
+	bson.EncodeStringArray(buf, "MigratingTables", sk.MigratingTables)
+	bson.EncodeInt64(buf, "MigratingTablesExpireTime", sk.MigratingTablesExpireTime)
 
 	buf.WriteByte(0)
 	lenWriter.RecordLen()
@@ -346,6 +350,10 @@ func (sk *SrvKeyspace) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			sk.ShardingColumnType = key.KeyspaceIdType(bson.DecodeString(buf, kind))
 		case "ServedFrom":
 			sk.ServedFrom = DecodeServedFrom(buf, kind)
+		case "MigratingTables":
+			sk.MigratingTables = bson.DecodeStringArray(buf, kind)
+		case "MigratingTablesExpireTime":
+			sk.MigratingTablesExpireTime = bson.DecodeInt64(buf, kind)
 		default:
 			bson.Skip(buf, kind)
 		}
@@ -13,24 +13,28 @@ import (
 )
 
 type reflectSrvKeyspace struct {
-	Partitions         map[string]*KeyspacePartition
-	Shards             []SrvShard
-	TabletTypes        []TabletType
-	ShardingColumnName string
-	ShardingColumnType key.KeyspaceIdType
-	ServedFrom         map[string]string
-	version            int64
+	Partitions                map[string]*KeyspacePartition
+	Shards                    []SrvShard
+	TabletTypes               []TabletType
+	ShardingColumnName        string
+	ShardingColumnType        key.KeyspaceIdType
+	ServedFrom                map[string]string
+	MigratingTables           []string
+	MigratingTablesExpireTime int64
+	version                   int64
 }
 
 type extraSrvKeyspace struct {
-	Extra              int
-	Partitions         map[TabletType]*KeyspacePartition
-	Shards             []SrvShard
-	TabletTypes        []TabletType
-	ShardingColumnName string
-	ShardingColumnType key.KeyspaceIdType
-	ServedFrom         map[TabletType]string
-	version            int64
+	Extra                     int
+	Partitions                map[TabletType]*KeyspacePartition
+	Shards                    []SrvShard
+	TabletTypes               []TabletType
+	ShardingColumnName        string
+	ShardingColumnType        key.KeyspaceIdType
+	ServedFrom                map[TabletType]string
+	MigratingTables           []string
+	MigratingTablesExpireTime int64
+	version                   int64
 }
 
 func TestSrvKeySpace(t *testing.T) {
@@ -50,6 +54,8 @@ func TestSrvKeySpace(t *testing.T) {
 		ServedFrom: map[string]string{
 			string(TYPE_REPLICA): "other_keyspace",
 		},
+		MigratingTables:           []string{"table1"},
+		MigratingTablesExpireTime: 1,
 	})
 	if err != nil {
 		t.Error(err)
@@ -72,6 +78,8 @@ func TestSrvKeySpace(t *testing.T) {
 		ServedFrom: map[TabletType]string{
 			TYPE_REPLICA: "other_keyspace",
 		},
+		MigratingTables:           []string{"table1"},
+		MigratingTablesExpireTime: 1,
 	}
 
 	encoded, err := bson.Marshal(&custom)
@@ -312,6 +312,16 @@ const (
 	STATE_READ_ONLY = TabletState("ReadOnly")
 )
 
+// WORKLOAD_TAG is the tablet tag that says which workload (e.g. "oltp"
+// or "olap") the tablet serves. It is published in the tablet's EndPoint,
+// so clients can isolate workloads within the same tablet type.
+const WORKLOAD_TAG = "workload"
+
+// WEIGHT_TAG is the tablet tag that holds the weight of the tablet, a
+// positive integer, relative to the others (see EndPoint.Weight). It
+// can drain a tablet, or favor a bigger machine.
+const WEIGHT_TAG = "weight"
+
 // Tablet is a pure data struct for information serialized into json
 // and stored into topo.Server
 type Tablet struct {
@@ -335,6 +345,7 @@ type Tablet struct {
 	Portmap map[string]int
 
 	// Tags contain freeform information about the tablet.
+	// The WORKLOAD_TAG and WEIGHT_TAG tags are copied to the serving graph.
 	Tags map[string]string
 
 	// Information about the tablet inside a keyspace/shard
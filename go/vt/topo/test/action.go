@@ -115,7 +115,7 @@ func CheckActions(t *testing.T, ts topo.Server) {
 
 		wg2.Done()
 		return nil
-	}, done)
+	}, nil, 1, 0, done)
 
 	// first wait for the processing to be done, then close the
 	// action loop, then wait for the response to be received.
@@ -66,6 +66,25 @@ func CheckServingGraph(t *testing.T, ts topo.Server) {
 		t.Errorf("GetEndPoints(2): %v %v", err, addrs)
 	}
 
+	if err := ts.RemoveTabletEndpoint(cell, "test_keyspace", "-10", topo.TYPE_REPLICA, 2); err != nil {
+		t.Errorf("RemoveTabletEndpoint(invalid): %v", err)
+	}
+	if err := ts.RemoveTabletEndpoint(cell, "test_keyspace", "-10", topo.TYPE_MASTER, 4); err != nil {
+		t.Errorf("RemoveTabletEndpoint(unknown uid): %v", err)
+	}
+	if err := ts.RemoveTabletEndpoint(cell, "test_keyspace", "-10", topo.TYPE_MASTER, 3); err != nil {
+		t.Errorf("RemoveTabletEndpoint(master): %v", err)
+	}
+	if addrs, err := ts.GetEndPoints(cell, "test_keyspace", "-10", topo.TYPE_MASTER); err != nil || len(addrs.Entries) != 1 || addrs.Entries[0].Uid != 1 {
+		t.Errorf("GetEndPoints(3): %v %v", err, addrs)
+	}
+	if err := ts.RemoveTabletEndpoint(cell, "test_keyspace", "-10", topo.TYPE_MASTER, 1); err != nil {
+		t.Errorf("RemoveTabletEndpoint(master): %v", err)
+	}
+	if addrs, err := ts.GetEndPoints(cell, "test_keyspace", "-10", topo.TYPE_MASTER); err != nil || len(addrs.Entries) != 0 {
+		t.Errorf("GetEndPoints(4): %v %v", err, addrs)
+	}
+
 	if err := ts.DeleteSrvTabletType(cell, "test_keyspace", "-10", topo.TYPE_REPLICA); err != topo.ErrNoNode {
 		t.Errorf("DeleteSrvTabletType(unknown): %v", err)
 	}
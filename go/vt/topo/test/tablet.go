@@ -143,6 +143,10 @@ func CheckPid(t *testing.T, ts topo.Server) {
 	}
 	tabletAlias := topo.TabletAlias{Cell: cell, Uid: 1}
 
+	if _, err := ts.GetTabletPidNode(tabletAlias); err != topo.ErrNoNode {
+		t.Errorf("GetTabletPidNode(no pid node): %v", err)
+	}
+
 	done := make(chan struct{}, 1)
 	if err := ts.CreateTabletPidNode(tabletAlias, "contents", done); err != nil {
 		t.Errorf("ts.CreateTabletPidNode: %v", err)
@@ -165,5 +169,18 @@ func CheckPid(t *testing.T, ts topo.Server) {
 		time.Sleep(time.Second)
 	}
 
+	if contents, err := ts.GetTabletPidNode(tabletAlias); err != nil || contents != "contents" {
+		t.Errorf("GetTabletPidNode: %v %v", contents, err)
+	}
+
 	close(done)
+	if err := ts.DeleteTabletPidNode(tabletAlias); err != nil {
+		t.Fatalf("ts.DeleteTabletPidNode: %v", err)
+	}
+	if err := ts.ValidateTabletPidNode(tabletAlias); err == nil {
+		t.Errorf("ts.ValidateTabletPidNode succeeded after DeleteTabletPidNode")
+	}
+	if err := ts.DeleteTabletPidNode(tabletAlias); err != nil {
+		t.Errorf("ts.DeleteTabletPidNode of a deleted node: %v", err)
+	}
 }
@@ -480,6 +480,18 @@ func (tee *Tee) UpdateTabletEndpoint(cell, keyspace, shard string, tabletType to
 	return nil
 }
 
+func (tee *Tee) RemoveTabletEndpoint(cell, keyspace, shard string, tabletType topo.TabletType, uid uint32) error {
+	if err := tee.primary.RemoveTabletEndpoint(cell, keyspace, shard, tabletType, uid); err != nil {
+		return err
+	}
+
+	if err := tee.secondary.RemoveTabletEndpoint(cell, keyspace, shard, tabletType, uid); err != nil {
+		// not critical enough to fail
+		log.Warningf("secondary.RemoveTabletEndpoint(%v, %v, %v, %v, %v) failed: %v", cell, keyspace, shard, tabletType, uid, err)
+	}
+	return nil
+}
+
 //
 // Keyspace and Shard locks for actions, global.
 //
@@ -587,10 +599,18 @@ func (tee *Tee) WriteTabletAction(tabletAlias topo.TabletAlias, contents string)
 	return tee.primary.WriteTabletAction(tabletAlias, contents)
 }
 
+func (tee *Tee) WriteTabletActionWithPriority(tabletAlias topo.TabletAlias, contents string, priority int) (string, error) {
+	return tee.primary.WriteTabletActionWithPriority(tabletAlias, contents, priority)
+}
+
 func (tee *Tee) WaitForTabletAction(actionPath string, waitTime time.Duration, interrupted chan struct{}) (string, error) {
 	return tee.primary.WaitForTabletAction(actionPath, waitTime, interrupted)
 }
 
+func (tee *Tee) CancelTabletAction(tabletAlias topo.TabletAlias, actionGuid string) error {
+	return tee.primary.CancelTabletAction(tabletAlias, actionGuid)
+}
+
 func (tee *Tee) PurgeTabletActions(tabletAlias topo.TabletAlias, canBePurged func(data string) bool) error {
 	return tee.primary.PurgeTabletActions(tabletAlias, canBePurged)
 }
@@ -623,6 +643,17 @@ func (tee *Tee) CreateTabletPidNode(tabletAlias topo.TabletAlias, contents strin
 	return nil
 }
 
+func (tee *Tee) DeleteTabletPidNode(tabletAlias topo.TabletAlias) error {
+	if err := tee.primary.DeleteTabletPidNode(tabletAlias); err != nil {
+		return err
+	}
+
+	if err := tee.secondary.DeleteTabletPidNode(tabletAlias); err != nil {
+		log.Warningf("secondary.DeleteTabletPidNode(%v) failed: %v", tabletAlias, err)
+	}
+	return nil
+}
+
 func (tee *Tee) ValidateTabletPidNode(tabletAlias topo.TabletAlias) error {
 	// if the primary fails, no need to go on
 	if err := tee.primary.ValidateTabletPidNode(tabletAlias); err != nil {
@@ -635,12 +666,16 @@ func (tee *Tee) ValidateTabletPidNode(tabletAlias topo.TabletAlias) error {
 	return nil
 }
 
+func (tee *Tee) GetTabletPidNode(tabletAlias topo.TabletAlias) (string, error) {
+	return tee.readFrom.GetTabletPidNode(tabletAlias)
+}
+
 func (tee *Tee) GetSubprocessFlags() []string {
 	p := tee.primary.GetSubprocessFlags()
 	return append(p, tee.secondary.GetSubprocessFlags()...)
 }
 
-func (tee *Tee) ActionEventLoop(tabletAlias topo.TabletAlias, dispatchAction func(actionPath, data string) error, done chan struct{}) {
+func (tee *Tee) ActionEventLoop(tabletAlias topo.TabletAlias, dispatchAction func(actionPath, data string) error, decoder topo.ActionNodeDecoder, concurrency int, minInterval time.Duration, done chan struct{}) {
 	// We run the action loop on both primary and secondary.
 	// We dispatch actions by adding a 'p' or 's'
 	// as the first character of the action.
@@ -651,7 +686,7 @@ func (tee *Tee) ActionEventLoop(tabletAlias topo.TabletAlias, dispatchAction fun
 	go func() {
 		tee.primary.ActionEventLoop(tabletAlias, func(actionPath, data string) error {
 			return dispatchAction("p"+actionPath, data)
-		}, done)
+		}, decoder, concurrency, minInterval, done)
 		wg.Done()
 	}()
 
@@ -660,7 +695,7 @@ func (tee *Tee) ActionEventLoop(tabletAlias topo.TabletAlias, dispatchAction fun
 	go func() {
 		tee.secondary.ActionEventLoop(tabletAlias, func(actionPath, data string) error {
 			return dispatchAction("s"+actionPath, data)
-		}, done)
+		}, decoder, concurrency, minInterval, done)
 		wg.Done()
 	}()
 
@@ -668,6 +703,11 @@ func (tee *Tee) ActionEventLoop(tabletAlias topo.TabletAlias, dispatchAction fun
 	wg.Wait()
 }
 
+func (tee *Tee) ActionCancelLoop(tabletAlias topo.TabletAlias, cancelAction func(actionGuid string), done chan struct{}) {
+	// CancelTabletAction goes through the primary.
+	tee.primary.ActionCancelLoop(tabletAlias, cancelAction, done)
+}
+
 func (tee *Tee) ReadTabletActionPath(actionPath string) (topo.TabletAlias, string, int64, error) {
 	if actionPath[0] == 'p' {
 		return tee.primary.ReadTabletActionPath(actionPath[1:])
@@ -696,6 +736,24 @@ func (tee *Tee) StoreTabletActionResponse(actionPath, data string) error {
 	return tee.primary.StoreTabletActionResponse(actionPath, data)
 }
 
+func (tee *Tee) StoreTabletActionResult(actionPath, data string) error {
+	if actionPath[0] == 'p' {
+		return tee.primary.StoreTabletActionResult(actionPath[1:], data)
+	} else if actionPath[0] == 's' {
+		return tee.secondary.StoreTabletActionResult(actionPath[1:], data)
+	}
+	return tee.primary.StoreTabletActionResult(actionPath, data)
+}
+
+func (tee *Tee) RecordCompletedAction(actionPath, actionGuid string) error {
+	if actionPath[0] == 'p' {
+		return tee.primary.RecordCompletedAction(actionPath[1:], actionGuid)
+	} else if actionPath[0] == 's' {
+		return tee.secondary.RecordCompletedAction(actionPath[1:], actionGuid)
+	}
+	return tee.primary.RecordCompletedAction(actionPath, actionGuid)
+}
+
 func (tee *Tee) UnblockTabletAction(actionPath string) error {
 	if actionPath[0] == 'p' {
 		return tee.primary.UnblockTabletAction(actionPath[1:])
@@ -5,18 +5,208 @@
 package vtgate
 
 import (
+	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
+	"hash/fnv"
 	"math/rand"
+	"strconv"
 	"sync"
 	"time"
 
 	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/vt/topo"
 )
 
+var workloadFallback = flag.Bool("workload_fallback", true, "if no tablet is tagged with the workload a session asks for, use the untagged tablets")
+
+const (
+	// BALANCE_ROUND_ROBIN sends each tablet traffic in proportion to
+	// its health score: with equal scores, it's a round-robin.
+	BALANCE_ROUND_ROBIN = "round_robin"
+	// BALANCE_WEIGHTED is BALANCE_ROUND_ROBIN, with the health score
+	// multiplied by the weight of the tablet (see topo.WEIGHT_TAG).
+	BALANCE_WEIGHTED = "weighted"
+	// BALANCE_LEAST_CONNECTIONS picks the tablet with the fewest
+	// requests in flight from this vtgate.
+	BALANCE_LEAST_CONNECTIONS = "least_connections"
+)
+
+var (
+	tabletMinHealth        = flag.Int("tablet_min_health", 0, "skip the tablets with a health score below this, unless none is above (0 to use all the tablets)")
+	tabletFailureThreshold = flag.Int("tablet_failure_threshold", 1, "number of consecutive failures after which a tablet is marked down")
+	tabletRecoveryWindow   = flag.Duration("tablet_recovery_window", 0, "how long a tablet marked down or unhealthy is skipped before it's tried again (0 for -retry_delay)")
+)
+
+var tabletBreakerCooldown = flag.Duration("tablet_breaker_cooldown", 0, "if set, a tablet marked down gets no traffic for this long, then a single probe request: queries fail fast when all the tablets are in that state (0 to disable)")
+
+// ErrCircuitOpen is returned by Balancer.Get when the circuits
+// of all the tablets are open, see -tablet_breaker_cooldown.
+var ErrCircuitOpen = errors.New("circuit open for all tablets")
+
+// breakerState is the state of the circuit breaker of a tablet.
+type breakerState int64
+
+const (
+	// breakerClosed lets all the requests through.
+	breakerClosed breakerState = iota
+	// breakerOpen lets no request through until the cooldown is over.
+	breakerOpen
+	// breakerHalfOpen lets a single probe request through: if it
+	// succeeds the circuit closes, if it fails it opens again.
+	breakerHalfOpen
+)
+
+var (
+	// tabletBreakers is the state of the circuits that are not
+	// closed, by tablet uid. It's published as VtgateTabletBreakers.
+	tabletBreakersMu sync.Mutex
+	tabletBreakers   = make(map[uint32]breakerState)
+
+	breakerTrips      = stats.NewCounters("VtgateTabletBreakerTrips")
+	breakerRejections = stats.NewInt("VtgateTabletBreakerRejections")
+)
+
+func init() {
+	stats.Publish("VtgateTabletBreakers", stats.CountersFunc(func() map[string]int64 {
+		tabletBreakersMu.Lock()
+		defer tabletBreakersMu.Unlock()
+		states := make(map[string]int64, len(tabletBreakers))
+		for uid, state := range tabletBreakers {
+			states[strconv.FormatUint(uint64(uid), 10)] = int64(state)
+		}
+		return states
+	}))
+}
+
+var balancerStrategy = flag.String("balancer_strategy", BALANCE_ROUND_ROBIN, "how to pick the tablet each request goes to: round_robin, weighted or least_connections")
+
+// balancingStrategy picks, among the nodes that are not marked down,
+// the one a Balancer returns. The nodes are in round-robin order,
+// starting after the last pick.
+type balancingStrategy interface {
+	pick(nodes []*addressStatus) *addressStatus
+}
+
+// smoothWeighted is the smooth weighted round-robin: every node gains
+// its score, the one with the most is picked and gives back the sum
+// of the scores.
+type smoothWeighted func(endPoint *topo.EndPoint) int
+
+func (score smoothWeighted) pick(nodes []*addressStatus) *addressStatus {
+	var best *addressStatus
+	total := 0
+	for _, addrNode := range nodes {
+		s := score(&addrNode.endPoint)
+		addrNode.current += s
+		total += s
+		if best == nil || addrNode.current > best.current {
+			best = addrNode
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// leastConnections picks the node with the fewest requests in flight,
+// the first one in round-robin order on ties.
+type leastConnections struct{}
+
+func (leastConnections) pick(nodes []*addressStatus) *addressStatus {
+	tabletRequestsMu.Lock()
+	defer tabletRequestsMu.Unlock()
+	best := nodes[0]
+	for _, addrNode := range nodes[1:] {
+		if tabletRequests[addrNode.endPoint.Uid] < tabletRequests[best.endPoint.Uid] {
+			best = addrNode
+		}
+	}
+	return best
+}
+
+// getStrategy returns the strategy of -balancer_strategy.
+func getStrategy() balancingStrategy {
+	switch *balancerStrategy {
+	case BALANCE_WEIGHTED:
+		return smoothWeighted(func(endPoint *topo.EndPoint) int {
+			return endPoint.HealthScore() * endPoint.WeightScore()
+		})
+	case BALANCE_LEAST_CONNECTIONS:
+		return leastConnections{}
+	case BALANCE_ROUND_ROBIN:
+	default:
+		log.Warningf("unknown balancer_strategy %v, using %v", *balancerStrategy, BALANCE_ROUND_ROBIN)
+	}
+	return smoothWeighted((*topo.EndPoint).HealthScore)
+}
+
+var (
+	// tabletRequests is the number of requests in flight
+	// to each tablet, by uid, for leastConnections.
+	tabletRequestsMu sync.Mutex
+	tabletRequests   = make(map[uint32]int)
+)
+
+// tabletRequestStarted and tabletRequestEnded count
+// the requests in flight to the tablet uid.
+func tabletRequestStarted(uid uint32) {
+	tabletRequestsMu.Lock()
+	defer tabletRequestsMu.Unlock()
+	tabletRequests[uid]++
+}
+
+func tabletRequestEnded(uid uint32) {
+	tabletRequestsMu.Lock()
+	defer tabletRequestsMu.Unlock()
+	if tabletRequests[uid] <= 1 {
+		delete(tabletRequests, uid)
+		return
+	}
+	tabletRequests[uid]--
+}
+
 type GetEndPointsFunc func() (*topo.EndPoints, error)
 
-// Balancer is a simple round-robin load balancer.
+// SelectFunc returns the index in endPoints of the end point a Balancer
+// should use. endPoints are the ones that are not marked down
+// or blacklisted.
+// Returning an index out of range falls back to round-robin.
+type SelectFunc func(endPoints []topo.EndPoint) int
+
+var (
+	selectMu   sync.Mutex
+	selectFunc SelectFunc
+)
+
+// SetSelectFunc makes the Balancers created from now on use f to pick
+// their end points, so tests of the routing behaviors are reproducible.
+// Production code should not use it. nil restores the default
+// round-robin. Balancer.SetSelectFunc changes an existing Balancer.
+func SetSelectFunc(f SelectFunc) {
+	selectMu.Lock()
+	defer selectMu.Unlock()
+	selectFunc = f
+}
+
+// PickUid returns a SelectFunc that picks the end point with that uid
+// if it's available.
+func PickUid(uid uint32) SelectFunc {
+	return func(endPoints []topo.EndPoint) int {
+		for i, endPoint := range endPoints {
+			if endPoint.Uid == uid {
+				return i
+			}
+		}
+		return -1
+	}
+}
+
+// Balancer is a load balancer. By default, it's a simple round-robin,
+// weighted by the health score of the nodes (see topo.EndPoint.Health):
+// a node gets traffic in proportion to its score. -balancer_strategy
+// picks another strategy.
 // It allows you to temporarily mark down nodes that
 // are non-functional.
 type Balancer struct {
@@ -25,31 +215,94 @@ type Balancer struct {
 	index        int
 	getEndPoints GetEndPointsFunc
 	retryDelay   time.Duration
+	workload     string
+	strategy     balancingStrategy
+	// lastRefresh is when the addresses were last refreshed,
+	// so unhealthy nodes get a chance to recover.
+	lastRefresh time.Time
+	// watchStop stops the watch of the end points, see Watch.
+	watchStop chan struct{}
+	// selectFunc, if set, picks the end points, see SetSelectFunc.
+	selectFunc SelectFunc
+	// onUpdate, if set, is called after each update of the watch
+	// of the end points, without mu held.
+	onUpdate func()
+	// version counts the updates of the addresses, see routingVersion.
+	version int64
 }
 
 type addressStatus struct {
 	endPoint  topo.EndPoint
 	timeRetry time.Time
 	balancer  *Balancer
+	// current is the smooth weighted round-robin weight of the node.
+	current int
+	// failures is the number of consecutive failures of the node,
+	// see MarkDown.
+	failures int
+	// breaker is the state of the circuit breaker of the node, and
+	// probeStart when its probe was handed out if it's half open.
+	breaker    breakerState
+	probeStart time.Time
+}
+
+// setBreaker changes the state of the circuit breaker of addrNode.
+func (addrNode *addressStatus) setBreaker(state breakerState) {
+	addrNode.breaker = state
+	addrNode.probeStart = time.Time{}
+	tabletBreakersMu.Lock()
+	defer tabletBreakersMu.Unlock()
+	if state == breakerClosed {
+		delete(tabletBreakers, addrNode.endPoint.Uid)
+		return
+	}
+	tabletBreakers[addrNode.endPoint.Uid] = state
+}
+
+// probing returns true if addrNode is half open and its probe is
+// in flight. A probe that neither succeeded nor failed within the
+// cooldown is deemed lost, and another one can go.
+func (addrNode *addressStatus) probing(now time.Time) bool {
+	return addrNode.breaker == breakerHalfOpen && !addrNode.probeStart.IsZero() && now.Sub(addrNode.probeStart) < *tabletBreakerCooldown
 }
 
 // NewBalancer creates a Balancer. getAddreses is the function
 // it will use to refresh the list of addresses if one of the
 // nodes has been marked down. The list of addresses is shuffled.
 // retryDelay specifies the minimum time a node will be marked down
-// before it will be cleared for a retry.
-func NewBalancer(getEndPoints GetEndPointsFunc, retryDelay time.Duration) *Balancer {
+// before it will be cleared for a retry. If workload is set, only
+// the nodes tagged with that workload are used (see filterByWorkload).
+func NewBalancer(getEndPoints GetEndPointsFunc, retryDelay time.Duration, workload string) *Balancer {
 	blc := new(Balancer)
 	blc.getEndPoints = getEndPoints
 	blc.retryDelay = retryDelay
+	blc.workload = workload
+	blc.strategy = getStrategy()
+	selectMu.Lock()
+	blc.selectFunc = selectFunc
+	selectMu.Unlock()
 	return blc
 }
 
+// SetSelectFunc makes the Balancer use f to pick its end points,
+// like the package level SetSelectFunc does for the new ones.
+func (blc *Balancer) SetSelectFunc(f SelectFunc) {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	blc.selectFunc = f
+}
+
 // Get returns a single endpoint that was not recently marked down.
 // If it finds an address that was down for longer than retryDelay,
 // it refreshes the list of addresses and returns the next available
 // node. If all addresses are marked down, it waits and retries.
 // If a refresh fails, it returns an error.
+// Nodes are picked by the strategy of the Balancer.
+// With -tablet_breaker_cooldown, a node marked down for the cooldown
+// gets a single probe request, and Get returns ErrCircuitOpen instead
+// of waiting if no node can be used.
+// The blacklisted nodes are skipped (see BlacklistTablet): if all the
+// nodes that can be used are, Get returns ErrAllBlacklisted.
 func (blc *Balancer) Get() (endPoint topo.EndPoint, err error) {
 	blc.mu.Lock()
 	defer blc.mu.Unlock()
@@ -61,17 +314,20 @@ func (blc *Balancer) Get() (endPoint topo.EndPoint, err error) {
 		}
 	}
 
+	if blc.selectFunc != nil {
+		if endPoint, ok := blc.selectWith(blc.selectFunc); ok {
+			return endPoint, nil
+		}
+	}
+
 outer:
 	for {
-		for i := range blc.addressNodes {
-			index := (blc.index + i + 1) % len(blc.addressNodes)
-			addrNode := blc.addressNodes[index]
-			if addrNode.timeRetry.IsZero() {
-				blc.index = index
-				return addrNode.endPoint, nil
-			}
-			if time.Now().Sub(addrNode.timeRetry) > 0 {
+		for _, addrNode := range blc.addressNodes {
+			if !addrNode.timeRetry.IsZero() && time.Now().Sub(addrNode.timeRetry) > 0 {
 				addrNode.timeRetry = time.Time{}
+				if addrNode.breaker == breakerOpen {
+					addrNode.setBreaker(breakerHalfOpen)
+				}
 				err = blc.refresh()
 				if err != nil {
 					return topo.EndPoint{}, err
@@ -79,6 +335,38 @@ outer:
 				continue outer
 			}
 		}
+		if blc.hasUnhealthy() && time.Now().Sub(blc.lastRefresh) > blc.recoveryWindow() {
+			// Maybe they recovered.
+			if err = blc.refresh(); err != nil {
+				return topo.EndPoint{}, err
+			}
+		}
+		now := time.Now()
+		available := make([]*addressStatus, 0, len(blc.addressNodes))
+		for i := range blc.addressNodes {
+			addrNode := blc.addressNodes[(blc.index+i+1)%len(blc.addressNodes)]
+			if addrNode.timeRetry.IsZero() && !addrNode.probing(now) {
+				available = append(available, addrNode)
+			}
+		}
+		if len(available) != 0 {
+			if available = skipBlacklisted(available, now); len(available) == 0 {
+				return topo.EndPoint{}, ErrAllBlacklisted
+			}
+		}
+		available = skipUnhealthy(available)
+		if len(available) != 0 {
+			best := blc.strategy.pick(available)
+			if best.breaker == breakerHalfOpen {
+				best.probeStart = now
+			}
+			blc.index = findAddrNode(blc.addressNodes, best.endPoint.Uid)
+			return best.endPoint, nil
+		}
+		if *tabletBreakerCooldown > 0 {
+			breakerRejections.Add(1)
+			return topo.EndPoint{}, ErrCircuitOpen
+		}
 		// Allow mark downs to happen while sleeping.
 		blc.mu.Unlock()
 		time.Sleep(blc.retryDelay + (1 * time.Millisecond))
@@ -86,22 +374,222 @@ outer:
 	}
 }
 
-// MarkDown marks the specified address down. Such addresses
-// will not be used by Balancer for the duration of retryDelay.
+// GetAffinity returns the end point affinityKey hashes to among the
+// ones that are not marked down or blacklisted, so queries sharing a
+// key keep hitting the same tablet and its caches. It uses rendezvous hashing: when a
+// tablet is marked down or goes away, only its keys move elsewhere.
+// If all the end points are marked down, it falls back to Get.
+func (blc *Balancer) GetAffinity(affinityKey string) (endPoint topo.EndPoint, err error) {
+	blc.mu.Lock()
+	if len(blc.addressNodes) == 0 {
+		if err = blc.refresh(); err != nil {
+			blc.mu.Unlock()
+			return topo.EndPoint{}, err
+		}
+	}
+	var best *addressStatus
+	var bestWeight uint32
+	now := time.Now()
+	for _, addrNode := range blc.addressNodes {
+		if !addrNode.timeRetry.IsZero() && now.Before(addrNode.timeRetry) || addrNode.probing(now) || isBlacklisted(addrNode.endPoint.Uid, now) {
+			continue
+		}
+		if weight := affinityWeight(affinityKey, addrNode.endPoint.Uid); best == nil || weight > bestWeight {
+			best, bestWeight = addrNode, weight
+		}
+	}
+	if best != nil {
+		best.timeRetry = time.Time{}
+		blc.mu.Unlock()
+		return best.endPoint, nil
+	}
+	blc.mu.Unlock()
+	return blc.Get()
+}
+
+// isUnhealthy returns true if the health score of the end point
+// is below -tablet_min_health.
+func isUnhealthy(endPoint *topo.EndPoint) bool {
+	return endPoint.HealthScore() < *tabletMinHealth
+}
+
+// hasUnhealthy returns true if some nodes are unhealthy. mu must be held.
+func (blc *Balancer) hasUnhealthy() bool {
+	for _, addrNode := range blc.addressNodes {
+		if isUnhealthy(&addrNode.endPoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipUnhealthy returns the healthy nodes, or all of them if none is:
+// an unhealthy tablet is better than no tablet at all.
+func skipUnhealthy(nodes []*addressStatus) []*addressStatus {
+	healthy := make([]*addressStatus, 0, len(nodes))
+	for _, addrNode := range nodes {
+		if !isUnhealthy(&addrNode.endPoint) {
+			healthy = append(healthy, addrNode)
+		}
+	}
+	if len(healthy) == 0 {
+		return nodes
+	}
+	return healthy
+}
+
+// routable returns true if the end point uid is one of the addresses,
+// not blacklisted, and it's healthy or none is: Get may return it. The
+// nodes marked down are routable, they come back when their retry
+// delay is over.
+func (blc *Balancer) routable(uid uint32) bool {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	index := findAddrNode(blc.addressNodes, uid)
+	if index == -1 || isBlacklisted(uid, time.Now()) {
+		return false
+	}
+	if !isUnhealthy(&blc.addressNodes[index].endPoint) {
+		return true
+	}
+	// Like Get, use an unhealthy node only if no node is healthy.
+	return len(skipUnhealthy(blc.addressNodes)) == len(blc.addressNodes)
+}
+
+// routingVersion changes every time the addresses are updated, or a
+// tablet is blacklisted: what routable returns may have changed since.
+func (blc *Balancer) routingVersion() int64 {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	return blc.version + blacklistVersion()
+}
+
+// recoveryWindow is how long nodes marked down or unhealthy are skipped.
+func (blc *Balancer) recoveryWindow() time.Duration {
+	if *tabletRecoveryWindow > 0 {
+		return *tabletRecoveryWindow
+	}
+	return blc.retryDelay
+}
+
+// affinityWeight is the rendezvous hashing weight of uid for affinityKey.
+func affinityWeight(affinityKey string, uid uint32) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(affinityKey))
+	binary.Write(h, binary.BigEndian, uid)
+	return h.Sum32()
+}
+
+// selectWith returns the end point f picks among the ones
+// that are not marked down or blacklisted. mu must be held.
+func (blc *Balancer) selectWith(f SelectFunc) (topo.EndPoint, bool) {
+	var available []topo.EndPoint
+	now := time.Now()
+	for _, addrNode := range blc.addressNodes {
+		if addrNode.timeRetry.IsZero() && !isBlacklisted(addrNode.endPoint.Uid, now) {
+			available = append(available, addrNode.endPoint)
+		}
+	}
+	index := f(available)
+	if index < 0 || index >= len(available) {
+		return topo.EndPoint{}, false
+	}
+	return available[index], true
+}
+
+// HasEndPoints returns true if the Balancer has at least one
+// address that is not marked down, or whose retry delay is over.
+// It refreshes the list if it's empty.
+func (blc *Balancer) HasEndPoints() bool {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	if len(blc.addressNodes) == 0 {
+		if err := blc.refresh(); err != nil {
+			return false
+		}
+	}
+	now := time.Now()
+	for _, addrNode := range blc.addressNodes {
+		if addrNode.timeRetry.IsZero() || !now.Before(addrNode.timeRetry) {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkDown records a failure of the specified address. After
+// -tablet_failure_threshold consecutive failures, it's marked down:
+// Balancer won't use it for the recovery window, -tablet_recovery_window
+// or else retryDelay. With -tablet_breaker_cooldown, this opens its
+// circuit for the cooldown instead, and a failed probe reopens it.
 func (blc *Balancer) MarkDown(uid uint32) {
 	blc.mu.Lock()
 	defer blc.mu.Unlock()
-	if index := findAddrNode(blc.addressNodes, uid); index != -1 {
-		log.Infof("Marking down %v at %+v", uid, blc.addressNodes[index].endPoint)
-		blc.addressNodes[index].timeRetry = time.Now().Add(blc.retryDelay)
+	index := findAddrNode(blc.addressNodes, uid)
+	if index == -1 {
+		return
+	}
+	addrNode := blc.addressNodes[index]
+	addrNode.failures++
+	if *tabletBreakerCooldown > 0 {
+		if addrNode.breaker == breakerOpen {
+			return
+		}
+		if addrNode.breaker == breakerClosed && addrNode.failures < *tabletFailureThreshold {
+			log.Infof("Failure %v of %v at %+v", addrNode.failures, uid, addrNode.endPoint)
+			return
+		}
+		log.Infof("Opening the circuit of %v at %+v", uid, addrNode.endPoint)
+		addrNode.failures = 0
+		addrNode.timeRetry = time.Now().Add(*tabletBreakerCooldown)
+		addrNode.setBreaker(breakerOpen)
+		breakerTrips.Add(strconv.FormatUint(uint64(uid), 10), 1)
+		return
+	}
+	if addrNode.failures < *tabletFailureThreshold {
+		log.Infof("Failure %v of %v at %+v", addrNode.failures, uid, addrNode.endPoint)
+		return
+	}
+	log.Infof("Marking down %v at %+v", uid, addrNode.endPoint)
+	addrNode.failures = 0
+	addrNode.timeRetry = time.Now().Add(blc.recoveryWindow())
+}
+
+// MarkUp records a success of the specified address, which
+// resets its count of consecutive failures and closes its circuit.
+func (blc *Balancer) MarkUp(uid uint32) {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	index := findAddrNode(blc.addressNodes, uid)
+	if index == -1 {
+		return
+	}
+	addrNode := blc.addressNodes[index]
+	addrNode.failures = 0
+	if addrNode.breaker != breakerClosed {
+		log.Infof("Closing the circuit of %v at %+v", uid, addrNode.endPoint)
+		addrNode.setBreaker(breakerClosed)
 	}
 }
 
 func (blc *Balancer) refresh() error {
+	blc.lastRefresh = time.Now()
 	endPoints, err := blc.getEndPoints()
 	if err != nil {
 		return err
 	}
+	return blc.update(endPoints)
+}
+
+// update replaces the addresses of the Balancer with endPoints,
+// keeping the state of the ones that were there already.
+// mu must be held.
+func (blc *Balancer) update(endPoints *topo.EndPoints) error {
+	blc.version++
+	endPoints, err := filterByWorkload(endPoints, blc.workload)
+	if err != nil {
+		return err
+	}
 	// Add new addressNodes
 	if endPoints != nil {
 		for _, endPoint := range endPoints.Entries {
@@ -120,6 +608,7 @@ func (blc *Balancer) refresh() error {
 	i := 0
 	for i < len(blc.addressNodes) {
 		if index := findAddress(endPoints, blc.addressNodes[i].endPoint.Uid); index == -1 {
+			blc.addressNodes[i].setBreaker(breakerClosed)
 			blc.addressNodes = delAddrNode(blc.addressNodes, i)
 			continue
 		}
@@ -132,6 +621,32 @@ func (blc *Balancer) refresh() error {
 	return nil
 }
 
+// filterByWorkload returns the end points tagged with workload.
+// If there are none and -workload_fallback is set, it returns the
+// untagged end points instead. An empty workload matches everything.
+func filterByWorkload(endPoints *topo.EndPoints, workload string) (*topo.EndPoints, error) {
+	if workload == "" || endPoints == nil {
+		return endPoints, nil
+	}
+	tagged := topo.NewEndPoints()
+	untagged := topo.NewEndPoints()
+	for _, endPoint := range endPoints.Entries {
+		switch endPoint.Workload {
+		case workload:
+			tagged.Entries = append(tagged.Entries, endPoint)
+		case "":
+			untagged.Entries = append(untagged.Entries, endPoint)
+		}
+	}
+	if len(tagged.Entries) != 0 {
+		return tagged, nil
+	}
+	if *workloadFallback && len(untagged.Entries) != 0 {
+		return untagged, nil
+	}
+	return nil, fmt.Errorf("no available addresses for workload %v", workload)
+}
+
 func findAddrNode(addressNodes []*addressStatus, uid uint32) (index int) {
 	for i, addrNode := range addressNodes {
 		if uid == addrNode.endPoint.Uid {
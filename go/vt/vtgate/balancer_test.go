@@ -6,6 +6,7 @@ package vtgate
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 	"time"
 
@@ -49,7 +50,7 @@ func endPoints3() (*topo.EndPoints, error) {
 
 func TestRandomness(t *testing.T) {
 	for i := 0; i < 100; i++ {
-		b := NewBalancer(endPoints3, RETRY_DELAY)
+		b := NewBalancer(endPoints3, RETRY_DELAY, "")
 		endPoint, _ := b.Get()
 		// Ensure that you don't always get the first element
 		// in the balancer.
@@ -98,7 +99,7 @@ func endPointsNone() (*topo.EndPoints, error) {
 }
 
 func TestGetAddressesFail(t *testing.T) {
-	b := NewBalancer(endPointsError, RETRY_DELAY)
+	b := NewBalancer(endPointsError, RETRY_DELAY, "")
 	_, err := b.Get()
 	// Ensure that end point errors are returned correctly.
 	want := "expected error"
@@ -122,8 +123,55 @@ func TestGetAddressesFail(t *testing.T) {
 	}
 }
 
+func endPointsMixedWorkloads() (*topo.EndPoints, error) {
+	return &topo.EndPoints{
+		Entries: []topo.EndPoint{
+			{Uid: 0, Host: "0", NamedPortMap: map[string]int{"vt": 1}, Workload: "oltp"},
+			{Uid: 1, Host: "1", NamedPortMap: map[string]int{"vt": 2}, Workload: "olap"},
+			{Uid: 2, Host: "2", NamedPortMap: map[string]int{"vt": 3}},
+			{Uid: 3, Host: "3", NamedPortMap: map[string]int{"vt": 4}, Workload: "oltp"},
+		},
+	}, nil
+}
+
+func TestGetWorkload(t *testing.T) {
+	testCases := []struct {
+		workload string
+		want     map[uint32]bool
+	}{
+		{"oltp", map[uint32]bool{0: true, 3: true}},
+		{"olap", map[uint32]bool{1: true}},
+		// No tablet is tagged batch, fall back to the untagged one.
+		{"batch", map[uint32]bool{2: true}},
+		{"", map[uint32]bool{0: true, 1: true, 2: true, 3: true}},
+	}
+	for _, tc := range testCases {
+		b := NewBalancer(endPointsMixedWorkloads, RETRY_DELAY, tc.workload)
+		got := make(map[uint32]bool)
+		for i := 0; i < 20; i++ {
+			endPoint, err := b.Get()
+			if err != nil {
+				t.Fatalf("workload %q: %v", tc.workload, err)
+			}
+			got[endPoint.Uid] = true
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("workload %q: want %v, got %v", tc.workload, tc.want, got)
+		}
+	}
+
+	*workloadFallback = false
+	defer func() { *workloadFallback = true }()
+	b := NewBalancer(endPointsMixedWorkloads, RETRY_DELAY, "batch")
+	_, err := b.Get()
+	want := "no available addresses for workload batch"
+	if err == nil || err.Error() != want {
+		t.Errorf("want %s, got %v", want, err)
+	}
+}
+
 func TestGetSimple(t *testing.T) {
-	b := NewBalancer(endPoints3, RETRY_DELAY)
+	b := NewBalancer(endPoints3, RETRY_DELAY, "")
 	endPoints := make([]topo.EndPoint, 0, 4)
 	for i := 0; i < 4; i++ {
 		endPoint, _ := b.Get()
@@ -139,9 +187,96 @@ func TestGetSimple(t *testing.T) {
 	}
 }
 
+func TestGetHealth(t *testing.T) {
+	// Tablet 2 is half as healthy as the others.
+	b := NewBalancer(func() (*topo.EndPoints, error) {
+		endPoints, _ := endPoints3()
+		endPoints.Entries[1].Health = topo.MAX_HEALTH
+		endPoints.Entries[2].Health = topo.MAX_HEALTH / 2
+		return endPoints, nil
+	}, RETRY_DELAY, "")
+	counts := make(map[uint32]int)
+	for i := 0; i < 50; i++ {
+		endPoint, err := b.Get()
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		counts[endPoint.Uid]++
+	}
+	// Tablet 0 has no score, so it counts as healthy.
+	if counts[0] != 20 || counts[1] != 20 || counts[2] != 10 {
+		t.Errorf("want 20, 20 and 10 gets, got %v", counts)
+	}
+}
+
+func TestGetWeighted(t *testing.T) {
+	defer func() { *balancerStrategy = BALANCE_ROUND_ROBIN }()
+	*balancerStrategy = BALANCE_WEIGHTED
+	// Tablet 1 weighs twice the default, tablet 2 is half as
+	// healthy as the others.
+	b := NewBalancer(func() (*topo.EndPoints, error) {
+		endPoints, _ := endPoints3()
+		endPoints.Entries[1].Weight = 2 * topo.DEFAULT_WEIGHT
+		endPoints.Entries[2].Health = topo.MAX_HEALTH / 2
+		return endPoints, nil
+	}, RETRY_DELAY, "")
+	counts := make(map[uint32]int)
+	for i := 0; i < 70; i++ {
+		endPoint, err := b.Get()
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		counts[endPoint.Uid]++
+	}
+	if counts[0] != 20 || counts[1] != 40 || counts[2] != 10 {
+		t.Errorf("want 20, 40 and 10 gets, got %v", counts)
+	}
+}
+
+func TestGetLeastConnections(t *testing.T) {
+	defer func() { *balancerStrategy = BALANCE_ROUND_ROBIN }()
+	*balancerStrategy = BALANCE_LEAST_CONNECTIONS
+	// The request counts are global: use uids no other test uses.
+	b := NewBalancer(func() (*topo.EndPoints, error) {
+		endPoints, _ := endPoints3()
+		for i := range endPoints.Entries {
+			endPoints.Entries[i].Uid += 1000
+		}
+		return endPoints, nil
+	}, RETRY_DELAY, "")
+
+	// Each Get goes to the tablet with the fewest requests.
+	counts := make(map[uint32]int)
+	for i := 0; i < 6; i++ {
+		endPoint, err := b.Get()
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		counts[endPoint.Uid]++
+		tabletRequestStarted(endPoint.Uid)
+	}
+	if counts[1000] != 2 || counts[1001] != 2 || counts[1002] != 2 {
+		t.Errorf("want 2 gets each, got %v", counts)
+	}
+
+	// Tablet 1001 is done with its requests: it gets the next ones.
+	tabletRequestEnded(1001)
+	tabletRequestEnded(1001)
+	for i := 0; i < 2; i++ {
+		if endPoint, _ := b.Get(); endPoint.Uid != 1001 {
+			t.Errorf("want tablet 1001, got %v", endPoint.Uid)
+		}
+		tabletRequestStarted(1001)
+	}
+	for uid := uint32(1000); uid < 1003; uid++ {
+		tabletRequestEnded(uid)
+		tabletRequestEnded(uid)
+	}
+}
+
 func TestMarkDown(t *testing.T) {
 	start := counter
-	b := NewBalancer(endPoints3, 10*time.Millisecond)
+	b := NewBalancer(endPoints3, 10*time.Millisecond, "")
 	addr, _ := b.Get()
 	b.MarkDown(addr.Uid)
 	addr, _ = b.Get()
@@ -169,8 +304,152 @@ func TestMarkDown(t *testing.T) {
 	}
 }
 
+func TestHasEndPoints(t *testing.T) {
+	b := NewBalancer(endPoints3, 10*time.Millisecond, "")
+	if !b.HasEndPoints() {
+		t.Errorf("want true, got false")
+	}
+	b.MarkDown(0)
+	b.MarkDown(1)
+	if !b.HasEndPoints() {
+		t.Errorf("one marked up: want true, got false")
+	}
+	b.MarkDown(2)
+	if b.HasEndPoints() {
+		t.Errorf("all marked down: want false, got true")
+	}
+	time.Sleep(10 * time.Millisecond)
+	if !b.HasEndPoints() {
+		t.Errorf("after the retry delay: want true, got false")
+	}
+}
+
 var addrNum uint32 = 10
 
+func TestFailureThreshold(t *testing.T) {
+	defer func() { *tabletFailureThreshold = 1 }()
+	*tabletFailureThreshold = 3
+	b := NewBalancer(endPoints3, time.Hour, "")
+	b.SetSelectFunc(PickUid(1))
+
+	b.Get()
+	// Two failures, or a success in between, don't mark it down.
+	b.MarkDown(1)
+	b.MarkDown(1)
+	b.MarkUp(1)
+	b.MarkDown(1)
+	b.MarkDown(1)
+	if addr, _ := b.Get(); addr.Uid != 1 {
+		t.Errorf("want 1, got %v", addr.Uid)
+	}
+	b.MarkDown(1)
+	if addr, _ := b.Get(); addr.Uid == 1 {
+		t.Errorf("want another tablet than 1, got it")
+	}
+}
+
+func TestSkipUnhealthy(t *testing.T) {
+	defer func() { *tabletMinHealth, *tabletRecoveryWindow = 0, 0 }()
+	*tabletMinHealth = topo.MAX_HEALTH / 2
+	*tabletRecoveryWindow = 10 * time.Millisecond
+	health := topo.MAX_HEALTH / 4
+	b := NewBalancer(func() (*topo.EndPoints, error) {
+		endPoints, _ := endPoints3()
+		endPoints.Entries[2].Health = health
+		return endPoints, nil
+	}, RETRY_DELAY, "")
+	for i := 0; i < 10; i++ {
+		if addr, _ := b.Get(); addr.Uid == 2 {
+			t.Fatalf("want a healthy tablet, got 2")
+		}
+	}
+
+	// It's back once a refresh shows it recovered.
+	health = topo.MAX_HEALTH
+	time.Sleep(20 * time.Millisecond)
+	counts := make(map[uint32]int)
+	for i := 0; i < 3; i++ {
+		addr, _ := b.Get()
+		counts[addr.Uid]++
+	}
+	if counts[2] != 1 {
+		t.Errorf("want 1 get of tablet 2, got %v", counts)
+	}
+
+	// If no tablet is healthy, they're all used.
+	*tabletMinHealth = topo.MAX_HEALTH + 1
+	counts = make(map[uint32]int)
+	for i := 0; i < 3; i++ {
+		addr, _ := b.Get()
+		counts[addr.Uid]++
+	}
+	if len(counts) != 3 {
+		t.Errorf("want the 3 tablets, got %v", counts)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	defer func() { *tabletBreakerCooldown = 0 }()
+	*tabletBreakerCooldown = 10 * time.Millisecond
+	b := NewBalancer(endPoints3, RETRY_DELAY, "")
+	trips := breakerTrips.Counts()["1"]
+	for i := 0; i < 3; i++ {
+		addr, _ := b.Get()
+		b.MarkDown(addr.Uid)
+	}
+	// All the circuits are open: fail fast.
+	startTime := time.Now()
+	if _, err := b.Get(); err != ErrCircuitOpen {
+		t.Errorf("want %v, got %v", ErrCircuitOpen, err)
+	}
+	if d := time.Now().Sub(startTime); d > 5*time.Millisecond {
+		t.Errorf("want a fast failure, got %v", d)
+	}
+	if got := breakerTrips.Counts()["1"]; got != trips+1 {
+		t.Errorf("want %v trips, got %v", trips+1, got)
+	}
+
+	// After the cooldown, each tablet gets a single probe.
+	time.Sleep(20 * time.Millisecond)
+	probes := make(map[uint32]bool)
+	for i := 0; i < 3; i++ {
+		addr, err := b.Get()
+		if err != nil {
+			t.Fatalf("want a probe, got %v", err)
+		}
+		probes[addr.Uid] = true
+	}
+	if len(probes) != 3 {
+		t.Errorf("want the 3 tablets probed, got %v", probes)
+	}
+	if _, err := b.Get(); err != ErrCircuitOpen {
+		t.Errorf("want %v, got %v", ErrCircuitOpen, err)
+	}
+	tabletBreakersMu.Lock()
+	state := tabletBreakers[1]
+	tabletBreakersMu.Unlock()
+	if state != breakerHalfOpen {
+		t.Errorf("want half open, got %v", state)
+	}
+
+	// A failed probe opens the circuit again, a successful one closes it.
+	b.MarkDown(0)
+	b.MarkDown(2)
+	b.MarkUp(1)
+	for i := 0; i < 3; i++ {
+		if addr, _ := b.Get(); addr.Uid != 1 {
+			t.Errorf("want 1, got %v", addr.Uid)
+		}
+	}
+	tabletBreakersMu.Lock()
+	_, ok := tabletBreakers[1]
+	state = tabletBreakers[0]
+	tabletBreakersMu.Unlock()
+	if ok || state != breakerOpen {
+		t.Errorf("want 1 closed and 0 open, got %v, %v", ok, state)
+	}
+}
+
 func endPointsMorph() (*topo.EndPoints, error) {
 	addrNum++
 	return &topo.EndPoints{
@@ -201,7 +480,7 @@ func endPointsMorph() (*topo.EndPoints, error) {
 }
 
 func TestRefresh(t *testing.T) {
-	b := NewBalancer(endPointsMorph, RETRY_DELAY)
+	b := NewBalancer(endPointsMorph, RETRY_DELAY, "")
 	b.refresh()
 	index := findAddrNode(b.addressNodes, 11)
 	// "11" should be found in the list.
@@ -235,3 +514,65 @@ func TestRefresh(t *testing.T) {
 		t.Errorf("want 12, got %v", port_new)
 	}
 }
+
+func TestSelectFunc(t *testing.T) {
+	SetSelectFunc(PickUid(1))
+	defer SetSelectFunc(nil)
+	b := NewBalancer(endPoints3, RETRY_DELAY, "")
+	for i := 0; i < 10; i++ {
+		endPoint, err := b.Get()
+		if err != nil {
+			t.Fatalf("want nil, got %v", err)
+		}
+		if endPoint.Uid != 1 {
+			t.Errorf("want 1, got %v", endPoint.Uid)
+		}
+	}
+
+	// Round-robin is used if the picked end point is down.
+	b.MarkDown(1)
+	for i := 0; i < 10; i++ {
+		endPoint, err := b.Get()
+		if err != nil {
+			t.Fatalf("want nil, got %v", err)
+		}
+		if endPoint.Uid == 1 {
+			t.Errorf("want other than 1, got %v", endPoint.Uid)
+		}
+	}
+}
+
+func TestGetAffinity(t *testing.T) {
+	b := NewBalancer(endPoints3, RETRY_DELAY, "")
+	uids := make(map[string]uint32)
+	used := make(map[uint32]bool)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%v", i)
+		endPoint, err := b.GetAffinity(key)
+		if err != nil {
+			t.Fatalf("want nil, got %v", err)
+		}
+		uids[key] = endPoint.Uid
+		used[endPoint.Uid] = true
+	}
+	if len(used) < 2 {
+		t.Errorf("want keys spread over the end points, got %v", used)
+	}
+	for key, uid := range uids {
+		if endPoint, _ := b.GetAffinity(key); endPoint.Uid != uid {
+			t.Errorf("key %v: want %v, got %v", key, uid, endPoint.Uid)
+		}
+	}
+
+	// Only the keys of a marked down end point move.
+	b.MarkDown(1)
+	for key, uid := range uids {
+		endPoint, _ := b.GetAffinity(key)
+		if uid == 1 && endPoint.Uid == 1 {
+			t.Errorf("key %v: want other than 1, got 1", key)
+		}
+		if uid != 1 && endPoint.Uid != uid {
+			t.Errorf("key %v: want %v, got %v", key, uid, endPoint.Uid)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"sort"
+)
+
+// validateBindVars returns an error naming the first bind variable,
+// in name order, whose value is not of a type vtgate can send to the
+// tablets: ints, floats, strings, []byte or nil. The tablets would
+// reject the others with a much less helpful error.
+func validateBindVars(bindVars map[string]interface{}) error {
+	names := make([]string, 0, len(bindVars))
+	for name := range bindVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch v := bindVars[name]; v.(type) {
+		case nil, string, []byte,
+			int, int8, int16, int32, int64,
+			uint, uint8, uint16, uint32, uint64,
+			float32, float64:
+		default:
+			return fmt.Errorf("bind variable %v has an unsupported type: %T", name, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateBindVars(t *testing.T) {
+	valid := map[string]interface{}{
+		"nil":    nil,
+		"int":    1,
+		"int64":  int64(1),
+		"uint64": uint64(1),
+		"float":  1.5,
+		"string": "a",
+		"bytes":  []byte("a"),
+	}
+	if err := validateBindVars(valid); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if err := validateBindVars(nil); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+
+	invalid := map[string]interface{}{
+		"a": 1,
+		"b": true,
+		"c": time.Now(),
+	}
+	want := "bind variable b has an unsupported type: bool"
+	if err := validateBindVars(invalid); err == nil || err.Error() != want {
+		t.Errorf("want %v, got %v", want, err)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// ErrDraining is returned to the new sessions of a draining vtgate,
+// see VTGate.Drain. They can be retried on another vtgate.
+var ErrDraining = errors.New("vtgate is draining")
+
+// drainPollInterval is how often Drain checks for the queries and
+// transactions still open.
+var drainPollInterval = 10 * time.Millisecond
+
+// Drain prepares vtgate to stop. It refuses the new sessions, that is
+// the queries and Begins outside of a transaction, with ErrDraining.
+// It then waits for the queries in flight and the open transactions
+// to complete, for timeout at most, and rolls back the transactions
+// still open. It returns how many it rolled back. The sessions in a
+// transaction can still run queries until then.
+func (vtg *VTGate) Drain(timeout time.Duration) int {
+	vtg.mu.Lock()
+	vtg.draining = true
+	vtg.mu.Unlock()
+	log.Infof("VTGate draining, for %v at most", timeout)
+
+	deadline := time.Now().Add(timeout)
+	for inflightQueries.Get() > 0 || vtg.currentScatterConn().openTransactions() > 0 {
+		if !time.Now().Before(deadline) {
+			break
+		}
+		time.Sleep(drainPollInterval)
+	}
+	rolledBack := vtg.currentScatterConn().rollbackAll("vtgate is draining")
+	log.Infof("VTGate drained, %v transactions rolled back", rolledBack)
+	return rolledBack
+}
+
+// currentScatterConn returns the ScatterConn in use: ReloadTopo
+// can replace it while vtgate drains.
+func (vtg *VTGate) currentScatterConn() *ScatterConn {
+	vtg.mu.Lock()
+	defer vtg.mu.Unlock()
+	return vtg.scatterConn
+}
+
+// checkDraining returns ErrDraining if vtgate is draining and
+// session has no transaction open here. What the client says
+// about its session isn't trusted: only the transactions vtgate
+// began for it count, so a session that called Begin but ran
+// no query yet is refused too.
+func (vtg *VTGate) checkDraining(session *proto.Session) error {
+	vtg.mu.Lock()
+	draining := vtg.draining
+	stc := vtg.scatterConn
+	vtg.mu.Unlock()
+	if draining && !stc.hasTransaction(session) {
+		return ErrDraining
+	}
+	return nil
+}
+
+// getShardConns returns the ShardConns created so far.
+func (stc *ScatterConn) getShardConns() []*ShardConn {
+	stc.mu.Lock()
+	defer stc.mu.Unlock()
+	shardConns := make([]*ShardConn, 0, len(stc.shardConns))
+	for _, sdc := range stc.shardConns {
+		shardConns = append(shardConns, sdc)
+	}
+	return shardConns
+}
+
+// openTransactions returns the number of transactions begun
+// and not yet concluded.
+func (stc *ScatterConn) openTransactions() int {
+	count := 0
+	for _, sdc := range stc.getShardConns() {
+		count += sdc.OpenTransactions()
+	}
+	return count
+}
+
+// hasTransaction returns true if one of the transactions of session
+// was begun here and isn't concluded yet.
+func (stc *ScatterConn) hasTransaction(session *proto.Session) bool {
+	if session == nil || !session.InTransaction {
+		return false
+	}
+	for _, shardSession := range session.ShardSessions {
+		stc.mu.Lock()
+		key := fmt.Sprintf("%s.%s.%s.%s", shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, session.Workload)
+		sdc := stc.shardConns[key]
+		stc.mu.Unlock()
+		if sdc != nil && sdc.hasTransaction(shardSession.TransactionId) {
+			return true
+		}
+	}
+	return false
+}
+
+// rollbackAll rolls back the open transactions, and returns
+// how many there were. why is logged.
+func (stc *ScatterConn) rollbackAll(why string) int {
+	count := 0
+	for _, sdc := range stc.getShardConns() {
+		count += sdc.RollbackAll(why)
+	}
+	return count
+}
@@ -0,0 +1,78 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+func TestVTGateDrain(t *testing.T) {
+	defer func() {
+		RpcVTGate.mu.Lock()
+		RpcVTGate.draining = false
+		RpcVTGate.mu.Unlock()
+	}()
+	resetSandbox()
+	sbc := &sandboxConn{}
+	mapTestConn("A0-C0", sbc)
+	query := func(session *proto.Session) (*proto.QueryResult, error) {
+		q := proto.QueryShard{
+			Sql:     "query1",
+			Shards:  []string{"A0-C0"},
+			Session: session,
+		}
+		qr := new(proto.QueryResult)
+		return qr, RpcVTGate.ExecuteShard(nil, &q, qr)
+	}
+	session := new(proto.Session)
+	RpcVTGate.Begin(nil, session)
+	qr, err := query(session)
+	if err != nil || qr.Error != "" {
+		t.Fatalf("want nil, got %v, %v", err, qr.Error)
+	}
+	session = qr.Session
+
+	done := make(chan int)
+	go func() {
+		done <- RpcVTGate.Drain(50 * time.Millisecond)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// New sessions are refused, the transaction goes on.
+	if _, err := query(nil); err != ErrDraining {
+		t.Errorf("want %v, got %v", ErrDraining, err)
+	}
+	if err := RpcVTGate.Begin(nil, new(proto.Session)); err != ErrDraining {
+		t.Errorf("want %v, got %v", ErrDraining, err)
+	}
+	if qr, err := query(session); err != nil || qr.Error != "" {
+		t.Errorf("want nil, got %v, %v", err, qr.Error)
+	}
+
+	// The client can't claim a transaction vtgate doesn't have.
+	forged := &proto.Session{InTransaction: true, ShardSessions: []*proto.ShardSession{{
+		Keyspace:      session.ShardSessions[0].Keyspace,
+		Shard:         session.ShardSessions[0].Shard,
+		TabletType:    session.ShardSessions[0].TabletType,
+		TransactionId: session.ShardSessions[0].TransactionId + 1,
+	}}}
+	if _, err := query(forged); err != ErrDraining {
+		t.Errorf("want %v, got %v", ErrDraining, err)
+	}
+	if _, err := query(&proto.Session{InTransaction: true}); err != ErrDraining {
+		t.Errorf("want %v, got %v", ErrDraining, err)
+	}
+
+	// It isn't concluded in time, so it's rolled back.
+	if got := <-done; got != 1 {
+		t.Errorf("want 1 transaction rolled back, got %v", got)
+	}
+	if sbc.RollbackCount.Get() != 1 {
+		t.Errorf("want 1 rollback, got %v", sbc.RollbackCount.Get())
+	}
+}
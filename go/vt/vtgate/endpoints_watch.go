@@ -0,0 +1,118 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"errors"
+	"flag"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+var (
+	watchEndPoints          = flag.Bool("watch_endpoints", false, "watch the serving graph, so the balancers see the end points that are added or removed right away, if the topo server supports it (one watch per keyspace, shard and tablet type in use)")
+	endPointsPollMaxBackoff = flag.Duration("endpoints_poll_max_backoff", time.Minute, "when the watch of end points fails, they're polled instead, backing off up to this interval between two polls")
+)
+
+// minWatchBackoff is the first interval between two polls of the end
+// points when their watch fails, if the retry delay is shorter.
+const minWatchBackoff = 100 * time.Millisecond
+
+// ErrWatchNotSupported is returned by WatchEndPoints when the topo
+// server cannot watch the end points.
+var ErrWatchNotSupported = topo.ErrWatchNotSupported
+
+// errWatchStopped is logged when the channel of a watch is closed.
+var errWatchStopped = errors.New("watch stopped")
+
+// EndPointsWatcher is implemented by the SrvTopoServers that can
+// notify of the changes of the end points.
+type EndPointsWatcher interface {
+	// WatchEndPoints sends the current end points on the returned
+	// channel, then the new ones every time they change. The channel
+	// is closed when the watch fails, or after stop is closed.
+	WatchEndPoints(cell, keyspace, shard string, tabletType topo.TabletType, stop <-chan struct{}) (<-chan *topo.EndPoints, error)
+}
+
+// WatchEndPointsFunc starts a watch of the end points of a Balancer,
+// see EndPointsWatcher.
+type WatchEndPointsFunc func(stop <-chan struct{}) (<-chan *topo.EndPoints, error)
+
+// Watch makes the Balancer apply the end points watch sends as soon
+// as they change, instead of when a node is marked down. When the
+// watch fails, the Balancer polls its end points instead, from
+// retryDelay apart up to -endpoints_poll_max_backoff, and watches them
+// again after each poll. If watch returns ErrWatchNotSupported, the
+// Balancer only refreshes its end points as it did before.
+// Close stops the watch.
+func (blc *Balancer) Watch(watch WatchEndPointsFunc) {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	if blc.watchStop != nil {
+		return
+	}
+	blc.watchStop = make(chan struct{})
+	go blc.watchLoop(watch, blc.watchStop)
+}
+
+// Close stops the watch of the end points, if any.
+func (blc *Balancer) Close() {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	if blc.watchStop != nil {
+		close(blc.watchStop)
+		blc.watchStop = nil
+	}
+}
+
+func (blc *Balancer) watchLoop(watch WatchEndPointsFunc, stop chan struct{}) {
+	backoff := blc.retryDelay
+	if backoff < minWatchBackoff {
+		backoff = minWatchBackoff
+	}
+	delay := backoff
+	for {
+		updates, err := watch(stop)
+		if err == ErrWatchNotSupported {
+			return
+		}
+		if err == nil {
+			for endPoints := range updates {
+				delay = backoff
+				blc.mu.Lock()
+				blc.lastRefresh = time.Now()
+				if err := blc.update(endPoints); err != nil {
+					log.Warningf("watched end points: %v", err)
+				}
+				blc.mu.Unlock()
+				if blc.onUpdate != nil {
+					blc.onUpdate()
+				}
+			}
+			err = errWatchStopped
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		log.Warningf("end points watch failed (%v), polling them in %v", err, delay)
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+		blc.mu.Lock()
+		if err := blc.refresh(); err != nil {
+			log.Warningf("polled end points: %v", err)
+		}
+		blc.mu.Unlock()
+		if delay *= 2; delay > *endPointsPollMaxBackoff {
+			delay = *endPointsPollMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+func TestBalancerWatch(t *testing.T) {
+	b := NewBalancer(endPoints3, RETRY_DELAY, "")
+	defer b.Close()
+	updates := make(chan *topo.EndPoints)
+	b.Watch(func(stop <-chan struct{}) (<-chan *topo.EndPoints, error) {
+		return updates, nil
+	})
+	if _, err := b.Get(); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+
+	// A new end point is used right away, and the ones gone are dropped.
+	updates <- &topo.EndPoints{Entries: []topo.EndPoint{{Uid: 3, Host: "3"}}}
+	endPoint, err := b.Get()
+	if err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if endPoint.Uid != 3 {
+		t.Errorf("want uid 3, got %v", endPoint.Uid)
+	}
+}
+
+func TestBalancerWatchFailure(t *testing.T) {
+	defer func(d time.Duration) { *endPointsPollMaxBackoff = d }(*endPointsPollMaxBackoff)
+	*endPointsPollMaxBackoff = minWatchBackoff
+	polls := make(chan struct{}, 10)
+	b := NewBalancer(func() (*topo.EndPoints, error) {
+		select {
+		case polls <- struct{}{}:
+		default:
+		}
+		return endPoints3()
+	}, 0, "")
+	defer b.Close()
+	watches := make(chan struct{}, 10)
+	b.Watch(func(stop <-chan struct{}) (<-chan *topo.EndPoints, error) {
+		select {
+		case watches <- struct{}{}:
+		default:
+		}
+		return nil, fmt.Errorf("watch error")
+	})
+
+	// The Balancer polls, then watches again.
+	for _, c := range []chan struct{}{watches, polls, watches} {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Fatalf("the end points were not polled and watched again")
+		}
+	}
+}
+
+func TestBalancerWatchNotSupported(t *testing.T) {
+	b := NewBalancer(endPoints3, RETRY_DELAY, "")
+	defer b.Close()
+	done := make(chan struct{})
+	b.Watch(func(stop <-chan struct{}) (<-chan *topo.EndPoints, error) {
+		close(done)
+		return nil, ErrWatchNotSupported
+	})
+	<-done
+	if _, err := (&ResilientSrvTopoServer{topoServer: new(sandboxTopo)}).WatchEndPoints("aa", "ks", "0", topo.TYPE_REPLICA, nil); err != ErrWatchNotSupported {
+		t.Errorf("want ErrWatchNotSupported, got %v", err)
+	}
+}
@@ -49,6 +49,30 @@ func (vtg *VTGate) Rollback(context *rpcproto.Context, inSession *proto.Session,
 	return vtg.server.Rollback(context, inSession)
 }
 
+func (vtg *VTGate) Ping(context *rpcproto.Context, inSession *proto.Session, noOutput *rpc.UnusedResponse) error {
+	return vtg.server.Ping(context, inSession)
+}
+
+func (vtg *VTGate) Savepoint(context *rpcproto.Context, request *proto.SavepointRequest, outSession *proto.Session) error {
+	return vtg.server.Savepoint(context, request, outSession)
+}
+
+func (vtg *VTGate) ReleaseSavepoint(context *rpcproto.Context, request *proto.SavepointRequest, outSession *proto.Session) error {
+	return vtg.server.ReleaseSavepoint(context, request, outSession)
+}
+
+func (vtg *VTGate) RollbackToSavepoint(context *rpcproto.Context, request *proto.SavepointRequest, outSession *proto.Session) error {
+	return vtg.server.RollbackToSavepoint(context, request, outSession)
+}
+
+func (vtg *VTGate) UpgradeSession(context *rpcproto.Context, inSession *proto.Session, outSession *proto.Session) error {
+	return vtg.server.UpgradeSession(context, inSession, outSession)
+}
+
+func (vtg *VTGate) DowngradeSession(context *rpcproto.Context, inSession *proto.Session, outSession *proto.Session) error {
+	return vtg.server.DowngradeSession(context, inSession, outSession)
+}
+
 func init() {
 	vtgate.RegisterVTGates = append(vtgate.RegisterVTGates, func(vtGate *vtgate.VTGate) {
 		rpcwrap.RegisterAuthenticated(&VTGate{vtGate})
@@ -0,0 +1,119 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+var keyspaceRetryInterval = flag.Duration("keyspace_retry_interval", 30*time.Second, "how often to retry loading the keyspaces whose serving graph couldn't be read")
+
+// keyspaceAvailability tracks the keyspaces whose serving graph
+// couldn't be loaded. Queries to these keyspaces fail until a reload
+// succeeds, while the other keyspaces are served.
+type keyspaceAvailability struct {
+	mu          sync.Mutex
+	loaded      map[string]bool
+	unavailable map[string]error
+	// namesErr is set if the list of keyspaces couldn't be read.
+	namesErr error
+	retrying bool
+}
+
+func newKeyspaceAvailability() *keyspaceAvailability {
+	return &keyspaceAvailability{
+		loaded:      make(map[string]bool),
+		unavailable: make(map[string]error),
+	}
+}
+
+// load reads the serving graph of all the keyspaces of cell.
+// It returns true if they were all loaded.
+func (ka *keyspaceAvailability) load(serv SrvTopoServer, cell string) bool {
+	names, err := serv.GetSrvKeyspaceNames(cell)
+	ka.mu.Lock()
+	ka.namesErr = err
+	ka.mu.Unlock()
+	if err != nil {
+		log.Errorf("cannot read the keyspace names of cell %v: %v", cell, err)
+		return false
+	}
+
+	ok := true
+	for _, keyspace := range names {
+		_, err := serv.GetSrvKeyspace(cell, keyspace)
+		ka.mu.Lock()
+		if err != nil {
+			log.Errorf("keyspace %v is unavailable: %v", keyspace, err)
+			ka.unavailable[keyspace] = err
+			delete(ka.loaded, keyspace)
+			ok = false
+		} else {
+			if _, wasUnavailable := ka.unavailable[keyspace]; wasUnavailable {
+				log.Infof("keyspace %v is available", keyspace)
+			}
+			delete(ka.unavailable, keyspace)
+			ka.loaded[keyspace] = true
+		}
+		ka.mu.Unlock()
+	}
+	return ok
+}
+
+// check returns an error if keyspace couldn't be loaded.
+func (ka *keyspaceAvailability) check(keyspace string) error {
+	ka.mu.Lock()
+	defer ka.mu.Unlock()
+	if err, ok := ka.unavailable[keyspace]; ok {
+		return fmt.Errorf("keyspace %v is unavailable: %v", keyspace, err)
+	}
+	return nil
+}
+
+// startRetrying calls load every -keyspace_retry_interval, with the
+// SrvTopoServer returned by serv, until it succeeds. Only one
+// such loop runs at a time.
+func (ka *keyspaceAvailability) startRetrying(serv func() SrvTopoServer, cell string) {
+	ka.mu.Lock()
+	defer ka.mu.Unlock()
+	if ka.retrying {
+		return
+	}
+	ka.retrying = true
+	go func() {
+		for {
+			time.Sleep(*keyspaceRetryInterval)
+			if ka.load(serv(), cell) {
+				break
+			}
+		}
+		ka.mu.Lock()
+		ka.retrying = false
+		ka.mu.Unlock()
+	}()
+}
+
+// Status returns the availability of each keyspace: "available",
+// or the reason why it isn't.
+func (ka *keyspaceAvailability) Status() map[string]string {
+	ka.mu.Lock()
+	defer ka.mu.Unlock()
+	result := make(map[string]string, len(ka.loaded)+len(ka.unavailable))
+	for keyspace := range ka.loaded {
+		result[keyspace] = "available"
+	}
+	for keyspace, err := range ka.unavailable {
+		result[keyspace] = fmt.Sprintf("unavailable: %v", err)
+	}
+	if ka.namesErr != nil {
+		result["*"] = fmt.Sprintf("cannot list keyspaces: %v", ka.namesErr)
+	}
+	return result
+}
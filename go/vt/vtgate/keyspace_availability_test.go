@@ -0,0 +1,84 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// This file uses the sandbox_test framework.
+
+// badKeyspaceTopo is a sandboxTopo that can't read the
+// serving graph of the "bad" keyspace until fixed is set.
+type badKeyspaceTopo struct {
+	sandboxTopo
+	mu    sync.Mutex
+	fixed bool
+}
+
+func (bkt *badKeyspaceTopo) GetSrvKeyspaceNames(cell string) ([]string, error) {
+	return []string{"good", "bad"}, nil
+}
+
+func (bkt *badKeyspaceTopo) GetSrvKeyspace(cell, keyspace string) (*topo.SrvKeyspace, error) {
+	bkt.mu.Lock()
+	defer bkt.mu.Unlock()
+	if keyspace == "bad" && !bkt.fixed {
+		return nil, fmt.Errorf("topo error")
+	}
+	return bkt.sandboxTopo.GetSrvKeyspace(cell, keyspace)
+}
+
+func TestVTGatePartialKeyspaces(t *testing.T) {
+	defer func(interval time.Duration) { *keyspaceRetryInterval = interval }(*keyspaceRetryInterval)
+	*keyspaceRetryInterval = 10 * time.Millisecond
+	resetSandbox()
+	testConns[0] = &sandboxConn{}
+	serv := &badKeyspaceTopo{}
+	vtg := newVTGate(serv, "aa", 1*time.Second, 10, 1*time.Second)
+
+	execute := func(keyspace string) string {
+		q := proto.QueryShard{
+			Sql:      "query",
+			Keyspace: keyspace,
+			Shards:   []string{"0"},
+		}
+		reply := new(proto.QueryResult)
+		vtg.ExecuteShard(nil, &q, reply)
+		return reply.Error
+	}
+	if err := execute("good"); err != "" {
+		t.Errorf("want no error, got %v", err)
+	}
+	want := "keyspace bad is unavailable: topo error"
+	if err := execute("bad"); err != want {
+		t.Errorf("want %v, got %v", want, err)
+	}
+	status := vtg.KeyspaceAvailability()
+	if status["good"] != "available" || !strings.HasPrefix(status["bad"], "unavailable") {
+		t.Errorf("unexpected status: %v", status)
+	}
+
+	// The bad keyspace is retried in the background.
+	serv.mu.Lock()
+	serv.fixed = true
+	serv.mu.Unlock()
+	for i := 0; vtg.KeyspaceAvailability()["bad"] != "available"; i++ {
+		if i == 100 {
+			t.Fatalf("keyspace bad still unavailable: %v", vtg.KeyspaceAvailability())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := execute("bad"); err != "" {
+		t.Errorf("want no error, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+var migrationRejects = stats.NewCounters("VtgateMigrationRejects")
+
+// checkMigratingTables returns a retryable error if one of the queries
+// uses a table of keyspace that is going through a schema change (see
+// topo.Keyspace.MigratingTables), so clients back off until all the
+// shards have the new schema. The list comes from the SrvKeyspace,
+// so the queries go through again once the change is done and the
+// serving graph rebuilt, or once the list expires. The tables of the
+// queries come from sqlparser; for the queries it can't parse, they
+// are matched against all the identifiers, which errs on the side of
+// rejecting.
+func checkMigratingTables(stc *ScatterConn, keyspace string, queries ...string) error {
+	srvKeyspace, err := stc.toposerv.GetSrvKeyspace(stc.cell, keyspace)
+	if err != nil || len(srvKeyspace.MigratingTables) == 0 {
+		// Topology errors are reported by the query itself.
+		return nil
+	}
+	if srvKeyspace.MigratingTablesExpireTime != 0 && time.Now().UnixNano() > srvKeyspace.MigratingTablesExpireTime {
+		return nil
+	}
+	migrating := make(map[string]bool, len(srvKeyspace.MigratingTables))
+	for _, table := range srvKeyspace.MigratingTables {
+		migrating[strings.ToLower(table)] = true
+	}
+	for _, query := range queries {
+		tables, err := sqlparser.GetTableNames(query)
+		if err != nil {
+			tables = queryIdentifiers(query)
+		}
+		for _, table := range tables {
+			if table := strings.ToLower(table); migrating[table] {
+				migrationRejects.Add(keyspace, 1)
+				return fmt.Errorf("retry: table %v of keyspace %v is going through a schema change, try again later", table, keyspace)
+			}
+		}
+	}
+	return nil
+}
+
+// queryIdentifiers returns all the identifiers of query.
+func queryIdentifiers(query string) []string {
+	var identifiers []string
+	tokenizer := sqlparser.NewStringTokenizer(query)
+	for {
+		node := tokenizer.Scan()
+		if node.Type == 0 || node.Type == sqlparser.LEX_ERROR {
+			return identifiers
+		}
+		if node.Type == sqlparser.ID {
+			identifiers = append(identifiers, string(node.Value))
+		}
+	}
+}
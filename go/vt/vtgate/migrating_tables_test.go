@@ -0,0 +1,139 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// This file uses the sandbox_test framework.
+
+// migratingTopo is a sandboxTopo whose keyspaces have
+// the migrating tables in tables.
+type migratingTopo struct {
+	sandboxTopo
+	mu         sync.Mutex
+	tables     []string
+	expireTime int64
+}
+
+func (mt *migratingTopo) setTables(tables ...string) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.tables = tables
+	mt.expireTime = 0
+}
+
+func (mt *migratingTopo) setExpireTime(expireTime time.Time) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.expireTime = expireTime.UnixNano()
+}
+
+func (mt *migratingTopo) GetSrvKeyspace(cell, keyspace string) (*topo.SrvKeyspace, error) {
+	srvKeyspace, err := mt.sandboxTopo.GetSrvKeyspace(cell, keyspace)
+	if err != nil {
+		return nil, err
+	}
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	srvKeyspace.MigratingTables = mt.tables
+	srvKeyspace.MigratingTablesExpireTime = mt.expireTime
+	return srvKeyspace, nil
+}
+
+func TestVTGateMigratingTables(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	serv := &migratingTopo{}
+	vtg := newVTGate(serv, "aa", 1*time.Second, 10, 1*time.Second)
+
+	execute := func(sql string) string {
+		q := proto.QueryShard{
+			Sql:      sql,
+			Keyspace: "ks",
+			Shards:   []string{"0"},
+		}
+		reply := new(proto.QueryResult)
+		vtg.ExecuteShard(nil, &q, reply)
+		return reply.Error
+	}
+
+	if err := execute("select * from t1"); err != "" {
+		t.Errorf("want no error, got %v", err)
+	}
+
+	serv.setTables("t1")
+	for _, sql := range []string{
+		"select * from t1",
+		"select * from T1 where a = 1",
+		"update `t1` set a = 1",
+		"select * from t2 join t1 on t2.id = t1.id",
+	} {
+		if err := execute(sql); !strings.HasPrefix(err, "retry: table t1 of keyspace ks") {
+			t.Errorf("%v: want retry error, got %v", sql, err)
+		}
+	}
+	for _, sql := range []string{
+		"select * from t2",
+		"select t1 from t2 where t1 = 1",
+		"update t2 set t1 = 1",
+	} {
+		if err := execute(sql); err != "" {
+			t.Errorf("%v: want no error for another table, got %v", sql, err)
+		}
+	}
+	// the queries that don't parse are matched on all their identifiers
+	if err := execute("select t1 from t2 where"); !strings.HasPrefix(err, "retry:") {
+		t.Errorf("want retry error for an unparsable query, got %v", err)
+	}
+
+	bq := proto.BatchQueryShard{
+		Queries: []tproto.BoundQuery{
+			{Sql: "select * from t2"},
+			{Sql: "delete from t1"},
+		},
+		Keyspace: "ks",
+		Shards:   []string{"0"},
+	}
+	qrl := new(proto.QueryResultList)
+	vtg.ExecuteBatchShard(nil, &bq, qrl)
+	if !strings.HasPrefix(qrl.Error, "retry:") {
+		t.Errorf("want retry error for the batch, got %v", qrl.Error)
+	}
+
+	err := vtg.StreamExecuteShard(nil, &proto.QueryShard{Sql: "select * from t1", Keyspace: "ks", Shards: []string{"0"}}, func(*proto.QueryResult) error { return nil })
+	if err == nil || !strings.HasPrefix(err.Error(), "retry:") {
+		t.Errorf("want retry error for the stream, got %v", err)
+	}
+
+	// the list expired
+	serv.setExpireTime(time.Now().Add(-time.Second))
+	if err := execute("select * from t1"); err != "" {
+		t.Errorf("want no error once expired, got %v", err)
+	}
+	serv.setExpireTime(time.Now().Add(time.Hour))
+	if err := execute("select * from t1"); !strings.HasPrefix(err, "retry:") {
+		t.Errorf("want retry error before expiry, got %v", err)
+	}
+
+	// the migration is done
+	queries := len(sbc.Queries)
+	serv.setTables()
+	if err := execute("select * from t1"); err != "" {
+		t.Errorf("want no error, got %v", err)
+	}
+	if len(sbc.Queries) != queries+1 {
+		t.Errorf("want the query sent, got %v", sbc.Queries[queries:])
+	}
+}
@@ -22,6 +22,46 @@ import (
 type Session struct {
 	InTransaction bool
 	ShardSessions []*ShardSession
+	// LogQueries enables verbose logging of all the queries,
+	// commits and rollbacks executed with this session. Begin
+	// returns a new session, so clients have to set it again
+	// on the session they get back.
+	LogQueries bool
+	// Workload restricts the session to the tablets tagged with
+	// this workload (see topo.WORKLOAD_TAG), e.g. "olap" for
+	// analytics. An empty Workload can use any tablet.
+	Workload string
+	// MaxExecutionTimeHint makes vtgate add a MAX_EXECUTION_TIME
+	// hint, set to its query timeout, to the non-streaming selects
+	// of the session. MySQL then aborts the runaway ones by itself.
+	// Only set it if the tablets run MySQL 5.7.8 or later.
+	MaxExecutionTimeHint bool
+	// FallbackTabletTypes are the tablet types, in order of
+	// preference, that reads outside of transactions can use
+	// when a shard has no tablet of the requested type, e.g.
+	// replica then master for rdonly queries. Writes never
+	// fall back, and nothing after master is ever used.
+	FallbackTabletTypes []topo.TabletType
+	// Upgraded sends all the queries of the session to the masters,
+	// whatever tablet type they ask for, so a replica session can
+	// write (see VTGate.UpgradeSession).
+	Upgraded bool
+	// RetryWrites lets vtgate retry the DMLs of the session on
+	// connection errors, like it does the reads. The DML may then
+	// be applied twice, so only set it for idempotent writes.
+	RetryWrites bool
+	// Savepoints are the savepoints set in the transaction, in
+	// order (see VTGate.Savepoint).
+	Savepoints []string
+	// MaxStaleness bounds the replication lag, in nanoseconds, of
+	// the replicas the reads outside of transactions go to: only
+	// the tablets that report a lag within it are used. If a shard
+	// has none, the reads go to its master. 0 for no bound.
+	MaxStaleness int64
+	// TabletType is the tablet type of the queries of the session
+	// that don't set one. A query that sets one overrides it, e.g.
+	// for the occasional master read of a replica session.
+	TabletType topo.TabletType
 }
 
 // ShardSession represents the session state for a shard.
@@ -30,6 +70,9 @@ type ShardSession struct {
 	Shard         string
 	TabletType    topo.TabletType
 	TransactionId int64
+	// Savepoints are the savepoints of Session.Savepoints set on the
+	// shard: the ones set before it joined the transaction are missing.
+	Savepoints []string
 }
 
 // MarshalBson marshals Session into buf.
@@ -39,13 +82,22 @@ func (session *Session) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 
 	bson.EncodeBool(buf, "InTransaction", session.InTransaction)
 	encodeShardSessionsBson(session.ShardSessions, "ShardSessions", buf)
+	bson.EncodeBool(buf, "LogQueries", session.LogQueries)
+	bson.EncodeString(buf, "Workload", session.Workload)
+	bson.EncodeBool(buf, "MaxExecutionTimeHint", session.MaxExecutionTimeHint)
+	topo.EncodeTabletTypeArray(buf, "FallbackTabletTypes", session.FallbackTabletTypes)
+	bson.EncodeBool(buf, "Upgraded", session.Upgraded)
+	bson.EncodeBool(buf, "RetryWrites", session.RetryWrites)
+	bson.EncodeStringArray(buf, "Savepoints", session.Savepoints)
+	bson.EncodeInt64(buf, "MaxStaleness", session.MaxStaleness)
+	bson.EncodeString(buf, "TabletType", string(session.TabletType))
 
 	buf.WriteByte(0)
 	lenWriter.RecordLen()
 }
 
 func (session *Session) String() string {
-	return fmt.Sprintf("InTransaction: %v, ShardSession: %+v", session.InTransaction, session.ShardSessions)
+	return fmt.Sprintf("InTransaction: %v, ShardSession: %+v, LogQueries: %v, Workload: %v, MaxExecutionTimeHint: %v, FallbackTabletTypes: %v, Upgraded: %v, RetryWrites: %v, Savepoints: %v, MaxStaleness: %v, TabletType: %v", session.InTransaction, session.ShardSessions, session.LogQueries, session.Workload, session.MaxExecutionTimeHint, session.FallbackTabletTypes, session.Upgraded, session.RetryWrites, session.Savepoints, session.MaxStaleness, session.TabletType)
 }
 
 func encodeShardSessionsBson(shardSessions []*ShardSession, key string, buf *bytes2.ChunkedWriter) {
@@ -67,6 +119,7 @@ func (shardSession *ShardSession) MarshalBson(buf *bytes2.ChunkedWriter, key str
 	bson.EncodeString(buf, "Shard", shardSession.Shard)
 	bson.EncodeString(buf, "TabletType", string(shardSession.TabletType))
 	bson.EncodeInt64(buf, "TransactionId", shardSession.TransactionId)
+	bson.EncodeStringArray(buf, "Savepoints", shardSession.Savepoints)
 
 	buf.WriteByte(0)
 	lenWriter.RecordLen()
@@ -85,6 +138,24 @@ func (session *Session) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			session.InTransaction = bson.DecodeBool(buf, kind)
 		case "ShardSessions":
 			session.ShardSessions = decodeShardSessionsBson(buf, kind)
+		case "LogQueries":
+			session.LogQueries = bson.DecodeBool(buf, kind)
+		case "Workload":
+			session.Workload = bson.DecodeString(buf, kind)
+		case "MaxExecutionTimeHint":
+			session.MaxExecutionTimeHint = bson.DecodeBool(buf, kind)
+		case "FallbackTabletTypes":
+			session.FallbackTabletTypes = topo.DecodeTabletTypeArray(buf, kind)
+		case "Upgraded":
+			session.Upgraded = bson.DecodeBool(buf, kind)
+		case "RetryWrites":
+			session.RetryWrites = bson.DecodeBool(buf, kind)
+		case "Savepoints":
+			session.Savepoints = bson.DecodeStringArray(buf, kind)
+		case "MaxStaleness":
+			session.MaxStaleness = bson.DecodeInt64(buf, kind)
+		case "TabletType":
+			session.TabletType = topo.TabletType(bson.DecodeString(buf, kind))
 		default:
 			bson.Skip(buf, kind)
 		}
@@ -135,6 +206,8 @@ func (shardSession *ShardSession) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			shardSession.TabletType = topo.TabletType(bson.DecodeString(buf, kind))
 		case "TransactionId":
 			shardSession.TransactionId = bson.DecodeInt64(buf, kind)
+		case "Savepoints":
+			shardSession.Savepoints = bson.DecodeStringArray(buf, kind)
 		default:
 			bson.Skip(buf, kind)
 		}
@@ -149,8 +222,24 @@ type QueryShard struct {
 	BindVariables map[string]interface{}
 	Keyspace      string
 	Shards        []string
-	TabletType    topo.TabletType
-	Session       *Session
+	// TabletType, if set, overrides the TabletType of the session
+	// for this query.
+	TabletType topo.TabletType
+	// AllowScatterDML must be set for a DML to be sent to
+	// more than one shard (see vtgate's -scatter_dml_policy).
+	AllowScatterDML bool
+	// AffinityKey, if set, sends the query to the same tablet
+	// as the other queries with that key, outside of transactions.
+	AffinityKey string
+	// Explain is a debug option: instead of executing the select,
+	// return its MySQL plan on each shard (see ScatterConn.Explain).
+	Explain bool
+	// Quorum, if set, makes a select return as soon as that many
+	// shards answered, leaving out the slower ones (see
+	// ScatterConn.ExecuteQuorum). QueryResult.Shards lists the
+	// shards that made it.
+	Quorum  int
+	Session *Session
 }
 
 // MarshalBson marshals QueryShard into buf.
@@ -163,6 +252,10 @@ func (qrs *QueryShard) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 	bson.EncodeString(buf, "Keyspace", qrs.Keyspace)
 	bson.EncodeStringArray(buf, "Shards", qrs.Shards)
 	bson.EncodeString(buf, "TabletType", string(qrs.TabletType))
+	bson.EncodeBool(buf, "AllowScatterDML", qrs.AllowScatterDML)
+	bson.EncodeString(buf, "AffinityKey", qrs.AffinityKey)
+	bson.EncodeBool(buf, "Explain", qrs.Explain)
+	bson.EncodeInt(buf, "Quorum", qrs.Quorum)
 
 	if qrs.Session != nil {
 		qrs.Session.MarshalBson(buf, "Session")
@@ -191,6 +284,14 @@ func (qrs *QueryShard) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			qrs.TabletType = topo.TabletType(bson.DecodeString(buf, kind))
 		case "Shards":
 			qrs.Shards = bson.DecodeStringArray(buf, kind)
+		case "AllowScatterDML":
+			qrs.AllowScatterDML = bson.DecodeBool(buf, kind)
+		case "AffinityKey":
+			qrs.AffinityKey = bson.DecodeString(buf, kind)
+		case "Explain":
+			qrs.Explain = bson.DecodeBool(buf, kind)
+		case "Quorum":
+			qrs.Quorum = bson.DecodeInt(buf, kind)
 		case "Session":
 			if kind != bson.Null {
 				qrs.Session = new(Session)
@@ -211,6 +312,12 @@ type QueryResult struct {
 	Rows         [][]sqltypes.Value
 	Session      *Session
 	Error        string
+	// ConnectionId is the id of the MySQL connection that ran the
+	// query. It's only set in debug mode, and if it's known.
+	ConnectionId int64
+	// Shards lists the shards whose rows are in the result,
+	// for the queries that set a Quorum.
+	Shards []string
 }
 
 func PopulateQueryResult(in *mproto.QueryResult, out *QueryResult) {
@@ -238,6 +345,14 @@ func (qr *QueryResult) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 		bson.EncodeString(buf, "Error", qr.Error)
 	}
 
+	if qr.ConnectionId != 0 {
+		bson.EncodeInt64(buf, "ConnectionId", qr.ConnectionId)
+	}
+
+	if qr.Shards != nil {
+		bson.EncodeStringArray(buf, "Shards", qr.Shards)
+	}
+
 	buf.WriteByte(0)
 	lenWriter.RecordLen()
 }
@@ -266,6 +381,10 @@ func (qr *QueryResult) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			}
 		case "Error":
 			qr.Error = bson.DecodeString(buf, kind)
+		case "ConnectionId":
+			qr.ConnectionId = bson.DecodeInt64(buf, kind)
+		case "Shards":
+			qr.Shards = bson.DecodeStringArray(buf, kind)
 		default:
 			bson.Skip(buf, kind)
 		}
@@ -280,7 +399,16 @@ type BatchQueryShard struct {
 	Keyspace   string
 	Shards     []string
 	TabletType topo.TabletType
-	Session    *Session
+	// AllowScatterDML must be set for a batch containing DMLs
+	// to be sent to more than one shard.
+	AllowScatterDML bool
+	// ContinueOnError executes the queries one by one, in order,
+	// and keeps going after a failed one: its error goes to
+	// QueryResultList.Errors instead of failing the batch. Without
+	// it, the queries are sent together and the first error fails
+	// the batch.
+	ContinueOnError bool
+	Session         *Session
 }
 
 // MarshalBson marshals BatchQueryShard into buf.
@@ -292,6 +420,8 @@ func (bqs *BatchQueryShard) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 	bson.EncodeString(buf, "Keyspace", bqs.Keyspace)
 	bson.EncodeStringArray(buf, "Shards", bqs.Shards)
 	bson.EncodeString(buf, "TabletType", string(bqs.TabletType))
+	bson.EncodeBool(buf, "AllowScatterDML", bqs.AllowScatterDML)
+	bson.EncodeBool(buf, "ContinueOnError", bqs.ContinueOnError)
 
 	if bqs.Session != nil {
 		bqs.Session.MarshalBson(buf, "Session")
@@ -318,6 +448,10 @@ func (bqs *BatchQueryShard) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			bqs.Shards = bson.DecodeStringArray(buf, kind)
 		case "TabletType":
 			bqs.TabletType = topo.TabletType(bson.DecodeString(buf, kind))
+		case "AllowScatterDML":
+			bqs.AllowScatterDML = bson.DecodeBool(buf, kind)
+		case "ContinueOnError":
+			bqs.ContinueOnError = bson.DecodeBool(buf, kind)
 		case "Session":
 			if kind != bson.Null {
 				bqs.Session = new(Session)
@@ -335,6 +469,9 @@ type QueryResultList struct {
 	List    []mproto.QueryResult
 	Session *Session
 	Error   string
+	// Errors are the errors of the queries of a ContinueOnError
+	// batch, in order, "" for the ones that succeeded.
+	Errors []string
 }
 
 // MarshalBson marshals QueryResultList into buf.
@@ -352,6 +489,10 @@ func (qrl *QueryResultList) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 		bson.EncodeString(buf, "Error", qrl.Error)
 	}
 
+	if qrl.Errors != nil {
+		bson.EncodeStringArray(buf, "Errors", qrl.Errors)
+	}
+
 	buf.WriteByte(0)
 	lenWriter.RecordLen()
 }
@@ -374,6 +515,8 @@ func (qrl *QueryResultList) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 			}
 		case "Error":
 			qrl.Error = bson.DecodeString(buf, kind)
+		case "Errors":
+			qrl.Errors = bson.DecodeStringArray(buf, kind)
 		default:
 			bson.Skip(buf, kind)
 		}
@@ -437,3 +580,46 @@ func (sqs *StreamQueryKeyRange) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 		kind = bson.NextByte(buf)
 	}
 }
+
+// SavepointRequest is the request of the savepoint calls
+// (see VTGate.Savepoint).
+type SavepointRequest struct {
+	Name    string
+	Session *Session
+}
+
+func (req *SavepointRequest) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
+	bson.EncodeOptionalPrefix(buf, bson.Object, key)
+	lenWriter := bson.NewLenWriter(buf)
+
+	bson.EncodeString(buf, "Name", req.Name)
+
+	if req.Session != nil {
+		req.Session.MarshalBson(buf, "Session")
+	}
+
+	buf.WriteByte(0)
+	lenWriter.RecordLen()
+}
+
+func (req *SavepointRequest) UnmarshalBson(buf *bytes.Buffer, kind byte) {
+	bson.VerifyObject(kind)
+	bson.Next(buf, 4)
+
+	kind = bson.NextByte(buf)
+	for kind != bson.EOO {
+		keyName := bson.ReadCString(buf)
+		switch keyName {
+		case "Name":
+			req.Name = bson.DecodeString(buf, kind)
+		case "Session":
+			if kind != bson.Null {
+				req.Session = new(Session)
+				req.Session.UnmarshalBson(buf, kind)
+			}
+		default:
+			bson.Skip(buf, kind)
+		}
+		kind = bson.NextByte(buf)
+	}
+}
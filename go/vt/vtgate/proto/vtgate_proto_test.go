@@ -28,17 +28,47 @@ var commonSession = Session{
 		TabletType:    topo.TabletType("master"),
 		TransactionId: 2,
 	}},
+	LogQueries: true,
+	Workload:   "olap",
+
+	MaxExecutionTimeHint: true,
+	FallbackTabletTypes:  []topo.TabletType{"replica", "master"},
+	Upgraded:             true,
+	RetryWrites:          true,
+	Savepoints:           []string{"sp1"},
+	MaxStaleness:         4,
+	TabletType:           "replica",
 }
 
 type reflectSession struct {
 	InTransaction bool
 	ShardSessions []*ShardSession
+	LogQueries    bool
+	Workload      string
+
+	MaxExecutionTimeHint bool
+	FallbackTabletTypes  []topo.TabletType
+	Upgraded             bool
+	RetryWrites          bool
+	Savepoints           []string
+	MaxStaleness         int64
+	TabletType           topo.TabletType
 }
 
 type extraSession struct {
 	Extra         int
 	InTransaction bool
 	ShardSessions []*ShardSession
+	LogQueries    bool
+	Workload      string
+
+	MaxExecutionTimeHint bool
+	FallbackTabletTypes  []topo.TabletType
+	Upgraded             bool
+	RetryWrites          bool
+	Savepoints           []string
+	MaxStaleness         int64
+	TabletType           topo.TabletType
 }
 
 func TestSession(t *testing.T) {
@@ -55,6 +85,16 @@ func TestSession(t *testing.T) {
 			TabletType:    topo.TabletType("master"),
 			TransactionId: 2,
 		}},
+		LogQueries: true,
+		Workload:   "olap",
+
+		MaxExecutionTimeHint: true,
+		FallbackTabletTypes:  []topo.TabletType{"replica", "master"},
+		Upgraded:             true,
+		RetryWrites:          true,
+		Savepoints:           []string{"sp1"},
+		MaxStaleness:         4,
+		TabletType:           "replica",
 	})
 	if err != nil {
 		t.Error(err)
@@ -90,33 +130,110 @@ func TestSession(t *testing.T) {
 	}
 }
 
-type reflectQueryShard struct {
-	Sql           string
-	BindVariables map[string]interface{}
+type reflectShardSession struct {
 	Keyspace      string
-	Shards        []string
+	Shard         string
 	TabletType    topo.TabletType
-	Session       *Session
+	TransactionId int64
+	Savepoints    []string
 }
 
-type extraQueryShard struct {
+type extraShardSession struct {
 	Extra         int
-	Sql           string
-	BindVariables map[string]interface{}
 	Keyspace      string
-	Shards        []string
+	Shard         string
 	TabletType    topo.TabletType
-	Session       *Session
+	TransactionId int64
+	Savepoints    []string
+}
+
+func TestShardSession(t *testing.T) {
+	reflected, err := bson.Marshal(&reflectShardSession{
+		Keyspace:      "a",
+		Shard:         "0",
+		TabletType:    topo.TabletType("master"),
+		TransactionId: 1,
+		Savepoints:    []string{"sp1"},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	want := string(reflected)
+
+	custom := ShardSession{
+		Keyspace:      "a",
+		Shard:         "0",
+		TabletType:    topo.TabletType("master"),
+		TransactionId: 1,
+		Savepoints:    []string{"sp1"},
+	}
+	encoded, err := bson.Marshal(&custom)
+	if err != nil {
+		t.Error(err)
+	}
+	got := string(encoded)
+	if want != got {
+		t.Errorf("want\n%#v, got\n%#v", want, got)
+	}
+
+	var unmarshalled ShardSession
+	err = bson.Unmarshal(encoded, &unmarshalled)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(custom, unmarshalled) {
+		t.Errorf("want \n%#v, got \n%#v", custom, unmarshalled)
+	}
+
+	extra, err := bson.Marshal(&extraShardSession{})
+	if err != nil {
+		t.Error(err)
+	}
+	err = bson.Unmarshal(extra, &unmarshalled)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+type reflectQueryShard struct {
+	Sql             string
+	BindVariables   map[string]interface{}
+	Keyspace        string
+	Shards          []string
+	TabletType      topo.TabletType
+	AllowScatterDML bool
+	AffinityKey     string
+	Explain         bool
+	Quorum          int
+	Session         *Session
+}
+
+type extraQueryShard struct {
+	Extra           int
+	Sql             string
+	BindVariables   map[string]interface{}
+	Keyspace        string
+	Shards          []string
+	TabletType      topo.TabletType
+	AllowScatterDML bool
+	AffinityKey     string
+	Explain         bool
+	Quorum          int
+	Session         *Session
 }
 
 func TestQueryShard(t *testing.T) {
 	reflected, err := bson.Marshal(&reflectQueryShard{
-		Sql:           "query",
-		BindVariables: map[string]interface{}{"val": int64(1)},
-		Keyspace:      "keyspace",
-		Shards:        []string{"shard1", "shard2"},
-		TabletType:    topo.TabletType("replica"),
-		Session:       &commonSession,
+		Sql:             "query",
+		BindVariables:   map[string]interface{}{"val": int64(1)},
+		Keyspace:        "keyspace",
+		Shards:          []string{"shard1", "shard2"},
+		TabletType:      topo.TabletType("replica"),
+		AllowScatterDML: true,
+		AffinityKey:     "key",
+		Explain:         true,
+		Quorum:          2,
+		Session:         &commonSession,
 	})
 	if err != nil {
 		t.Error(err)
@@ -124,12 +241,16 @@ func TestQueryShard(t *testing.T) {
 	want := string(reflected)
 
 	custom := QueryShard{
-		Sql:           "query",
-		BindVariables: map[string]interface{}{"val": int64(1)},
-		Keyspace:      "keyspace",
-		Shards:        []string{"shard1", "shard2"},
-		TabletType:    topo.TabletType("replica"),
-		Session:       &commonSession,
+		Sql:             "query",
+		BindVariables:   map[string]interface{}{"val": int64(1)},
+		Keyspace:        "keyspace",
+		Shards:          []string{"shard1", "shard2"},
+		TabletType:      topo.TabletType("replica"),
+		AllowScatterDML: true,
+		AffinityKey:     "key",
+		Explain:         true,
+		Quorum:          2,
+		Session:         &commonSession,
 	}
 	encoded, err := bson.Marshal(&custom)
 	if err != nil {
@@ -162,7 +283,7 @@ func TestQueryShard(t *testing.T) {
 func TestQueryResult(t *testing.T) {
 	// We can't do the reflection test because bson
 	// doesn't do it correctly for embedded fields.
-	want := "o\x01\x00\x00" +
+	want := "\x8d\x02\x00\x00" +
 		"\x04Fields\x00*\x00\x00\x00" +
 		"\x030\x00\"\x00\x00\x00" +
 		"\x05Name\x00\x04\x00\x00\x00\x00name" +
@@ -174,22 +295,44 @@ func TestQueryResult(t *testing.T) {
 		"\x050\x00\x01\x00\x00\x00" +
 		"\x001\x051\x00\x02\x00\x00\x00\x00aa" +
 		"\x00\x00" +
-		"\x03Session\x00\xd0\x00\x00\x00" +
+		"\x03Session\x00\xb9\x01\x00\x00" +
 		"\bInTransaction\x00\x01" +
-		"\x04ShardSessions\x00\xac\x00\x00\x00" +
-		"\x030\x00Q\x00\x00\x00" +
+		"\x04ShardSessions\x00\xc4\x00\x00\x00" +
+		"\x030\x00]\x00\x00\x00" +
 		"\x05Keyspace\x00\x01\x00\x00\x00\x00a" +
 		"\x05Shard\x00\x01\x00\x00\x00\x000" +
 		"\x05TabletType\x00\a\x00\x00\x00\x00replica" +
 		"\x12TransactionId\x00\x01\x00\x00\x00\x00\x00\x00\x00" +
+		"\nSavepoints\x00" +
 		"\x00" +
-		"\x031\x00P\x00\x00\x00" +
+		"\x031\x00\\\x00\x00\x00" +
 		"\x05Keyspace\x00\x01\x00\x00\x00\x00b" +
 		"\x05Shard\x00\x01\x00\x00\x00\x001" +
 		"\x05TabletType\x00\x06\x00\x00\x00\x00master" +
 		"\x12TransactionId\x00\x02\x00\x00\x00\x00\x00\x00\x00" +
-		"\x00\x00\x00" +
+		"\nSavepoints\x00" +
+		"\x00\x00" +
+		"\bLogQueries\x00\x01" +
+		"\x05Workload\x00\x04\x00\x00\x00\x00olap" +
+		"\bMaxExecutionTimeHint\x00\x01" +
+		"\x04FallbackTabletTypes\x00\"\x00\x00\x00" +
+		"\x050\x00\a\x00\x00\x00\x00replica" +
+		"\x051\x00\x06\x00\x00\x00\x00master" +
+		"\x00" +
+		"\bUpgraded\x00\x01" +
+		"\bRetryWrites\x00\x01" +
+		"\x04Savepoints\x00\x10\x00\x00\x00" +
+		"\x050\x00\x03\x00\x00\x00\x00sp1" +
+		"\x00" +
+		"\x12MaxStaleness\x00\x04\x00\x00\x00\x00\x00\x00\x00" +
+		"\x05TabletType\x00\a\x00\x00\x00\x00replica" +
+		"\x00" +
 		"\x05Error\x00\x05\x00\x00\x00\x00error" +
+		"\x12ConnectionId\x00\a\x00\x00\x00\x00\x00\x00\x00" +
+		"\x04Shards\x00\x17\x00\x00\x00" +
+		"\x050\x00\x01\x00\x00\x00\x000" +
+		"\x051\x00\x01\x00\x00\x00\x001" +
+		"\x00" +
 		"\x00"
 
 	custom := QueryResult{
@@ -199,8 +342,10 @@ func TestQueryResult(t *testing.T) {
 		Rows: [][]sqltypes.Value{
 			{{sqltypes.String("1")}, {sqltypes.String("aa")}},
 		},
-		Session: &commonSession,
-		Error:   "error",
+		Session:      &commonSession,
+		Error:        "error",
+		ConnectionId: 7,
+		Shards:       []string{"0", "1"},
 	}
 	encoded, err := bson.Marshal(&custom)
 	if err != nil {
@@ -227,20 +372,24 @@ type reflectBoundQuery struct {
 }
 
 type reflectBatchQueryShard struct {
-	Queries    []reflectBoundQuery
-	Keyspace   string
-	Shards     []string
-	TabletType topo.TabletType
-	Session    *Session
+	Queries         []reflectBoundQuery
+	Keyspace        string
+	Shards          []string
+	TabletType      topo.TabletType
+	AllowScatterDML bool
+	ContinueOnError bool
+	Session         *Session
 }
 
 type extraBatchQueryShard struct {
-	Extra      int
-	Queries    []reflectBoundQuery
-	Keyspace   string
-	Shards     []string
-	TabletType topo.TabletType
-	Session    *Session
+	Extra           int
+	Queries         []reflectBoundQuery
+	Keyspace        string
+	Shards          []string
+	TabletType      topo.TabletType
+	AllowScatterDML bool
+	ContinueOnError bool
+	Session         *Session
 }
 
 func TestBatchQueryShard(t *testing.T) {
@@ -249,8 +398,10 @@ func TestBatchQueryShard(t *testing.T) {
 			Sql:           "query",
 			BindVariables: map[string]interface{}{"val": int64(1)},
 		}},
-		Keyspace: "keyspace",
-		Shards:   []string{"shard1", "shard2"},
+		Keyspace:        "keyspace",
+		Shards:          []string{"shard1", "shard2"},
+		AllowScatterDML: true,
+		ContinueOnError: true,
 		Session: &Session{InTransaction: true,
 			ShardSessions: []*ShardSession{{
 				Keyspace:      "a",
@@ -263,6 +414,16 @@ func TestBatchQueryShard(t *testing.T) {
 				TabletType:    topo.TabletType("master"),
 				TransactionId: 2,
 			}},
+			LogQueries: true,
+			Workload:   "olap",
+
+			MaxExecutionTimeHint: true,
+			FallbackTabletTypes:  []topo.TabletType{"replica", "master"},
+			Upgraded:             true,
+			RetryWrites:          true,
+			Savepoints:           []string{"sp1"},
+			MaxStaleness:         4,
+			TabletType:           "replica",
 		},
 	})
 	if err != nil {
@@ -275,9 +436,11 @@ func TestBatchQueryShard(t *testing.T) {
 			Sql:           "query",
 			BindVariables: map[string]interface{}{"val": int64(1)},
 		}},
-		Keyspace: "keyspace",
-		Shards:   []string{"shard1", "shard2"},
-		Session:  &commonSession,
+		Keyspace:        "keyspace",
+		Shards:          []string{"shard1", "shard2"},
+		AllowScatterDML: true,
+		ContinueOnError: true,
+		Session:         &commonSession,
 	}
 	encoded, err := bson.Marshal(&custom)
 	if err != nil {
@@ -332,6 +495,7 @@ type reflectQueryResultList struct {
 	List    []mproto.QueryResult
 	Session *Session
 	Error   string
+	Errors  []string
 }
 
 type extraQueryResultList struct {
@@ -339,6 +503,7 @@ type extraQueryResultList struct {
 	List    []mproto.QueryResult
 	Session *Session
 	Error   string
+	Errors  []string
 }
 
 func TestQueryResultList(t *testing.T) {
@@ -353,6 +518,7 @@ func TestQueryResultList(t *testing.T) {
 		}},
 		Session: &commonSession,
 		Error:   "error",
+		Errors:  []string{"", "error"},
 	})
 	if err != nil {
 		t.Error(err)
@@ -370,6 +536,7 @@ func TestQueryResultList(t *testing.T) {
 		}},
 		Session: &commonSession,
 		Error:   "error",
+		Errors:  []string{"", "error"},
 	}
 	encoded, err := bson.Marshal(&custom)
 	if err != nil {
@@ -468,3 +635,56 @@ func TestStreamQueryKeyRange(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+type reflectSavepointRequest struct {
+	Name    string
+	Session *Session
+}
+
+type extraSavepointRequest struct {
+	Extra   int
+	Name    string
+	Session *Session
+}
+
+func TestSavepointRequest(t *testing.T) {
+	reflected, err := bson.Marshal(&reflectSavepointRequest{
+		Name:    "sp1",
+		Session: &commonSession,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	want := string(reflected)
+
+	custom := SavepointRequest{
+		Name:    "sp1",
+		Session: &commonSession,
+	}
+	encoded, err := bson.Marshal(&custom)
+	if err != nil {
+		t.Error(err)
+	}
+	got := string(encoded)
+	if want != got {
+		t.Errorf("want\n%#v, got\n%#v", want, got)
+	}
+
+	var unmarshalled SavepointRequest
+	err = bson.Unmarshal(encoded, &unmarshalled)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(custom, unmarshalled) {
+		t.Errorf("want \n%#v, got \n%#v", custom, unmarshalled)
+	}
+
+	extra, err := bson.Marshal(&extraSavepointRequest{})
+	if err != nil {
+		t.Error(err)
+	}
+	err = bson.Unmarshal(extra, &unmarshalled)
+	if err != nil {
+		t.Error(err)
+	}
+}
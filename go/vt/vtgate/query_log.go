@@ -0,0 +1,39 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/sync2"
+)
+
+var queryLogSampleRate = flag.Int("query_log_sample_rate", 0, "log one in that many ExecuteShard, ExecuteBatchShard, StreamExecuteShard and StreamExecuteKeyRange queries, and all the failed ones (0 disables the query log)")
+
+// queryLogCount counts the queries that succeeded, to pick
+// the ones to log.
+var queryLogCount sync2.AtomicInt64
+
+// logSampledQuery is used to write the query log.
+// It can be replaced by tests.
+var logSampledQuery = func(format string, args ...interface{}) {
+	log.Infof(format, args...)
+}
+
+// sampleQuery logs the query sql, sent to shards with bindVariables
+// bind variables and started at startTime, if the query log is on
+// and it's sampled, or it failed with errMsg.
+func sampleQuery(method, keyspace string, shards []string, sql string, bindVariables int, startTime time.Time, errMsg string) {
+	rate := int64(*queryLogSampleRate)
+	if rate <= 0 {
+		return
+	}
+	if errMsg == "" && queryLogCount.Add(1)%rate != 0 {
+		return
+	}
+	logSampledQuery("%v: keyspace %v, shards %v, %v bind variables, duration %v, error %q: %v", method, keyspace, shards, bindVariables, time.Now().Sub(startTime), errMsg, sql)
+}
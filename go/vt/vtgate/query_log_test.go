@@ -0,0 +1,77 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+func TestQueryLogSampling(t *testing.T) {
+	defer func(rate int, logf func(string, ...interface{})) {
+		*queryLogSampleRate, logSampledQuery = rate, logf
+	}(*queryLogSampleRate, logSampledQuery)
+	var logged []string
+	logSampledQuery = func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	resetSandbox()
+	sbc := &sandboxConn{}
+	mapTestConn("80-A0", sbc)
+	execute := func(sql string) {
+		q := proto.QueryShard{
+			Sql:           sql,
+			BindVariables: map[string]interface{}{"id": 1},
+			Keyspace:      "ks",
+			Shards:        []string{"80-A0"},
+		}
+		RpcVTGate.ExecuteShard(nil, &q, new(proto.QueryResult))
+	}
+
+	// Off by default.
+	*queryLogSampleRate = 0
+	execute("select off")
+	if len(logged) != 0 {
+		t.Errorf("want nothing logged, got %v", logged)
+	}
+
+	// One in 3, and all the errors.
+	*queryLogSampleRate = 3
+	queryLogCount.Set(0)
+	for i := 0; i < 6; i++ {
+		execute("select sampled")
+	}
+	sbc.mustFailServer = 1
+	execute("select failed")
+	if len(logged) != 3 {
+		t.Fatalf("want 3 queries logged, got %v", logged)
+	}
+	if !strings.Contains(logged[0], "ExecuteShard: keyspace ks, shards [80-A0], 1 bind variables") || !strings.HasSuffix(logged[0], "select sampled") {
+		t.Errorf("unexpected log: %v", logged[0])
+	}
+	if !strings.Contains(logged[2], "error") || !strings.HasSuffix(logged[2], "select failed") {
+		t.Errorf("want the failed query logged, got %v", logged[2])
+	}
+
+	// Batches are logged too.
+	*queryLogSampleRate = 1
+	logged = nil
+	bq := proto.BatchQueryShard{
+		Queries: []tproto.BoundQuery{
+			{Sql: "select one", BindVariables: map[string]interface{}{"id": 1}},
+			{Sql: "select two", BindVariables: map[string]interface{}{"id": 2}},
+		},
+		Keyspace: "ks",
+		Shards:   []string{"80-A0"},
+	}
+	RpcVTGate.ExecuteBatchShard(nil, &bq, new(proto.QueryResultList))
+	if len(logged) != 1 || !strings.Contains(logged[0], "ExecuteBatchShard: keyspace ks, shards [80-A0], 2 bind variables") || !strings.HasSuffix(logged[0], "select one; select two") {
+		t.Errorf("want the batch logged, got %v", logged)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+)
+
+var (
+	maxResultRows  = flag.Int("max_result_rows", 0, "max number of rows of a non-streaming result, past which the query fails: the tablets fail it for the result of their shard, vtgate for the result merged across shards (0 for no limit)")
+	maxResultBytes = flag.Int("max_result_bytes", 0, "max size in bytes of the values of a non-streaming result, past which the query fails: the tablets fail it for the result of their shard, vtgate for the result merged across shards (0 for no limit)")
+)
+
+// resultsTooLarge counts the results that went past the limits.
+var resultsTooLarge = stats.NewInt("VtgateResultsTooLarge")
+
+// RESULT_TOO_LARGE_ERR prefixes the error of a ResultTooLargeError.
+const RESULT_TOO_LARGE_ERR = "result too large"
+
+// ResultTooLargeError is the error of a non-streaming query whose
+// result, merged across shards, went past -max_result_rows or
+// -max_result_bytes. Rows and Bytes are its size when it did: the
+// rest isn't read. The result of each shard is bounded by vttablet,
+// which fails the query with a "result too large" error before
+// sending a larger one: ShardConn turns it into a ResultTooLargeError
+// with the ShardIdentifier and the Err of the tablet instead.
+// Streaming queries have no such limit.
+type ResultTooLargeError struct {
+	Rows            int
+	Bytes           int
+	ShardIdentifier string
+	Err             string
+}
+
+func (e *ResultTooLargeError) Error() string {
+	if e.ShardIdentifier != "" {
+		return fmt.Sprintf("%v, shard, host: %s", e.Err, e.ShardIdentifier)
+	}
+	return fmt.Sprintf("%s: %v rows, %v bytes, the limits are %v rows and %v bytes", RESULT_TOO_LARGE_ERR, e.Rows, e.Bytes, *maxResultRows, *maxResultBytes)
+}
+
+// tabletResultTooLarge returns the message of err if it's the error
+// of a tablet that failed a query because its result went past the
+// limits, and "" otherwise.
+func tabletResultTooLarge(err error) string {
+	serverError, ok := err.(*tabletconn.ServerError)
+	if !ok || serverError.Code != tabletconn.ERR_NORMAL {
+		return ""
+	}
+	message := strings.TrimPrefix(serverError.Err, "error: ")
+	if !strings.HasPrefix(message, RESULT_TOO_LARGE_ERR) {
+		return ""
+	}
+	return message
+}
+
+// resultSize is the size of a result being merged.
+type resultSize struct {
+	rows  int
+	bytes int
+}
+
+// add adds rows to the size, and returns a ResultTooLargeError
+// if it's now past the limits.
+func (size *resultSize) add(rows [][]sqltypes.Value) error {
+	size.rows += len(rows)
+	if *maxResultBytes > 0 {
+		for _, row := range rows {
+			for _, value := range row {
+				size.bytes += len(value.Raw())
+			}
+		}
+	}
+	if (*maxResultRows > 0 && size.rows > *maxResultRows) || (*maxResultBytes > 0 && size.bytes > *maxResultBytes) {
+		resultsTooLarge.Add(1)
+		return &ResultTooLargeError{Rows: size.rows, Bytes: size.bytes}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"sync"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	rpcproto "github.com/youtube/vitess/go/rpcwrap/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+)
+
+// ResultTransformer can rewrite the results of a query before VTGate
+// returns them to the client, for instance to mask sensitive columns.
+// Transform is called with the keyspace the query ran against and the
+// authenticated principal, if any, so a transformer can decide per
+// keyspace or per caller what to do. It may change values and drop
+// rows, but every row it leaves must still have one value per field.
+// An error fails the query.
+type ResultTransformer interface {
+	Transform(keyspace, principal string, qr *mproto.QueryResult) error
+}
+
+var (
+	transformersMu     sync.Mutex
+	resultTransformers []ResultTransformer
+)
+
+// RegisterResultTransformer adds a ResultTransformer to the ones applied
+// to every query result. It is meant to be called from init() in a plugin.
+// Transformers are applied in the order they were registered. Without any,
+// results are returned untouched.
+func RegisterResultTransformer(t ResultTransformer) {
+	transformersMu.Lock()
+	defer transformersMu.Unlock()
+	resultTransformers = append(resultTransformers, t)
+}
+
+func getResultTransformers() []ResultTransformer {
+	transformersMu.Lock()
+	defer transformersMu.Unlock()
+	return resultTransformers
+}
+
+// principal returns the authenticated user of the rpc context,
+// or "" if the call is not authenticated.
+func principal(context interface{}) string {
+	if ctx, ok := context.(*rpcproto.Context); ok {
+		return ctx.Username
+	}
+	return ""
+}
+
+// transformResult runs the registered transformers on qr and returns
+// the result to send back. Transformers work on a copy, so qr is never
+// modified and a transformer error can't leak a partially rewritten
+// result.
+func transformResult(context interface{}, keyspace string, qr *mproto.QueryResult) (*mproto.QueryResult, error) {
+	transformers := getResultTransformers()
+	if len(transformers) == 0 || qr == nil {
+		return qr, nil
+	}
+	out := copyResult(qr)
+	countsRows := len(qr.Fields) != 0 && qr.RowsAffected == uint64(len(qr.Rows))
+	user := principal(context)
+	for _, t := range transformers {
+		if err := t.Transform(keyspace, user, out); err != nil {
+			return nil, fmt.Errorf("result transformer %T: %v", t, err)
+		}
+		if err := checkResult(out, len(qr.Fields)); err != nil {
+			return nil, fmt.Errorf("result transformer %T: %v", t, err)
+		}
+	}
+	if countsRows {
+		out.RowsAffected = uint64(len(out.Rows))
+	}
+	return out, nil
+}
+
+// transformStream returns a sendReply function that transforms every
+// streamed result before passing it on to sendReply. Only the first
+// result of each shard carries the fields, so they are remembered
+// and lent to the results that follow.
+func transformStream(context interface{}, keyspace string, sendReply func(*mproto.QueryResult) error) func(*mproto.QueryResult) error {
+	if len(getResultTransformers()) == 0 {
+		return sendReply
+	}
+	var fields []mproto.Field
+	return func(qr *mproto.QueryResult) error {
+		hasFields := qr.Fields != nil
+		if hasFields {
+			fields = qr.Fields
+		} else {
+			withFields := *qr
+			withFields.Fields = fields
+			qr = &withFields
+		}
+		out, err := transformResult(context, keyspace, qr)
+		if err != nil {
+			return err
+		}
+		if !hasFields {
+			out.Fields = nil
+		}
+		return sendReply(out)
+	}
+}
+
+func copyResult(qr *mproto.QueryResult) *mproto.QueryResult {
+	out := *qr
+	if qr.Fields != nil {
+		out.Fields = make([]mproto.Field, len(qr.Fields))
+		copy(out.Fields, qr.Fields)
+	}
+	if qr.Rows != nil {
+		out.Rows = make([][]sqltypes.Value, len(qr.Rows))
+		for i, row := range qr.Rows {
+			out.Rows[i] = make([]sqltypes.Value, len(row))
+			copy(out.Rows[i], row)
+		}
+	}
+	return &out
+}
+
+// checkResult verifies a transformed result still has fieldCount fields
+// and one value per field in every row.
+func checkResult(qr *mproto.QueryResult, fieldCount int) error {
+	if len(qr.Fields) != fieldCount {
+		return fmt.Errorf("changed the number of fields from %v to %v", fieldCount, len(qr.Fields))
+	}
+	if fieldCount == 0 {
+		return nil
+	}
+	for i, row := range qr.Rows {
+		if len(row) != fieldCount {
+			return fmt.Errorf("row %v has %v values, expecting %v", i, len(row), fieldCount)
+		}
+	}
+	return nil
+}
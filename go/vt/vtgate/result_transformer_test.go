@@ -0,0 +1,117 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	rpcproto "github.com/youtube/vitess/go/rpcwrap/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// columnMasker redacts a column in a keyspace, except for one principal.
+type columnMasker struct {
+	keyspace, column, exempt string
+}
+
+func (cm *columnMasker) Transform(keyspace, principal string, qr *mproto.QueryResult) error {
+	if keyspace != cm.keyspace || principal == cm.exempt {
+		return nil
+	}
+	for i, field := range qr.Fields {
+		if field.Name != cm.column {
+			continue
+		}
+		for _, row := range qr.Rows {
+			row[i] = sqltypes.MakeString([]byte("xxx"))
+		}
+	}
+	return nil
+}
+
+// columnDropper breaks the field/row contract.
+type columnDropper struct{}
+
+func (columnDropper) Transform(keyspace, principal string, qr *mproto.QueryResult) error {
+	for i, row := range qr.Rows {
+		qr.Rows[i] = row[:len(row)-1]
+	}
+	return nil
+}
+
+func valueColumn(qr *proto.QueryResult) string {
+	if len(qr.Rows) != 1 {
+		return fmt.Sprintf("%v rows", len(qr.Rows))
+	}
+	return qr.Rows[0][1].String()
+}
+
+func TestResultTransformer(t *testing.T) {
+	defer func() { resultTransformers = nil }()
+	RegisterResultTransformer(&columnMasker{keyspace: "masked", column: "value", exempt: "admin"})
+	resetSandbox()
+	testConns[0] = &sandboxConn{}
+	q := proto.QueryShard{
+		Sql:      "query",
+		Keyspace: "masked",
+		Shards:   []string{"0"},
+	}
+
+	qr := new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if got := valueColumn(qr); got != "xxx" {
+		t.Errorf("want xxx, got %v", got)
+	}
+	if got := singleRowResult.Rows[0][1].String(); got != "foo" {
+		t.Errorf("transformer modified the tablet result: %v", got)
+	}
+
+	qr = new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(&rpcproto.Context{Username: "admin"}, &q, qr)
+	if got := valueColumn(qr); got != "foo" {
+		t.Errorf("want foo for the exempt principal, got %v", got)
+	}
+
+	var streamed []string
+	RpcVTGate.StreamExecuteShard(nil, &q, func(r *proto.QueryResult) error {
+		streamed = append(streamed, valueColumn(r))
+		return nil
+	})
+	if len(streamed) != 1 || streamed[0] != "xxx" {
+		t.Errorf("want [xxx], got %v", streamed)
+	}
+
+	q.Keyspace = "unmasked"
+	qr = new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if got := valueColumn(qr); got != "foo" {
+		t.Errorf("want foo in another keyspace, got %v", got)
+	}
+}
+
+func TestResultTransformerContract(t *testing.T) {
+	defer func() { resultTransformers = nil }()
+	RegisterResultTransformer(columnDropper{})
+	resetSandbox()
+	testConns[0] = &sandboxConn{}
+	q := proto.QueryShard{
+		Sql:      "query",
+		Keyspace: "dropped",
+		Shards:   []string{"0"},
+	}
+	qr := new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	want := "row 0 has 1 values, expecting 2"
+	if !strings.Contains(qr.Error, want) {
+		t.Errorf("want %q, got %q", want, qr.Error)
+	}
+	if qr.Rows != nil {
+		t.Errorf("want no rows, got %v", qr.Rows)
+	}
+}
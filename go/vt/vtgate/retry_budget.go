@@ -0,0 +1,110 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	rpcproto "github.com/youtube/vitess/go/rpcwrap/proto"
+	"github.com/youtube/vitess/go/stats"
+)
+
+var (
+	retryBudget       = flag.Int("retry_budget", 0, "number of retries a caller, by username or else by host, can do over -retry_budget_window, after which its failed queries fail fast instead of retrying (0 for no limit)")
+	retryBudgetWindow = flag.Duration("retry_budget_window", 1*time.Minute, "window over which a caller gets its -retry_budget back")
+
+	retryBudgetExhausted = stats.NewInt("VtgateRetryBudgetExhausted")
+
+	// callerRetryBudgets are the budgets of the callers, see
+	// allowCallerRetry.
+	callerRetryBudgets = newRetryBudgets()
+)
+
+// retryBudgets are token buckets, by caller, each holding up to
+// -retry_budget retries, refilled over -retry_budget_window. They
+// are kept by vtgate: what the clients send can't be trusted.
+type retryBudgets struct {
+	mu        sync.Mutex
+	buckets   map[string]*retryBucket
+	lastSweep time.Time
+}
+
+type retryBucket struct {
+	tokens     float64
+	refillTime time.Time
+}
+
+func newRetryBudgets() *retryBudgets {
+	return &retryBudgets{buckets: make(map[string]*retryBucket)}
+}
+
+// callerKey returns who made the call with context: its username,
+// or else the host it came from. The calls without a context, or
+// with neither, share the "" budget.
+func callerKey(context interface{}) string {
+	ctx, ok := context.(*rpcproto.Context)
+	if !ok || ctx == nil {
+		return ""
+	}
+	if ctx.Username != "" {
+		return "user:" + ctx.Username
+	}
+	if host, _, err := net.SplitHostPort(ctx.RemoteAddr); err == nil {
+		return "host:" + host
+	}
+	return "host:" + ctx.RemoteAddr
+}
+
+// allowCallerRetry takes a retry from the budget of the caller of
+// context, and returns false if it has none left, so one client can't
+// start a retry storm. Without -retry_budget, all the retries are
+// allowed.
+func allowCallerRetry(context interface{}) bool {
+	if *retryBudget <= 0 {
+		return true
+	}
+	return callerRetryBudgets.allow(callerKey(context), time.Now(), float64(*retryBudget), *retryBudgetWindow)
+}
+
+// allow takes a token from the bucket of caller at now, if it has
+// one. The buckets hold up to capacity tokens, refilled over window.
+func (rb *retryBudgets) allow(caller string, now time.Time, capacity float64, window time.Duration) bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.sweep(now, window)
+	bucket, ok := rb.buckets[caller]
+	if !ok {
+		bucket = &retryBucket{tokens: capacity, refillTime: now}
+		rb.buckets[caller] = bucket
+	}
+	refill := capacity * float64(now.Sub(bucket.refillTime)) / float64(window)
+	bucket.tokens = math.Min(capacity, bucket.tokens+refill)
+	bucket.refillTime = now
+	if bucket.tokens < 1 {
+		retryBudgetExhausted.Add(1)
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// sweep forgets, once per window, the buckets that have been full
+// again for a while, so the callers that are gone don't pile up.
+// mu must be held.
+func (rb *retryBudgets) sweep(now time.Time, window time.Duration) {
+	if now.Sub(rb.lastSweep) < window {
+		return
+	}
+	rb.lastSweep = now
+	for caller, bucket := range rb.buckets {
+		if now.Sub(bucket.refillTime) >= window {
+			delete(rb.buckets, caller)
+		}
+	}
+}
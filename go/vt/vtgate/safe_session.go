@@ -6,6 +6,7 @@ package vtgate
 
 import (
 	"sync"
+	"time"
 
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
@@ -29,18 +30,115 @@ func (session *SafeSession) InTransaction() bool {
 	return session.Session.InTransaction
 }
 
+// Workload returns the workload the session asked for, if any.
+func (session *SafeSession) Workload() string {
+	if session == nil || session.Session == nil {
+		return ""
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.Session.Workload
+}
+
+// MaxExecutionTimeHint returns true if the session asked for
+// MAX_EXECUTION_TIME hints on its queries.
+func (session *SafeSession) MaxExecutionTimeHint() bool {
+	if session == nil || session.Session == nil {
+		return false
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.Session.MaxExecutionTimeHint
+}
+
+// FallbackTabletTypes returns the fallback tablet types of the session.
+func (session *SafeSession) FallbackTabletTypes() []topo.TabletType {
+	if session == nil || session.Session == nil {
+		return nil
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.Session.FallbackTabletTypes
+}
+
+// Upgraded returns true if the session was upgraded to the masters.
+func (session *SafeSession) Upgraded() bool {
+	if session == nil || session.Session == nil {
+		return false
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.Session.Upgraded
+}
+
+// TabletType returns the tablet type the session set for its
+// queries, "" if none.
+func (session *SafeSession) TabletType() topo.TabletType {
+	if session == nil || session.Session == nil {
+		return ""
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.Session.TabletType
+}
+
+// MaxStaleness returns the bound on the replication lag of the
+// replicas the session reads from, 0 for none.
+func (session *SafeSession) MaxStaleness() time.Duration {
+	if session == nil || session.Session == nil {
+		return 0
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return time.Duration(session.Session.MaxStaleness)
+}
+
+// AllowWriteRetry returns true if the session opted in to the
+// retry of its DMLs.
+func (session *SafeSession) AllowWriteRetry() bool {
+	if session == nil || session.Session == nil {
+		return false
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.Session.RetryWrites
+}
+
+// AllowRetry is part of the RetryBudget interface. Sessions don't
+// limit their retries: the budgets are kept by caller, see
+// allowCallerRetry.
+func (session *SafeSession) AllowRetry() bool {
+	return true
+}
+
 func (session *SafeSession) Find(keyspace, shard string, tabletType topo.TabletType) int64 {
 	if session == nil {
 		return 0
 	}
 	session.mu.Lock()
 	defer session.mu.Unlock()
+	if shardSession := session.find(keyspace, shard, tabletType); shardSession != nil {
+		return shardSession.TransactionId
+	}
+	return 0
+}
+
+// SetTransactionId replaces the transaction id of the shard session.
+func (session *SafeSession) SetTransactionId(keyspace, shard string, tabletType topo.TabletType, transactionId int64) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if shardSession := session.find(keyspace, shard, tabletType); shardSession != nil {
+		shardSession.TransactionId = transactionId
+	}
+}
+
+func (session *SafeSession) find(keyspace, shard string, tabletType topo.TabletType) *proto.ShardSession {
 	for _, shardSession := range session.ShardSessions {
 		if keyspace == shardSession.Keyspace && tabletType == shardSession.TabletType && shard == shardSession.Shard {
-			return shardSession.TransactionId
+			return shardSession
 		}
 	}
-	return 0
+	return nil
 }
 
 func (session *SafeSession) Append(shardSession *proto.ShardSession) {
@@ -8,6 +8,7 @@ import (
 	"flag"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -42,6 +43,10 @@ var (
 
 	// dialMustFail specifies how often sandboxDialer must fail before succeeding
 	dialMustFail int
+
+	// sandboxEndPoints overrides the end points returned by GetEndPoints
+	// for the tablet types it contains
+	sandboxEndPoints map[topo.TabletType][]topo.EndPoint
 )
 
 var (
@@ -62,6 +67,7 @@ func resetSandbox() {
 	endPointCounter = 0
 	dialCounter = 0
 	dialMustFail = 0
+	sandboxEndPoints = nil
 	transactionId.Set(0)
 }
 
@@ -186,6 +192,9 @@ func (sct *sandboxTopo) GetEndPoints(cell, keyspace, shard string, tabletType to
 		endPointMustFail--
 		return nil, fmt.Errorf("topo error")
 	}
+	if entries, ok := sandboxEndPoints[tabletType]; ok {
+		return &topo.EndPoints{Entries: entries}, nil
+	}
 	uid, err := getUidForShard(shard)
 	if err != nil {
 		panic(err)
@@ -231,6 +240,8 @@ type sandboxConn struct {
 	mustFailTxPool int
 	mustFailNotTx  int
 	mustDelay      time.Duration
+	// connectionId is returned by Execute as the MySQL connection id.
+	connectionId int64
 
 	// These Count vars report how often the corresponding
 	// functions were called.
@@ -239,6 +250,16 @@ type sandboxConn struct {
 	CommitCount   sync2.AtomicInt64
 	RollbackCount sync2.AtomicInt64
 	CloseCount    sync2.AtomicInt64
+
+	// Queries stores the non-streaming queries received.
+	queriesMu sync.Mutex
+	Queries   []tproto.BoundQuery
+}
+
+func (sbc *sandboxConn) addQueries(queries ...tproto.BoundQuery) {
+	sbc.queriesMu.Lock()
+	defer sbc.queriesMu.Unlock()
+	sbc.Queries = append(sbc.Queries, queries...)
 }
 
 func (sbc *sandboxConn) getError() error {
@@ -269,25 +290,41 @@ func (sbc *sandboxConn) getError() error {
 	return nil
 }
 
-func (sbc *sandboxConn) Execute(context interface{}, query string, bindVars map[string]interface{}, transactionId int64) (*mproto.QueryResult, error) {
+func (sbc *sandboxConn) Execute(context interface{}, query string, bindVars map[string]interface{}, transactionId int64, maxRows, maxBytes int64) (*mproto.QueryResult, error) {
 	sbc.ExecCount.Add(1)
+	sbc.addQueries(tproto.BoundQuery{Sql: query, BindVariables: bindVars})
 	if sbc.mustDelay != 0 {
 		time.Sleep(sbc.mustDelay)
 	}
 	if err := sbc.getError(); err != nil {
 		return nil, err
 	}
+	if strings.HasPrefix(query, "explain ") {
+		return explainResult, nil
+	}
+	if err := checkSandboxResultLimits(singleRowResult, maxRows, maxBytes); err != nil {
+		return nil, err
+	}
+	if sbc.connectionId != 0 {
+		qr := *singleRowResult
+		qr.ConnectionId = sbc.connectionId
+		return &qr, nil
+	}
 	return singleRowResult, nil
 }
 
-func (sbc *sandboxConn) ExecuteBatch(context interface{}, queries []tproto.BoundQuery, transactionId int64) (*tproto.QueryResultList, error) {
+func (sbc *sandboxConn) ExecuteBatch(context interface{}, queries []tproto.BoundQuery, transactionId int64, maxRows, maxBytes int64) (*tproto.QueryResultList, error) {
 	sbc.ExecCount.Add(1)
+	sbc.addQueries(queries...)
 	if sbc.mustDelay != 0 {
 		time.Sleep(sbc.mustDelay)
 	}
 	if err := sbc.getError(); err != nil {
 		return nil, err
 	}
+	if err := checkSandboxResultLimits(singleRowResult, maxRows, maxBytes); err != nil {
+		return nil, err
+	}
 	qrl := &tproto.QueryResultList{}
 	qrl.List = make([]mproto.QueryResult, 0, len(queries))
 	for _ = range queries {
@@ -296,15 +333,32 @@ func (sbc *sandboxConn) ExecuteBatch(context interface{}, queries []tproto.Bound
 	return qrl, nil
 }
 
+// checkSandboxResultLimits fails like vttablet does when qr is
+// past maxRows or maxBytes.
+func checkSandboxResultLimits(qr *mproto.QueryResult, maxRows, maxBytes int64) error {
+	var size int64
+	for _, row := range qr.Rows {
+		for _, value := range row {
+			size += int64(len(value.Raw()))
+		}
+	}
+	if (maxRows > 0 && int64(len(qr.Rows)) > maxRows) || (maxBytes > 0 && size > maxBytes) {
+		return &tabletconn.ServerError{Code: tabletconn.ERR_NORMAL, Err: "error: result too large"}
+	}
+	return nil
+}
+
 func (sbc *sandboxConn) StreamExecute(context interface{}, query string, bindVars map[string]interface{}, transactionId int64) (<-chan *mproto.QueryResult, tabletconn.ErrFunc) {
 	sbc.ExecCount.Add(1)
 	if sbc.mustDelay != 0 {
 		time.Sleep(sbc.mustDelay)
 	}
 	ch := make(chan *mproto.QueryResult, 1)
-	ch <- singleRowResult
-	close(ch)
 	err := sbc.getError()
+	if err == nil {
+		ch <- singleRowResult
+	}
+	close(ch)
 	return ch, func() error { return err }
 }
 
@@ -348,6 +402,22 @@ func (sbc *sandboxConn) EndPoint() topo.EndPoint {
 	return sbc.endPoint
 }
 
+// explainResult is the fake plan of the "explain" queries.
+var explainResult = &mproto.QueryResult{
+	Fields: []mproto.Field{
+		{Name: "id", Type: 8},
+		{Name: "select_type", Type: 253},
+		{Name: "table", Type: 253},
+		{Name: "key", Type: 253}},
+	RowsAffected: 1,
+	Rows: [][]sqltypes.Value{{
+		{Inner: sqltypes.Numeric("1")},
+		{Inner: sqltypes.String("SIMPLE")},
+		{Inner: sqltypes.String("t")},
+		{Inner: sqltypes.String("PRIMARY")},
+	}},
+}
+
 var singleRowResult = &mproto.QueryResult{
 	Fields: []mproto.Field{
 		{"id", 3},
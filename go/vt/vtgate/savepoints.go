@@ -0,0 +1,191 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/youtube/vitess/go/vt/concurrency"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// savepointNameRegexp matches the valid savepoint names.
+var savepointNameRegexp = regexp.MustCompile(`^\w+$`)
+
+// Savepoint sets the savepoint name in the transaction of session,
+// on the shards it's open on. A savepoint with the same name is
+// replaced. The shards that join the transaction later don't have
+// it: RollbackToSavepoint rolls back their whole transaction.
+func (stc *ScatterConn) Savepoint(context interface{}, name string, session *SafeSession) error {
+	if err := checkSavepoint(name, session); err != nil {
+		return err
+	}
+	shardSessions := session.openShardSessions()
+	if err := stc.execSavepoint(context, "savepoint "+name, shardSessions, session); err != nil {
+		return err
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.Savepoints = append(removeSavepoint(session.Savepoints, name), name)
+	for _, shardSession := range shardSessions {
+		shardSession.Savepoints = append(removeSavepoint(shardSession.Savepoints, name), name)
+	}
+	return nil
+}
+
+// ReleaseSavepoint removes the savepoint name, and the ones set after
+// it, from the transaction of session.
+func (stc *ScatterConn) ReleaseSavepoint(context interface{}, name string, session *SafeSession) error {
+	if err := checkSavepoint(name, session); err != nil {
+		return err
+	}
+	index, withIt, _ := session.findSavepoint(name)
+	if index == -1 {
+		return fmt.Errorf("savepoint %v does not exist", name)
+	}
+	if err := stc.execSavepoint(context, "release savepoint "+name, withIt, session); err != nil {
+		return err
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.Savepoints = session.Savepoints[:index]
+	for _, shardSession := range withIt {
+		shardSession.Savepoints = shardSession.Savepoints[:savepointIndex(shardSession.Savepoints, name)]
+	}
+	return nil
+}
+
+// RollbackToSavepoint rolls back the transaction of session to the
+// savepoint name, which is kept, and removes the ones set after it.
+// Only the shards that have the savepoint are rolled back to it: the
+// transactions of those that joined later are rolled back entirely,
+// and they leave the session.
+func (stc *ScatterConn) RollbackToSavepoint(context interface{}, name string, session *SafeSession) error {
+	if err := checkSavepoint(name, session); err != nil {
+		return err
+	}
+	index, withIt, withoutIt := session.findSavepoint(name)
+	if index == -1 {
+		return fmt.Errorf("savepoint %v does not exist", name)
+	}
+	if err := stc.execSavepoint(context, "rollback to savepoint "+name, withIt, session); err != nil {
+		return err
+	}
+	for _, shardSession := range withoutIt {
+		sdc := stc.getConnection(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, session.Workload())
+		if err := sdc.Rollback(context, shardSession.TransactionId); err != nil {
+			return err
+		}
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.Savepoints = session.Savepoints[:index+1]
+	for _, shardSession := range withIt {
+		shardSession.Savepoints = shardSession.Savepoints[:savepointIndex(shardSession.Savepoints, name)+1]
+	}
+	kept := session.ShardSessions[:0]
+	for _, shardSession := range session.ShardSessions {
+		if savepointIndex(shardSession.Savepoints, name) != -1 || shardSession.TransactionId == 0 {
+			kept = append(kept, shardSession)
+		}
+	}
+	session.ShardSessions = kept
+	return nil
+}
+
+// execSavepoint runs the savepoint statement sql on shardSessions
+// in parallel. With -tx_failover_policy=replay, it's recorded in the
+// replay log with the other statements of the transactions.
+func (stc *ScatterConn) execSavepoint(context interface{}, sql string, shardSessions []*proto.ShardSession, session *SafeSession) error {
+	var wg sync.WaitGroup
+	allErrors := new(concurrency.AllErrorRecorder)
+	for _, shardSession := range shardSessions {
+		wg.Add(1)
+		go func(shardSession *proto.ShardSession) {
+			defer wg.Done()
+			sdc := stc.getConnection(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, session.Workload())
+			if _, err := sdc.Execute(context, sql, nil, shardSession.TransactionId, session); err != nil {
+				allErrors.RecordError(err)
+				return
+			}
+			if *txFailoverPolicy == TX_FAILOVER_REPLAY {
+				stc.replayLog.record(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, shardSession.TransactionId, []tproto.BoundQuery{{Sql: sql}}, *txReplayMaxStatements)
+			}
+		}(shardSession)
+	}
+	wg.Wait()
+	return allErrors.Error()
+}
+
+// checkSavepoint returns an error if name can't be a savepoint
+// of session.
+func checkSavepoint(name string, session *SafeSession) error {
+	if !session.InTransaction() {
+		return fmt.Errorf("savepoint %v: not in transaction", name)
+	}
+	if !savepointNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid savepoint name: %q", name)
+	}
+	return nil
+}
+
+// openShardSessions returns the shard sessions whose
+// transaction isn't lost.
+func (session *SafeSession) openShardSessions() []*proto.ShardSession {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	var open []*proto.ShardSession
+	for _, shardSession := range session.ShardSessions {
+		if shardSession.TransactionId != 0 {
+			open = append(open, shardSession)
+		}
+	}
+	return open
+}
+
+// findSavepoint returns the index of name in the savepoints of the
+// session, -1 if it has no such savepoint, and its open shard
+// sessions with and without it.
+func (session *SafeSession) findSavepoint(name string) (index int, withIt, withoutIt []*proto.ShardSession) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	index = savepointIndex(session.Savepoints, name)
+	for _, shardSession := range session.ShardSessions {
+		switch {
+		case shardSession.TransactionId == 0:
+		case savepointIndex(shardSession.Savepoints, name) != -1:
+			withIt = append(withIt, shardSession)
+		default:
+			withoutIt = append(withoutIt, shardSession)
+		}
+	}
+	return index, withIt, withoutIt
+}
+
+func savepointIndex(savepoints []string, name string) int {
+	for i, savepoint := range savepoints {
+		if savepoint == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeSavepoint returns savepoints without name.
+func removeSavepoint(savepoints []string, name string) []string {
+	i := savepointIndex(savepoints, name)
+	if i == -1 {
+		return savepoints
+	}
+	removed := make([]string, 0, len(savepoints))
+	removed = append(removed, savepoints[:i]...)
+	return append(removed, savepoints[i+1:]...)
+}
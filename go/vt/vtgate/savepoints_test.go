@@ -0,0 +1,116 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+func lastQuery(sbc *sandboxConn) string {
+	sbc.queriesMu.Lock()
+	defer sbc.queriesMu.Unlock()
+	if len(sbc.Queries) == 0 {
+		return ""
+	}
+	return sbc.Queries[len(sbc.Queries)-1].Sql
+}
+
+func TestScatterConnSavepoints(t *testing.T) {
+	resetSandbox()
+	sbc0 := &sandboxConn{}
+	testConns[0] = sbc0
+	sbc1 := &sandboxConn{}
+	testConns[1] = sbc1
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+	defer stc.Close()
+
+	if err := stc.Savepoint(nil, "sp1", NewSafeSession(new(proto.Session))); err == nil {
+		t.Errorf("want error outside of a transaction, got nil")
+	}
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	if err := stc.Savepoint(nil, "sp1; drop table a", session); err == nil {
+		t.Errorf("want error for an invalid name, got nil")
+	}
+
+	// Shard 0 gets sp1, both shards get sp2.
+	stc.Execute(nil, "query1", nil, "", []string{"0"}, "", "", session)
+	if err := stc.Savepoint(nil, "sp1", session); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if got := lastQuery(sbc0); got != "savepoint sp1" {
+		t.Errorf("want savepoint sp1, got %v", got)
+	}
+	stc.Execute(nil, "query1", nil, "", []string{"0", "1"}, "", "", session)
+	if err := stc.Savepoint(nil, "sp2", session); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if want := []string{"sp1", "sp2"}; !reflect.DeepEqual(session.Savepoints, want) {
+		t.Errorf("want %v, got %v", want, session.Savepoints)
+	}
+	if want := []string{"sp2"}; !reflect.DeepEqual(session.ShardSessions[1].Savepoints, want) {
+		t.Errorf("want %v, got %v", want, session.ShardSessions[1].Savepoints)
+	}
+
+	// Shard 1 joined after sp1: its transaction is rolled back.
+	if err := stc.RollbackToSavepoint(nil, "sp1", session); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if got := lastQuery(sbc0); got != "rollback to savepoint sp1" {
+		t.Errorf("want rollback to savepoint sp1, got %v", got)
+	}
+	if sbc1.RollbackCount.Get() != 1 {
+		t.Errorf("want 1 rollback, got %v", sbc1.RollbackCount.Get())
+	}
+	if len(session.ShardSessions) != 1 || session.ShardSessions[0].Shard != "0" {
+		t.Errorf("want shard 0 only, got %+v", session.ShardSessions)
+	}
+	if want := []string{"sp1"}; !reflect.DeepEqual(session.Savepoints, want) || !reflect.DeepEqual(session.ShardSessions[0].Savepoints, want) {
+		t.Errorf("want %v, got %v, %v", want, session.Savepoints, session.ShardSessions[0].Savepoints)
+	}
+
+	if err := stc.ReleaseSavepoint(nil, "sp1", session); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if got := lastQuery(sbc0); got != "release savepoint sp1" {
+		t.Errorf("want release savepoint sp1, got %v", got)
+	}
+	if len(session.Savepoints) != 0 || len(session.ShardSessions[0].Savepoints) != 0 {
+		t.Errorf("want no savepoints, got %v, %v", session.Savepoints, session.ShardSessions[0].Savepoints)
+	}
+	if err := stc.RollbackToSavepoint(nil, "sp1", session); err == nil {
+		t.Errorf("want error for a released savepoint, got nil")
+	}
+}
+
+func TestVTGateSavepoint(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	mapTestConn("60-80", sbc)
+	q := proto.QueryShard{
+		Sql:     "query1",
+		Shards:  []string{"60-80"},
+		Session: new(proto.Session),
+	}
+	RpcVTGate.Begin(nil, q.Session)
+	qr := new(proto.QueryResult)
+	if err := RpcVTGate.ExecuteShard(nil, &q, qr); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+
+	session := new(proto.Session)
+	if err := RpcVTGate.Savepoint(nil, &proto.SavepointRequest{Name: "sp1", Session: qr.Session}, session); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if want := []string{"sp1"}; !reflect.DeepEqual(session.Savepoints, want) || !reflect.DeepEqual(session.ShardSessions[0].Savepoints, want) {
+		t.Errorf("want %v, got %v, %v", want, session.Savepoints, session.ShardSessions[0].Savepoints)
+	}
+	if err := RpcVTGate.Commit(nil, session); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+}
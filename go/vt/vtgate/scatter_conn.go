@@ -5,12 +5,18 @@
 package vtgate
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/flagutil"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/sync2"
 	"github.com/youtube/vitess/go/vt/concurrency"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
@@ -20,6 +26,75 @@ import (
 
 var idGen sync2.AtomicInt64
 
+var quorumAbandons = stats.NewCounters("VtgateQuorumAbandonedShards")
+
+var (
+	// shardQueries times the queries sent to each shard, by
+	// keyspace.shard.tablet_type. shardQueryErrors counts those
+	// that failed.
+	shardQueries     = stats.NewTimings("VtgateShardQueries")
+	shardQueryErrors = stats.NewCounters("VtgateShardQueryErrors")
+)
+
+// recordShardQuery records a query to keyspace.shard.tabletType
+// started at startTime, which failed if err isn't nil.
+func recordShardQuery(keyspace, shard string, tabletType topo.TabletType, startTime time.Time, err error) {
+	name := fmt.Sprintf("%s.%s.%s", keyspace, shard, tabletType)
+	shardQueries.Record(name, startTime)
+	if err != nil {
+		shardQueryErrors.Add(name, 1)
+	}
+}
+
+const (
+	// TX_FAILOVER_FAIL fails the operation that finds its transaction
+	// lost with a TX_LOST_ERR error, and rolls back the session.
+	TX_FAILOVER_FAIL = "fail"
+	// TX_FAILOVER_REPLAY begins a new transaction on the new master,
+	// replays the statements executed so far and retries the operation.
+	// Transactions that executed streaming queries, or more than
+	// -tx_replay_max_statements statements, fail as with TX_FAILOVER_FAIL,
+	// like the ones evicted from the replay log (see txReplayLog).
+	TX_FAILOVER_REPLAY = "replay"
+)
+
+// readSplitKeyspaces are the keyspaces whose reads sent to the master
+// outside of a transaction can go to a replica instead.
+var readSplitKeyspaces flagutil.StringListValue
+
+func init() {
+	flag.Var(&readSplitKeyspaces, "read_split_keyspaces", "comma separated list of keyspaces whose non-transactional master reads are sent to replicas")
+}
+
+var (
+	shardConnIdleTimeout   = flag.Duration("shard_conn_idle_timeout", 10*time.Minute, "close the tablet connections of a shard unused for that long (0 to keep them open)")
+	shardConnTxIdleTimeout = flag.Duration("shard_conn_tx_idle_timeout", time.Hour, "same as shard_conn_idle_timeout, for the shards with open transactions, which are rolled back before closing the connections")
+	shardConnReapInterval  = flag.Duration("shard_conn_reap_interval", time.Minute, "how often to look for idle tablet connections")
+)
+
+// scatterParallelism bounds the number of shards a single query
+// runs on at the same time.
+var scatterParallelism = flag.Int("scatter_parallelism", 0, "max number of shards a query runs on concurrently (0 for all of them)")
+
+// streamStallTimeout bounds how long a streaming query waits for its
+// client to take a result, see forwardStream.
+var streamStallTimeout = flag.Duration("stream_stall_timeout", 0, "abort the streaming queries whose client doesn't take a result for that long, by closing their tablet connection, which also fails its other requests (0 to wait forever)")
+
+// streamStalls counts the streams aborted by stream_stall_timeout.
+var streamStalls = stats.NewInt("VtgateStreamStalls")
+
+// ErrStreamStalled is returned when the client of a streaming
+// query stopped taking its results (see -stream_stall_timeout).
+var ErrStreamStalled = errors.New("vtgate: client stalled, stream aborted")
+
+// idleShardConnsClosed counts the ShardConns closed for being idle.
+var idleShardConnsClosed = stats.NewInt("VtgateIdleShardConnsClosed")
+
+var (
+	txFailoverPolicy      = flag.String("tx_failover_policy", TX_FAILOVER_FAIL, "what to do with transactions lost in a master failover: fail or replay")
+	txReplayMaxStatements = flag.Int("tx_replay_max_statements", 100, "max number of statements a transaction can execute and still be replayed after a failover")
+)
+
 // ScatterConn is used for executing queries across
 // multiple ShardConn connections.
 type ScatterConn struct {
@@ -31,6 +106,17 @@ type ScatterConn struct {
 
 	mu         sync.Mutex
 	shardConns map[string]*ShardConn
+
+	// inFlight tracks the VTGate calls using this ScatterConn,
+	// see VTGate.ReloadTopo.
+	inFlight sync.WaitGroup
+
+	// reaperDone stops reapIdleShardConns, see Close.
+	reaperDone chan struct{}
+
+	// replayLog has the statements of the open transactions,
+	// for -tx_failover_policy=replay.
+	replayLog *txReplayLog
 }
 
 // shardActionFunc defines the contract for a shard action. Every such function
@@ -42,18 +128,63 @@ type shardActionFunc func(conn *ShardConn, transactionId int64, sResults chan<-
 
 // NewScatterConn creates a new ScatterConn. All input parameters are passed through
 // for creating the appropriate ShardConn.
+// It closes the idle ones in the background, see reapIdleShardConns.
 func NewScatterConn(serv SrvTopoServer, cell string, retryDelay time.Duration, retryCount int, timeout time.Duration) *ScatterConn {
-	return &ScatterConn{
+	stc := &ScatterConn{
 		toposerv:   serv,
 		cell:       cell,
 		retryDelay: retryDelay,
 		retryCount: retryCount,
 		timeout:    timeout,
 		shardConns: make(map[string]*ShardConn),
+		replayLog:  newTxReplayLog(*txReplayLogSize),
+	}
+	if *shardConnIdleTimeout > 0 && *shardConnReapInterval > 0 {
+		stc.reaperDone = make(chan struct{})
+		go stc.reapLoop(*shardConnReapInterval, stc.reaperDone)
+	}
+	return stc
+}
+
+func (stc *ScatterConn) reapLoop(interval time.Duration, done chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			stc.reapIdleShardConns(now, *shardConnIdleTimeout, *shardConnTxIdleTimeout)
+		}
+	}
+}
+
+// reapIdleShardConns closes the tablet connections of the ShardConns
+// idle for idleTimeout, or txIdleTimeout with open transactions
+// (see ShardConn.CloseIfIdle). It returns how many it closed.
+func (stc *ScatterConn) reapIdleShardConns(now time.Time, idleTimeout, txIdleTimeout time.Duration) int {
+	stc.mu.Lock()
+	shardConns := make(map[string]*ShardConn, len(stc.shardConns))
+	for key, sdc := range stc.shardConns {
+		shardConns[key] = sdc
 	}
+	stc.mu.Unlock()
+
+	closed := 0
+	for key, sdc := range shardConns {
+		if sdc.CloseIfIdle(now, idleTimeout, txIdleTimeout) {
+			log.Infof("closed the idle tablet connections of %v", key)
+			closed++
+		}
+	}
+	idleShardConnsClosed.Add(int64(closed))
+	return closed
 }
 
 // Execute executes a non-streaming query on the specified shards.
+// Outside of transactions, a non-empty affinityKey sends it to the
+// tablet the key hashes to in each shard (see Balancer.GetAffinity).
+// The merged result is bound by -max_result_rows and -max_result_bytes.
 func (stc *ScatterConn) Execute(
 	context interface{},
 	query string,
@@ -61,16 +192,29 @@ func (stc *ScatterConn) Execute(
 	keyspace string,
 	shards []string,
 	tabletType topo.TabletType,
+	affinityKey string,
 	session *SafeSession,
 ) (*mproto.QueryResult, error) {
+	if session.MaxExecutionTimeHint() {
+		query = addMaxExecutionTime(query, stc.timeout)
+	}
+	tabletType = upgradedTabletType(tabletType, session)
+	tabletType = readSplitTabletType(query, keyspace, tabletType, session)
 	results, allErrors := stc.multiGo(
 		context,
 		keyspace,
 		shards,
-		tabletType,
+		tabletTypeChain(tabletType, session, isDML(query)),
 		session,
+		[]tproto.BoundQuery{{Sql: query, BindVariables: bindVars}},
 		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
-			innerqr, err := sdc.Execute(context, query, bindVars, transactionId)
+			var innerqr *mproto.QueryResult
+			var err error
+			if affinityKey != "" && transactionId == 0 {
+				innerqr, err = sdc.ExecuteWithAffinity(context, query, bindVars, affinityKey, session)
+			} else {
+				innerqr, err = sdc.Execute(context, query, bindVars, transactionId, session)
+			}
 			if err != nil {
 				return err
 			}
@@ -79,9 +223,73 @@ func (stc *ScatterConn) Execute(
 		})
 
 	qr := new(mproto.QueryResult)
+	count := 0
+	var size resultSize
+	var sizeErr error
 	for innerqr := range results {
 		innerqr := innerqr.(*mproto.QueryResult)
-		appendResult(qr, innerqr)
+		if sizeErr != nil {
+			continue
+		}
+		if sizeErr = size.add(innerqr.Rows); sizeErr != nil {
+			// Let the rows go, we're only draining the results now.
+			allErrors.RecordError(sizeErr)
+			qr.Rows = nil
+			continue
+		}
+		if err := appendResult(qr, innerqr); err != nil {
+			allErrors.RecordError(err)
+		}
+		count++
+		// A MySQL connection id only makes sense for a single shard.
+		if count == 1 {
+			qr.ConnectionId = innerqr.ConnectionId
+		} else {
+			qr.ConnectionId = 0
+		}
+	}
+	if allErrors.HasErrors() {
+		return nil, allErrors.Error()
+	}
+	return qr, nil
+}
+
+// Explain returns the MySQL plan of a select on each of the specified
+// shards, without executing it. The tablets run EXPLAIN on the query
+// they would send to MySQL. A first "shard" column tells the shards
+// apart. Other statements can't be explained.
+func (stc *ScatterConn) Explain(
+	context interface{},
+	query string,
+	bindVars map[string]interface{},
+	keyspace string,
+	shards []string,
+	tabletType topo.TabletType,
+) (*mproto.QueryResult, error) {
+	if fields := strings.Fields(strings.ToLower(query)); len(fields) == 0 || fields[0] != "select" {
+		return nil, fmt.Errorf("EXPLAIN is only supported for selects: %v", query)
+	}
+	results, allErrors := stc.multiGo(
+		context,
+		keyspace,
+		shards,
+		[]topo.TabletType{tabletType},
+		nil,
+		nil,
+		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
+			innerqr, err := sdc.Execute(context, "explain "+query, bindVars, 0, nil)
+			if err != nil {
+				return err
+			}
+			sResults <- withShardColumn(innerqr, sdc.shard)
+			return nil
+		})
+
+	qr := new(mproto.QueryResult)
+	for innerqr := range results {
+		if err := appendResult(qr, innerqr.(*mproto.QueryResult)); err != nil {
+			allErrors.RecordError(err)
+		}
 	}
 	if allErrors.HasErrors() {
 		return nil, allErrors.Error()
@@ -89,6 +297,88 @@ func (stc *ScatterConn) Execute(
 	return qr, nil
 }
 
+// ExecuteQuorum executes a select on the specified shards, and returns
+// as soon as quorum of them answered, without waiting for the others.
+// This bounds the latency of reads that can do with approximate results
+// to the one of the fastest shards. It also returns the shards whose rows
+// are in the result. It fails if fewer than quorum shards answer within
+// the timeout.
+func (stc *ScatterConn) ExecuteQuorum(
+	context interface{},
+	query string,
+	bindVars map[string]interface{},
+	keyspace string,
+	shards []string,
+	tabletType topo.TabletType,
+	quorum int,
+) (*mproto.QueryResult, []string, error) {
+	if !isRead(query) {
+		return nil, nil, fmt.Errorf("a quorum is only supported for selects: %v", query)
+	}
+	shardCount := len(unique(shards))
+	if quorum < 1 || quorum > shardCount {
+		return nil, nil, fmt.Errorf("invalid quorum %v for %v shards", quorum, shardCount)
+	}
+	type shardResult struct {
+		shard string
+		qr    *mproto.QueryResult
+	}
+	// results is buffered for all the shards, so the ones that
+	// answer after the quorum is reached don't block.
+	results, allErrors := stc.multiGo(
+		context,
+		keyspace,
+		shards,
+		[]topo.TabletType{tabletType},
+		nil,
+		nil,
+		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
+			innerqr, err := sdc.Execute(context, query, bindVars, 0, nil)
+			if err != nil {
+				return err
+			}
+			sResults <- &shardResult{shard: sdc.shard, qr: innerqr}
+			return nil
+		})
+
+	qr := new(mproto.QueryResult)
+	var size resultSize
+	var answered []string
+	deadline := time.NewTimer(stc.timeout)
+	defer deadline.Stop()
+	for len(answered) < quorum {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				return nil, nil, fmt.Errorf("only %v of %v shards answered, quorum is %v: %v", len(answered), shardCount, quorum, allErrors.Error())
+			}
+			sr := result.(*shardResult)
+			if err := size.add(sr.qr.Rows); err != nil {
+				return nil, nil, err
+			}
+			if err := appendResult(qr, sr.qr); err != nil {
+				return nil, nil, err
+			}
+			answered = append(answered, sr.shard)
+		case <-deadline.C:
+			return nil, nil, fmt.Errorf("only %v of %v shards answered within %v, quorum is %v", len(answered), shardCount, stc.timeout, quorum)
+		}
+	}
+	quorumAbandons.Add(keyspace, int64(shardCount-len(answered)))
+	return qr, answered, nil
+}
+
+// withShardColumn returns qr with a first "shard" column set to shard.
+func withShardColumn(qr *mproto.QueryResult, shard string) *mproto.QueryResult {
+	out := *qr
+	out.Fields = append([]mproto.Field{{Name: "shard", Type: mproto.VT_VAR_STRING}}, qr.Fields...)
+	out.Rows = make([][]sqltypes.Value, len(qr.Rows))
+	for i, row := range qr.Rows {
+		out.Rows[i] = append([]sqltypes.Value{sqltypes.MakeString([]byte(shard))}, row...)
+	}
+	return &out
+}
+
 // ExecuteBatch executes a batch of non-streaming queries on the specified shards.
 func (stc *ScatterConn) ExecuteBatch(
 	context interface{},
@@ -98,14 +388,30 @@ func (stc *ScatterConn) ExecuteBatch(
 	tabletType topo.TabletType,
 	session *SafeSession,
 ) (qrs *tproto.QueryResultList, err error) {
+	tabletType = upgradedTabletType(tabletType, session)
+	if session.MaxExecutionTimeHint() {
+		hinted := make([]tproto.BoundQuery, len(queries))
+		for i, query := range queries {
+			hinted[i] = tproto.BoundQuery{
+				Sql:           addMaxExecutionTime(query.Sql, stc.timeout),
+				BindVariables: query.BindVariables,
+			}
+		}
+		queries = hinted
+	}
+	write := false
+	for _, query := range queries {
+		write = write || isDML(query.Sql)
+	}
 	results, allErrors := stc.multiGo(
 		context,
 		keyspace,
 		shards,
-		tabletType,
+		tabletTypeChain(tabletType, session, write),
 		session,
+		queries,
 		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
-			innerqrs, err := sdc.ExecuteBatch(context, queries, transactionId)
+			innerqrs, err := sdc.ExecuteBatch(context, queries, transactionId, session)
 			if err != nil {
 				return err
 			}
@@ -115,10 +421,22 @@ func (stc *ScatterConn) ExecuteBatch(
 
 	qrs = &tproto.QueryResultList{}
 	qrs.List = make([]mproto.QueryResult, len(queries))
+	sizes := make([]resultSize, len(queries))
+	var sizeErr error
 	for innerqr := range results {
 		innerqr := innerqr.(*tproto.QueryResultList)
+		if sizeErr != nil {
+			continue
+		}
 		for i := range qrs.List {
-			appendResult(&qrs.List[i], &innerqr.List[i])
+			if sizeErr = sizes[i].add(innerqr.List[i].Rows); sizeErr != nil {
+				allErrors.RecordError(sizeErr)
+				qrs.List = nil
+				break
+			}
+			if err := appendResult(&qrs.List[i], &innerqr.List[i]); err != nil {
+				allErrors.RecordError(err)
+			}
 		}
 	}
 	if allErrors.HasErrors() {
@@ -127,7 +445,26 @@ func (stc *ScatterConn) ExecuteBatch(
 	return qrs, nil
 }
 
+// STREAM_TRUNCATED_ERR prefixes the error of a StreamTruncatedError.
+const STREAM_TRUNCATED_ERR = "stream truncated"
+
+// StreamTruncatedError is the error of a streaming query that failed
+// after sending replies: the Rows rows already sent are valid, but
+// the others are missing. A client that needs all the rows has to
+// run the query again from scratch.
+type StreamTruncatedError struct {
+	Rows int64
+	Err  error
+}
+
+func (e *StreamTruncatedError) Error() string {
+	return fmt.Sprintf("%s after %d rows: %v", STREAM_TRUNCATED_ERR, e.Rows, e.Err)
+}
+
 // StreamExecute executes a streaming query on vttablet. The retry rules are the same.
+// Streaming queries have no timeout, so they never get a MAX_EXECUTION_TIME hint.
+// Once sendReply returns an error, it isn't called anymore. If the query
+// fails after replies were sent, the error is a StreamTruncatedError.
 func (stc *ScatterConn) StreamExecute(
 	context interface{},
 	query string,
@@ -138,41 +475,134 @@ func (stc *ScatterConn) StreamExecute(
 	session *SafeSession,
 	sendReply func(reply *mproto.QueryResult) error,
 ) error {
+	tabletType = upgradedTabletType(tabletType, session)
+	// Past the deadline of the caller, the tablet streams are
+	// aborted and we stop sending replies.
+	var expired chan struct{}
+	if deadline := contextDeadline(context); !deadline.IsZero() {
+		expired = make(chan struct{})
+		timer := time.AfterFunc(deadline.Sub(time.Now()), func() { close(expired) })
+		defer timer.Stop()
+	}
 	results, allErrors := stc.multiGo(
 		context,
 		keyspace,
 		shards,
-		tabletType,
+		tabletTypeChain(tabletType, session, isDML(query)),
 		session,
+		nil,
 		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
-			sr, errFunc := sdc.StreamExecute(context, query, bindVars, transactionId)
-			for qr := range sr {
-				sResults <- qr
+			sr, errFunc, abort := sdc.StreamExecute(context, query, bindVars, transactionId, session)
+			if err := forwardStream(sr, sResults, *streamStallTimeout, expired, abort); err != nil {
+				return err
 			}
 			return errFunc()
 		})
+	// expired is closed, so it's set to nil once seen.
+	expiredReplies := expired
 	var replyErr error
-	for innerqr := range results {
-		// We still need to finish pumping
-		if replyErr != nil {
-			continue
+	var replies, rows int64
+	for results != nil {
+		select {
+		case innerqr, ok := <-results:
+			if !ok {
+				results = nil
+				break
+			}
+			// We still need to finish pumping
+			if replyErr != nil {
+				continue
+			}
+			qr := innerqr.(*mproto.QueryResult)
+			if replyErr = sendReply(qr); replyErr == nil {
+				replies++
+				rows += int64(len(qr.Rows))
+			}
+		case <-expiredReplies:
+			expiredReplies = nil
+			if replyErr == nil {
+				replyErr = ErrDeadlineExceeded
+			}
 		}
-		replyErr = sendReply(innerqr.(*mproto.QueryResult))
 	}
 	if replyErr != nil {
 		allErrors.RecordError(replyErr)
 	}
+	if allErrors.HasErrors() && replies > 0 {
+		return &StreamTruncatedError{Rows: rows, Err: allErrors.Error()}
+	}
 	return allErrors.Error()
 }
 
+// forwardStream sends the results of sr to sResults, so a client
+// slower than the tablet slows down the stream: the buffering is
+// bounded by the channels. If sResults doesn't take a result within
+// stallTimeout, the tablet stream is aborted, what it already sent is
+// discarded and ErrStreamStalled is returned. A stallTimeout of 0
+// waits forever. Likewise, once expired is closed, the tablet stream
+// is aborted and ErrDeadlineExceeded is returned. A nil expired
+// never expires.
+func forwardStream(sr <-chan *mproto.QueryResult, sResults chan<- interface{}, stallTimeout time.Duration, expired <-chan struct{}, abort func()) error {
+	stop := func(err error) error {
+		abort()
+		for range sr {
+		}
+		return err
+	}
+	for {
+		var qr *mproto.QueryResult
+		select {
+		case result, ok := <-sr:
+			if !ok {
+				return nil
+			}
+			qr = result
+		case <-expired:
+			return stop(ErrDeadlineExceeded)
+		}
+		select {
+		case sResults <- qr:
+			continue
+		default:
+		}
+		var timer *time.Timer
+		var stalled <-chan time.Time
+		if stallTimeout != 0 {
+			timer = time.NewTimer(stallTimeout)
+			stalled = timer.C
+		}
+		var err error
+		select {
+		case sResults <- qr:
+		case <-stalled:
+			streamStalls.Add(1)
+			err = ErrStreamStalled
+		case <-expired:
+			err = ErrDeadlineExceeded
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return stop(err)
+		}
+	}
+}
+
 // Commit commits the current transaction. There are no retries on this operation.
+// The shards are committed one by one, in the order they joined the
+// transaction: if one fails, the next ones are rolled back, but the
+// ones before stay committed. There's no two-phase commit.
 func (stc *ScatterConn) Commit(context interface{}, session *SafeSession) (err error) {
 	if !session.InTransaction() {
 		return fmt.Errorf("cannot commit: not in transaction")
 	}
+	for _, shardSession := range session.ShardSessions {
+		stc.replayLog.end(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, shardSession.TransactionId)
+	}
 	committing := true
 	for _, shardSession := range session.ShardSessions {
-		sdc := stc.getConnection(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		sdc := stc.getConnection(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, session.Workload())
 		if !committing {
 			go sdc.Rollback(context, shardSession.TransactionId)
 			continue
@@ -188,17 +618,46 @@ func (stc *ScatterConn) Commit(context interface{}, session *SafeSession) (err e
 // Rollback rolls back the current transaction. There are no retries on this operation.
 func (stc *ScatterConn) Rollback(context interface{}, session *SafeSession) (err error) {
 	for _, shardSession := range session.ShardSessions {
-		sdc := stc.getConnection(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if shardSession.TransactionId == 0 {
+			// The transaction was lost, there's nothing to roll back.
+			continue
+		}
+		stc.replayLog.end(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, shardSession.TransactionId)
+		sdc := stc.getConnection(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, session.Workload())
 		go sdc.Rollback(context, shardSession.TransactionId)
 	}
 	session.Reset()
 	return nil
 }
 
+// Ping pings the tablets of the shard sessions of session (see
+// ShardConn.Ping), which also keeps their connections from being
+// closed as idle. A session that uses no shard has nothing to ping.
+func (stc *ScatterConn) Ping(context interface{}, session *SafeSession) error {
+	allErrors := new(concurrency.AllErrorRecorder)
+	var wg sync.WaitGroup
+	for _, shardSession := range session.ShardSessions {
+		wg.Add(1)
+		go func(shardSession *proto.ShardSession) {
+			defer wg.Done()
+			sdc := stc.getConnection(shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, session.Workload())
+			if err := sdc.Ping(context, shardSession.TransactionId); err != nil {
+				allErrors.RecordError(err)
+			}
+		}(shardSession)
+	}
+	wg.Wait()
+	return allErrors.Error()
+}
+
 // Close closes the underlying ShardConn connections.
 func (stc *ScatterConn) Close() error {
 	stc.mu.Lock()
 	defer stc.mu.Unlock()
+	if stc.reaperDone != nil {
+		close(stc.reaperDone)
+		stc.reaperDone = nil
+	}
 	for _, v := range stc.shardConns {
 		go v.Close()
 	}
@@ -206,31 +665,47 @@ func (stc *ScatterConn) Close() error {
 	return nil
 }
 
-// multiGo performs the requested 'action' on the specified shards in parallel.
+// multiGo performs the requested 'action' on the specified shards in parallel,
+// -scatter_parallelism of them at a time if it's set.
 // For each shard, it obtains a ShardConn connection. If the requested
 // session is in a transaction, it opens a new transactions on the connection,
 // and updates the Session with the transaction id. If the session already
 // contains a transaction id for the shard, it reuses it.
 // If there are any unrecoverable errors during a transaction, multiGo
 // rolls back the transaction for all shards.
+// statements are the statements the action executes, which are
+// recorded in the replay log for -tx_failover_policy=replay. A nil
+// list means that the action cannot be replayed.
+// tabletTypes is the chain returned by tabletTypeChain: each shard uses
+// the first tablet type it has end points for.
 // The action function must match the shardActionFunc signature.
 func (stc *ScatterConn) multiGo(
 	context interface{},
 	keyspace string,
 	shards []string,
-	tabletType topo.TabletType,
+	tabletTypes []topo.TabletType,
 	session *SafeSession,
+	statements []tproto.BoundQuery,
 	action shardActionFunc,
 ) (rResults <-chan interface{}, allErrors *concurrency.AllErrorRecorder) {
 	allErrors = new(concurrency.AllErrorRecorder)
 	results := make(chan interface{}, len(shards))
 	var wg sync.WaitGroup
+	// At most -scatter_parallelism shards run at the same time.
+	var slots chan struct{}
+	if *scatterParallelism > 0 {
+		slots = make(chan struct{}, *scatterParallelism)
+	}
 	// We need the shards to be unique.
 	for shard := range unique(shards) {
 		wg.Add(1)
 		go func(shard string) {
 			defer wg.Done()
-			stc.execShardAction(context, keyspace, shard, tabletType, session, action, allErrors, results)
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+			stc.execShardAction(context, keyspace, shard, tabletTypes, session, statements, action, allErrors, results)
 		}(shard)
 	}
 	go func() {
@@ -241,7 +716,7 @@ func (stc *ScatterConn) multiGo(
 			if session.InTransaction() {
 				errstr := allErrors.Error().Error()
 				// We cannot recover from these errors
-				if strings.Contains(errstr, "tx_pool_full") || strings.Contains(errstr, "not_in_tx") {
+				if strings.Contains(errstr, "tx_pool_full") || strings.Contains(errstr, "not_in_tx") || strings.Contains(errstr, TX_LOST_ERR) {
 					stc.Rollback(context, session)
 				}
 			}
@@ -254,35 +729,55 @@ func (stc *ScatterConn) multiGo(
 // execShardAction executes the action on a particular shard.
 // If the action fails, it determines whether the keyspace/shard
 // have moved, re-resolves the topology and tries again, if it is
-// not executing a transaction.
+// not executing a transaction. If the transaction was lost
+// in a failover, it applies the -tx_failover_policy.
 func (stc *ScatterConn) execShardAction(
 	context interface{},
 	keyspace string,
 	shard string,
-	tabletType topo.TabletType,
+	tabletTypes []topo.TabletType,
 	session *SafeSession,
+	statements []tproto.BoundQuery,
 	action shardActionFunc,
 	allErrors *concurrency.AllErrorRecorder,
 	results chan interface{},
 ) {
+	startTime := time.Now()
 	for {
-		sdc := stc.getConnection(keyspace, shard, tabletType)
+		tabletType := stc.selectTabletType(keyspace, shard, tabletTypes, session.Workload())
+		tabletType = stc.freshTabletType(keyspace, shard, tabletType, session)
+		sdc := stc.getConnection(keyspace, shard, tabletType, session.Workload())
 		transactionId, err := stc.updateSession(context, sdc, keyspace, shard, tabletType, session)
 		if err != nil {
+			recordShardQuery(keyspace, shard, tabletType, startTime, err)
 			allErrors.RecordError(err)
 			return
 		}
 		err = action(sdc, transactionId, results)
+		if isTxLost(err) {
+			// The transaction is gone, make sure nobody tries to roll it back.
+			session.SetTransactionId(keyspace, shard, tabletType, 0)
+			replayed, replayable := stc.replayLog.take(keyspace, shard, tabletType, transactionId)
+			if *txFailoverPolicy == TX_FAILOVER_REPLAY && statements != nil {
+				if transactionId, err = stc.replayTransaction(context, sdc, keyspace, shard, tabletType, replayed, replayable, session); err == nil {
+					err = action(sdc, transactionId, results)
+				}
+			}
+		}
+		if err == nil && transactionId != 0 && *txFailoverPolicy == TX_FAILOVER_REPLAY {
+			stc.replayLog.record(keyspace, shard, tabletType, transactionId, statements, *txReplayMaxStatements)
+		}
 		// Determine whether keyspace can be re-resolved
 		if shouldResolveKeyspace(err, transactionId) {
 			newKeyspace, err := getKeyspaceAlias(stc.toposerv, stc.cell, keyspace, tabletType)
 			if err == nil && newKeyspace != keyspace {
 				sdc.Close()
-				stc.cleanupShardConn(keyspace, shard, tabletType)
+				stc.cleanupShardConn(keyspace, shard, tabletType, session.Workload())
 				keyspace = newKeyspace
 				continue
 			}
 		}
+		recordShardQuery(keyspace, shard, tabletType, startTime, err)
 		if err != nil {
 			allErrors.RecordError(err)
 			return
@@ -291,22 +786,93 @@ func (stc *ScatterConn) execShardAction(
 	}
 }
 
-func (stc *ScatterConn) cleanupShardConn(keyspace, shard string, tabletType topo.TabletType) {
+// readSplitTabletType returns the tablet type to use for query:
+// replica if it's a read sent to the master of a keyspace listed in
+// -read_split_keyspaces outside of a transaction, tabletType otherwise.
+// The sessions that chose their tablet type, by setting one or by
+// being upgraded, keep it.
+func readSplitTabletType(query, keyspace string, tabletType topo.TabletType, session *SafeSession) topo.TabletType {
+	if tabletType != topo.TYPE_MASTER || session.InTransaction() || !isRead(query) {
+		return tabletType
+	}
+	if session.Upgraded() || session.TabletType() != "" {
+		return tabletType
+	}
+	for _, ks := range readSplitKeyspaces {
+		if ks == keyspace {
+			return topo.TYPE_REPLICA
+		}
+	}
+	return tabletType
+}
+
+// isRead returns true if sql is a select that doesn't lock rows.
+func isRead(sql string) bool {
+	fields := strings.Fields(strings.ToLower(sql))
+	if len(fields) == 0 || fields[0] != "select" {
+		return false
+	}
+	lower := strings.Join(fields, " ")
+	return !strings.Contains(lower, " for update") && !strings.Contains(lower, " lock in share mode")
+}
+
+// upgradedTabletType returns master for the sessions that were
+// upgraded (see VTGate.UpgradeSession), and tabletType for the others.
+func upgradedTabletType(tabletType topo.TabletType, session *SafeSession) topo.TabletType {
+	if session.Upgraded() {
+		return topo.TYPE_MASTER
+	}
+	return tabletType
+}
+
+// tabletTypeChain returns the tablet types a query can use, in order of
+// preference: tabletType, then the session's FallbackTabletTypes. Writes
+// and transactions don't fall back, and nothing after master is used.
+func tabletTypeChain(tabletType topo.TabletType, session *SafeSession, write bool) []topo.TabletType {
+	chain := []topo.TabletType{tabletType}
+	if write || tabletType == topo.TYPE_MASTER || session.InTransaction() {
+		return chain
+	}
+	for _, fallback := range session.FallbackTabletTypes() {
+		if fallback == tabletType {
+			continue
+		}
+		chain = append(chain, fallback)
+		if fallback == topo.TYPE_MASTER {
+			break
+		}
+	}
+	return chain
+}
+
+// selectTabletType returns the first tablet type of the chain that
+// the shard has end points for. The last one is used as a last resort,
+// and reports the errors if it has none.
+func (stc *ScatterConn) selectTabletType(keyspace, shard string, tabletTypes []topo.TabletType, workload string) topo.TabletType {
+	for _, tabletType := range tabletTypes[:len(tabletTypes)-1] {
+		if stc.getConnection(keyspace, shard, tabletType, workload).HasEndPoints() {
+			return tabletType
+		}
+	}
+	return tabletTypes[len(tabletTypes)-1]
+}
+
+func (stc *ScatterConn) cleanupShardConn(keyspace, shard string, tabletType topo.TabletType, workload string) {
 	stc.mu.Lock()
 	defer stc.mu.Unlock()
 
-	key := fmt.Sprintf("%s.%s.%s", keyspace, shard, tabletType)
+	key := fmt.Sprintf("%s.%s.%s.%s", keyspace, shard, tabletType, workload)
 	delete(stc.shardConns, key)
 }
 
-func (stc *ScatterConn) getConnection(keyspace, shard string, tabletType topo.TabletType) *ShardConn {
+func (stc *ScatterConn) getConnection(keyspace, shard string, tabletType topo.TabletType, workload string) *ShardConn {
 	stc.mu.Lock()
 	defer stc.mu.Unlock()
 
-	key := fmt.Sprintf("%s.%s.%s", keyspace, shard, tabletType)
+	key := fmt.Sprintf("%s.%s.%s.%s", keyspace, shard, tabletType, workload)
 	sdc, ok := stc.shardConns[key]
 	if !ok {
-		sdc = NewShardConn(stc.toposerv, stc.cell, keyspace, shard, tabletType, stc.retryDelay, stc.retryCount, stc.timeout)
+		sdc = NewShardConn(stc.toposerv, stc.cell, keyspace, shard, tabletType, workload, stc.retryDelay, stc.retryCount, stc.timeout)
 		stc.shardConns[key] = sdc
 	}
 	return sdc
@@ -330,10 +896,13 @@ func (stc *ScatterConn) updateSession(
 	if transactionId != 0 {
 		return transactionId, nil
 	}
-	transactionId, err = sdc.Begin(context)
+	transactionId, err = sdc.Begin(context, session)
 	if err != nil {
 		return 0, err
 	}
+	if *txFailoverPolicy == TX_FAILOVER_REPLAY {
+		stc.replayLog.begin(keyspace, shard, tabletType, transactionId)
+	}
 	session.Append(&proto.ShardSession{
 		Keyspace:      keyspace,
 		TabletType:    tabletType,
@@ -343,15 +912,76 @@ func (stc *ScatterConn) updateSession(
 	return transactionId, nil
 }
 
-func appendResult(qr, innerqr *mproto.QueryResult) {
+// replayTransaction begins a new transaction for a shard session
+// whose transaction was lost, and replays its statements, taken from
+// the replay log: replayable is false if it had none. It returns the
+// id of the new transaction.
+func (stc *ScatterConn) replayTransaction(
+	context interface{},
+	sdc *ShardConn,
+	keyspace, shard string,
+	tabletType topo.TabletType,
+	statements []tproto.BoundQuery,
+	replayable bool,
+	session *SafeSession,
+) (transactionId int64, err error) {
+	if !replayable {
+		return 0, fmt.Errorf("%s: transaction cannot be replayed, shard: %s.%s.%s", TX_LOST_ERR, keyspace, shard, tabletType)
+	}
+	transactionId, err = sdc.Begin(context, session)
+	if err != nil {
+		return 0, err
+	}
+	if len(statements) != 0 {
+		if _, err = sdc.ExecuteBatch(context, statements, transactionId, session); err != nil {
+			go sdc.Rollback(context, transactionId)
+			return 0, err
+		}
+	}
+	session.SetTransactionId(keyspace, shard, tabletType, transactionId)
+	stc.replayLog.begin(keyspace, shard, tabletType, transactionId)
+	stc.replayLog.record(keyspace, shard, tabletType, transactionId, statements, *txReplayMaxStatements)
+	return transactionId, nil
+}
+
+// addMaxExecutionTime adds a MAX_EXECUTION_TIME optimizer hint to sql
+// if it's a select, so MySQL aborts it after timeout even if we don't.
+func addMaxExecutionTime(sql string, timeout time.Duration) string {
+	trimmed := strings.TrimLeft(sql, " \t\r\n")
+	if len(trimmed) < 6 || !strings.EqualFold(trimmed[:6], "select") {
+		return sql
+	}
+	return fmt.Sprintf("%s /*+ MAX_EXECUTION_TIME(%d) */%s", trimmed[:6], timeout/time.Millisecond, trimmed[6:])
+}
+
+// appendResult merges the result of a shard, innerqr, into qr. It
+// returns an error if both have fields, and they don't have the same
+// names: the rows would not line up. Their types can differ, e.g. for
+// a column that's NULL on a shard.
+func appendResult(qr, innerqr *mproto.QueryResult) error {
 	if qr.Fields == nil {
 		qr.Fields = innerqr.Fields
+	} else if innerqr.Fields != nil && !sameFieldNames(qr.Fields, innerqr.Fields) {
+		return fmt.Errorf("shards returned different fields: %v and %v", qr.Fields, innerqr.Fields)
 	}
 	qr.RowsAffected += innerqr.RowsAffected
 	if innerqr.InsertId != 0 {
 		qr.InsertId = innerqr.InsertId
 	}
 	qr.Rows = append(qr.Rows, innerqr.Rows...)
+	return nil
+}
+
+func sameFieldNames(fields1, fields2 []mproto.Field) bool {
+	if len(fields1) != len(fields2) {
+		return false
+	}
+	for i := range fields1 {
+		if fields1[i].Name != fields2[i].Name {
+			return false
+		}
+	}
+	return true
 }
 
 func unique(in []string) map[string]struct{} {
@@ -362,6 +992,13 @@ func unique(in []string) map[string]struct{} {
 	return out
 }
 
+func isTxLost(err error) bool {
+	if shardConnErr, ok := err.(*ShardConnError); ok {
+		return shardConnErr.txLost
+	}
+	return false
+}
+
 func shouldResolveKeyspace(err error, transactionId int64) bool {
 	if err == nil || transactionId != 0 {
 		return false
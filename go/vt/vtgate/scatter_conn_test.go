@@ -7,11 +7,14 @@ package vtgate
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 )
 
@@ -20,7 +23,7 @@ import (
 func TestScatterConnExecute(t *testing.T) {
 	testScatterConnGeneric(t, func(shards []string) (*mproto.QueryResult, error) {
 		stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
-		return stc.Execute(nil, "query", nil, "", shards, "", nil)
+		return stc.Execute(nil, "query", nil, "", shards, "", "", nil)
 	})
 }
 
@@ -48,6 +51,32 @@ func TestScatterConnStreamExecute(t *testing.T) {
 	})
 }
 
+func TestScatterConnStreamExecuteTruncated(t *testing.T) {
+	resetSandbox()
+	testConns[0] = &sandboxConn{}
+	testConns[1] = &sandboxConn{mustFailServer: 1, mustDelay: 20 * time.Millisecond}
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+	defer stc.Close()
+
+	// Shard 0 sends its row, then shard 1 fails.
+	var rows int
+	err := stc.StreamExecute(nil, "query", nil, "", []string{"0", "1"}, "", nil, func(r *mproto.QueryResult) error {
+		rows += len(r.Rows)
+		return nil
+	})
+	truncated, ok := err.(*StreamTruncatedError)
+	if !ok {
+		t.Fatalf("want a StreamTruncatedError, got %v", err)
+	}
+	if truncated.Rows != 1 || rows != 1 {
+		t.Errorf("want 1 row sent, got %v (%v received)", truncated.Rows, rows)
+	}
+	want := "stream truncated after 1 rows: error: err, shard, host: .1."
+	if !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("want %v, got %v", want, err)
+	}
+}
+
 func testScatterConnGeneric(t *testing.T, f func(shards []string) (*mproto.QueryResult, error)) {
 	// no shard
 	resetSandbox()
@@ -64,7 +93,7 @@ func testScatterConnGeneric(t *testing.T, f func(shards []string) (*mproto.Query
 	sbc := &sandboxConn{mustFailServer: 1}
 	testConns[0] = sbc
 	qr, err = f([]string{"0"})
-	want := "error: err, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1]}"
+	want := "error: err, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1] Workload: Health:0 Weight:0 HealthMap:map[]}"
 	// Verify server error string.
 	if err == nil || err.Error() != want {
 		t.Errorf("want %s, got %v", want, err)
@@ -82,7 +111,7 @@ func testScatterConnGeneric(t *testing.T, f func(shards []string) (*mproto.Query
 	testConns[1] = sbc1
 	_, err = f([]string{"0", "1"})
 	// Verify server errors are consolidated.
-	want = "error: err, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1]}\nerror: err, shard, host: .1., {Uid:1 Host:1 NamedPortMap:map[vt:1]}"
+	want = "error: err, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1] Workload: Health:0 Weight:0 HealthMap:map[]}\nerror: err, shard, host: .1., {Uid:1 Host:1 NamedPortMap:map[vt:1] Workload: Health:0 Weight:0 HealthMap:map[]}"
 	if err == nil || err.Error() != want {
 		t.Errorf("\nwant\n%s\ngot\n%v", want, err)
 	}
@@ -153,7 +182,7 @@ func TestScatterConnCommitSuccess(t *testing.T) {
 
 	// Sequence the executes to ensure commit order
 	session := NewSafeSession(&proto.Session{InTransaction: true})
-	stc.Execute(nil, "query1", nil, "", []string{"0"}, "", session)
+	stc.Execute(nil, "query1", nil, "", []string{"0"}, "", "", session)
 	wantSession := proto.Session{
 		InTransaction: true,
 		ShardSessions: []*proto.ShardSession{{
@@ -166,7 +195,7 @@ func TestScatterConnCommitSuccess(t *testing.T) {
 	if !reflect.DeepEqual(wantSession, *session.Session) {
 		t.Errorf("want\n%#v, got\n%#v", wantSession, *session.Session)
 	}
-	stc.Execute(nil, "query1", nil, "", []string{"0", "1"}, "", session)
+	stc.Execute(nil, "query1", nil, "", []string{"0", "1"}, "", "", session)
 	wantSession = proto.Session{
 		InTransaction: true,
 		ShardSessions: []*proto.ShardSession{{
@@ -215,8 +244,8 @@ func TestScatterConnRollback(t *testing.T) {
 
 	// Sequence the executes to ensure commit order
 	session := NewSafeSession(&proto.Session{InTransaction: true})
-	stc.Execute(nil, "query1", nil, "", []string{"0"}, "", session)
-	stc.Execute(nil, "query1", nil, "", []string{"0", "1"}, "", session)
+	stc.Execute(nil, "query1", nil, "", []string{"0"}, "", "", session)
+	stc.Execute(nil, "query1", nil, "", []string{"0", "1"}, "", "", session)
 	err := stc.Rollback(nil, session)
 	if err != nil {
 		t.Errorf("want nil, got %v", err)
@@ -237,12 +266,314 @@ func TestScatterConnRollback(t *testing.T) {
 	*/
 }
 
+func TestScatterConnTxFailoverFail(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	if _, err := stc.Execute(nil, "query1", nil, "", []string{"0"}, "", "", session); err != nil {
+		t.Fatal(err)
+	}
+	// A retry error can leave the transaction alive on the tablet.
+	sbc.mustFailRetry = 1
+	_, err := stc.Execute(nil, "query2", nil, "", []string{"0"}, "", "", session)
+	if err == nil || strings.Contains(err.Error(), TX_LOST_ERR) {
+		t.Errorf("want a retry error, got %v", err)
+	}
+	if !session.InTransaction() || session.ShardSessions[0].TransactionId != 1 {
+		t.Errorf("want the transaction to be kept, got %#v", *session.Session)
+	}
+	// Simulate a failover: the new master doesn't know the transaction.
+	sbc.mustFailNotTx = 1
+	_, err = stc.Execute(nil, "query3", nil, "", []string{"0"}, "", "", session)
+	if err == nil || !strings.Contains(err.Error(), TX_LOST_ERR) {
+		t.Errorf("want %s, got %v", TX_LOST_ERR, err)
+	}
+	wantSession := proto.Session{}
+	if !reflect.DeepEqual(wantSession, *session.Session) {
+		t.Errorf("want\n%#v, got\n%#v", wantSession, *session.Session)
+	}
+	if sbc.BeginCount != 1 {
+		t.Errorf("want 1, got %v", sbc.BeginCount)
+	}
+}
+
+func TestScatterConnTxFailoverReplay(t *testing.T) {
+	*txFailoverPolicy = TX_FAILOVER_REPLAY
+	defer func() { *txFailoverPolicy = TX_FAILOVER_FAIL }()
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	if _, err := stc.Execute(nil, "query1", nil, "", []string{"0"}, "", "", session); err != nil {
+		t.Fatal(err)
+	}
+	sbc.mustFailNotTx = 1
+	if _, err := stc.Execute(nil, "query2", nil, "", []string{"0"}, "", "", session); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	wantSession := proto.Session{
+		InTransaction: true,
+		ShardSessions: []*proto.ShardSession{{
+			Keyspace:      "",
+			Shard:         "0",
+			TabletType:    "",
+			TransactionId: 2,
+		}},
+	}
+	if !reflect.DeepEqual(wantSession, *session.Session) {
+		t.Errorf("want\n%#v, got\n%#v", wantSession, *session.Session)
+	}
+	// The statements are replayed from vtgate's log, which the
+	// session doesn't carry.
+	wantQueries := []tproto.BoundQuery{{Sql: "query1"}, {Sql: "query2"}, {Sql: "query1"}, {Sql: "query2"}}
+	if !reflect.DeepEqual(sbc.Queries, wantQueries) {
+		t.Errorf("want %v, got %v", wantQueries, sbc.Queries)
+	}
+	if statements, ok := stc.replayLog.take("", "0", "", 2); !ok || len(statements) != 2 {
+		t.Errorf("want 2 statements in the replay log, got %v, %v", statements, ok)
+	}
+	stc.replayLog.begin("", "0", "", 2)
+
+	// A streaming query makes the transaction not replayable.
+	err := stc.StreamExecute(nil, "query3", nil, "", []string{"0"}, "", session, func(*mproto.QueryResult) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stc.replayLog.take("", "0", "", 2); ok {
+		t.Errorf("want the transaction out of the replay log")
+	}
+	sbc.mustFailNotTx = 1
+	_, err = stc.Execute(nil, "query4", nil, "", []string{"0"}, "", "", session)
+	if err == nil || !strings.Contains(err.Error(), TX_LOST_ERR) {
+		t.Errorf("want %s, got %v", TX_LOST_ERR, err)
+	}
+	wantSession = proto.Session{}
+	if !reflect.DeepEqual(wantSession, *session.Session) {
+		t.Errorf("want\n%#v, got\n%#v", wantSession, *session.Session)
+	}
+}
+
+func TestScatterConnMaxExecutionTimeHint(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 2*time.Second)
+
+	session := NewSafeSession(&proto.Session{MaxExecutionTimeHint: true})
+	stc.Execute(nil, "select * from t", nil, "", []string{"0"}, "", "", session)
+	stc.ExecuteBatch(nil, []tproto.BoundQuery{{Sql: " SELECT 1"}, {Sql: "update t set a=1"}}, "", []string{"0"}, "", session)
+	// No hint without the session option.
+	stc.Execute(nil, "select * from t", nil, "", []string{"0"}, "", "", nil)
+	want := []tproto.BoundQuery{
+		{Sql: "select /*+ MAX_EXECUTION_TIME(2000) */ * from t"},
+		{Sql: "SELECT /*+ MAX_EXECUTION_TIME(2000) */ 1"},
+		{Sql: "update t set a=1"},
+		{Sql: "select * from t"},
+	}
+	if !reflect.DeepEqual(sbc.Queries, want) {
+		t.Errorf("want\n%#v, got\n%#v", want, sbc.Queries)
+	}
+}
+
+func TestScatterConnFallbackTabletTypes(t *testing.T) {
+	endPoint := func(uid uint32) []topo.EndPoint {
+		return []topo.EndPoint{{Uid: uid, Host: "0", NamedPortMap: map[string]int{"vt": 1}}}
+	}
+	fallbacks := []topo.TabletType{topo.TYPE_REPLICA, topo.TYPE_MASTER, topo.TYPE_BATCH}
+	testCases := []struct {
+		endPoints map[topo.TabletType][]topo.EndPoint
+		query     string
+		wantUid   uint32
+	}{
+		{
+			endPoints: map[topo.TabletType][]topo.EndPoint{
+				topo.TYPE_RDONLY:  endPoint(10),
+				topo.TYPE_REPLICA: endPoint(11),
+				topo.TYPE_MASTER:  endPoint(12),
+			},
+			query:   "select 1",
+			wantUid: 10,
+		}, {
+			endPoints: map[topo.TabletType][]topo.EndPoint{
+				topo.TYPE_RDONLY:  nil,
+				topo.TYPE_REPLICA: endPoint(11),
+				topo.TYPE_MASTER:  endPoint(12),
+			},
+			query:   "select 1",
+			wantUid: 11,
+		}, {
+			endPoints: map[topo.TabletType][]topo.EndPoint{
+				topo.TYPE_RDONLY:  nil,
+				topo.TYPE_REPLICA: nil,
+				topo.TYPE_MASTER:  endPoint(12),
+				topo.TYPE_BATCH:   endPoint(13),
+			},
+			query:   "select 1",
+			wantUid: 12,
+		}, {
+			// Nothing after master is used.
+			endPoints: map[topo.TabletType][]topo.EndPoint{
+				topo.TYPE_RDONLY:  nil,
+				topo.TYPE_REPLICA: nil,
+				topo.TYPE_MASTER:  nil,
+				topo.TYPE_BATCH:   endPoint(13),
+			},
+			query: "select 1",
+		}, {
+			// Writes don't fall back.
+			endPoints: map[topo.TabletType][]topo.EndPoint{
+				topo.TYPE_RDONLY:  nil,
+				topo.TYPE_REPLICA: endPoint(11),
+				topo.TYPE_MASTER:  endPoint(12),
+			},
+			query: "update t set a=1",
+		},
+	}
+	for i, tc := range testCases {
+		resetSandbox()
+		sandboxEndPoints = tc.endPoints
+		sbcs := map[uint32]*sandboxConn{}
+		for _, uid := range []uint32{10, 11, 12, 13} {
+			sbcs[uid] = &sandboxConn{}
+			testConns[uid] = sbcs[uid]
+		}
+		stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+		session := NewSafeSession(&proto.Session{FallbackTabletTypes: fallbacks})
+		_, err := stc.Execute(nil, tc.query, nil, "", []string{"0"}, topo.TYPE_RDONLY, "", session)
+		if tc.wantUid == 0 {
+			if err == nil {
+				t.Errorf("case %d: want error, got nil", i)
+			}
+		} else if err != nil {
+			t.Errorf("case %d: want nil, got %v", i, err)
+		}
+		for uid, sbc := range sbcs {
+			want := int64(0)
+			if uid == tc.wantUid {
+				want = 1
+			}
+			if got := sbc.ExecCount.Get(); got != want {
+				t.Errorf("case %d: uid %d: want %d, got %d", i, uid, want, got)
+			}
+		}
+	}
+}
+
+func TestScatterConnReadSplit(t *testing.T) {
+	defer func() { readSplitKeyspaces = nil }()
+	testCases := []struct {
+		keyspaces   []string
+		query       string
+		transaction bool
+		upgraded    bool
+		sessionType topo.TabletType
+		wantReplica bool
+	}{
+		{keyspaces: []string{"ks"}, query: "select * from t", wantReplica: true},
+		{keyspaces: nil, query: "select * from t"},
+		{keyspaces: []string{"other"}, query: "select * from t"},
+		{keyspaces: []string{"ks"}, query: "update t set a=1"},
+		{keyspaces: []string{"ks"}, query: "select * from t for update"},
+		{keyspaces: []string{"ks"}, query: "select * from t", transaction: true},
+		{keyspaces: []string{"ks"}, query: "select * from t", upgraded: true},
+		{keyspaces: []string{"ks"}, query: "select * from t", sessionType: topo.TYPE_MASTER},
+	}
+	for i, tc := range testCases {
+		resetSandbox()
+		sandboxEndPoints = map[topo.TabletType][]topo.EndPoint{
+			topo.TYPE_MASTER:  {{Uid: 20, Host: "0", NamedPortMap: map[string]int{"vt": 1}}},
+			topo.TYPE_REPLICA: {{Uid: 21, Host: "0", NamedPortMap: map[string]int{"vt": 1}}},
+		}
+		master, replica := &sandboxConn{}, &sandboxConn{}
+		testConns[20], testConns[21] = master, replica
+		readSplitKeyspaces = tc.keyspaces
+		stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+		session := NewSafeSession(&proto.Session{InTransaction: tc.transaction, Upgraded: tc.upgraded, TabletType: tc.sessionType})
+		if _, err := stc.Execute(nil, tc.query, nil, "ks", []string{"0"}, topo.TYPE_MASTER, "", session); err != nil {
+			t.Errorf("case %d: want nil, got %v", i, err)
+		}
+		wantMaster, wantReplica := 1, 0
+		if tc.wantReplica {
+			wantMaster, wantReplica = 0, 1
+		}
+		if len(master.Queries) != wantMaster || len(replica.Queries) != wantReplica {
+			t.Errorf("case %d: want master %d replica %d, got master %d replica %d", i, wantMaster, wantReplica, len(master.Queries), len(replica.Queries))
+		}
+		stc.Rollback(nil, session)
+	}
+}
+
+func TestScatterConnAffinityKey(t *testing.T) {
+	resetSandbox()
+	sandboxEndPoints = map[topo.TabletType][]topo.EndPoint{
+		topo.TYPE_REPLICA: {
+			{Uid: 30, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+			{Uid: 31, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+			{Uid: 32, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+		},
+	}
+	conns := []*sandboxConn{{}, {}, {}}
+	for i, conn := range conns {
+		testConns[uint32(30+i)] = conn
+	}
+	// tablet returns the index of the only conn that got query.
+	tablet := func(query string) int {
+		found := -1
+		for i, conn := range conns {
+			for _, q := range conn.Queries {
+				if q.Sql != query {
+					continue
+				}
+				if found != -1 && found != i {
+					t.Errorf("%v went to tablets %v and %v", query, found, i)
+				}
+				found = i
+			}
+		}
+		return found
+	}
+
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Minute, 3, 1*time.Second)
+	for i := 0; i < 10; i++ {
+		query := fmt.Sprintf("select %v", i)
+		for j := 0; j < 5; j++ {
+			if _, err := stc.Execute(nil, query, nil, "ks", []string{"0"}, topo.TYPE_REPLICA, fmt.Sprintf("key%v", i), nil); err != nil {
+				t.Fatalf("want nil, got %v", err)
+			}
+		}
+		if tablet(query) == -1 {
+			t.Errorf("%v wasn't executed", query)
+		}
+	}
+
+	// When its tablet fails, a key moves to another one and stays there
+	// while the tablet is marked down.
+	before := tablet("select 0")
+	conns[before].mustFailConn = 1
+	if _, err := stc.Execute(nil, "select failover", nil, "ks", []string{"0"}, topo.TYPE_REPLICA, "key0", nil); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	for j := 0; j < 5; j++ {
+		if _, err := stc.Execute(nil, "select again", nil, "ks", []string{"0"}, topo.TYPE_REPLICA, "key0", nil); err != nil {
+			t.Fatalf("want nil, got %v", err)
+		}
+	}
+	if after := tablet("select again"); after == before || after == -1 {
+		t.Errorf("want a tablet other than %v, got %v", before, after)
+	}
+}
+
 func TestScatterConnClose(t *testing.T) {
 	resetSandbox()
 	sbc := &sandboxConn{}
 	testConns[0] = sbc
 	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Millisecond)
-	stc.Execute(nil, "query1", nil, "", []string{"0"}, "", nil)
+	stc.Execute(nil, "query1", nil, "", []string{"0"}, "", "", nil)
 	stc.Close()
 	/*
 		// Flaky: This test should be run manually.
@@ -252,3 +583,287 @@ func TestScatterConnClose(t *testing.T) {
 		}
 	*/
 }
+
+func TestScatterConnReapIdleShardConns(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+	defer stc.Close()
+	stc.Execute(nil, "query1", nil, "", []string{"0"}, "", "", nil)
+	now := time.Now()
+
+	if got := stc.reapIdleShardConns(now, time.Minute, time.Hour); got != 0 {
+		t.Errorf("want 0 closed, got %v", got)
+	}
+	if got := stc.reapIdleShardConns(now.Add(2*time.Minute), time.Minute, time.Hour); got != 1 {
+		t.Errorf("want 1 closed, got %v", got)
+	}
+	if sbc.CloseCount.Get() != 1 {
+		t.Errorf("want 1, got %v", sbc.CloseCount.Get())
+	}
+	// Already closed.
+	if got := stc.reapIdleShardConns(now.Add(2*time.Minute), time.Minute, time.Hour); got != 0 {
+		t.Errorf("want 0 closed, got %v", got)
+	}
+
+	// The ShardConn reconnects on demand, and an open transaction
+	// gets the longer timeout.
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	if _, err := stc.Execute(nil, "query1", nil, "", []string{"0"}, "", "", session); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	now = time.Now()
+	if got := stc.reapIdleShardConns(now.Add(2*time.Minute), time.Minute, time.Hour); got != 0 {
+		t.Errorf("want 0 closed, got %v", got)
+	}
+	if got := stc.reapIdleShardConns(now.Add(2*time.Hour), time.Minute, time.Hour); got != 1 {
+		t.Errorf("want 1 closed, got %v", got)
+	}
+}
+
+func TestScatterConnPing(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+	defer stc.Close()
+
+	// A session that uses no shard has nothing to ping.
+	if err := stc.Ping(nil, NewSafeSession(&proto.Session{})); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if got := sbc.ExecCount.Get(); got != 0 {
+		t.Errorf("want 0, got %v", got)
+	}
+
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	if _, err := stc.Execute(nil, "query1", nil, "", []string{"0"}, "", "", session); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	before := time.Now()
+	if err := stc.Ping(nil, session); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if got := sbc.Queries[len(sbc.Queries)-1].Sql; got != "select 1" {
+		t.Errorf("want select 1, got %v", got)
+	}
+	// The ping counts as a use of the connections.
+	if got := stc.reapIdleShardConns(before.Add(time.Hour-time.Nanosecond), time.Minute, time.Hour); got != 0 {
+		t.Errorf("want 0 closed, got %v", got)
+	}
+
+	// A broken connection is reported, not retried.
+	sbc.mustFailConn = 1
+	execCount := sbc.ExecCount.Get()
+	if err := stc.Ping(nil, session); err == nil {
+		t.Errorf("want error, got nil")
+	}
+	if got := sbc.ExecCount.Get(); got != execCount+1 {
+		t.Errorf("want %v, got %v", execCount+1, got)
+	}
+}
+
+func TestScatterConnExecuteQuorum(t *testing.T) {
+	resetSandbox()
+	slow := &sandboxConn{mustDelay: 500 * time.Millisecond}
+	testConns[0] = &sandboxConn{}
+	testConns[1] = &sandboxConn{}
+	testConns[2] = slow
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+	shards := []string{"0", "1", "2"}
+
+	start := time.Now()
+	qr, answered, err := stc.ExecuteQuorum(nil, "select * from t", nil, "", shards, "", 2)
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if elapsed := time.Now().Sub(start); elapsed >= slow.mustDelay {
+		t.Errorf("waited %v for the slow shard", elapsed)
+	}
+	sort.Strings(answered)
+	if !reflect.DeepEqual(answered, []string{"0", "1"}) {
+		t.Errorf("want shards [0 1], got %v", answered)
+	}
+	if len(qr.Rows) != 2 {
+		t.Errorf("want the rows of 2 shards, got %v", qr.Rows)
+	}
+
+	// A failed shard makes the quorum unreachable.
+	testConns[1] = &sandboxConn{mustFailServer: 1}
+	stc = NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+	_, _, err = stc.ExecuteQuorum(nil, "select * from t", nil, "", []string{"0", "1"}, "", 2)
+	if want := "only 1 of 2 shards answered, quorum is 2"; err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("want %v, got %v", want, err)
+	}
+
+	// The quorum is bounded by the timeout. Without retries, the
+	// abandoned shard doesn't dial again after the test.
+	stc = NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 0, 10*time.Millisecond)
+	_, _, err = stc.ExecuteQuorum(nil, "select * from t", nil, "", shards, "", 3)
+	if want := "only 2 of 3 shards answered within 10ms, quorum is 3"; err == nil || err.Error() != want {
+		t.Errorf("want %v, got %v", want, err)
+	}
+
+	_, _, err = stc.ExecuteQuorum(nil, "update t set a = 1", nil, "", shards, "", 2)
+	if want := "a quorum is only supported for selects: update t set a = 1"; err == nil || err.Error() != want {
+		t.Errorf("want %v, got %v", want, err)
+	}
+	_, _, err = stc.ExecuteQuorum(nil, "select * from t", nil, "", shards, "", 4)
+	if want := "invalid quorum 4 for 3 shards"; err == nil || err.Error() != want {
+		t.Errorf("want %v, got %v", want, err)
+	}
+}
+
+func TestScatterConnParallelism(t *testing.T) {
+	defer func() { *scatterParallelism = 0 }()
+	*scatterParallelism = 1
+	resetSandbox()
+	sbcs := make([]*sandboxConn, 3)
+	for i := range sbcs {
+		sbcs[i] = &sandboxConn{mustDelay: 10 * time.Millisecond}
+		testConns[uint32(i)] = sbcs[i]
+	}
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+	defer stc.Close()
+
+	// One shard at a time.
+	startTime := time.Now()
+	qr, err := stc.Execute(nil, "query", nil, "", []string{"0", "1", "2"}, "", "", nil)
+	if err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if d := time.Now().Sub(startTime); d < 30*time.Millisecond {
+		t.Errorf("want >30ms, got %v", d)
+	}
+	if len(qr.Rows) != 3 {
+		t.Errorf("want 3 rows, got %v", len(qr.Rows))
+	}
+}
+
+func TestScatterConnShardStats(t *testing.T) {
+	resetSandbox()
+	sbc0 := &sandboxConn{}
+	testConns[0] = sbc0
+	sbc1 := &sandboxConn{mustFailServer: 1}
+	testConns[1] = sbc1
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+	defer stc.Close()
+
+	stc.Execute(nil, "query", nil, "ks_stats", []string{"0", "1"}, "replica", "", nil)
+	stc.StreamExecute(nil, "query", nil, "ks_stats", []string{"0"}, "replica", nil, func(*mproto.QueryResult) error { return nil })
+	counts := shardQueries.Counts()
+	if counts["ks_stats.0.replica"] != 2 || counts["ks_stats.1.replica"] != 1 {
+		t.Errorf("want 2 and 1 queries, got %v", counts)
+	}
+	errors := shardQueryErrors.Counts()
+	if errors["ks_stats.0.replica"] != 0 || errors["ks_stats.1.replica"] != 1 {
+		t.Errorf("want 0 and 1 errors, got %v", errors)
+	}
+}
+
+func TestScatterConnMaxResultSize(t *testing.T) {
+	defer func() { *maxResultRows, *maxResultBytes = 0, 0 }()
+	resetSandbox()
+	testConns[0] = &sandboxConn{}
+	testConns[1] = &sandboxConn{}
+	stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+	defer stc.Close()
+
+	// The limit applies to the merged result.
+	*maxResultRows = 1
+	if _, err := stc.Execute(nil, "query", nil, "", []string{"0"}, "", "", nil); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	_, err := stc.Execute(nil, "query", nil, "", []string{"0", "1"}, "", "", nil)
+	if err == nil || !strings.Contains(err.Error(), RESULT_TOO_LARGE_ERR) {
+		t.Errorf("want %v, got %v", RESULT_TOO_LARGE_ERR, err)
+	}
+	if _, err := stc.ExecuteBatch(nil, []tproto.BoundQuery{{Sql: "query"}}, "", []string{"0", "1"}, "", nil); err == nil {
+		t.Errorf("want error, got nil")
+	}
+
+	// The limits are sent to the tablets, which fail the
+	// queries whose result is past them.
+	*maxResultRows, *maxResultBytes = 0, 3
+	tooLarge := resultsTooLarge.Get()
+	_, err = stc.Execute(nil, "query", nil, "", []string{"0"}, "", "", nil)
+	if err == nil || !strings.HasPrefix(err.Error(), RESULT_TOO_LARGE_ERR) {
+		t.Errorf("want %v, got %v", RESULT_TOO_LARGE_ERR, err)
+	}
+	if got := resultsTooLarge.Get() - tooLarge; got != 1 {
+		t.Errorf("want 1 result too large, got %v", got)
+	}
+	if _, err := stc.ExecuteBatch(nil, []tproto.BoundQuery{{Sql: "query"}}, "", []string{"0"}, "", nil); err == nil || !strings.Contains(err.Error(), RESULT_TOO_LARGE_ERR) {
+		t.Errorf("want %v, got %v", RESULT_TOO_LARGE_ERR, err)
+	}
+}
+
+func TestForwardStream(t *testing.T) {
+	newStream := func() chan *mproto.QueryResult {
+		sr := make(chan *mproto.QueryResult, 3)
+		for i := 0; i < 3; i++ {
+			sr <- singleRowResult
+		}
+		close(sr)
+		return sr
+	}
+
+	// A client that keeps up gets everything.
+	sResults := make(chan interface{}, 3)
+	aborted := false
+	abort := func() { aborted = true }
+	if err := forwardStream(newStream(), sResults, 10*time.Millisecond, nil, abort); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if len(sResults) != 3 || aborted {
+		t.Errorf("want 3 results and no abort, got %v, %v", len(sResults), aborted)
+	}
+
+	// A stalled client gets the first result, the tablet stream is
+	// aborted and what it sent is discarded.
+	sr := newStream()
+	sResults = make(chan interface{}, 1)
+	stalls := streamStalls.Get()
+	if err := forwardStream(sr, sResults, 10*time.Millisecond, nil, abort); err != ErrStreamStalled {
+		t.Errorf("want %v, got %v", ErrStreamStalled, err)
+	}
+	if len(sResults) != 1 || len(sr) != 0 {
+		t.Errorf("want 1 result sent and none left, got %v, %v", len(sResults), len(sr))
+	}
+	if got := streamStalls.Get() - stalls; got != 1 {
+		t.Errorf("want 1 stall, got %v", got)
+	}
+	if !aborted {
+		t.Errorf("want the stream aborted, it wasn't")
+	}
+
+	// Past the deadline, an idle tablet stream is aborted.
+	sr = make(chan *mproto.QueryResult)
+	expired := make(chan struct{})
+	close(expired)
+	if err := forwardStream(sr, sResults, 0, expired, func() { close(sr) }); err != ErrDeadlineExceeded {
+		t.Errorf("want %v, got %v", ErrDeadlineExceeded, err)
+	}
+}
+
+func TestAppendResult(t *testing.T) {
+	qr := new(mproto.QueryResult)
+	if err := appendResult(qr, singleRowResult); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	// Results without fields, like DMLs, always merge.
+	if err := appendResult(qr, &mproto.QueryResult{RowsAffected: 1}); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if err := appendResult(qr, singleRowResult); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if len(qr.Rows) != 2 || qr.RowsAffected != 3 {
+		t.Errorf("want 2 rows and 3 rows affected, got %v, %v", len(qr.Rows), qr.RowsAffected)
+	}
+	other := &mproto.QueryResult{Fields: []mproto.Field{{Name: "other"}}}
+	if err := appendResult(qr, other); err == nil {
+		t.Errorf("want error for different fields, got nil")
+	}
+}
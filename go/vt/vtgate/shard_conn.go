@@ -5,20 +5,47 @@
 package vtgate
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	log "github.com/golang/glog"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
+	rpcproto "github.com/youtube/vitess/go/rpcwrap/proto"
+	"github.com/youtube/vitess/go/stats"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
 	"github.com/youtube/vitess/go/vt/topo"
 )
 
+var (
+	// tabletConns is the number of open tablet connections.
+	tabletConns = stats.NewInt("VtgateTabletConns")
+	// tabletConnRequests is the number of requests in flight
+	// on the tablet connections.
+	tabletConnRequests = stats.NewInt("VtgateTabletConnRequests")
+)
+
+func init() {
+	stats.Publish("VtgateTabletConnMultiplexing", stats.FloatFunc(func() float64 {
+		conns := tabletConns.Get()
+		if conns == 0 {
+			return 0
+		}
+		return float64(tabletConnRequests.Get()) / float64(conns)
+	}))
+}
+
 // ShardConn represents a load balanced connection to a group
 // of vttablets that belong to the same shard. ShardConn can
-// be concurrently used across goroutines. Such requests are
-// interleaved on the same underlying connection.
+// be concurrently used across goroutines. Each request goes to
+// the tablet the Balancer picks for it, so its strategy applies
+// per request, and the requests to a tablet are interleaved on
+// one connection, transactions included: they are identified by
+// their transaction id, not by the connection.
+// VtgateTabletConnMultiplexing reports the average number of
+// requests in flight per connection.
 type ShardConn struct {
 	keyspace   string
 	shard      string
@@ -28,15 +55,40 @@ type ShardConn struct {
 	timeout    time.Duration
 	balancer   *Balancer
 
-	// conn needs a mutex because it can change during the lifetime of ShardConn.
-	mu   sync.Mutex
-	conn tabletconn.TabletConn
+	// conns need a mutex because they change during the lifetime of ShardConn.
+	mu sync.Mutex
+	// conns are the connections to the tablets, by uid. They're
+	// opened when a request first goes to their tablet.
+	conns map[uint32]tabletconn.TabletConn
+	// retired are the connections to the tablets the balancer
+	// stopped routing to (see Balancer.routable): they get no new
+	// requests, and are closed once no request uses them and no
+	// transaction is pinned to them. routingVersion is the version
+	// of the routing of the balancer conns were last checked at.
+	retired        map[tabletconn.TabletConn]bool
+	routingVersion int64
+	// connRequests is the number of requests using each connection.
+	connRequests map[tabletconn.TabletConn]int
+	// txConns pins the transactions begun here to the connection
+	// that began them, by transaction id, until they're concluded.
+	txConns map[int64]tabletconn.TabletConn
+	// requests is the number of requests using the connections,
+	// lastUsed the last time one started or ended, and openTx the
+	// number of transactions begun and not yet concluded. They
+	// decide when the connections are idle, see CloseIfIdle.
+	requests int
+	lastUsed time.Time
+	openTx   int
 }
 
 // NewShardConn creates a new ShardConn. It creates a Balancer using
-// serv, cell, keyspace, tabletType and retryDelay. retryCount is the max
+// serv, cell, keyspace, tabletType, retryDelay and workload. retryCount is the max
 // number of retries before a ShardConn returns an error on an operation.
-func NewShardConn(serv SrvTopoServer, cell, keyspace, shard string, tabletType topo.TabletType, retryDelay time.Duration, retryCount int, timeout time.Duration) *ShardConn {
+// With -watch_endpoints, the Balancer watches its end points if serv
+// is an EndPointsWatcher, until Close: the connections to the end
+// points removed are retired as soon as the update comes, and the
+// end points added get their share of the next requests.
+func NewShardConn(serv SrvTopoServer, cell, keyspace, shard string, tabletType topo.TabletType, workload string, retryDelay time.Duration, retryCount int, timeout time.Duration) *ShardConn {
 	getAddresses := func() (*topo.EndPoints, error) {
 		endpoints, err := serv.GetEndPoints(cell, keyspace, shard, tabletType)
 		if err != nil {
@@ -44,8 +96,8 @@ func NewShardConn(serv SrvTopoServer, cell, keyspace, shard string, tabletType t
 		}
 		return endpoints, nil
 	}
-	blc := NewBalancer(getAddresses, retryDelay)
-	return &ShardConn{
+	blc := NewBalancer(getAddresses, retryDelay, workload)
+	sdc := &ShardConn{
 		keyspace:   keyspace,
 		shard:      shard,
 		tabletType: tabletType,
@@ -53,160 +105,578 @@ func NewShardConn(serv SrvTopoServer, cell, keyspace, shard string, tabletType t
 		retryCount: retryCount,
 		timeout:    timeout,
 		balancer:   blc,
+
+		conns:        make(map[uint32]tabletconn.TabletConn),
+		retired:      make(map[tabletconn.TabletConn]bool),
+		connRequests: make(map[tabletconn.TabletConn]int),
+		txConns:      make(map[int64]tabletconn.TabletConn),
+		lastUsed:     time.Now(),
+	}
+	if watcher, ok := serv.(EndPointsWatcher); ok && *watchEndPoints {
+		blc.onUpdate = sdc.checkRouting
+		blc.Watch(func(stop <-chan struct{}) (<-chan *topo.EndPoints, error) {
+			return watcher.WatchEndPoints(cell, keyspace, shard, tabletType, stop)
+		})
 	}
+	return sdc
+}
+
+// ErrDeadlineExceeded is returned when the deadline of the caller (see
+// rpcproto.Context.Deadline) passed before the tablet answered.
+var ErrDeadlineExceeded = errors.New("vtgate: deadline exceeded")
+
+// contextDeadline returns the deadline of context, or the zero time
+// if it has none.
+func contextDeadline(context interface{}) time.Time {
+	if ctx, ok := context.(*rpcproto.Context); ok {
+		return ctx.Deadline
+	}
+	return time.Time{}
 }
 
 type ShardConnError struct {
 	Code            int
 	ShardIdentifier string
-	topoReResolve   bool
-	Err             string
+	// DeadlineExceeded is set if the error is ErrDeadlineExceeded,
+	// rather than an error from the tablet.
+	DeadlineExceeded bool
+	topoReResolve    bool
+	txLost           bool
+	Err              string
 }
 
+// TX_LOST_ERR prefixes the error returned when the tablet that held
+// a transaction went away, typically because the master failed over.
+const TX_LOST_ERR = "transaction lost due to failover"
+
 func (e *ShardConnError) Error() string {
 	return fmt.Sprintf("%v, shard, host: %s", e.Err, e.ShardIdentifier)
 }
 
+// WRITE_NOT_RETRIED_ERR prefixes the error returned when a DML failed
+// in a way that leaves it possibly applied, so it wasn't retried.
+const WRITE_NOT_RETRIED_ERR = "non-retryable write failed, it may have been applied"
+
+// RetryBudget limits the retries of a call: ShardConn asks it, and
+// then the budget of the caller (see allowCallerRetry), before each
+// retry, and fails right away if either says no. AllowWriteRetry opts
+// in to the retry of DMLs that may have been applied (see IsRetryable).
+// A nil RetryBudget allows all the retries, except those.
+type RetryBudget interface {
+	AllowRetry() bool
+	AllowWriteRetry() bool
+}
+
+// noRetries is the RetryBudget of the calls that must report their
+// first failure, like Ping.
+type noRetries struct{}
+
+func (noRetries) AllowRetry() bool      { return false }
+func (noRetries) AllowWriteRetry() bool { return false }
+
+// IsRetryable returns false for the DMLs: if the connection fails
+// while they run, retrying them could apply them twice. All the other
+// queries can be retried.
+func IsRetryable(sql string) bool {
+	return !isDML(sql)
+}
+
 // Execute executes a non-streaming query on vttablet. If there are connection errors,
-// it retries retryCount times before failing. It does not retry if the connection is in
-// the middle of a transaction.
-func (sdc *ShardConn) Execute(context interface{}, query string, bindVars map[string]interface{}, transactionId int64) (qr *mproto.QueryResult, err error) {
+// it retries retryCount times before failing, as long as budget allows. It does not
+// retry if the connection is in the middle of a transaction, or a DML that may have
+// been applied (see IsRetryable). vttablet fails the query if its result is past
+// -max_result_rows or -max_result_bytes, so it isn't sent back.
+func (sdc *ShardConn) Execute(context interface{}, query string, bindVars map[string]interface{}, transactionId int64, budget RetryBudget) (qr *mproto.QueryResult, err error) {
+	err = sdc.withRetry(context, func(conn tabletconn.TabletConn) error {
+		var innerErr error
+		qr, innerErr = conn.Execute(context, query, bindVars, transactionId, int64(*maxResultRows), int64(*maxResultBytes))
+		return innerErr
+	}, transactionId, false, "", IsRetryable(query), budget)
+	return qr, err
+}
+
+// ExecuteWithAffinity executes a non-transactional query on the tablet
+// affinityKey hashes to (see Balancer.GetAffinity), so that queries
+// sharing a key hit the same tablet caches. If that tablet fails, the
+// key moves to another one. The retry rules are the same as Execute.
+func (sdc *ShardConn) ExecuteWithAffinity(context interface{}, query string, bindVars map[string]interface{}, affinityKey string, budget RetryBudget) (qr *mproto.QueryResult, err error) {
 	err = sdc.withRetry(context, func(conn tabletconn.TabletConn) error {
 		var innerErr error
-		qr, innerErr = conn.Execute(context, query, bindVars, transactionId)
+		qr, innerErr = conn.Execute(context, query, bindVars, 0, int64(*maxResultRows), int64(*maxResultBytes))
 		return innerErr
-	}, transactionId, false)
+	}, 0, false, affinityKey, IsRetryable(query), budget)
 	return qr, err
 }
 
 // ExecuteBatch executes a group of queries. The retry rules are the same as Execute.
-func (sdc *ShardConn) ExecuteBatch(context interface{}, queries []tproto.BoundQuery, transactionId int64) (qrs *tproto.QueryResultList, err error) {
+// A batch with a DML is not retryable.
+func (sdc *ShardConn) ExecuteBatch(context interface{}, queries []tproto.BoundQuery, transactionId int64, budget RetryBudget) (qrs *tproto.QueryResultList, err error) {
+	retryable := true
+	for _, query := range queries {
+		retryable = retryable && IsRetryable(query.Sql)
+	}
 	err = sdc.withRetry(context, func(conn tabletconn.TabletConn) error {
 		var innerErr error
-		qrs, innerErr = conn.ExecuteBatch(context, queries, transactionId)
+		qrs, innerErr = conn.ExecuteBatch(context, queries, transactionId, int64(*maxResultRows), int64(*maxResultBytes))
 		return innerErr
-	}, transactionId, false)
+	}, transactionId, false, "", retryable, budget)
 	return qrs, err
 }
 
 // StreamExecute executes a streaming query on vttablet. The retry rules are the same as Execute.
-func (sdc *ShardConn) StreamExecute(context interface{}, query string, bindVars map[string]interface{}, transactionId int64) (results <-chan *mproto.QueryResult, errFunc tabletconn.ErrFunc) {
+// abort ends the stream early by closing the connection it uses: the
+// other requests on that connection fail, the next ones open a new one.
+func (sdc *ShardConn) StreamExecute(context interface{}, query string, bindVars map[string]interface{}, transactionId int64, budget RetryBudget) (results <-chan *mproto.QueryResult, errFunc tabletconn.ErrFunc, abort func()) {
 	var usedConn tabletconn.TabletConn
 	var erFunc tabletconn.ErrFunc
 	err := sdc.withRetry(context, func(conn tabletconn.TabletConn) error {
 		results, erFunc = conn.StreamExecute(context, query, bindVars, transactionId)
 		usedConn = conn
 		return erFunc()
-	}, transactionId, true)
+	}, transactionId, true, "", IsRetryable(query), budget)
+	abort = func() {
+		if usedConn != nil {
+			sdc.closeConn(usedConn)
+		}
+	}
 	if err != nil {
-		return results, func() error { return err }
+		return results, func() error { return err }, abort
 	}
 	inTransaction := (transactionId != 0)
-	return results, func() error { return sdc.WrapError(erFunc(), usedConn, inTransaction) }
+	return results, func() error { return sdc.WrapError(erFunc(), usedConn, inTransaction) }, abort
 }
 
 // Begin begins a transaction. The retry rules are the same as Execute.
-func (sdc *ShardConn) Begin(context interface{}) (transactionId int64, err error) {
+// The transaction is pinned to the connection that began it: its
+// statements don't go to the tablets the balancer picks.
+func (sdc *ShardConn) Begin(context interface{}, budget RetryBudget) (transactionId int64, err error) {
+	var txConn tabletconn.TabletConn
 	err = sdc.withRetry(context, func(conn tabletconn.TabletConn) error {
 		var innerErr error
 		transactionId, innerErr = conn.Begin(context)
+		txConn = conn
 		return innerErr
-	}, 0, false)
+	}, 0, false, "", true, budget)
+	if err == nil {
+		sdc.beginTx(transactionId, txConn)
+	}
 	return transactionId, err
 }
 
 // Commit commits the current transaction. The retry rules are the same as Execute.
 func (sdc *ShardConn) Commit(context interface{}, transactionId int64) (err error) {
+	defer sdc.endTx(transactionId)
 	return sdc.withRetry(context, func(conn tabletconn.TabletConn) error {
 		return conn.Commit(context, transactionId)
-	}, transactionId, false)
+	}, transactionId, false, "", true, nil)
 }
 
 // Rollback rolls back the current transaction. The retry rules are the same as Execute.
 func (sdc *ShardConn) Rollback(context interface{}, transactionId int64) (err error) {
+	defer sdc.endTx(transactionId)
 	return sdc.withRetry(context, func(conn tabletconn.TabletConn) error {
 		return conn.Rollback(context, transactionId)
-	}, transactionId, false)
+	}, transactionId, false, "", true, nil)
+}
+
+// Ping runs a trivial query on the connection the transaction
+// transactionId is pinned to, or of a tablet the balancer picks if
+// it's 0, to check that its tablet is still reachable. Unlike the other calls,
+// it's never retried, so a broken connection is reported instead of
+// replaced, but it's marked down the same way. As any request, it
+// keeps the connections from being closed as idle.
+func (sdc *ShardConn) Ping(context interface{}, transactionId int64) error {
+	return sdc.withRetry(context, func(conn tabletconn.TabletConn) error {
+		_, err := conn.Execute(context, "select 1", nil, transactionId, 0, 0)
+		return err
+	}, transactionId, false, "", true, noRetries{})
+}
+
+// beginTx counts the transaction transactionId in, and pins it to conn.
+func (sdc *ShardConn) beginTx(transactionId int64, conn tabletconn.TabletConn) {
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	sdc.openTx++
+	sdc.txConns[transactionId] = conn
+}
+
+// endTx counts the transaction transactionId out, and unpins it.
+// A failed commit or rollback still counts the transaction out:
+// it's unlikely to be concluded later.
+func (sdc *ShardConn) endTx(transactionId int64) {
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	if sdc.openTx > 0 {
+		sdc.openTx--
+	}
+	if conn, ok := sdc.txConns[transactionId]; ok {
+		delete(sdc.txConns, transactionId)
+		sdc.closeIfDone(conn)
+	}
+}
+
+// hasTransaction returns true if the transaction transactionId
+// was begun here and isn't concluded yet.
+func (sdc *ShardConn) hasTransaction(transactionId int64) bool {
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	_, ok := sdc.txConns[transactionId]
+	return ok
+}
+
+// CloseIfIdle closes the connections if they're open, no request
+// uses them, and they have been unused for idleTimeout, or
+// txIdleTimeout if transactions are open: clients that crash leave
+// their transactions open for good, so they can't keep the
+// connections open forever either. Those transactions are rolled
+// back first, so they don't hold their locks until the vttablet
+// transaction timeout. It returns true if it closed the connections.
+// Like Close, it doesn't prevent the reuse of ShardConn.
+func (sdc *ShardConn) CloseIfIdle(now time.Time, idleTimeout, txIdleTimeout time.Duration) bool {
+	sdc.mu.Lock()
+	if len(sdc.conns) == 0 && len(sdc.retired) == 0 {
+		sdc.mu.Unlock()
+		return false
+	}
+	if sdc.requests > 0 {
+		sdc.mu.Unlock()
+		return false
+	}
+	timeout := idleTimeout
+	if sdc.openTx > 0 {
+		timeout = txIdleTimeout
+	}
+	if now.Sub(sdc.lastUsed) < timeout {
+		sdc.mu.Unlock()
+		return false
+	}
+	pinned := sdc.takePinned()
+	conns := sdc.takeConns()
+	sdc.mu.Unlock()
+
+	// The rollbacks need the connections, so they're closed after.
+	sdc.rollbackPinned(pinned, "idle")
+	for _, conn := range conns {
+		conn.Close()
+	}
+	return true
+}
+
+// takePinned forgets the transactions pinned to the connections,
+// and returns them. mu must be held.
+func (sdc *ShardConn) takePinned() map[int64]tabletconn.TabletConn {
+	pinned := sdc.txConns
+	sdc.txConns = make(map[int64]tabletconn.TabletConn)
+	sdc.openTx = 0
+	return pinned
+}
+
+// rollbackPinned rolls back the transactions of pinned, which are
+// open for why. The rollbacks run in parallel, each bounded by
+// sdc.timeout, so a hung tablet can't hold up the callers for longer.
+// mu must not be held: the other requests go on meanwhile.
+func (sdc *ShardConn) rollbackPinned(pinned map[int64]tabletconn.TabletConn, why string) {
+	var wg sync.WaitGroup
+	for transactionId, conn := range pinned {
+		log.Infof("%v.%v.%v: rolling back transaction %v: %v", sdc.keyspace, sdc.shard, sdc.tabletType, transactionId, why)
+		wg.Add(1)
+		go func(transactionId int64, conn tabletconn.TabletConn) {
+			defer wg.Done()
+			if err := sdc.rollbackWithTimeout(conn, transactionId); err != nil {
+				log.Warningf("%v.%v.%v: rollback of transaction %v failed: %v", sdc.keyspace, sdc.shard, sdc.tabletType, transactionId, err)
+			}
+		}(transactionId, conn)
+	}
+	wg.Wait()
+}
+
+// rollbackWithTimeout rolls back transactionId on conn, and gives
+// up after sdc.timeout.
+func (sdc *ShardConn) rollbackWithTimeout(conn tabletconn.TabletConn, transactionId int64) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.Rollback(nil, transactionId)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(sdc.timeout):
+		return tabletconn.OperationalError("vttablet: call timeout")
+	}
+}
+
+// RollbackAll rolls back the open transactions begun here,
+// and returns how many there were. why is logged.
+func (sdc *ShardConn) RollbackAll(why string) int {
+	sdc.mu.Lock()
+	pinned := sdc.takePinned()
+	sdc.mu.Unlock()
+	sdc.rollbackPinned(pinned, why)
+	return len(pinned)
+}
+
+// OpenTransactions returns the number of transactions
+// begun here and not yet concluded.
+func (sdc *ShardConn) OpenTransactions() int {
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	return sdc.openTx
+}
+
+// HasEndPoints returns true if there are end points to send queries to.
+// End points that are marked down still count.
+func (sdc *ShardConn) HasEndPoints() bool {
+	return sdc.balancer.HasEndPoints()
 }
 
 // Close closes the underlying TabletConn. ShardConn can be
 // reused after this because it opens connections on demand.
+// It also stops the watch of the end points: a reused ShardConn
+// refreshes them when its tablets fail, as without -watch_endpoints.
 func (sdc *ShardConn) Close() {
+	sdc.balancer.Close()
 	sdc.mu.Lock()
 	defer sdc.mu.Unlock()
-	if sdc.conn == nil {
-		return
+	sdc.closeConns()
+}
+
+// closeConns closes the connections, and forgets the transactions
+// pinned to them. mu must be held.
+func (sdc *ShardConn) closeConns() {
+	for transactionId := range sdc.txConns {
+		delete(sdc.txConns, transactionId)
+	}
+	for _, conn := range sdc.takeConns() {
+		conn.Close()
 	}
-	sdc.conn.Close()
-	sdc.conn = nil
+}
+
+// takeConns forgets the connections, retired ones included, and
+// returns them for the caller to close. mu must be held.
+func (sdc *ShardConn) takeConns() []tabletconn.TabletConn {
+	conns := make([]tabletconn.TabletConn, 0, len(sdc.conns)+len(sdc.retired))
+	for uid, conn := range sdc.conns {
+		conns = append(conns, conn)
+		delete(sdc.conns, uid)
+		tabletConns.Add(-1)
+	}
+	for conn := range sdc.retired {
+		conns = append(conns, conn)
+		delete(sdc.retired, conn)
+		tabletConns.Add(-1)
+	}
+	return conns
 }
 
 // withRetry sets up the connection and executes the action. If there are connection errors,
 // it retries retryCount times before failing. It does not retry if the connection is in
 // the middle of a transaction. While returning the error check if it maybe a result of
 // a resharding event, and set the re-resolve bit and let the upper layers
-// re-resolve and retry.
-func (sdc *ShardConn) withRetry(context interface{}, action func(conn tabletconn.TabletConn) error, transactionId int64, isStreaming bool) error {
+// re-resolve and retry. A non-empty affinityKey, or the staleness bound of
+// budget, picks the tablet (see getConn). Each retry needs the approval of budget. An action that isn't retryable
+// is not retried once it may have run, unless budget opts in.
+func (sdc *ShardConn) withRetry(context interface{}, action func(conn tabletconn.TabletConn) error, transactionId int64, isStreaming bool, affinityKey string, retryable bool, budget RetryBudget) error {
 	var conn tabletconn.TabletConn
 	var err error
 	var retry bool
 	inTransaction := (transactionId != 0)
+	deadline := contextDeadline(context)
+	maxStaleness := sdc.maxStaleness(budget)
 	// execute the action at least once even without retrying
 	for i := 0; i < sdc.retryCount+1; i++ {
-		conn, err, retry = sdc.getConn(context)
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return sdc.WrapError(ErrDeadlineExceeded, conn, inTransaction)
+		}
+		conn, err, retry = sdc.getConn(context, transactionId, affinityKey, maxStaleness)
 		if err != nil {
-			if retry {
+			if retry && sdc.retryAllowed(context, i, budget) {
 				continue
 			}
 			return sdc.WrapError(err, conn, inTransaction)
 		}
 		// no timeout for streaming query
 		if isStreaming {
+			tabletConnRequests.Add(1)
 			err = action(conn)
+			tabletConnRequests.Add(-1)
+			sdc.endRequest(conn)
 		} else {
-			timer := time.After(sdc.timeout)
+			// The call times out after sdc.timeout, or earlier
+			// at the deadline of the caller.
+			timeout, timeoutErr := sdc.timeout, error(tabletconn.OperationalError("vttablet: call timeout"))
+			if !deadline.IsZero() {
+				if untilDeadline := deadline.Sub(time.Now()); untilDeadline < timeout {
+					timeout, timeoutErr = untilDeadline, ErrDeadlineExceeded
+				}
+			}
+			timer := time.After(timeout)
 			done := make(chan int)
 			var errAction error
+			tabletConnRequests.Add(1)
 			go func() {
 				errAction = action(conn)
+				tabletConnRequests.Add(-1)
+				sdc.endRequest(conn)
 				close(done)
 			}()
 			select {
 			case <-timer:
-				err = tabletconn.OperationalError("vttablet: call timeout")
+				err = timeoutErr
 			case <-done:
 				err = errAction
 			}
 		}
+		if err == nil {
+			sdc.balancer.MarkUp(conn.EndPoint().Uid)
+			return nil
+		}
+		if err == ErrDeadlineExceeded {
+			// Not the tablet's fault, and no time left to retry.
+			return sdc.WrapError(err, conn, inTransaction)
+		}
 		if sdc.canRetry(err, transactionId, conn) {
-			continue
+			if !retryable && mayHaveRun(err) && (budget == nil || !budget.AllowWriteRetry()) {
+				err = tabletconn.OperationalError(fmt.Sprintf("%s: %v", WRITE_NOT_RETRIED_ERR, err))
+				return sdc.WrapError(err, conn, inTransaction)
+			}
+			if sdc.retryAllowed(context, i, budget) {
+				continue
+			}
 		}
 		return sdc.WrapError(err, conn, inTransaction)
 	}
 	return sdc.WrapError(err, conn, inTransaction)
 }
 
-// getConn reuses an existing connection if possible. Otherwise
-// it returns a connection which it will save for future reuse.
+// getConn returns the connection to the tablet the balancer picks
+// for the request, which it opens if needed and keeps for reuse.
 // If it returns an error,  retry will tell you if getConn can be retried.
-func (sdc *ShardConn) getConn(context interface{}) (conn tabletconn.TabletConn, err error, retry bool) {
+// With an affinityKey, the tablet is the one the key hashes to
+// instead, and with a maxStaleness one within that replication lag
+// (see Balancer.GetFresh). A transaction begun here gets
+// the connection it's pinned to, even if markDown closed it since:
+// failing is better than running it on a tablet that doesn't have
+// it. A returned connection counts as a request until endRequest
+// is called.
+func (sdc *ShardConn) getConn(context interface{}, transactionId int64, affinityKey string, maxStaleness time.Duration) (conn tabletconn.TabletConn, err error, retry bool) {
+	if transactionId != 0 {
+		sdc.mu.Lock()
+		conn, ok := sdc.txConns[transactionId]
+		if ok {
+			sdc.startRequest(conn)
+		}
+		sdc.mu.Unlock()
+		if ok {
+			return conn, nil, false
+		}
+	}
+	// The balancer may wait for a tablet: the other
+	// requests go on meanwhile.
+	var endPoint topo.EndPoint
+	switch {
+	case affinityKey != "":
+		endPoint, err = sdc.balancer.GetAffinity(affinityKey)
+	case maxStaleness > 0:
+		endPoint, err = sdc.balancer.GetFresh(maxStaleness)
+	default:
+		endPoint, err = sdc.balancer.Get()
+	}
+	if err != nil {
+		return nil, err, false
+	}
 	sdc.mu.Lock()
 	defer sdc.mu.Unlock()
-	if sdc.conn != nil {
-		return sdc.conn, nil, false
+	sdc.retireConns()
+	if conn, err, retry = sdc.connTo(context, endPoint); err != nil {
+		return nil, err, retry
 	}
+	sdc.startRequest(conn)
+	return conn, nil, false
+}
 
-	endPoint, err := sdc.balancer.Get()
-	if err != nil {
-		return nil, err, false
+// startRequest counts a request on conn in. mu must be held.
+func (sdc *ShardConn) startRequest(conn tabletconn.TabletConn) {
+	sdc.requests++
+	sdc.connRequests[conn]++
+	sdc.lastUsed = time.Now()
+	tabletRequestStarted(conn.EndPoint().Uid)
+}
+
+// retireConns retires the connections to the tablets the balancer
+// doesn't route to anymore, if its routing changed since the last
+// check: their requests go on, the next ones go to other tablets,
+// a new connection is opened if their tablet comes back. mu must
+// be held.
+func (sdc *ShardConn) retireConns() {
+	version := sdc.balancer.routingVersion()
+	if version == sdc.routingVersion {
+		return
+	}
+	sdc.routingVersion = version
+	for uid, conn := range sdc.conns {
+		if sdc.balancer.routable(uid) {
+			continue
+		}
+		log.Infof("%v.%v.%v: retiring the connection to %v", sdc.keyspace, sdc.shard, sdc.tabletType, uid)
+		delete(sdc.conns, uid)
+		sdc.retired[conn] = true
+		sdc.closeIfDone(conn)
+	}
+}
+
+// checkRouting retires the connections to the tablets the balancer
+// doesn't route to anymore, without waiting for the next request.
+func (sdc *ShardConn) checkRouting() {
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	sdc.retireConns()
+}
+
+// closeIfDone closes conn if it's retired, no request uses it and
+// no transaction is pinned to it. mu must be held.
+func (sdc *ShardConn) closeIfDone(conn tabletconn.TabletConn) {
+	if !sdc.retired[conn] || sdc.connRequests[conn] > 0 {
+		return
+	}
+	for _, txConn := range sdc.txConns {
+		if txConn == conn {
+			return
+		}
+	}
+	delete(sdc.retired, conn)
+	go conn.Close()
+	tabletConns.Add(-1)
+}
+
+// endRequest ends a request started by getConn on conn.
+func (sdc *ShardConn) endRequest(conn tabletconn.TabletConn) {
+	tabletRequestEnded(conn.EndPoint().Uid)
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	sdc.requests--
+	if sdc.connRequests[conn] <= 1 {
+		delete(sdc.connRequests, conn)
+	} else {
+		sdc.connRequests[conn]--
+	}
+	sdc.lastUsed = time.Now()
+	sdc.closeIfDone(conn)
+}
+
+// connTo returns the connection to endPoint, which it dials
+// if needed. mu must be held.
+func (sdc *ShardConn) connTo(context interface{}, endPoint topo.EndPoint) (conn tabletconn.TabletConn, err error, retry bool) {
+	if conn, ok := sdc.conns[endPoint.Uid]; ok {
+		return conn, nil, false
 	}
 	conn, err = tabletconn.GetDialer()(context, endPoint, sdc.keyspace, sdc.shard, sdc.timeout)
 	if err != nil {
 		sdc.balancer.MarkDown(endPoint.Uid)
 		return nil, err, true
 	}
-	sdc.conn = conn
-	return sdc.conn, nil, false
+	sdc.conns[endPoint.Uid] = conn
+	tabletConns.Add(1)
+	return conn, nil, false
 }
 
 // canRetry determines whether a query can be retried or not.
@@ -235,6 +705,21 @@ func (sdc *ShardConn) canRetry(err error, transactionId int64, conn tabletconn.T
 	return !inTransaction
 }
 
+// retryAllowed returns true if attempt i, made for the caller of
+// context, can be retried: it isn't the last one, and both budget
+// and the caller's budget allow it.
+func (sdc *ShardConn) retryAllowed(context interface{}, i int, budget RetryBudget) bool {
+	return i < sdc.retryCount && (budget == nil || budget.AllowRetry()) && allowCallerRetry(context)
+}
+
+// mayHaveRun returns true if the query that failed with err may have
+// run anyway: the server errors are returned before, or instead of,
+// running it, but the connection may fail after.
+func mayHaveRun(err error) bool {
+	_, ok := err.(*tabletconn.ServerError)
+	return !ok
+}
+
 func shouldResolveTopo(err error, inTransaction bool) bool {
 	if err == nil {
 		return false
@@ -253,14 +738,39 @@ func shouldResolveTopo(err error, inTransaction bool) bool {
 func (sdc *ShardConn) markDown(conn tabletconn.TabletConn) {
 	sdc.mu.Lock()
 	defer sdc.mu.Unlock()
-	if conn != sdc.conn {
-		return
+	if sdc.forgetConn(conn) {
+		sdc.balancer.MarkDown(conn.EndPoint().Uid)
 	}
-	sdc.balancer.MarkDown(conn.EndPoint().Uid)
+}
+
+// closeConn closes conn, so its requests fail, without marking its
+// end point down. A conn that is not in conns anymore, like the one
+// of a transaction, is closed all the same.
+func (sdc *ShardConn) closeConn(conn tabletconn.TabletConn) {
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	if !sdc.forgetConn(conn) {
+		go conn.Close()
+	}
+}
 
+// forgetConn closes conn if it's in conns or retired, so the next
+// requests open a new one, and returns false if it's neither. mu
+// must be held.
+func (sdc *ShardConn) forgetConn(conn tabletconn.TabletConn) bool {
+	uid := conn.EndPoint().Uid
+	switch {
+	case sdc.conns[uid] == conn:
+		delete(sdc.conns, uid)
+	case sdc.retired[conn]:
+		delete(sdc.retired, conn)
+	default:
+		return false
+	}
 	// Launch as goroutine so we don't block
-	go sdc.conn.Close()
-	sdc.conn = nil
+	go conn.Close()
+	tabletConns.Add(-1)
+	return true
 }
 
 // WrapError returns ShardConnError which preserves the original error code if possible,
@@ -276,6 +786,11 @@ func (sdc *ShardConn) WrapError(in error, conn tabletconn.TabletConn, inTransact
 		shardIdentifier += fmt.Sprintf(", %+v", conn.EndPoint())
 	}
 
+	if message := tabletResultTooLarge(in); message != "" {
+		resultsTooLarge.Add(1)
+		return &ResultTooLargeError{ShardIdentifier: shardIdentifier, Err: message}
+	}
+
 	code := tabletconn.ERR_NORMAL
 	serverError, ok := in.(*tabletconn.ServerError)
 	if ok {
@@ -283,11 +798,34 @@ func (sdc *ShardConn) WrapError(in error, conn tabletconn.TabletConn, inTransact
 	}
 
 	topoReResolve := shouldResolveTopo(in, inTransaction)
+	txLost := inTransaction && confirmsTxLost(in)
+	errStr := in.Error()
+	if txLost {
+		errStr = fmt.Sprintf("%s: %s", TX_LOST_ERR, errStr)
+	}
 
 	shardConnErr := &ShardConnError{Code: code,
-		ShardIdentifier: shardIdentifier,
-		topoReResolve:   topoReResolve,
-		Err:             in.Error(),
+		ShardIdentifier:  shardIdentifier,
+		DeadlineExceeded: in == ErrDeadlineExceeded,
+		topoReResolve:    topoReResolve,
+		txLost:           txLost,
+		Err:              errStr,
 	}
 	return shardConnErr
 }
+
+// confirmsTxLost returns true if err means that the transaction it
+// was returned for is lost for sure: the tablet doesn't know about it
+// (e.g. it's the new master, or it restarted), or the connection the
+// transaction is pinned to is closed, so nothing can commit it anymore
+// and the tablet rolls it back when it times out. The other errors,
+// like RETRY or a call timeout, can leave it alive on the tablet.
+func confirmsTxLost(err error) bool {
+	switch err := err.(type) {
+	case *tabletconn.ServerError:
+		return err.Code == tabletconn.ERR_NOT_IN_TX
+	case tabletconn.OperationalError:
+		return err == tabletconn.CONN_CLOSED
+	}
+	return false
+}
@@ -5,73 +5,104 @@
 package vtgate
 
 import (
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	rpcproto "github.com/youtube/vitess/go/rpcwrap/proto"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
 )
 
 // This file uses the sandbox_test framework.
 
 func TestShardConnExecute(t *testing.T) {
 	testShardConnGeneric(t, func() error {
-		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
-		_, err := sdc.Execute(nil, "query", nil, 0)
+		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+		_, err := sdc.Execute(nil, "query", nil, 0, nil)
 		return err
 	})
 	testShardConnTransact(t, func() error {
-		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
-		_, err := sdc.Execute(nil, "query", nil, 1)
+		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+		_, err := sdc.Execute(nil, "query", nil, 1, nil)
 		return err
 	})
 }
 
 func TestShardConnExecuteBatch(t *testing.T) {
 	testShardConnGeneric(t, func() error {
-		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Millisecond)
 		queries := []tproto.BoundQuery{{"query", nil}}
-		_, err := sdc.ExecuteBatch(nil, queries, 0)
+		_, err := sdc.ExecuteBatch(nil, queries, 0, nil)
 		return err
 	})
 	testShardConnTransact(t, func() error {
-		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Millisecond)
 		queries := []tproto.BoundQuery{{"query", nil}}
-		_, err := sdc.ExecuteBatch(nil, queries, 1)
+		_, err := sdc.ExecuteBatch(nil, queries, 1, nil)
 		return err
 	})
 }
 
 func TestShardConnExecuteStream(t *testing.T) {
 	testShardConnGeneric(t, func() error {
-		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
-		_, errfunc := sdc.StreamExecute(nil, "query", nil, 0)
+		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+		_, errfunc, _ := sdc.StreamExecute(nil, "query", nil, 0, nil)
 		return errfunc()
 	})
 	testShardConnTransact(t, func() error {
-		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
-		_, errfunc := sdc.StreamExecute(nil, "query", nil, 1)
+		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+		_, errfunc, _ := sdc.StreamExecute(nil, "query", nil, 1, nil)
 		return errfunc()
 	})
 }
 
+func TestShardConnStreamAbort(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+	_, errfunc, abort := sdc.StreamExecute(nil, "query", nil, 0, nil)
+	if err := errfunc(); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	abort()
+	// The conn is closed in the background.
+	for i := 0; sbc.CloseCount.Get() == 0 && i < 100; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if got := sbc.CloseCount.Get(); got != 1 {
+		t.Errorf("want 1 close, got %v", got)
+	}
+	// The next request opens a new conn.
+	if _, err := sdc.Execute(nil, "query", nil, 0, nil); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if dialCounter != 2 {
+		t.Errorf("want 2 dials, got %v", dialCounter)
+	}
+}
+
 func TestShardConnBegin(t *testing.T) {
 	testShardConnGeneric(t, func() error {
-		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
-		_, err := sdc.Begin(nil)
+		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+		_, err := sdc.Begin(nil, nil)
 		return err
 	})
 }
 
 func TestShardConnCommi(t *testing.T) {
 	testShardConnTransact(t, func() error {
-		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Millisecond)
 		return sdc.Commit(nil, 1)
 	})
 }
 
 func TestShardConnRollback(t *testing.T) {
 	testShardConnTransact(t, func() error {
-		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+		sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Millisecond)
 		return sdc.Rollback(nil, 1)
 	})
 }
@@ -107,7 +138,7 @@ func testShardConnGeneric(t *testing.T, f func() error) {
 	sbc := &sandboxConn{mustFailRetry: 4}
 	testConns[0] = sbc
 	err = f()
-	want = "retry: err, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1]}"
+	want = "retry: err, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1] Workload: Health:0 Weight:0 HealthMap:map[]}"
 	if err == nil || err.Error() != want {
 		t.Errorf("want %s, got %v", want, err)
 	}
@@ -159,7 +190,7 @@ func testShardConnGeneric(t *testing.T, f func() error) {
 	sbc = &sandboxConn{mustFailServer: 1}
 	testConns[0] = sbc
 	err = f()
-	want = "error: err, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1]}"
+	want = "error: err, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1] Workload: Health:0 Weight:0 HealthMap:map[]}"
 	if err == nil || err.Error() != want {
 		t.Errorf("want %s, got %v", want, err)
 	}
@@ -211,7 +242,7 @@ func testShardConnTransact(t *testing.T, f func() error) {
 	sbc := &sandboxConn{mustFailRetry: 3}
 	testConns[0] = sbc
 	err := f()
-	want := "retry: err, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1]}"
+	want := "retry: err, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1] Workload: Health:0 Weight:0 HealthMap:map[]}"
 	if err == nil || err.Error() != want {
 		t.Errorf("want %s, got %v", want, err)
 	}
@@ -225,7 +256,7 @@ func testShardConnTransact(t *testing.T, f func() error) {
 	sbc = &sandboxConn{mustFailConn: 3}
 	testConns[0] = sbc
 	err = f()
-	want = "error: conn, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1]}"
+	want = "error: conn, shard, host: .0., {Uid:0 Host:0 NamedPortMap:map[vt:1] Workload: Health:0 Weight:0 HealthMap:map[]}"
 	if err == nil || err.Error() != want {
 		t.Errorf("want %s, got %v", want, err)
 	}
@@ -240,9 +271,9 @@ func TestShardConnBeginOther(t *testing.T) {
 	resetSandbox()
 	sbc := &sandboxConn{mustFailTxPool: 1}
 	testConns[0] = sbc
-	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", 10*time.Millisecond, 3, 1*time.Millisecond)
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 10*time.Millisecond, 3, 1*time.Millisecond)
 	startTime := time.Now()
-	_, err := sdc.Begin(nil)
+	_, err := sdc.Begin(nil, nil)
 	// If transaction pool is full, Begin should wait and retry.
 	if time.Now().Sub(startTime) < (10 * time.Millisecond) {
 		t.Errorf("want >10ms, got %v", time.Now().Sub(startTime))
@@ -259,3 +290,582 @@ func TestShardConnBeginOther(t *testing.T) {
 		t.Errorf("want 2, got %v", sbc.ExecCount)
 	}
 }
+
+func TestShardConnMultiplexing(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{mustDelay: 50 * time.Millisecond}
+	testConns[0] = sbc
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Second)
+	conns, requests := tabletConns.Get(), tabletConnRequests.Get()
+
+	// Concurrent queries and transactions share one connection.
+	const sessions = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	txIds := make(map[int64]bool)
+	for i := 0; i < sessions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%2 == 0 {
+				if _, err := sdc.Execute(nil, "query", nil, 0, nil); err != nil {
+					t.Errorf("want nil, got %v", err)
+				}
+				return
+			}
+			txId, err := sdc.Begin(nil, nil)
+			if err != nil {
+				t.Errorf("want nil, got %v", err)
+				return
+			}
+			mu.Lock()
+			txIds[txId] = true
+			mu.Unlock()
+			if err := sdc.Commit(nil, txId); err != nil {
+				t.Errorf("want nil, got %v", err)
+			}
+		}(i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := tabletConnRequests.Get() - requests; got != sessions {
+		t.Errorf("want %v requests in flight, got %v", sessions, got)
+	}
+	wg.Wait()
+
+	if dialCounter != 1 {
+		t.Errorf("want 1 dial, got %v", dialCounter)
+	}
+	if got := tabletConns.Get() - conns; got != 1 {
+		t.Errorf("want 1 new connection, got %v", got)
+	}
+	// Each transaction has its own id.
+	if len(txIds) != sessions/2 {
+		t.Errorf("want %v distinct transaction ids, got %v", sessions/2, txIds)
+	}
+	if sbc.CommitCount.Get() != sessions/2 {
+		t.Errorf("want %v, got %v", sessions/2, sbc.CommitCount.Get())
+	}
+	sdc.Close()
+	if got := tabletConns.Get() - conns; got != 0 {
+		t.Errorf("want 0 new connection, got %v", got)
+	}
+}
+
+func TestShardConnTxAffinity(t *testing.T) {
+	resetSandbox()
+	sandboxEndPoints = map[topo.TabletType][]topo.EndPoint{
+		"": {
+			{Uid: 40, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+			{Uid: 41, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+		},
+	}
+	conns := []*sandboxConn{{}, {}}
+	for i, conn := range conns {
+		testConns[uint32(40+i)] = conn
+	}
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Second)
+	defer sdc.Close()
+
+	txId, err := sdc.Begin(nil, nil)
+	if err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	pinned, other := conns[0], conns[1]
+	if pinned.BeginCount.Get() == 0 {
+		pinned, other = other, pinned
+	}
+
+	// The shared connection moves to the other tablet,
+	// the transaction stays.
+	sdc.markDown(pinned)
+	if _, err := sdc.Execute(nil, "query1", nil, 0, nil); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if lastQuery(other) != "query1" {
+		t.Errorf("want query1 on the other tablet, got %v", other.Queries)
+	}
+	if _, err := sdc.Execute(nil, "query2", nil, txId, nil); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if lastQuery(pinned) != "query2" {
+		t.Errorf("want query2 on the pinned tablet, got %v", pinned.Queries)
+	}
+	if err := sdc.Commit(nil, txId); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if pinned.CommitCount.Get() != 1 || other.CommitCount.Get() != 0 {
+		t.Errorf("want the commit on the pinned tablet, got %v, %v", pinned.CommitCount.Get(), other.CommitCount.Get())
+	}
+	if len(sdc.txConns) != 0 {
+		t.Errorf("want no pinned transaction, got %v", sdc.txConns)
+	}
+}
+
+func TestShardConnWeighted(t *testing.T) {
+	defer func() { *balancerStrategy = BALANCE_ROUND_ROBIN }()
+	*balancerStrategy = BALANCE_WEIGHTED
+	resetSandbox()
+	sandboxEndPoints = map[topo.TabletType][]topo.EndPoint{
+		"": {
+			{Uid: 50, Host: "0", NamedPortMap: map[string]int{"vt": 1}, Weight: 3 * topo.DEFAULT_WEIGHT},
+			{Uid: 51, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+		},
+	}
+	heavy, light := &sandboxConn{}, &sandboxConn{}
+	testConns[50], testConns[51] = heavy, light
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Second)
+	defer sdc.Close()
+
+	// The weights apply to each request, over one connection
+	// per tablet.
+	for i := 0; i < 40; i++ {
+		if _, err := sdc.Execute(nil, "query", nil, 0, nil); err != nil {
+			t.Fatalf("want nil, got %v", err)
+		}
+	}
+	if heavy.ExecCount.Get() != 30 || light.ExecCount.Get() != 10 {
+		t.Errorf("want 30 and 10 queries, got %v and %v", heavy.ExecCount.Get(), light.ExecCount.Get())
+	}
+	if dialCounter != 2 {
+		t.Errorf("want 2 dials, got %v", dialCounter)
+	}
+}
+
+func TestShardConnUnhealthy(t *testing.T) {
+	defer func(minHealth int) { *tabletMinHealth = minHealth }(*tabletMinHealth)
+	*tabletMinHealth = topo.MAX_HEALTH / 2
+	resetSandbox()
+	sandboxEndPoints = map[topo.TabletType][]topo.EndPoint{
+		"": {
+			{Uid: 60, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+			{Uid: 61, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+		},
+	}
+	sick, healthy := &sandboxConn{}, &sandboxConn{}
+	testConns[60], testConns[61] = sick, healthy
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Second)
+	defer sdc.Close()
+	execute := func() {
+		for i := 0; i < 4; i++ {
+			if _, err := sdc.Execute(nil, "query", nil, 0, nil); err != nil {
+				t.Fatalf("want nil, got %v", err)
+			}
+		}
+	}
+	execute()
+	if sick.ExecCount.Get() != 2 || healthy.ExecCount.Get() != 2 {
+		t.Fatalf("want 2 and 2 queries, got %v and %v", sick.ExecCount.Get(), healthy.ExecCount.Get())
+	}
+
+	// Once a refresh sees it unhealthy, the tablet gets no more
+	// queries, and its connection is closed.
+	conns := tabletConns.Get()
+	sandboxEndPoints[""][0].Health = 1
+	sdc.balancer.mu.Lock()
+	sdc.balancer.refresh()
+	sdc.balancer.mu.Unlock()
+	execute()
+	if sick.ExecCount.Get() != 2 || healthy.ExecCount.Get() != 6 {
+		t.Errorf("want 2 and 6 queries, got %v and %v", sick.ExecCount.Get(), healthy.ExecCount.Get())
+	}
+	if _, ok := sdc.conns[60]; ok || len(sdc.retired) != 0 {
+		t.Errorf("want the connection to 60 closed, got %v, %v", sdc.conns, sdc.retired)
+	}
+	if got := conns - tabletConns.Get(); got != 1 {
+		t.Errorf("want 1 connection closed, got %v", got)
+	}
+}
+
+func TestShardConnBlacklist(t *testing.T) {
+	defer BlacklistTablet(0, 0)
+	resetSandbox()
+	sandboxEndPoints = map[topo.TabletType][]topo.EndPoint{
+		"": {
+			{Uid: 0, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+			{Uid: 1, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+		},
+	}
+	blacklisted, other := &sandboxConn{}, &sandboxConn{}
+	testConns[0], testConns[1] = blacklisted, other
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Second)
+	defer sdc.Close()
+	var txId int64
+	for i := 0; i < 2; i++ {
+		id, err := sdc.Begin(nil, nil)
+		if err != nil {
+			t.Fatalf("want nil, got %v", err)
+		}
+		defer sdc.Rollback(nil, id)
+		sdc.mu.Lock()
+		if sdc.txConns[id].EndPoint().Uid == 0 {
+			txId = id
+		}
+		sdc.mu.Unlock()
+	}
+	if txId == 0 {
+		t.Fatalf("want a transaction on 0, got none")
+	}
+
+	// The transaction open on 0 goes on, the other queries go to 1.
+	BlacklistTablet(0, time.Hour)
+	for i := 0; i < 4; i++ {
+		if _, err := sdc.Execute(nil, "query", nil, 0, nil); err != nil {
+			t.Fatalf("want nil, got %v", err)
+		}
+	}
+	if _, err := sdc.Execute(nil, "query", nil, txId, nil); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	if blacklisted.ExecCount.Get() != 2 || other.ExecCount.Get() != 5 {
+		t.Errorf("want 2 and 5 queries, got %v and %v", blacklisted.ExecCount.Get(), other.ExecCount.Get())
+	}
+	sdc.mu.Lock()
+	if _, ok := sdc.conns[0]; ok || !sdc.retired[testConns[0]] {
+		t.Errorf("want the connection to 0 retired, got %v, %v", sdc.conns, sdc.retired)
+	}
+	sdc.mu.Unlock()
+
+	// The connection to 0 is closed once the transaction is.
+	if err := sdc.Commit(nil, txId); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	sdc.mu.Lock()
+	if len(sdc.retired) != 0 {
+		t.Errorf("want the connection to 0 closed, got %v", sdc.retired)
+	}
+	sdc.mu.Unlock()
+}
+
+// watchTopo is a sandboxTopo that sends updates as the end points.
+type watchTopo struct {
+	sandboxTopo
+	updates chan *topo.EndPoints
+}
+
+func (wt *watchTopo) WatchEndPoints(cell, keyspace, shard string, tabletType topo.TabletType, stop <-chan struct{}) (<-chan *topo.EndPoints, error) {
+	return wt.updates, nil
+}
+
+func TestShardConnWatch(t *testing.T) {
+	defer func(watch bool) { *watchEndPoints = watch }(*watchEndPoints)
+	*watchEndPoints = true
+	resetSandbox()
+	sandboxEndPoints = map[topo.TabletType][]topo.EndPoint{
+		"": {
+			{Uid: 70, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+			{Uid: 71, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+		},
+	}
+	removed, kept, added := &sandboxConn{}, &sandboxConn{}, &sandboxConn{}
+	testConns[70], testConns[71], testConns[72] = removed, kept, added
+	updates := make(chan *topo.EndPoints)
+	defer close(updates)
+	sdc := NewShardConn(&watchTopo{updates: updates}, "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Second)
+	defer sdc.Close()
+	execute := func(n int) {
+		for i := 0; i < n; i++ {
+			if _, err := sdc.Execute(nil, "query", nil, 0, nil); err != nil {
+				t.Fatalf("want nil, got %v", err)
+			}
+		}
+	}
+	execute(2)
+
+	// The connection to 70 is closed when the update comes, and 72
+	// gets its share of the next queries. The update is sent twice
+	// to wait for the first one to be applied.
+	endPoints := &topo.EndPoints{Entries: []topo.EndPoint{
+		{Uid: 71, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+		{Uid: 72, Host: "0", NamedPortMap: map[string]int{"vt": 1}},
+	}}
+	updates <- endPoints
+	updates <- endPoints
+	sdc.mu.Lock()
+	if _, ok := sdc.conns[70]; ok || len(sdc.retired) != 0 {
+		t.Errorf("want the connection to 70 closed, got %v, %v", sdc.conns, sdc.retired)
+	}
+	sdc.mu.Unlock()
+	execute(4)
+	if removed.ExecCount.Get() != 1 || kept.ExecCount.Get() != 3 || added.ExecCount.Get() != 2 {
+		t.Errorf("want 1, 3 and 2 queries, got %v, %v and %v", removed.ExecCount.Get(), kept.ExecCount.Get(), added.ExecCount.Get())
+	}
+}
+
+func TestShardConnRetryBudget(t *testing.T) {
+	defer func(budget int, window time.Duration) {
+		*retryBudget, *retryBudgetWindow = budget, window
+	}(*retryBudget, *retryBudgetWindow)
+	*retryBudget = 4
+	*retryBudgetWindow = time.Hour
+	callerRetryBudgets = newRetryBudgets()
+
+	resetSandbox()
+	sbc := &sandboxConn{mustFailRetry: 100}
+	testConns[0] = sbc
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Millisecond)
+	caller := &rpcproto.Context{Username: "user1", RemoteAddr: "host1:1234"}
+	exhausted := retryBudgetExhausted.Get()
+
+	// The first query retries 3 times, the second one once.
+	for i, want := range []int64{4, 6} {
+		if _, err := sdc.Execute(caller, "query", nil, 0, NewSafeSession(&proto.Session{})); err == nil {
+			t.Fatalf("query %v: want error, got nil", i)
+		}
+		if got := sbc.ExecCount.Get(); got != want {
+			t.Errorf("query %v: want %v tablet calls, got %v", i, want, got)
+		}
+	}
+	if got := retryBudgetExhausted.Get() - exhausted; got != 1 {
+		t.Errorf("want the budget exhausted once, got %v", got)
+	}
+
+	// No budget left: fail fast, with a new session or none.
+	sdc.Execute(caller, "query", nil, 0, NewSafeSession(&proto.Session{}))
+	sdc.Execute(caller, "query", nil, 0, nil)
+	if got := sbc.ExecCount.Get(); got != 8 {
+		t.Errorf("want 8 tablet calls, got %v", got)
+	}
+
+	// Other callers still retry, by username or else by host.
+	sdc.Execute(&rpcproto.Context{Username: "user2", RemoteAddr: "host1:1234"}, "query", nil, 0, nil)
+	sdc.Execute(&rpcproto.Context{RemoteAddr: "host2:1234"}, "query", nil, 0, nil)
+	if got := sbc.ExecCount.Get(); got != 16 {
+		t.Errorf("want 16 tablet calls, got %v", got)
+	}
+	// host2 has one retry left, whatever its port.
+	sdc.Execute(&rpcproto.Context{RemoteAddr: "host2:5678"}, "query", nil, 0, nil)
+	if got := sbc.ExecCount.Get(); got != 18 {
+		t.Errorf("want 18 tablet calls, got %v", got)
+	}
+
+	// The budget refills over the window.
+	callerRetryBudgets.buckets[callerKey(caller)].refillTime = time.Now().Add(-*retryBudgetWindow / 4)
+	sdc.Execute(caller, "query", nil, 0, nil)
+	if got := sbc.ExecCount.Get(); got != 20 {
+		t.Errorf("want 20 tablet calls, got %v", got)
+	}
+}
+
+func TestRetryBudgetsSweep(t *testing.T) {
+	rb := newRetryBudgets()
+	now := time.Now()
+	rb.allow("caller1", now, 4, time.Minute)
+	rb.allow("caller2", now.Add(30*time.Second), 4, time.Minute)
+	rb.allow("caller2", now.Add(90*time.Second), 4, time.Minute)
+	if _, ok := rb.buckets["caller1"]; ok || len(rb.buckets) != 1 {
+		t.Errorf("want only caller2 left, got %v", rb.buckets)
+	}
+}
+
+func TestShardConnDeadline(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{mustDelay: 50 * time.Millisecond}
+	testConns[0] = sbc
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Second)
+
+	// The deadline is shorter than the timeout: it wins, and there's no retry.
+	context := &rpcproto.Context{Deadline: time.Now().Add(10 * time.Millisecond)}
+	_, err := sdc.Execute(context, "query", nil, 0, nil)
+	sdcErr, ok := err.(*ShardConnError)
+	if !ok || !sdcErr.DeadlineExceeded {
+		t.Errorf("want deadline exceeded, got %v", err)
+	}
+	if sbc.ExecCount.Get() != 1 {
+		t.Errorf("want 1, got %v", sbc.ExecCount.Get())
+	}
+
+	// A passed deadline doesn't even reach the tablet.
+	context = &rpcproto.Context{Deadline: time.Now().Add(-time.Second)}
+	_, err = sdc.Execute(context, "query", nil, 0, nil)
+	if sdcErr, ok := err.(*ShardConnError); !ok || !sdcErr.DeadlineExceeded {
+		t.Errorf("want deadline exceeded, got %v", err)
+	}
+	if sbc.ExecCount.Get() != 1 {
+		t.Errorf("want 1, got %v", sbc.ExecCount.Get())
+	}
+
+	// A far deadline changes nothing.
+	context = &rpcproto.Context{Deadline: time.Now().Add(time.Minute)}
+	if _, err := sdc.Execute(context, "query", nil, 0, nil); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+}
+
+func TestShardConnResultTooLarge(t *testing.T) {
+	defer func(rows, bytes int) {
+		*maxResultRows, *maxResultBytes = rows, bytes
+	}(*maxResultRows, *maxResultBytes)
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Second)
+	defer sdc.Close()
+
+	// The tablet fails the query, the error says why.
+	*maxResultBytes = 3
+	tooLarge := resultsTooLarge.Get()
+	_, err := sdc.Execute(nil, "query", nil, 0, nil)
+	tooLargeErr, ok := err.(*ResultTooLargeError)
+	if !ok || tooLargeErr.ShardIdentifier == "" || !strings.HasPrefix(err.Error(), RESULT_TOO_LARGE_ERR) {
+		t.Errorf("want a ResultTooLargeError from the tablet, got %#v", err)
+	}
+	if got := resultsTooLarge.Get() - tooLarge; got != 1 {
+		t.Errorf("want 1 result too large, got %v", got)
+	}
+	if sbc.ExecCount.Get() != 1 {
+		t.Errorf("want 1, got %v", sbc.ExecCount.Get())
+	}
+}
+
+func TestShardConnCloseIfIdle(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{mustDelay: 50 * time.Millisecond}
+	testConns[0] = sbc
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Second)
+	defer sdc.Close()
+
+	// Connections used by a request are never idle.
+	done := make(chan struct{})
+	go func() {
+		sdc.StreamExecute(nil, "query", nil, 0, nil)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if sdc.CloseIfIdle(time.Now().Add(time.Hour), time.Minute, time.Minute) {
+		t.Errorf("want false, got true")
+	}
+	<-done
+	if !sdc.CloseIfIdle(time.Now().Add(time.Hour), time.Minute, time.Minute) {
+		t.Errorf("want true, got false")
+	}
+}
+
+func TestShardConnCloseIfIdleRollback(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Second)
+	defer sdc.Close()
+
+	txId, err := sdc.Begin(nil, nil)
+	if err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	// Open transactions keep the connections for txIdleTimeout,
+	// then they're rolled back.
+	if sdc.CloseIfIdle(time.Now().Add(time.Minute), time.Second, time.Hour) {
+		t.Errorf("want false, got true")
+	}
+	if !sdc.CloseIfIdle(time.Now().Add(2*time.Hour), time.Second, time.Hour) {
+		t.Errorf("want true, got false")
+	}
+	if sbc.RollbackCount.Get() != 1 {
+		t.Errorf("want 1 rollback, got %v", sbc.RollbackCount.Get())
+	}
+	if len(sdc.txConns) != 0 || sdc.openTx != 0 {
+		t.Errorf("want transaction %v forgotten, got %v, %v", txId, sdc.txConns, sdc.openTx)
+	}
+}
+
+func TestShardConnRollbackAllTimeout(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 10*time.Millisecond)
+	defer sdc.Close()
+
+	if _, err := sdc.Begin(nil, nil); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	// A hung rollback gives up after the timeout, and doesn't
+	// hold the lock meanwhile.
+	sbc.mustDelay = time.Second
+	done := make(chan int)
+	go func() {
+		done <- sdc.RollbackAll("test")
+	}()
+	locked := make(chan bool)
+	go func() {
+		sdc.mu.Lock()
+		sdc.mu.Unlock()
+		locked <- true
+	}()
+	select {
+	case <-locked:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("want the lock free during the rollback")
+	}
+	select {
+	case count := <-done:
+		if count != 1 {
+			t.Errorf("want 1, got %v", count)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatalf("want the rollback to time out")
+	}
+	if len(sdc.txConns) != 0 || sdc.openTx != 0 {
+		t.Errorf("want the transaction forgotten, got %v, %v", sdc.txConns, sdc.openTx)
+	}
+}
+
+func TestShardConnWriteRetry(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{mustFailConn: 1}
+	testConns[0] = sbc
+	sdc := NewShardConn(new(sandboxTopo), "aa", "", "0", "", "", 1*time.Millisecond, 3, 1*time.Second)
+
+	// A DML that may have been applied is not retried.
+	_, err := sdc.Execute(nil, "insert into t values(1)", nil, 0, nil)
+	want := "non-retryable write failed, it may have been applied: error: conn"
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("want %v, got %v", want, err)
+	}
+	if sbc.ExecCount.Get() != 1 {
+		t.Errorf("want 1, got %v", sbc.ExecCount.Get())
+	}
+
+	// Server errors are returned before running it: it's retried.
+	sbc.mustFailRetry = 1
+	if _, err := sdc.Execute(nil, "insert into t values(1)", nil, 0, nil); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if sbc.ExecCount.Get() != 3 {
+		t.Errorf("want 3, got %v", sbc.ExecCount.Get())
+	}
+
+	// The session can opt in.
+	sbc.mustFailConn = 1
+	session := NewSafeSession(&proto.Session{RetryWrites: true})
+	if _, err := sdc.Execute(nil, "insert into t values(1)", nil, 0, session); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if sbc.ExecCount.Get() != 5 {
+		t.Errorf("want 5, got %v", sbc.ExecCount.Get())
+	}
+
+	// Batches with a DML aren't retried either.
+	sbc.mustFailConn = 1
+	queries := []tproto.BoundQuery{{Sql: "select 1"}, {Sql: "delete from t"}}
+	if _, err := sdc.ExecuteBatch(nil, queries, 0, nil); err == nil {
+		t.Errorf("want error, got nil")
+	}
+	if sbc.ExecCount.Get() != 6 {
+		t.Errorf("want 6, got %v", sbc.ExecCount.Get())
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for sql, want := range map[string]bool{
+		"select * from t":         true,
+		"  SELECT 1":              true,
+		"show tables":             true,
+		"insert into t values()":  false,
+		"Update t set a=1":        false,
+		"delete from t":           false,
+		"replace into t values()": false,
+	} {
+		if got := IsRetryable(sql); got != want {
+			t.Errorf("IsRetryable(%q): want %v, got %v", sql, want, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+)
+
+var (
+	slowQueriesCount  = flag.Int("slow_queries_count", 20, "number of slowest queries shown by /debug/slow_queries")
+	slowQueriesMaxAge = flag.Duration("slow_queries_max_age", 1*time.Hour, "how long a query stays in /debug/slow_queries")
+)
+
+// slowQueries keeps the slowest recent queries of Execute and StreamExecute.
+var slowQueries = &slowQueryList{}
+
+// slowQuery is an entry of slowQueryList. Sql doesn't include the bind
+// variables, so it's the shape of the query. ConnectionId is the MySQL
+// connection id, 0 if not known.
+type slowQuery struct {
+	Sql          string
+	Keyspace     string
+	ConnectionId int64
+	Duration     time.Duration
+	Time         time.Time
+}
+
+// slowQueryList is a bounded list of the slowest queries, sorted
+// from the slowest. It is safe for concurrent use.
+type slowQueryList struct {
+	mu      sync.Mutex
+	entries []slowQuery
+}
+
+// record adds the query to the list if it's one of the
+// *slowQueriesCount slowest of the last *slowQueriesMaxAge.
+func (list *slowQueryList) record(query, keyspace string, connectionId int64, start time.Time) {
+	now := time.Now()
+	list.add(slowQuery{
+		Sql:          query,
+		Keyspace:     keyspace,
+		ConnectionId: connectionId,
+		Duration:     now.Sub(start),
+		Time:         now,
+	}, now, *slowQueriesCount, *slowQueriesMaxAge)
+}
+
+func (list *slowQueryList) add(q slowQuery, now time.Time, count int, maxAge time.Duration) {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	list.expire(now, maxAge)
+	i := sort.Search(len(list.entries), func(i int) bool {
+		return list.entries[i].Duration < q.Duration
+	})
+	if i >= count {
+		return
+	}
+	list.entries = append(list.entries, slowQuery{})
+	copy(list.entries[i+1:], list.entries[i:])
+	list.entries[i] = q
+	if len(list.entries) > count {
+		list.entries = list.entries[:count]
+	}
+}
+
+// expire removes the entries older than maxAge. mu must be held.
+func (list *slowQueryList) expire(now time.Time, maxAge time.Duration) {
+	kept := list.entries[:0]
+	for _, e := range list.entries {
+		if now.Sub(e.Time) <= maxAge {
+			kept = append(kept, e)
+		}
+	}
+	list.entries = kept
+}
+
+// Entries returns a copy of the list, without the expired entries.
+func (list *slowQueryList) Entries() []slowQuery {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	list.expire(time.Now(), *slowQueriesMaxAge)
+	entries := make([]slowQuery, len(list.entries))
+	copy(entries, list.entries)
+	return entries
+}
+
+// Reset empties the list.
+func (list *slowQueryList) Reset() {
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	list.entries = nil
+}
+
+var slowQueriesTmpl = template.Must(template.New("slow_queries").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Slow queries</title></head>
+<body>
+<p>The {{len .}} slowest queries of the recent ones. <a href="?reset=1">Reset</a></p>
+<table border="1">
+<tr><th>Duration</th><th>Keyspace</th><th>Connection id</th><th>Time</th><th>Query</th></tr>
+{{range .}}<tr><td>{{.Duration}}</td><td>{{.Keyspace}}</td><td>{{if .ConnectionId}}{{.ConnectionId}}{{end}}</td><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Sql}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func init() {
+	http.HandleFunc("/debug/slow_queries", slowQueriesHandler)
+}
+
+// slowQueriesHandler displays the slowest recent queries.
+// ?reset=1 empties the list first.
+func slowQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("reset") != "" {
+		slowQueries.Reset()
+	}
+	if err := slowQueriesTmpl.Execute(w, slowQueries.Entries()); err != nil {
+		log.Errorf("slow_queries: %v", err)
+	}
+}
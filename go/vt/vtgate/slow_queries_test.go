@@ -0,0 +1,78 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+func TestSlowQueryList(t *testing.T) {
+	now := time.Now()
+	list := &slowQueryList{}
+	add := func(sql string, d time.Duration, age time.Duration) {
+		list.add(slowQuery{Sql: sql, Duration: d, Time: now.Add(-age)}, now, 3, time.Hour)
+	}
+	add("q1", 2*time.Second, 0)
+	add("q2", 1*time.Second, 0)
+	add("q3", 3*time.Second, 0)
+	add("q4", 500*time.Millisecond, 0)
+	add("q5", 4*time.Second, 0)
+	sqls := func() []string {
+		var result []string
+		for _, e := range list.entries {
+			result = append(result, e.Sql)
+		}
+		return result
+	}
+	// Sorted from the slowest, and bounded.
+	if got, want := sqls(), []string{"q5", "q3", "q1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	// Old entries age out.
+	list.entries[0].Time = now.Add(-2 * time.Hour)
+	add("q6", 1*time.Millisecond, 0)
+	if got, want := sqls(), []string{"q3", "q1", "q6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	list.Reset()
+	if got := list.Entries(); len(got) != 0 {
+		t.Errorf("want empty, got %v", got)
+	}
+}
+
+func TestSlowQueriesRecorded(t *testing.T) {
+	resetSandbox()
+	testConns[0] = &sandboxConn{}
+	slowQueries.Reset()
+	q := proto.QueryShard{
+		Sql:      "select slow",
+		Keyspace: "ks",
+		Shards:   []string{"0"},
+	}
+	RpcVTGate.ExecuteShard(nil, &q, new(proto.QueryResult))
+	entries := slowQueries.Entries()
+	if len(entries) != 1 || entries[0].Sql != "select slow" || entries[0].Keyspace != "ks" {
+		t.Errorf("want select slow on ks, got %+v", entries)
+	}
+
+	slowQueries.Reset()
+	bq := proto.BatchQueryShard{
+		Queries:  []tproto.BoundQuery{{Sql: "select one"}, {Sql: "select two"}},
+		Keyspace: "ks",
+		Shards:   []string{"0"},
+	}
+	RpcVTGate.ExecuteBatchShard(nil, &bq, new(proto.QueryResultList))
+	entries = slowQueries.Entries()
+	if len(entries) != 1 || entries[0].Sql != "select one; select two" {
+		t.Errorf("want the batch recorded, got %+v", entries)
+	}
+}
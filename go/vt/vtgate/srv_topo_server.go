@@ -42,6 +42,8 @@ type SrvTopoServer interface {
 type ResilientSrvTopoServer struct {
 	topoServer SrvTopoServer
 	counts     *stats.Counters
+	// refreshErrors counts the failed refreshes of the cache, by key.
+	refreshErrors *stats.Counters
 
 	// mu protects the cache map itself, not the individual values
 	// in the cache.
@@ -49,6 +51,10 @@ type ResilientSrvTopoServer struct {
 	srvKeyspaceNamesCache map[string]*srvKeyspaceNamesEntry
 	srvKeyspaceCache      map[string]*srvKeyspaceEntry
 	endPointsCache        map[string]*endPointsEntry
+	// endPointsRefreshed is when the entries of endPointsCache were
+	// last refreshed. It's kept out of the entries, which stay
+	// locked while they're refreshed.
+	endPointsRefreshed map[string]time.Time
 }
 
 type srvKeyspaceNamesEntry struct {
@@ -79,12 +85,29 @@ type endPointsEntry struct {
 // based on the provided SrvTopoServer.
 func NewResilientSrvTopoServer(base SrvTopoServer) *ResilientSrvTopoServer {
 	return &ResilientSrvTopoServer{
-		topoServer: base,
-		counts:     stats.NewCounters("ResilientSrvTopoServerCounts"),
+		topoServer:    base,
+		counts:        stats.NewCounters("ResilientSrvTopoServerCounts"),
+		refreshErrors: stats.NewCounters("ResilientSrvTopoServerRefreshErrors"),
 
 		srvKeyspaceNamesCache: make(map[string]*srvKeyspaceNamesEntry),
 		srvKeyspaceCache:      make(map[string]*srvKeyspaceEntry),
 		endPointsCache:        make(map[string]*endPointsEntry),
+		endPointsRefreshed:    make(map[string]time.Time),
+	}
+}
+
+// Renew returns a new ResilientSrvTopoServer based on base, with an
+// empty cache. It shares the counters of server.
+func (server *ResilientSrvTopoServer) Renew(base SrvTopoServer) *ResilientSrvTopoServer {
+	return &ResilientSrvTopoServer{
+		topoServer:    base,
+		counts:        server.counts,
+		refreshErrors: server.refreshErrors,
+
+		srvKeyspaceNamesCache: make(map[string]*srvKeyspaceNamesEntry),
+		srvKeyspaceCache:      make(map[string]*srvKeyspaceEntry),
+		endPointsCache:        make(map[string]*endPointsEntry),
+		endPointsRefreshed:    make(map[string]time.Time),
 	}
 }
 
@@ -115,6 +138,7 @@ func (server *ResilientSrvTopoServer) GetSrvKeyspaceNames(cell string) ([]string
 	// not in cache or too old, get the real value
 	result, err := server.topoServer.GetSrvKeyspaceNames(cell)
 	if err != nil {
+		server.refreshErrors.Add(key, 1)
 		if entry.insertionTime.IsZero() {
 			server.counts.Add(errorCategory, 1)
 			log.Errorf("GetSrvKeyspaceNames(%v) failed: %v (no cached value, returning error)", cell, err)
@@ -159,6 +183,7 @@ func (server *ResilientSrvTopoServer) GetSrvKeyspace(cell, keyspace string) (*to
 	// not in cache or too old, get the real value
 	result, err := server.topoServer.GetSrvKeyspace(cell, keyspace)
 	if err != nil {
+		server.refreshErrors.Add(key, 1)
 		if entry.insertionTime.IsZero() {
 			server.counts.Add(errorCategory, 1)
 			log.Errorf("GetSrvKeyspace(%v, %v) failed: %v (no cached value, returning error)", cell, keyspace, err)
@@ -203,6 +228,7 @@ func (server *ResilientSrvTopoServer) GetEndPoints(cell, keyspace, shard string,
 	// not in cache or too old, get the real value
 	result, err := server.topoServer.GetEndPoints(cell, keyspace, shard, tabletType)
 	if err != nil {
+		server.refreshErrors.Add(key, 1)
 		if entry.insertionTime.IsZero() {
 			server.counts.Add(errorCategory, 1)
 			log.Errorf("GetEndPoints(%v, %v, %v, %v) failed: %v (no cached value, returning error)", cell, keyspace, shard, tabletType, err)
@@ -217,5 +243,70 @@ func (server *ResilientSrvTopoServer) GetEndPoints(cell, keyspace, shard string,
 	// save the value we got and the current time in the cache
 	entry.insertionTime = time.Now()
 	entry.value = result
+	server.mutex.Lock()
+	server.endPointsRefreshed[key] = entry.insertionTime
+	server.mutex.Unlock()
 	return result, nil
 }
+
+// EndPointsCacheAges returns the age of the cached end points, in
+// milliseconds, by cell:keyspace:shard:tablet_type. The entries that
+// fail to refresh keep aging past -srv_topo_cache_ttl.
+func (server *ResilientSrvTopoServer) EndPointsCacheAges() map[string]int64 {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	now := time.Now()
+	ages := make(map[string]int64, len(server.endPointsRefreshed))
+	for key, refreshed := range server.endPointsRefreshed {
+		ages[key] = int64(now.Sub(refreshed) / time.Millisecond)
+	}
+	return ages
+}
+
+// WatchEndPoints is part of the EndPointsWatcher interface. It returns
+// ErrWatchNotSupported if the underlying server is not an
+// EndPointsWatcher. The end points it sends are cached as well, so the
+// balancers that refresh before the cache expires don't get old ones.
+func (server *ResilientSrvTopoServer) WatchEndPoints(cell, keyspace, shard string, tabletType topo.TabletType, stop <-chan struct{}) (<-chan *topo.EndPoints, error) {
+	watcher, ok := server.topoServer.(EndPointsWatcher)
+	if !ok {
+		return nil, ErrWatchNotSupported
+	}
+	key := cell + ":" + keyspace + ":" + shard + ":" + string(tabletType)
+	watched, err := watcher.WatchEndPoints(cell, keyspace, shard, tabletType, stop)
+	if err == ErrWatchNotSupported {
+		return nil, err
+	}
+	if err != nil {
+		server.refreshErrors.Add(key, 1)
+		return nil, err
+	}
+	updates := make(chan *topo.EndPoints)
+	go func() {
+		defer close(updates)
+		for endPoints := range watched {
+			server.mutex.Lock()
+			entry, ok := server.endPointsCache[key]
+			if !ok {
+				entry = &endPointsEntry{}
+				server.endPointsCache[key] = entry
+			}
+			server.mutex.Unlock()
+
+			entry.mutex.Lock()
+			entry.insertionTime = time.Now()
+			entry.value = endPoints
+			entry.mutex.Unlock()
+			server.mutex.Lock()
+			server.endPointsRefreshed[key] = entry.insertionTime
+			server.mutex.Unlock()
+
+			select {
+			case updates <- endPoints:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return updates, nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+func TestResilientSrvTopoServerStats(t *testing.T) {
+	defer func(ttl time.Duration) { *srvTopoCacheTTL = ttl }(*srvTopoCacheTTL)
+	*srvTopoCacheTTL = 0
+	resetSandbox()
+	rsts := NewResilientSrvTopoServer(new(sandboxTopo))
+	const key = "aa:ks:0:replica"
+
+	if _, err := rsts.GetEndPoints("aa", "ks", "0", topo.TYPE_REPLICA); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if age := rsts.EndPointsCacheAges()[key]; age < 10 {
+		t.Errorf("want an age >10ms, got %v", age)
+	}
+
+	// A failed refresh serves the cached end points, which keep aging.
+	endPointMustFail = 1
+	if _, err := rsts.GetEndPoints("aa", "ks", "0", topo.TYPE_REPLICA); err != nil {
+		t.Errorf("want the cached end points, got %v", err)
+	}
+	if got := rsts.refreshErrors.Counts()[key]; got != 1 {
+		t.Errorf("want 1 refresh error, got %v", got)
+	}
+	if age := rsts.EndPointsCacheAges()[key]; age < 10 {
+		t.Errorf("want an age >10ms, got %v", age)
+	}
+
+	// A successful one resets the age.
+	if _, err := rsts.GetEndPoints("aa", "ks", "0", topo.TYPE_REPLICA); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if age := rsts.EndPointsCacheAges()[key]; age >= 10 {
+		t.Errorf("want an age <10ms, got %v", age)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"errors"
+	"time"
+
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+// ErrNoFreshEndPoint is returned by Balancer.GetFresh when no tablet
+// it could use reports a replication lag within the staleness bound.
+var ErrNoFreshEndPoint = errors.New("no tablet within the staleness bound")
+
+// stalenessFallbacks counts the reads sent to the master because no
+// replica was within their staleness bound, by keyspace.shard.
+var stalenessFallbacks = stats.NewCounters("VtgateStalenessFallbacks")
+
+// isFresh returns true if endPoint reports a replication lag within
+// maxStaleness. The tablets that report none, like those whose
+// replication is broken, are not.
+func isFresh(endPoint *topo.EndPoint, maxStaleness time.Duration) bool {
+	lag, ok := endPoint.ReplicationLag()
+	return ok && lag <= maxStaleness
+}
+
+// freshNodes returns the nodes that are not marked down or blacklisted
+// and are within maxStaleness, in round-robin order. It refreshes the
+// end points first if the lags they report are older than the recovery
+// window. mu must be held.
+func (blc *Balancer) freshNodes(maxStaleness time.Duration) ([]*addressStatus, error) {
+	if len(blc.addressNodes) == 0 || time.Now().Sub(blc.lastRefresh) > blc.recoveryWindow() {
+		if err := blc.refresh(); err != nil {
+			return nil, err
+		}
+	}
+	now := time.Now()
+	var nodes []*addressStatus
+	for i := range blc.addressNodes {
+		addrNode := blc.addressNodes[(blc.index+i+1)%len(blc.addressNodes)]
+		if !addrNode.timeRetry.IsZero() && now.Before(addrNode.timeRetry) || addrNode.probing(now) || isBlacklisted(addrNode.endPoint.Uid, now) {
+			continue
+		}
+		if isFresh(&addrNode.endPoint, maxStaleness) {
+			nodes = append(nodes, addrNode)
+		}
+	}
+	return nodes, nil
+}
+
+// HasFreshEndPoints returns true if an end point that is not marked
+// down or blacklisted reports a replication lag within maxStaleness.
+func (blc *Balancer) HasFreshEndPoints(maxStaleness time.Duration) bool {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	nodes, err := blc.freshNodes(maxStaleness)
+	return err == nil && len(nodes) != 0
+}
+
+// GetFresh is Get among the end points that report a replication lag
+// within maxStaleness. It returns ErrNoFreshEndPoint if there are none,
+// instead of waiting.
+func (blc *Balancer) GetFresh(maxStaleness time.Duration) (topo.EndPoint, error) {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	nodes, err := blc.freshNodes(maxStaleness)
+	if err != nil {
+		return topo.EndPoint{}, err
+	}
+	if len(nodes) == 0 {
+		return topo.EndPoint{}, ErrNoFreshEndPoint
+	}
+	if blc.selectFunc != nil {
+		endPoints := make([]topo.EndPoint, len(nodes))
+		for i, addrNode := range nodes {
+			endPoints[i] = addrNode.endPoint
+		}
+		if index := blc.selectFunc(endPoints); index >= 0 && index < len(endPoints) {
+			return endPoints[index], nil
+		}
+	}
+	best := blc.strategy.pick(nodes)
+	blc.index = findAddrNode(blc.addressNodes, best.endPoint.Uid)
+	return best.endPoint, nil
+}
+
+// stalenessBound is implemented by the RetryBudgets that also bound
+// the replication lag of the tablets their reads go to, like
+// SafeSession (see proto.Session.MaxStaleness).
+type stalenessBound interface {
+	MaxStaleness() time.Duration
+}
+
+// maxStaleness returns the staleness bound budget sets on the queries
+// of sdc, 0 for none. The masters have no lag, so they have no bound.
+func (sdc *ShardConn) maxStaleness(budget RetryBudget) time.Duration {
+	if sdc.tabletType == topo.TYPE_MASTER {
+		return 0
+	}
+	if bound, ok := budget.(stalenessBound); ok {
+		return bound.MaxStaleness()
+	}
+	return 0
+}
+
+// HasFreshEndPoints returns true if there are end points within
+// maxStaleness to send queries to.
+func (sdc *ShardConn) HasFreshEndPoints(maxStaleness time.Duration) bool {
+	return sdc.balancer.HasFreshEndPoints(maxStaleness)
+}
+
+// freshTabletType returns master if the session bounds the staleness
+// of its reads and no tablet of tabletType is within the bound, and
+// tabletType otherwise. The transactions keep their tablet type.
+func (stc *ScatterConn) freshTabletType(keyspace, shard string, tabletType topo.TabletType, session *SafeSession) topo.TabletType {
+	maxStaleness := session.MaxStaleness()
+	if maxStaleness <= 0 || tabletType == topo.TYPE_MASTER || session.InTransaction() {
+		return tabletType
+	}
+	if stc.getConnection(keyspace, shard, tabletType, session.Workload()).HasFreshEndPoints(maxStaleness) {
+		return tabletType
+	}
+	stalenessFallbacks.Add(keyspace+"."+shard, 1)
+	return topo.TYPE_MASTER
+}
@@ -0,0 +1,103 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// laggedEndPoint returns an end point reporting lag, in seconds,
+// or no lag at all if lag is empty.
+func laggedEndPoint(uid uint32, lag string) topo.EndPoint {
+	endPoint := topo.EndPoint{Uid: uid, Host: "0", NamedPortMap: map[string]int{"vt": 1}}
+	if lag != "" {
+		endPoint.HealthMap = map[string]string{topo.HEALTH_REPLICATION_LAG: lag}
+	}
+	return endPoint
+}
+
+func TestGetFresh(t *testing.T) {
+	b := NewBalancer(func() (*topo.EndPoints, error) {
+		return &topo.EndPoints{Entries: []topo.EndPoint{
+			laggedEndPoint(0, "1"),
+			laggedEndPoint(1, "30"),
+			laggedEndPoint(2, ""),
+			laggedEndPoint(3, "2"),
+		}}, nil
+	}, RETRY_DELAY, "")
+
+	counts := make(map[uint32]int)
+	for i := 0; i < 10; i++ {
+		endPoint, err := b.GetFresh(5 * time.Second)
+		if err != nil {
+			t.Fatalf("GetFresh: %v", err)
+		}
+		counts[endPoint.Uid]++
+	}
+	if counts[0] != 5 || counts[3] != 5 {
+		t.Errorf("want 5 and 5 gets of 0 and 3, got %v", counts)
+	}
+
+	// A marked down end point is not fresh.
+	b.MarkDown(0)
+	if b.HasFreshEndPoints(1500 * time.Millisecond) {
+		t.Errorf("HasFreshEndPoints(1.5s) = true, want false")
+	}
+	if _, err := b.GetFresh(1500 * time.Millisecond); err != ErrNoFreshEndPoint {
+		t.Errorf("want %v, got %v", ErrNoFreshEndPoint, err)
+	}
+	if !b.HasFreshEndPoints(time.Minute) {
+		t.Errorf("HasFreshEndPoints(1m) = false, want true")
+	}
+}
+
+func TestScatterConnMaxStaleness(t *testing.T) {
+	testCases := []struct {
+		maxStaleness time.Duration
+		wantUid      uint32
+	}{
+		{0, 11},
+		{time.Hour, 11},
+		// 11 is too far behind.
+		{10 * time.Second, 14},
+		// Both replicas are too far behind: the reads go to the master.
+		{500 * time.Millisecond, 12},
+	}
+	for i, tc := range testCases {
+		resetSandbox()
+		sandboxEndPoints = map[topo.TabletType][]topo.EndPoint{
+			topo.TYPE_REPLICA: {laggedEndPoint(11, "60"), laggedEndPoint(14, "1")},
+			topo.TYPE_MASTER:  {laggedEndPoint(12, "")},
+		}
+		sbcs := map[uint32]*sandboxConn{}
+		for _, uid := range []uint32{11, 12, 14} {
+			sbcs[uid] = &sandboxConn{}
+			testConns[uid] = sbcs[uid]
+		}
+		SetSelectFunc(PickUid(11))
+		stc := NewScatterConn(new(sandboxTopo), "aa", 1*time.Millisecond, 3, 1*time.Second)
+		session := NewSafeSession(&proto.Session{MaxStaleness: int64(tc.maxStaleness)})
+		if _, err := stc.Execute(nil, "select 1", nil, "", []string{"0"}, topo.TYPE_REPLICA, "", session); err != nil {
+			t.Errorf("case %d: want nil, got %v", i, err)
+		}
+		SetSelectFunc(nil)
+		for uid, sbc := range sbcs {
+			want := int64(0)
+			if uid == tc.wantUid {
+				want = 1
+			}
+			if got := sbc.ExecCount.Get(); got != want {
+				t.Errorf("case %d: uid %d: want %d, got %d", i, uid, want, got)
+			}
+		}
+	}
+	if got := stalenessFallbacks.Counts()[".0"]; got != 1 {
+		t.Errorf("VtgateStalenessFallbacks = %v, want 1", got)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+)
+
+// ErrAllBlacklisted is returned by Balancer.Get when all the tablets
+// it could use are blacklisted, see BlacklistTablet.
+var ErrAllBlacklisted = errors.New("all tablets are blacklisted")
+
+var (
+	// tabletBlacklist is when the blacklisted tablets
+	// are re-admitted, by uid. tabletBlacklistVersion
+	// counts the calls to BlacklistTablet.
+	tabletBlacklistMu      sync.Mutex
+	tabletBlacklist        = make(map[uint32]time.Time)
+	tabletBlacklistVersion int64
+)
+
+// BlacklistTablet removes the tablet uid from the routing for
+// duration, without changing the serving graph: the Balancers skip it,
+// and it's re-admitted when the duration is over. The connections to
+// the tablet are retired: the queries already sent to it, and the
+// transactions open on it, go on, the next queries go to other tablets.
+// A duration of 0 or less re-admits the tablet right away.
+func BlacklistTablet(uid uint32, duration time.Duration) {
+	tabletBlacklistMu.Lock()
+	defer tabletBlacklistMu.Unlock()
+	tabletBlacklistVersion++
+	if duration <= 0 {
+		log.Infof("Re-admitting tablet %v", uid)
+		delete(tabletBlacklist, uid)
+		return
+	}
+	log.Infof("Blacklisting tablet %v for %v", uid, duration)
+	tabletBlacklist[uid] = time.Now().Add(duration)
+}
+
+// BlacklistedTablets returns when the blacklisted tablets will be
+// re-admitted, by uid.
+func BlacklistedTablets() map[uint32]time.Time {
+	tabletBlacklistMu.Lock()
+	defer tabletBlacklistMu.Unlock()
+	expireBlacklist(time.Now())
+	blacklist := make(map[uint32]time.Time, len(tabletBlacklist))
+	for uid, until := range tabletBlacklist {
+		blacklist[uid] = until
+	}
+	return blacklist
+}
+
+// blacklistVersion changes every time BlacklistTablet is called.
+func blacklistVersion() int64 {
+	tabletBlacklistMu.Lock()
+	defer tabletBlacklistMu.Unlock()
+	return tabletBlacklistVersion
+}
+
+// isBlacklisted returns true if the tablet uid is blacklisted at now.
+func isBlacklisted(uid uint32, now time.Time) bool {
+	tabletBlacklistMu.Lock()
+	defer tabletBlacklistMu.Unlock()
+	until, ok := tabletBlacklist[uid]
+	if ok && !now.Before(until) {
+		delete(tabletBlacklist, uid)
+		return false
+	}
+	return ok
+}
+
+// expireBlacklist re-admits the tablets whose blacklisting is over.
+// tabletBlacklistMu must be held.
+func expireBlacklist(now time.Time) {
+	for uid, until := range tabletBlacklist {
+		if !now.Before(until) {
+			delete(tabletBlacklist, uid)
+		}
+	}
+}
+
+// skipBlacklisted returns the nodes that are not blacklisted at now.
+func skipBlacklisted(nodes []*addressStatus, now time.Time) []*addressStatus {
+	admitted := make([]*addressStatus, 0, len(nodes))
+	for _, addrNode := range nodes {
+		if !isBlacklisted(addrNode.endPoint.Uid, now) {
+			admitted = append(admitted, addrNode)
+		}
+	}
+	return admitted
+}
+
+type blacklistEntry struct {
+	Uid   uint32
+	Until time.Time
+}
+
+var tabletBlacklistTmpl = template.Must(template.New("tablet_blacklist").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Tablet blacklist</title></head>
+<body>
+<p>{{len .}} tablets blacklisted. POST uid and duration (e.g. 10m) to blacklist a tablet, or a duration of 0 to re-admit it.</p>
+<table border="1">
+<tr><th>Uid</th><th>Until</th></tr>
+{{range .}}<tr><td>{{.Uid}}</td><td>{{.Until.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+func init() {
+	stats.Publish("VtgateTabletBlacklist", stats.CountersFunc(func() map[string]int64 {
+		now := time.Now()
+		blacklist := BlacklistedTablets()
+		remaining := make(map[string]int64, len(blacklist))
+		for uid, until := range blacklist {
+			remaining[strconv.FormatUint(uint64(uid), 10)] = int64(until.Sub(now) / time.Second)
+		}
+		return remaining
+	}))
+	http.HandleFunc("/debug/tablet_blacklist", tabletBlacklistHandler)
+}
+
+// tabletBlacklistHandler displays the blacklisted tablets.
+// A POST with uid and duration calls BlacklistTablet.
+func tabletBlacklistHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		uid, err := strconv.ParseUint(r.FormValue("uid"), 10, 32)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid uid: %v", err), http.StatusBadRequest)
+			return
+		}
+		duration, err := time.ParseDuration(r.FormValue("duration"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid duration: %v", err), http.StatusBadRequest)
+			return
+		}
+		BlacklistTablet(uint32(uid), duration)
+	}
+	var entries []blacklistEntry
+	for uid, until := range BlacklistedTablets() {
+		entries = append(entries, blacklistEntry{Uid: uid, Until: until})
+	}
+	sort.Sort(byUid(entries))
+	if err := tabletBlacklistTmpl.Execute(w, entries); err != nil {
+		log.Errorf("tablet_blacklist: %v", err)
+	}
+}
+
+type byUid []blacklistEntry
+
+func (entries byUid) Len() int           { return len(entries) }
+func (entries byUid) Swap(i, j int)      { entries[i], entries[j] = entries[j], entries[i] }
+func (entries byUid) Less(i, j int) bool { return entries[i].Uid < entries[j].Uid }
@@ -0,0 +1,50 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlacklistTablet(t *testing.T) {
+	defer BlacklistTablet(0, 0)
+	defer BlacklistTablet(1, 0)
+	defer BlacklistTablet(2, 0)
+	b := NewBalancer(endPoints3, RETRY_DELAY, "")
+
+	BlacklistTablet(1, time.Hour)
+	BlacklistTablet(2, time.Hour)
+	for i := 0; i < 10; i++ {
+		endPoint, err := b.Get()
+		if err != nil {
+			t.Fatalf("want nil, got %v", err)
+		}
+		if endPoint.Uid != 0 {
+			t.Errorf("want uid 0, got %v", endPoint.Uid)
+		}
+	}
+	if endPoint, _ := b.GetAffinity("key"); endPoint.Uid != 0 {
+		t.Errorf("want uid 0, got %v", endPoint.Uid)
+	}
+
+	BlacklistTablet(0, time.Hour)
+	if _, err := b.Get(); err != ErrAllBlacklisted {
+		t.Errorf("want ErrAllBlacklisted, got %v", err)
+	}
+	if got := len(BlacklistedTablets()); got != 3 {
+		t.Errorf("want 3 blacklisted tablets, got %v", got)
+	}
+
+	// Tablets are re-admitted when their duration is over.
+	BlacklistTablet(0, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if endPoint, err := b.Get(); err != nil || endPoint.Uid != 0 {
+		t.Errorf("want uid 0, got %v, %v", endPoint.Uid, err)
+	}
+	if _, ok := BlacklistedTablets()[0]; ok {
+		t.Errorf("tablet 0 is still blacklisted")
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/youtube/vitess/go/cache"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+)
+
+var txReplayLogSize = flag.Int("tx_replay_log_size", 100000, "max number of statements vtgate keeps for -tx_failover_policy=replay, over all the open transactions: the least recently used transactions are evicted past it, and can't be replayed")
+
+// txReplayLog records the statements executed so far by the open
+// transactions, so they can be replayed on the new master after a
+// failover (see TX_FAILOVER_REPLAY). It's kept in vtgate, the clients
+// never see it. A transaction that is not in the log can't be
+// replayed: it executed something that can't be, or too many
+// statements, or it was evicted, like the ones abandoned by their
+// clients.
+type txReplayLog struct {
+	transactions *cache.LRUCache
+}
+
+// txStatements are the statements of a transaction in txReplayLog.
+type txStatements []tproto.BoundQuery
+
+// Size is part of the cache.Value interface. The transactions that
+// didn't execute anything yet take room too.
+func (ts txStatements) Size() int {
+	return len(ts) + 1
+}
+
+func newTxReplayLog(size int) *txReplayLog {
+	return &txReplayLog{transactions: cache.NewLRUCache(int64(size))}
+}
+
+func txReplayKey(keyspace, shard string, tabletType topo.TabletType, transactionId int64) string {
+	return fmt.Sprintf("%s.%s.%s.%d", keyspace, shard, tabletType, transactionId)
+}
+
+// begin starts the log of a new transaction.
+func (trl *txReplayLog) begin(keyspace, shard string, tabletType topo.TabletType, transactionId int64) {
+	trl.transactions.Set(txReplayKey(keyspace, shard, tabletType, transactionId), txStatements{})
+}
+
+// record adds statements to the log of a transaction. A nil list,
+// or going over max statements, makes the transaction not replayable.
+func (trl *txReplayLog) record(keyspace, shard string, tabletType topo.TabletType, transactionId int64, statements []tproto.BoundQuery, max int) {
+	key := txReplayKey(keyspace, shard, tabletType, transactionId)
+	v, ok := trl.transactions.Get(key)
+	if !ok {
+		return
+	}
+	recorded := v.(txStatements)
+	if statements == nil || len(recorded)+len(statements) > max {
+		trl.transactions.Delete(key)
+		return
+	}
+	// The recorded statements may be being replayed, don't append
+	// to them in place.
+	updated := make(txStatements, 0, len(recorded)+len(statements))
+	updated = append(updated, recorded...)
+	trl.transactions.Set(key, append(updated, statements...))
+}
+
+// take removes the log of a transaction, and returns its statements,
+// or false if it can't be replayed.
+func (trl *txReplayLog) take(keyspace, shard string, tabletType topo.TabletType, transactionId int64) ([]tproto.BoundQuery, bool) {
+	key := txReplayKey(keyspace, shard, tabletType, transactionId)
+	v, ok := trl.transactions.Get(key)
+	if !ok {
+		return nil, false
+	}
+	trl.transactions.Delete(key)
+	return v.(txStatements), true
+}
+
+// end removes the log of a transaction that was committed or rolled
+// back.
+func (trl *txReplayLog) end(keyspace, shard string, tabletType topo.TabletType, transactionId int64) {
+	trl.transactions.Delete(txReplayKey(keyspace, shard, tabletType, transactionId))
+}
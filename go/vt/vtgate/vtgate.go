@@ -7,21 +7,127 @@
 package vtgate
 
 import (
+	"flag"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/golang/glog"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/sync2"
 	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 )
 
 var RpcVTGate *VTGate
 
+const (
+	// SCATTER_DML_REJECT rejects all DMLs that target more than one shard.
+	SCATTER_DML_REJECT = "reject"
+	// SCATTER_DML_EXPLICIT only lets through the multi-shard DMLs
+	// that have AllowScatterDML set.
+	SCATTER_DML_EXPLICIT = "explicit"
+	// SCATTER_DML_ALLOW lets all multi-shard DMLs through.
+	SCATTER_DML_ALLOW = "allow"
+)
+
+var sessionUpgrades = stats.NewCounters("VtgateSessionUpgrades")
+
+// inflightQueryRejections counts the queries refused by
+// max_inflight_queries.
+var inflightQueryRejections = stats.NewInt("VtgateInflightQueryRejections")
+
+var maxInflightQueries = flag.Int("max_inflight_queries", 0, "max number of client queries vtgate runs at the same time, past which queries fail with a too many queries in flight error (0 for no limit)")
+
+// inflightQueries is the number of client queries in flight,
+// bounded by max_inflight_queries.
+var inflightQueries sync2.AtomicInt64
+
+func init() {
+	stats.Publish("VtgateInflightQueries", stats.IntFunc(inflightQueries.Get))
+}
+
+var returnConnectionIds = flag.Bool("return_connection_ids", false, "debug mode: return the MySQL connection id that ran the query in ExecuteShard replies")
+
+var scatterDMLPolicy = flag.String("scatter_dml_policy", SCATTER_DML_EXPLICIT, "what to do with DMLs sent to more than one shard: reject, explicit (only if the query sets AllowScatterDML) or allow")
+
+// dmlPrefixes are the statement prefixes checked by the scatter DML policy.
+var dmlPrefixes = map[string]bool{
+	"insert":  true,
+	"update":  true,
+	"delete":  true,
+	"replace": true,
+}
+
+func isDML(sql string) bool {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return false
+	}
+	return dmlPrefixes[strings.ToLower(fields[0])]
+}
+
+// logSessionQuery is used to log the queries of sessions that
+// have LogQueries set. It can be replaced by tests.
+var logSessionQuery = func(format string, args ...interface{}) {
+	log.Infof(format, args...)
+}
+
+// logQuery logs the query if the session asked for it, so a single
+// client can be debugged without turning on verbose logging for all.
+func logQuery(session *proto.Session, method string, query interface{}) {
+	if session != nil && session.LogQueries {
+		logSessionQuery("%v: %+v", method, query)
+	}
+}
+
+// checkOutsideTransaction returns an error if session is in a
+// transaction. Explain and quorum reads don't go through the
+// session: they wouldn't see the writes of the transaction.
+func checkOutsideTransaction(session *proto.Session) error {
+	if session != nil && session.InTransaction {
+		return fmt.Errorf("explain and quorum reads are not supported in a transaction")
+	}
+	return nil
+}
+
+// checkScatterDML returns an error if sql is a DML that targets
+// more than one shard and the scatter DML policy doesn't allow it.
+// allowScatterDML is the client's explicit opt-in.
+func checkScatterDML(sql string, shardCount int, allowScatterDML bool) error {
+	if shardCount < 2 || !isDML(sql) {
+		return nil
+	}
+	switch *scatterDMLPolicy {
+	case SCATTER_DML_ALLOW:
+		return nil
+	case SCATTER_DML_EXPLICIT:
+		if allowScatterDML {
+			return nil
+		}
+		return fmt.Errorf("DML cannot be sent to %v shards unless AllowScatterDML is set", shardCount)
+	case SCATTER_DML_REJECT:
+		return fmt.Errorf("DML cannot be sent to %v shards", shardCount)
+	}
+	return fmt.Errorf("unknown scatter_dml_policy: %v", *scatterDMLPolicy)
+}
+
 // VTGate is the rpc interface to vtgate. Only one instance
 // can be created.
 type VTGate struct {
-	scatterConn *ScatterConn
+	// mu protects scatterConn and topoRefreshTime, which
+	// are replaced by ReloadTopo.
+	mu              sync.Mutex
+	scatterConn     *ScatterConn
+	topoRefreshTime time.Time
+
+	keyspaces *keyspaceAvailability
+
+	// draining is set by Drain, and protected by mu.
+	draining bool
 }
 
 // registration mechanism
@@ -33,26 +139,208 @@ func Init(serv SrvTopoServer, cell string, retryDelay time.Duration, retryCount
 	if RpcVTGate != nil {
 		log.Fatalf("VTGate already initialized")
 	}
-	RpcVTGate = &VTGate{
-		scatterConn: NewScatterConn(serv, cell, retryDelay, retryCount, timeout),
-	}
+	RpcVTGate = newVTGate(serv, cell, retryDelay, retryCount, timeout)
 	for _, f := range RegisterVTGates {
 		f(RpcVTGate)
 	}
 }
 
-// ExecuteShard executes a non-streaming query on the specified shards.
+// newVTGate creates a VTGate. It loads the keyspaces, and keeps retrying
+// in the background the ones that fail: they are unavailable until then,
+// but don't prevent serving the others.
+func newVTGate(serv SrvTopoServer, cell string, retryDelay time.Duration, retryCount int, timeout time.Duration) *VTGate {
+	vtg := &VTGate{
+		scatterConn:     NewScatterConn(serv, cell, retryDelay, retryCount, timeout),
+		topoRefreshTime: time.Now(),
+		keyspaces:       newKeyspaceAvailability(),
+	}
+	vtg.loadKeyspaces(serv)
+	return vtg
+}
+
+// loadKeyspaces loads the keyspaces with serv, and starts retrying
+// in the background if some fail.
+func (vtg *VTGate) loadKeyspaces(serv SrvTopoServer) {
+	cell := vtg.scatterConn.cell
+	if !vtg.keyspaces.load(serv, cell) {
+		vtg.keyspaces.startRetrying(func() SrvTopoServer {
+			vtg.mu.Lock()
+			defer vtg.mu.Unlock()
+			return vtg.scatterConn.toposerv
+		}, cell)
+	}
+}
+
+// KeyspaceAvailability returns the availability of each keyspace,
+// for the status page.
+func (vtg *VTGate) KeyspaceAvailability() map[string]string {
+	return vtg.keyspaces.Status()
+}
+
+// ReloadTopo replaces the ScatterConn with a new one that uses serv,
+// so a broken topo connection can be replaced without a restart.
+// Sessions are kept, and the queries in flight complete against the
+// old ScatterConn. Once they are all done, the old ScatterConn is closed
+// and done is called, if not nil.
+func (vtg *VTGate) ReloadTopo(serv SrvTopoServer, done func()) {
+	vtg.mu.Lock()
+	old := vtg.scatterConn
+	vtg.scatterConn = NewScatterConn(serv, old.cell, old.retryDelay, old.retryCount, old.timeout)
+	vtg.topoRefreshTime = time.Now()
+	vtg.mu.Unlock()
+	log.Infof("VTGate topo connection reloaded")
+	vtg.loadKeyspaces(serv)
+
+	go func() {
+		old.inFlight.Wait()
+		old.Close()
+		if done != nil {
+			done()
+		}
+	}()
+}
+
+// TopoRefreshTime returns the last time the topo connection
+// was set up, by Init or ReloadTopo.
+func (vtg *VTGate) TopoRefreshTime() time.Time {
+	vtg.mu.Lock()
+	defer vtg.mu.Unlock()
+	return vtg.topoRefreshTime
+}
+
+// getScatterConn returns the current ScatterConn, which ReloadTopo won't
+// close until the caller is done with it and calls inFlight.Done().
+func (vtg *VTGate) getScatterConn() *ScatterConn {
+	vtg.mu.Lock()
+	defer vtg.mu.Unlock()
+	vtg.scatterConn.inFlight.Add(1)
+	return vtg.scatterConn
+}
+
+// startQuery counts a client query of session in, or returns an
+// error if there are already max_inflight_queries of them, or vtgate
+// is draining and session isn't in a transaction. A successful call
+// has to be followed by endQuery. Commits and rollbacks are not counted:
+// refusing them would only keep more transactions open.
+func (vtg *VTGate) startQuery(session *proto.Session) error {
+	if err := vtg.checkDraining(session); err != nil {
+		return err
+	}
+	vtg.mu.Lock()
+	defer vtg.mu.Unlock()
+	if *maxInflightQueries > 0 && inflightQueries.Get() >= int64(*maxInflightQueries) {
+		inflightQueryRejections.Add(1)
+		return fmt.Errorf("too many queries in flight: %v", inflightQueries.Get())
+	}
+	inflightQueries.Add(1)
+	return nil
+}
+
+// endQuery counts a client query out.
+func (vtg *VTGate) endQuery() {
+	inflightQueries.Add(-1)
+}
+
+// resolveShards returns shards or, if it's empty, all the shards of
+// keyspace for tabletType in the serving graph: clients can then
+// target a whole keyspace without keeping track of its shards.
+func resolveShards(stc *ScatterConn, keyspace string, shards []string, tabletType topo.TabletType) ([]string, error) {
+	if len(shards) != 0 {
+		return shards, nil
+	}
+	return resolveKeyRangeToShards(stc.toposerv, stc.cell, keyspace, tabletType, key.KeyRange{})
+}
+
+// queryTabletType returns tabletType, the tablet type a query asks
+// for, or the default one of its session if it asks for none.
+func queryTabletType(tabletType topo.TabletType, session *proto.Session) topo.TabletType {
+	if tabletType == "" && session != nil {
+		return session.TabletType
+	}
+	return tabletType
+}
+
+// ExecuteShard executes a non-streaming query on the specified shards,
+// or on all the shards of the keyspace if none are specified.
 func (vtg *VTGate) ExecuteShard(context interface{}, query *proto.QueryShard, reply *proto.QueryResult) error {
-	qr, err := vtg.scatterConn.Execute(
-		context,
-		query.Sql,
-		query.BindVariables,
-		query.Keyspace,
-		query.Shards,
-		query.TabletType,
-		NewSafeSession(query.Session))
+	var connectionId int64
+	startTime := time.Now()
+	defer func() {
+		slowQueries.record(query.Sql, query.Keyspace, connectionId, startTime)
+		sampleQuery("ExecuteShard", query.Keyspace, query.Shards, query.Sql, len(query.BindVariables), startTime, reply.Error)
+	}()
+	if err := vtg.startQuery(query.Session); err != nil {
+		return err
+	}
+	defer vtg.endQuery()
+	stc := vtg.getScatterConn()
+	defer stc.inFlight.Done()
+
+	logQuery(query.Session, "ExecuteShard", query)
+	query.TabletType = queryTabletType(query.TabletType, query.Session)
+	err := vtg.keyspaces.check(query.Keyspace)
+	if err == nil {
+		err = validateBindVars(query.BindVariables)
+	}
+	if err == nil {
+		query.Shards, err = resolveShards(stc, query.Keyspace, query.Shards, query.TabletType)
+	}
+	if err == nil {
+		err = checkScatterDML(query.Sql, len(query.Shards), query.AllowScatterDML)
+	}
+	if err == nil {
+		err = checkMigratingTables(stc, query.Keyspace, query.Sql)
+	}
+	if err == nil && (query.Explain || query.Quorum != 0) {
+		err = checkOutsideTransaction(query.Session)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+		reply.Session = query.Session
+		log.Errorf("ExecuteShard: %v, query: %+v", err, query)
+		return nil
+	}
+	var qr *mproto.QueryResult
+	if query.Explain {
+		qr, err = stc.Explain(
+			context,
+			query.Sql,
+			query.BindVariables,
+			query.Keyspace,
+			query.Shards,
+			upgradedTabletType(query.TabletType, NewSafeSession(query.Session)))
+	} else if query.Quorum != 0 {
+		qr, reply.Shards, err = stc.ExecuteQuorum(
+			context,
+			query.Sql,
+			query.BindVariables,
+			query.Keyspace,
+			query.Shards,
+			upgradedTabletType(query.TabletType, NewSafeSession(query.Session)),
+			query.Quorum)
+	} else {
+		qr, err = stc.Execute(
+			context,
+			query.Sql,
+			query.BindVariables,
+			query.Keyspace,
+			query.Shards,
+			query.TabletType,
+			query.AffinityKey,
+			NewSafeSession(query.Session))
+	}
+	if err == nil {
+		qr, err = transformResult(context, query.Keyspace, qr)
+	}
 	if err == nil {
 		proto.PopulateQueryResult(qr, reply)
+		connectionId = qr.ConnectionId
+		if connectionId != 0 {
+			logQuery(query.Session, "ExecuteShard MySQL connection id", connectionId)
+		}
+		if *returnConnectionIds {
+			reply.ConnectionId = connectionId
+		}
 	} else {
 		reply.Error = err.Error()
 		log.Errorf("ExecuteShard: %v, query: %+v", err, query)
@@ -63,13 +351,84 @@ func (vtg *VTGate) ExecuteShard(context interface{}, query *proto.QueryShard, re
 
 // ExecuteBatchShard executes a group of queries on the specified shards.
 func (vtg *VTGate) ExecuteBatchShard(context interface{}, batchQuery *proto.BatchQueryShard, reply *proto.QueryResultList) error {
-	qrs, err := vtg.scatterConn.ExecuteBatch(
+	startTime := time.Now()
+	defer func() {
+		sqls := make([]string, len(batchQuery.Queries))
+		bindVariables := 0
+		for i, query := range batchQuery.Queries {
+			sqls[i] = query.Sql
+			bindVariables += len(query.BindVariables)
+		}
+		sql := strings.Join(sqls, "; ")
+		errMsg := reply.Error
+		for _, queryErr := range reply.Errors {
+			if errMsg == "" {
+				errMsg = queryErr
+			}
+		}
+		slowQueries.record(sql, batchQuery.Keyspace, 0, startTime)
+		sampleQuery("ExecuteBatchShard", batchQuery.Keyspace, batchQuery.Shards, sql, bindVariables, startTime, errMsg)
+	}()
+	if err := vtg.startQuery(batchQuery.Session); err != nil {
+		return err
+	}
+	defer vtg.endQuery()
+	stc := vtg.getScatterConn()
+	defer stc.inFlight.Done()
+
+	logQuery(batchQuery.Session, "ExecuteBatchShard", batchQuery)
+	batchQuery.TabletType = queryTabletType(batchQuery.TabletType, batchQuery.Session)
+	err := vtg.keyspaces.check(batchQuery.Keyspace)
+	if err == nil {
+		batchQuery.Shards, err = resolveShards(stc, batchQuery.Keyspace, batchQuery.Shards, batchQuery.TabletType)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+		reply.Session = batchQuery.Session
+		log.Errorf("ExecuteBatchShard: %v, queries: %+v", err, batchQuery)
+		return nil
+	}
+	sqls := make([]string, len(batchQuery.Queries))
+	for i, query := range batchQuery.Queries {
+		err := validateBindVars(query.BindVariables)
+		if err == nil {
+			err = checkScatterDML(query.Sql, len(batchQuery.Shards), batchQuery.AllowScatterDML)
+		}
+		if err != nil {
+			reply.Error = err.Error()
+			reply.Session = batchQuery.Session
+			log.Errorf("ExecuteBatchShard: %v, queries: %+v", err, batchQuery)
+			return nil
+		}
+		sqls[i] = query.Sql
+	}
+	if err := checkMigratingTables(stc, batchQuery.Keyspace, sqls...); err != nil {
+		reply.Error = err.Error()
+		reply.Session = batchQuery.Session
+		log.Errorf("ExecuteBatchShard: %v, queries: %+v", err, batchQuery)
+		return nil
+	}
+	if batchQuery.ContinueOnError {
+		executeBatchContinueOnError(context, stc, batchQuery, reply)
+		reply.Session = batchQuery.Session
+		return nil
+	}
+	qrs, err := stc.ExecuteBatch(
 		context,
 		batchQuery.Queries,
 		batchQuery.Keyspace,
 		batchQuery.Shards,
 		batchQuery.TabletType,
 		NewSafeSession(batchQuery.Session))
+	if err == nil {
+		for i := range qrs.List {
+			var qr *mproto.QueryResult
+			if qr, err = transformResult(context, batchQuery.Keyspace, &qrs.List[i]); err != nil {
+				break
+			}
+			qrs.List[i] = *qr
+		}
+	}
 	if err == nil {
 		reply.List = qrs.List
 	} else {
@@ -80,10 +439,39 @@ func (vtg *VTGate) ExecuteBatchShard(context interface{}, batchQuery *proto.Batc
 	return nil
 }
 
+// executeBatchContinueOnError executes the queries of batchQuery one
+// by one, in the session of the batch, and records the error of each
+// in reply.Errors instead of stopping at the first one.
+func executeBatchContinueOnError(context interface{}, stc *ScatterConn, batchQuery *proto.BatchQueryShard, reply *proto.QueryResultList) {
+	session := NewSafeSession(batchQuery.Session)
+	reply.List = make([]mproto.QueryResult, len(batchQuery.Queries))
+	reply.Errors = make([]string, len(batchQuery.Queries))
+	for i, query := range batchQuery.Queries {
+		qr, err := stc.Execute(
+			context,
+			query.Sql,
+			query.BindVariables,
+			batchQuery.Keyspace,
+			batchQuery.Shards,
+			batchQuery.TabletType,
+			"",
+			session)
+		if err == nil {
+			qr, err = transformResult(context, batchQuery.Keyspace, qr)
+		}
+		if err != nil {
+			reply.Errors[i] = err.Error()
+			log.Errorf("ExecuteBatchShard: %v, query: %+v", err, query)
+			continue
+		}
+		reply.List[i] = *qr
+	}
+}
+
 // This function implements the restriction of handling one keyrange
 // and one shard since streaming doesn't support merge sorting the results.
 // The input/output api is generic though.
-func (vtg *VTGate) mapKrToShardsForStreaming(streamQuery *proto.StreamQueryKeyRange) ([]string, error) {
+func (vtg *VTGate) mapKrToShardsForStreaming(stc *ScatterConn, streamQuery *proto.StreamQueryKeyRange) ([]string, error) {
 	var keyRange key.KeyRange
 	var err error
 	if streamQuery.KeyRange == "" {
@@ -95,8 +483,8 @@ func (vtg *VTGate) mapKrToShardsForStreaming(streamQuery *proto.StreamQueryKeyRa
 		}
 		keyRange = krArray[0]
 	}
-	shards, err := resolveKeyRangeToShards(vtg.scatterConn.toposerv,
-		vtg.scatterConn.cell,
+	shards, err := resolveKeyRangeToShards(stc.toposerv,
+		stc.cell,
 		streamQuery.Keyspace,
 		streamQuery.TabletType,
 		keyRange)
@@ -116,14 +504,42 @@ func (vtg *VTGate) mapKrToShardsForStreaming(streamQuery *proto.StreamQueryKeyRa
 // This function currently temporarily enforces the restriction of executing on one keyrange
 // and one shard since it cannot merge-sort the results to guarantee ordering of
 // response which is needed for checkpointing. The api supports supplying multiple keyranges
-// to make it future proof.
-func (vtg *VTGate) StreamExecuteKeyRange(context interface{}, streamQuery *proto.StreamQueryKeyRange, sendReply func(*proto.QueryResult) error) error {
-	shards, err := vtg.mapKrToShardsForStreaming(streamQuery)
+// to make it future proof. sendReply works as in StreamExecuteShard.
+func (vtg *VTGate) StreamExecuteKeyRange(context interface{}, streamQuery *proto.StreamQueryKeyRange, sendReply func(*proto.QueryResult) error) (err error) {
+	startTime := time.Now()
+	var shards []string
+	defer func() {
+		slowQueries.record(streamQuery.Sql, streamQuery.Keyspace, 0, startTime)
+		var errMsg string
+		if err != nil {
+			errMsg = err.Error()
+		}
+		sampleQuery("StreamExecuteKeyRange", streamQuery.Keyspace, shards, streamQuery.Sql, len(streamQuery.BindVariables), startTime, errMsg)
+	}()
+	if err := vtg.startQuery(streamQuery.Session); err != nil {
+		return err
+	}
+	defer vtg.endQuery()
+	stc := vtg.getScatterConn()
+	defer stc.inFlight.Done()
+
+	logQuery(streamQuery.Session, "StreamExecuteKeyRange", streamQuery)
+	streamQuery.TabletType = queryTabletType(streamQuery.TabletType, streamQuery.Session)
+	if err := vtg.keyspaces.check(streamQuery.Keyspace); err != nil {
+		return err
+	}
+	if err := validateBindVars(streamQuery.BindVariables); err != nil {
+		return err
+	}
+	if err := checkMigratingTables(stc, streamQuery.Keyspace, streamQuery.Sql); err != nil {
+		return err
+	}
+	shards, err = vtg.mapKrToShardsForStreaming(stc, streamQuery)
 	if err != nil {
 		return err
 	}
 
-	err = vtg.scatterConn.StreamExecute(
+	err = stc.StreamExecute(
 		context,
 		streamQuery.Sql,
 		streamQuery.BindVariables,
@@ -131,14 +547,14 @@ func (vtg *VTGate) StreamExecuteKeyRange(context interface{}, streamQuery *proto
 		shards,
 		streamQuery.TabletType,
 		NewSafeSession(streamQuery.Session),
-		func(mreply *mproto.QueryResult) error {
+		transformStream(context, streamQuery.Keyspace, func(mreply *mproto.QueryResult) error {
 			reply := new(proto.QueryResult)
 			proto.PopulateQueryResult(mreply, reply)
 			// Note we don't populate reply.Session here,
 			// as it may change incrementaly as responses
 			// are sent.
 			return sendReply(reply)
-		})
+		}))
 
 	if err != nil {
 		log.Errorf("StreamExecuteKeyRange: %v, query: %+v", err, streamQuery)
@@ -151,8 +567,44 @@ func (vtg *VTGate) StreamExecuteKeyRange(context interface{}, streamQuery *proto
 }
 
 // StreamExecuteShard executes a streaming query on the specified shards.
-func (vtg *VTGate) StreamExecuteShard(context interface{}, query *proto.QueryShard, sendReply func(*proto.QueryResult) error) error {
-	err := vtg.scatterConn.StreamExecute(
+// sendReply gets the results as they come, then the final Session if
+// any. A query that fails after sending results returns a
+// StreamTruncatedError telling how many rows were sent: they're valid,
+// but the stream is incomplete. An error from sendReply ends the stream.
+func (vtg *VTGate) StreamExecuteShard(context interface{}, query *proto.QueryShard, sendReply func(*proto.QueryResult) error) (err error) {
+	startTime := time.Now()
+	defer func() {
+		slowQueries.record(query.Sql, query.Keyspace, 0, startTime)
+		var errMsg string
+		if err != nil {
+			errMsg = err.Error()
+		}
+		sampleQuery("StreamExecuteShard", query.Keyspace, query.Shards, query.Sql, len(query.BindVariables), startTime, errMsg)
+	}()
+	if err := vtg.startQuery(query.Session); err != nil {
+		return err
+	}
+	defer vtg.endQuery()
+	stc := vtg.getScatterConn()
+	defer stc.inFlight.Done()
+
+	logQuery(query.Session, "StreamExecuteShard", query)
+	query.TabletType = queryTabletType(query.TabletType, query.Session)
+	if err := vtg.keyspaces.check(query.Keyspace); err != nil {
+		return err
+	}
+	if err := validateBindVars(query.BindVariables); err != nil {
+		return err
+	}
+	shards, err := resolveShards(stc, query.Keyspace, query.Shards, query.TabletType)
+	if err != nil {
+		return err
+	}
+	query.Shards = shards
+	if err := checkMigratingTables(stc, query.Keyspace, query.Sql); err != nil {
+		return err
+	}
+	err = stc.StreamExecute(
 		context,
 		query.Sql,
 		query.BindVariables,
@@ -160,14 +612,14 @@ func (vtg *VTGate) StreamExecuteShard(context interface{}, query *proto.QuerySha
 		query.Shards,
 		query.TabletType,
 		NewSafeSession(query.Session),
-		func(mreply *mproto.QueryResult) error {
+		transformStream(context, query.Keyspace, func(mreply *mproto.QueryResult) error {
 			reply := new(proto.QueryResult)
 			proto.PopulateQueryResult(mreply, reply)
 			// Note we don't populate reply.Session here,
 			// as it may change incrementaly as responses
 			// are sent.
 			return sendReply(reply)
-		})
+		}))
 
 	if err != nil {
 		log.Errorf("StreamExecuteShard: %v, query: %+v", err, query)
@@ -180,17 +632,105 @@ func (vtg *VTGate) StreamExecuteShard(context interface{}, query *proto.QuerySha
 }
 
 // Begin begins a transaction. It has to be concluded by a Commit or Rollback.
+// It fails with ErrDraining once Drain was called.
 func (vtg *VTGate) Begin(context interface{}, outSession *proto.Session) error {
+	if err := vtg.checkDraining(nil); err != nil {
+		return err
+	}
 	outSession.InTransaction = true
 	return nil
 }
 
 // Commit commits a transaction.
 func (vtg *VTGate) Commit(context interface{}, inSession *proto.Session) error {
-	return vtg.scatterConn.Commit(context, NewSafeSession(inSession))
+	stc := vtg.getScatterConn()
+	defer stc.inFlight.Done()
+
+	logQuery(inSession, "Commit", inSession)
+	return stc.Commit(context, NewSafeSession(inSession))
 }
 
 // Rollback rolls back a transaction.
 func (vtg *VTGate) Rollback(context interface{}, inSession *proto.Session) error {
-	return vtg.scatterConn.Rollback(context, NewSafeSession(inSession))
+	stc := vtg.getScatterConn()
+	defer stc.inFlight.Done()
+
+	logQuery(inSession, "Rollback", inSession)
+	return stc.Rollback(context, NewSafeSession(inSession))
+}
+
+// Ping checks that the tablets the session uses are still reachable
+// (see ScatterConn.Ping). Clients with long-lived sessions can call it
+// periodically, so the connections of their idle sessions are not
+// closed by -shard_conn_idle_timeout.
+func (vtg *VTGate) Ping(context interface{}, inSession *proto.Session) error {
+	stc := vtg.getScatterConn()
+	defer stc.inFlight.Done()
+
+	logQuery(inSession, "Ping", inSession)
+	return stc.Ping(context, NewSafeSession(inSession))
+}
+
+// Savepoint sets a savepoint in the transaction of the session
+// (see ScatterConn.Savepoint).
+func (vtg *VTGate) Savepoint(context interface{}, request *proto.SavepointRequest, outSession *proto.Session) error {
+	return vtg.savepointCall(context, "Savepoint", (*ScatterConn).Savepoint, request, outSession)
+}
+
+// ReleaseSavepoint removes a savepoint from the transaction of the
+// session (see ScatterConn.ReleaseSavepoint).
+func (vtg *VTGate) ReleaseSavepoint(context interface{}, request *proto.SavepointRequest, outSession *proto.Session) error {
+	return vtg.savepointCall(context, "ReleaseSavepoint", (*ScatterConn).ReleaseSavepoint, request, outSession)
+}
+
+// RollbackToSavepoint rolls back the transaction of the session to
+// a savepoint (see ScatterConn.RollbackToSavepoint).
+func (vtg *VTGate) RollbackToSavepoint(context interface{}, request *proto.SavepointRequest, outSession *proto.Session) error {
+	return vtg.savepointCall(context, "RollbackToSavepoint", (*ScatterConn).RollbackToSavepoint, request, outSession)
+}
+
+// savepointCall runs one of the savepoint methods of ScatterConn.
+// outSession is the session it updated, even if it failed.
+func (vtg *VTGate) savepointCall(context interface{}, method string, call func(*ScatterConn, interface{}, string, *SafeSession) error, request *proto.SavepointRequest, outSession *proto.Session) error {
+	if request.Session == nil {
+		return fmt.Errorf("%v: no session", method)
+	}
+	stc := vtg.getScatterConn()
+	defer stc.inFlight.Done()
+
+	logQuery(request.Session, method, request)
+	err := call(stc, context, request.Name, NewSafeSession(request.Session))
+	*outSession = *request.Session
+	if err != nil {
+		log.Errorf("%v: %v, savepoint: %v", method, err, request.Name)
+	}
+	return err
+}
+
+// UpgradeSession makes the queries of a session go to the masters,
+// whatever tablet type they ask for, so a session reading from replicas
+// can do an occasional write or read its own writes. It can't be done
+// in the middle of a transaction.
+func (vtg *VTGate) UpgradeSession(context interface{}, inSession, outSession *proto.Session) error {
+	return setUpgraded(inSession, outSession, true)
+}
+
+// DowngradeSession reverts UpgradeSession: the queries of the session
+// go to the tablet type they ask for again.
+func (vtg *VTGate) DowngradeSession(context interface{}, inSession, outSession *proto.Session) error {
+	return setUpgraded(inSession, outSession, false)
+}
+
+func setUpgraded(inSession, outSession *proto.Session, upgraded bool) error {
+	if inSession.InTransaction {
+		return fmt.Errorf("cannot change the routing of a session in a transaction")
+	}
+	*outSession = *inSession
+	outSession.Upgraded = upgraded
+	if upgraded {
+		sessionUpgrades.Add("Upgrade", 1)
+	} else {
+		sessionUpgrades.Add("Downgrade", 1)
+	}
+	return nil
 }
@@ -5,7 +5,9 @@
 package vtgate
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -77,6 +79,245 @@ func TestVTGateExecuteShard(t *testing.T) {
 	*/
 }
 
+func TestVTGateExecuteShardAllShards(t *testing.T) {
+	resetSandbox()
+	shards, _ := getAllShards()
+	for _, kr := range shards {
+		mapTestConn(getKeyRangeName(kr), &sandboxConn{})
+	}
+	q := proto.QueryShard{
+		Sql:        "query",
+		Keyspace:   TEST_SHARDED,
+		TabletType: topo.TYPE_MASTER,
+	}
+	qr := new(proto.QueryResult)
+	if err := RpcVTGate.ExecuteShard(nil, &q, qr); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if qr.Error != "" {
+		t.Errorf("want no error, got %v", qr.Error)
+	}
+	if int(qr.RowsAffected) != len(shards) {
+		t.Errorf("want %v, got %v", len(shards), qr.RowsAffected)
+	}
+
+	// The scatter DML policy applies to the resolved shards.
+	q = proto.QueryShard{
+		Sql:        "delete from t",
+		Keyspace:   TEST_SHARDED,
+		TabletType: topo.TYPE_MASTER,
+	}
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	want := "DML cannot be sent to 8 shards unless AllowScatterDML is set"
+	if qr.Error != want {
+		t.Errorf("want %v, got %v", want, qr.Error)
+	}
+
+	// No partition for the tablet type.
+	q = proto.QueryShard{
+		Sql:        "query",
+		Keyspace:   TEST_SHARDED,
+		TabletType: topo.TYPE_REPLICA,
+	}
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	want = "No shards available for tablet type 'replica' in keyspace 'TestSharded'"
+	if qr.Error != want {
+		t.Errorf("want %v, got %v", want, qr.Error)
+	}
+}
+
+func TestVTGateScatterDML(t *testing.T) {
+	defer func(policy string) { *scatterDMLPolicy = policy }(*scatterDMLPolicy)
+	resetSandbox()
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	mapTestConn("-20", sbc1)
+	mapTestConn("20-40", sbc2)
+	q := proto.QueryShard{
+		Sql:    "update t set a=1",
+		Shards: []string{"-20", "20-40"},
+	}
+
+	// explicit policy rejects scatter DMLs without AllowScatterDML
+	*scatterDMLPolicy = SCATTER_DML_EXPLICIT
+	qr := new(proto.QueryResult)
+	err := RpcVTGate.ExecuteShard(nil, &q, qr)
+	if err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	want := "DML cannot be sent to 2 shards unless AllowScatterDML is set"
+	if qr.Error != want {
+		t.Errorf("want %s, got %s", want, qr.Error)
+	}
+	if execCount := sbc1.ExecCount.Get() + sbc2.ExecCount.Get(); execCount != 0 {
+		t.Errorf("want 0, got %v", execCount)
+	}
+
+	// and lets them through with it, aggregating affected rows
+	q.AllowScatterDML = true
+	qr = new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if qr.Error != "" {
+		t.Errorf("want empty, got %s", qr.Error)
+	}
+	if qr.RowsAffected != 2 {
+		t.Errorf("want 2, got %v", qr.RowsAffected)
+	}
+
+	// reject policy doesn't care about AllowScatterDML
+	*scatterDMLPolicy = SCATTER_DML_REJECT
+	qr = new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	want = "DML cannot be sent to 2 shards"
+	if qr.Error != want {
+		t.Errorf("want %s, got %s", want, qr.Error)
+	}
+
+	// single shard DMLs and scatter selects are never affected
+	q.AllowScatterDML = false
+	q.Shards = []string{"-20"}
+	qr = new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if qr.Error != "" {
+		t.Errorf("want empty, got %s", qr.Error)
+	}
+	q.Sql = "select * from t"
+	q.Shards = []string{"-20", "20-40"}
+	qr = new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if qr.Error != "" {
+		t.Errorf("want empty, got %s", qr.Error)
+	}
+}
+
+func TestVTGateBatchScatterDML(t *testing.T) {
+	defer func(policy string) { *scatterDMLPolicy = policy }(*scatterDMLPolicy)
+	resetSandbox()
+	sbc1 := &sandboxConn{}
+	sbc2 := &sandboxConn{}
+	mapTestConn("-20", sbc1)
+	mapTestConn("20-40", sbc2)
+	q := proto.BatchQueryShard{
+		Queries: []tproto.BoundQuery{
+			{Sql: "select * from t"},
+			{Sql: "delete from t"},
+		},
+		Shards: []string{"-20", "20-40"},
+	}
+
+	// one DML is enough to reject the whole batch
+	*scatterDMLPolicy = SCATTER_DML_EXPLICIT
+	qrl := new(proto.QueryResultList)
+	err := RpcVTGate.ExecuteBatchShard(nil, &q, qrl)
+	if err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	want := "DML cannot be sent to 2 shards unless AllowScatterDML is set"
+	if qrl.Error != want {
+		t.Errorf("want %s, got %s", want, qrl.Error)
+	}
+	if execCount := sbc1.ExecCount.Get() + sbc2.ExecCount.Get(); execCount != 0 {
+		t.Errorf("want 0, got %v", execCount)
+	}
+
+	q.AllowScatterDML = true
+	qrl = new(proto.QueryResultList)
+	RpcVTGate.ExecuteBatchShard(nil, &q, qrl)
+	if qrl.Error != "" {
+		t.Errorf("want empty, got %s", qrl.Error)
+	}
+	if len(qrl.List) != 2 {
+		t.Errorf("want 2, got %v", len(qrl.List))
+	}
+
+	*scatterDMLPolicy = SCATTER_DML_REJECT
+	qrl = new(proto.QueryResultList)
+	RpcVTGate.ExecuteBatchShard(nil, &q, qrl)
+	want = "DML cannot be sent to 2 shards"
+	if qrl.Error != want {
+		t.Errorf("want %s, got %s", want, qrl.Error)
+	}
+
+	// a batch without DMLs is never affected
+	q.Queries = q.Queries[:1]
+	q.AllowScatterDML = false
+	qrl = new(proto.QueryResultList)
+	RpcVTGate.ExecuteBatchShard(nil, &q, qrl)
+	if qrl.Error != "" {
+		t.Errorf("want empty, got %s", qrl.Error)
+	}
+}
+
+func TestVTGateLogQueries(t *testing.T) {
+	var logged []string
+	defer func(f func(string, ...interface{})) { logSessionQuery = f }(logSessionQuery)
+	logSessionQuery = func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	resetSandbox()
+	testConns[0] = &sandboxConn{}
+
+	quiet := proto.QueryShard{
+		Sql:     "quiet query",
+		Shards:  []string{"0"},
+		Session: new(proto.Session),
+	}
+	RpcVTGate.ExecuteShard(nil, &quiet, new(proto.QueryResult))
+	if len(logged) != 0 {
+		t.Errorf("want no log, got %v", logged)
+	}
+
+	verbose := proto.QueryShard{
+		Sql:     "verbose query",
+		Shards:  []string{"0"},
+		Session: &proto.Session{LogQueries: true},
+	}
+	RpcVTGate.ExecuteShard(nil, &verbose, new(proto.QueryResult))
+	RpcVTGate.ExecuteShard(nil, &quiet, new(proto.QueryResult))
+	if len(logged) != 1 || !strings.Contains(logged[0], "verbose query") {
+		t.Errorf("want one log for verbose query, got %v", logged)
+	}
+}
+
+func TestVTGateLogQueriesTransaction(t *testing.T) {
+	var logged []string
+	defer func(f func(string, ...interface{})) { logSessionQuery = f }(logSessionQuery)
+	logSessionQuery = func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	resetSandbox()
+	testConns[0] = &sandboxConn{}
+
+	// Begin hands out a new session, the client sets the flag on it
+	session := new(proto.Session)
+	RpcVTGate.Begin(nil, session)
+	session.LogQueries = true
+	q := proto.QueryShard{
+		Sql:     "transaction query",
+		Shards:  []string{"0"},
+		Session: session,
+	}
+	qr := new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if qr.Error != "" {
+		t.Errorf("want empty, got %s", qr.Error)
+	}
+	if err := RpcVTGate.Commit(nil, qr.Session); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if len(logged) != 2 || !strings.Contains(logged[0], "transaction query") || !strings.HasPrefix(logged[1], "Commit: ") {
+		t.Errorf("want the query and the commit logged, got %v", logged)
+	}
+
+	logged = nil
+	RpcVTGate.Begin(nil, session)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	RpcVTGate.Rollback(nil, qr.Session)
+	if len(logged) != 2 || !strings.HasPrefix(logged[1], "Rollback: ") {
+		t.Errorf("want the query and the rollback logged, got %v", logged)
+	}
+}
+
 func TestVTGateExecuteBatchShard(t *testing.T) {
 	resetSandbox()
 	mapTestConn("-20", &sandboxConn{})
@@ -114,6 +355,52 @@ func TestVTGateExecuteBatchShard(t *testing.T) {
 	}
 }
 
+func TestVTGateExecuteBatchShardContinueOnError(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	mapTestConn("40-60", sbc)
+	q := proto.BatchQueryShard{
+		Queries: []tproto.BoundQuery{{
+			Sql: "query1",
+		}, {
+			Sql: "query2",
+		}},
+		Shards:          []string{"40-60"},
+		ContinueOnError: true,
+		Session:         new(proto.Session),
+	}
+	RpcVTGate.Begin(nil, q.Session)
+	qrl := new(proto.QueryResultList)
+	if err := RpcVTGate.ExecuteBatchShard(nil, &q, qrl); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if qrl.Errors[0] != "" || qrl.Errors[1] != "" {
+		t.Errorf("want no errors, got %#v", qrl.Errors)
+	}
+
+	// The first query fails, the second one still runs.
+	sbc.mustFailServer = 1
+	if err := RpcVTGate.ExecuteBatchShard(nil, &q, qrl); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if qrl.Error != "" {
+		t.Errorf("want no batch error, got %v", qrl.Error)
+	}
+	if len(qrl.Errors) != 2 || !strings.HasPrefix(qrl.Errors[0], "error: err") || qrl.Errors[1] != "" {
+		t.Errorf("want an error for the first query only, got %#v", qrl.Errors)
+	}
+	if len(qrl.List) != 2 || qrl.List[1].RowsAffected != 1 {
+		t.Errorf("want a result for the second query, got %#v", qrl.List)
+	}
+	// All the queries ran, one by one, in the transaction.
+	if got := len(sbc.Queries); got != 4 {
+		t.Errorf("want 4 queries, got %v", got)
+	}
+	if sbc.BeginCount.Get() != 1 || len(qrl.Session.ShardSessions) != 1 {
+		t.Errorf("want the batch in one transaction, got %v begins, session %#v", sbc.BeginCount.Get(), qrl.Session)
+	}
+}
+
 func TestVTGateStreamExecuteKeyRange(t *testing.T) {
 	resetSandbox()
 	sbc := &sandboxConn{}
@@ -233,3 +520,308 @@ func TestVTGateStreamExecuteShard(t *testing.T) {
 	}
 
 }
+
+func TestVTGateReloadTopo(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	q := proto.QueryShard{
+		Sql:     "query",
+		Shards:  []string{"0"},
+		Session: new(proto.Session),
+	}
+	RpcVTGate.Begin(nil, q.Session)
+	RpcVTGate.ExecuteShard(nil, &q, new(proto.QueryResult))
+
+	// Hold the old ScatterConn like an in-flight query would.
+	old := RpcVTGate.getScatterConn()
+	before := RpcVTGate.TopoRefreshTime()
+	done := make(chan struct{})
+	serv := new(sandboxTopo)
+	RpcVTGate.ReloadTopo(serv, func() { close(done) })
+	if got := RpcVTGate.TopoRefreshTime(); !got.After(before) {
+		t.Errorf("want refresh time after %v, got %v", before, got)
+	}
+	stc := RpcVTGate.getScatterConn()
+	stc.inFlight.Done()
+	if stc == old || stc.toposerv != serv {
+		t.Errorf("want a new ScatterConn using the new topo server")
+	}
+
+	// The session survives the reload.
+	if err := RpcVTGate.Commit(nil, q.Session); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if sbc.CommitCount != 1 {
+		t.Errorf("want 1, got %d", sbc.CommitCount)
+	}
+
+	select {
+	case <-done:
+		t.Errorf("old ScatterConn closed while in use")
+	case <-time.After(10 * time.Millisecond):
+	}
+	old.inFlight.Done()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("old ScatterConn not closed")
+	}
+}
+
+func TestVTGateMaxInflightQueries(t *testing.T) {
+	defer func() { *maxInflightQueries = 0 }()
+	*maxInflightQueries = 1
+	resetSandbox()
+	testConns[0] = &sandboxConn{}
+	q := proto.QueryShard{
+		Sql:    "query",
+		Shards: []string{"0"},
+	}
+	rejections := inflightQueryRejections.Get()
+
+	// Hold the only slot like an in-flight query would.
+	if err := RpcVTGate.startQuery(nil); err != nil {
+		t.Fatalf("want nil, got %v", err)
+	}
+	err := RpcVTGate.ExecuteShard(nil, &q, new(proto.QueryResult))
+	want := "too many queries in flight: 1"
+	if err == nil || err.Error() != want {
+		t.Errorf("want %v, got %v", want, err)
+	}
+	if got := inflightQueryRejections.Get() - rejections; got != 1 {
+		t.Errorf("want 1 rejection, got %v", got)
+	}
+	// Commits and rollbacks are never refused.
+	if err := RpcVTGate.Rollback(nil, new(proto.Session)); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+
+	// Once it's done, there's room again.
+	RpcVTGate.endQuery()
+	if err := RpcVTGate.ExecuteShard(nil, &q, new(proto.QueryResult)); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+	if got := inflightQueries.Get(); got != 0 {
+		t.Errorf("want 0 sessions, got %v", got)
+	}
+}
+
+func TestVTGateConnectionId(t *testing.T) {
+	defer func() { *returnConnectionIds = false }()
+	resetSandbox()
+	testConns[0] = &sandboxConn{connectionId: 42}
+	testConns[1] = &sandboxConn{connectionId: 43}
+	// A new keyspace, so the ShardConns of the previous tests aren't reused.
+	q := proto.QueryShard{
+		Sql:      "query",
+		Keyspace: "connection_id",
+		Shards:   []string{"0"},
+	}
+
+	// Not returned by default, but in the slow queries.
+	slowQueries.Reset()
+	qr := new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if qr.ConnectionId != 0 {
+		t.Errorf("want 0, got %v", qr.ConnectionId)
+	}
+	if entries := slowQueries.Entries(); len(entries) != 1 || entries[0].ConnectionId != 42 {
+		t.Errorf("want connection id 42, got %+v", entries)
+	}
+
+	*returnConnectionIds = true
+	qr = new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if qr.ConnectionId != 42 {
+		t.Errorf("want 42, got %v", qr.ConnectionId)
+	}
+
+	// Not known for more than one shard.
+	q.Shards = []string{"0", "1"}
+	qr = new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if qr.ConnectionId != 0 {
+		t.Errorf("want 0, got %v", qr.ConnectionId)
+	}
+}
+
+func TestVTGateExplain(t *testing.T) {
+	resetSandbox()
+	sbc0 := &sandboxConn{}
+	sbc1 := &sandboxConn{}
+	testConns[0] = sbc0
+	testConns[1] = sbc1
+	q := proto.QueryShard{
+		Sql:      "select * from t where id = :id",
+		Keyspace: "explain",
+		Shards:   []string{"0"},
+		Explain:  true,
+	}
+	qr := new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if qr.Error != "" {
+		t.Fatalf("want no error, got %v", qr.Error)
+	}
+	if len(sbc0.Queries) != 1 || sbc0.Queries[0].Sql != "explain select * from t where id = :id" {
+		t.Errorf("want the explain query, got %+v", sbc0.Queries)
+	}
+	if len(qr.Fields) != 5 || qr.Fields[0].Name != "shard" || qr.Fields[1].Name != "id" {
+		t.Errorf("want the shard and plan fields, got %+v", qr.Fields)
+	}
+	if len(qr.Rows) != 1 || qr.Rows[0][0].String() != "0" || qr.Rows[0][4].String() != "PRIMARY" {
+		t.Errorf("want the plan of shard 0, got %v", qr.Rows)
+	}
+
+	q.Shards = []string{"0", "1"}
+	qr = new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if len(qr.Rows) != 2 {
+		t.Errorf("want a plan per shard, got %v", qr.Rows)
+	}
+
+	// Only selects can be explained.
+	q.Sql = "update t set a = 1"
+	q.Shards = []string{"0"}
+	qr = new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if want := "EXPLAIN is only supported for selects"; !strings.HasPrefix(qr.Error, want) {
+		t.Errorf("want %v, got %v", want, qr.Error)
+	}
+
+	// Nor in a transaction.
+	q.Sql = "select * from t where id = :id"
+	q.Session = &proto.Session{InTransaction: true}
+	qr = new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if want := "explain and quorum reads are not supported in a transaction"; qr.Error != want {
+		t.Errorf("want %v, got %v", want, qr.Error)
+	}
+}
+
+func TestVTGateUpgradeSession(t *testing.T) {
+	resetSandbox()
+	sandboxEndPoints = map[topo.TabletType][]topo.EndPoint{
+		topo.TYPE_MASTER:  {{Uid: 40, Host: "0", NamedPortMap: map[string]int{"vt": 1}}},
+		topo.TYPE_REPLICA: {{Uid: 41, Host: "0", NamedPortMap: map[string]int{"vt": 1}}},
+	}
+	master := &sandboxConn{}
+	replica := &sandboxConn{}
+	testConns[40] = master
+	testConns[41] = replica
+	session := new(proto.Session)
+	q := proto.QueryShard{
+		Sql:        "query",
+		Keyspace:   "upgrade",
+		Shards:     []string{"0"},
+		TabletType: topo.TYPE_REPLICA,
+	}
+	execute := func(session *proto.Session) {
+		q.Session = session
+		qr := new(proto.QueryResult)
+		RpcVTGate.ExecuteShard(nil, &q, qr)
+		if qr.Error != "" {
+			t.Fatalf("want no error, got %v", qr.Error)
+		}
+	}
+
+	execute(session)
+	if len(master.Queries) != 0 || len(replica.Queries) != 1 {
+		t.Errorf("want the query on the replica, got master %v replica %v", len(master.Queries), len(replica.Queries))
+	}
+
+	upgraded := new(proto.Session)
+	if err := RpcVTGate.UpgradeSession(nil, session, upgraded); err != nil {
+		t.Fatalf("UpgradeSession: %v", err)
+	}
+	if !upgraded.Upgraded {
+		t.Errorf("want an upgraded session, got %+v", upgraded)
+	}
+	execute(upgraded)
+	if len(master.Queries) != 1 || len(replica.Queries) != 1 {
+		t.Errorf("want the query on the master, got master %v replica %v", len(master.Queries), len(replica.Queries))
+	}
+	// So do the quorum reads.
+	q.Sql, q.Quorum = "select * from t", 1
+	execute(upgraded)
+	q.Sql, q.Quorum = "query", 0
+	if len(master.Queries) != 2 || len(replica.Queries) != 1 {
+		t.Errorf("want the quorum read on the master, got master %v replica %v", len(master.Queries), len(replica.Queries))
+	}
+
+	downgraded := new(proto.Session)
+	if err := RpcVTGate.DowngradeSession(nil, upgraded, downgraded); err != nil {
+		t.Fatalf("DowngradeSession: %v", err)
+	}
+	execute(downgraded)
+	if len(master.Queries) != 2 || len(replica.Queries) != 2 {
+		t.Errorf("want the query on the replica, got master %v replica %v", len(master.Queries), len(replica.Queries))
+	}
+
+	// The routing can't change in a transaction.
+	inTx := &proto.Session{InTransaction: true}
+	err := RpcVTGate.UpgradeSession(nil, inTx, new(proto.Session))
+	if want := "cannot change the routing of a session in a transaction"; err == nil || err.Error() != want {
+		t.Errorf("want %v, got %v", want, err)
+	}
+}
+
+func TestVTGateSessionTabletType(t *testing.T) {
+	resetSandbox()
+	sandboxEndPoints = map[topo.TabletType][]topo.EndPoint{
+		topo.TYPE_MASTER:  {{Uid: 42, Host: "0", NamedPortMap: map[string]int{"vt": 1}}},
+		topo.TYPE_REPLICA: {{Uid: 43, Host: "0", NamedPortMap: map[string]int{"vt": 1}}},
+	}
+	master := &sandboxConn{}
+	replica := &sandboxConn{}
+	testConns[42] = master
+	testConns[43] = replica
+	session := &proto.Session{TabletType: topo.TYPE_REPLICA}
+	execute := func(tabletType topo.TabletType) {
+		q := proto.QueryShard{
+			Sql:        "query",
+			Keyspace:   "sessiontype",
+			Shards:     []string{"0"},
+			TabletType: tabletType,
+			Session:    session,
+		}
+		qr := new(proto.QueryResult)
+		RpcVTGate.ExecuteShard(nil, &q, qr)
+		if qr.Error != "" {
+			t.Fatalf("want no error, got %v", qr.Error)
+		}
+	}
+
+	// The queries that set no tablet type use the one of the session.
+	execute("")
+	if len(master.Queries) != 0 || len(replica.Queries) != 1 {
+		t.Errorf("want the query on the replica, got master %v replica %v", len(master.Queries), len(replica.Queries))
+	}
+	execute(topo.TYPE_MASTER)
+	if len(master.Queries) != 1 || len(replica.Queries) != 1 {
+		t.Errorf("want the query on the master, got master %v replica %v", len(master.Queries), len(replica.Queries))
+	}
+	execute("")
+	if len(master.Queries) != 1 || len(replica.Queries) != 2 {
+		t.Errorf("want the query on the replica, got master %v replica %v", len(master.Queries), len(replica.Queries))
+	}
+}
+
+func TestVTGateBindVarsValidation(t *testing.T) {
+	resetSandbox()
+	sbc := &sandboxConn{}
+	testConns[0] = sbc
+	q := proto.QueryShard{
+		Sql:           "query",
+		BindVariables: map[string]interface{}{"v": true},
+		Shards:        []string{"0"},
+	}
+	qr := new(proto.QueryResult)
+	RpcVTGate.ExecuteShard(nil, &q, qr)
+	if want := "bind variable v has an unsupported type: bool"; qr.Error != want {
+		t.Errorf("want %v, got %v", want, qr.Error)
+	}
+	if got := sbc.ExecCount.Get(); got != 0 {
+		t.Errorf("want 0, got %v", got)
+	}
+}
@@ -9,6 +9,7 @@ package vttablet
 
 import (
 	"encoding/json"
+	"flag"
 	"reflect"
 	"strings"
 
@@ -24,6 +25,8 @@ import (
 	"github.com/youtube/vitess/go/vt/topo"
 )
 
+var forcePidNode = flag.Bool("force", false, "start even if the tablet has a pid node from an agent on another host, and take it over: make sure that agent is gone first")
+
 func loadSchemaOverrides(overridesFile string) []ts.SchemaOverride {
 	var schemaOverrides []ts.SchemaOverride
 	if overridesFile == "" {
@@ -61,10 +64,12 @@ func InitAgent(
 	if err != nil {
 		return nil, err
 	}
+	agent.ForcePidNode = *forcePidNode
 
 	// Start the binlog player services, not playing at start.
 	agent.BinlogPlayerMap = tabletmanager.NewBinlogPlayerMap(topoServer, &dbcfgs.App.ConnectionParams, mysqld)
 	tabletmanager.RegisterBinlogPlayerMap(agent.BinlogPlayerMap)
+	tabletmanager.RegisterCurrentAction(agent)
 
 	// Action agent listens to changes in zookeeper and makes
 	// modifications to this tablet.
@@ -142,6 +147,7 @@ func InitAgent(
 				}
 				qrs.Add(qr)
 			}
+			ts.SetTabletType(string(newTablet.Type))
 			ts.AllowQueries(&dbcfgs.App, schemaOverrides, qrs, mysqld)
 			// Disable before enabling to force existing streams to stop.
 			binlog.DisableUpdateStreamService()
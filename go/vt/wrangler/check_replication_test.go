@@ -0,0 +1,114 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wrangler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/tabletmanager"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/zktopo"
+)
+
+func checkReplicationParents(t *testing.T, ts topo.Server, tabletAlias, want topo.TabletAlias) {
+	tablet, err := ts.GetTablet(tabletAlias)
+	if err != nil {
+		t.Fatalf("GetTablet failed: %v", err)
+	}
+	if tablet.Parent != want {
+		t.Errorf("tablet %v has parent %v, want %v", tabletAlias, tablet.Parent, want)
+	}
+	sri, err := ts.GetShardReplication(tabletAlias.Cell, "test_keyspace", "0")
+	if err != nil {
+		t.Fatalf("GetShardReplication failed: %v", err)
+	}
+	rl, err := sri.GetReplicationLink(tabletAlias)
+	if err != nil {
+		t.Errorf("tablet %v is not in the replication graph: %v", tabletAlias, err)
+	} else if rl.Parent != want {
+		t.Errorf("tablet %v has replication parent %v, want %v", tabletAlias, rl.Parent, want)
+	}
+}
+
+func TestCheckReplication(t *testing.T) {
+	ts := zktopo.NewTestServer(t, []string{"cell1"})
+	wr := New(ts, time.Minute, time.Second)
+
+	// the slave record points at the other slave, but MySQL
+	// really replicates from the master
+	masterAlias := createTestTablet(t, wr, "cell1", 0, topo.TYPE_MASTER, topo.TabletAlias{})
+	otherAlias := createTestTablet(t, wr, "cell1", 1, topo.TYPE_REPLICA, masterAlias)
+	slaveAlias := createTestTablet(t, wr, "cell1", 2, topo.TYPE_REPLICA, otherAlias)
+	mysqlDaemon := &mysqlctl.FakeMysqlDaemon{
+		MasterAddr: "100.0.0.1:3300",
+		MysqlPort:  3302,
+	}
+
+	// the master is skipped
+	reply, err := tabletmanager.CheckReplication(ts, mysqlDaemon, masterAlias, false)
+	if err != nil || len(reply.Discrepancies) != 0 {
+		t.Fatalf("CheckReplication(master) = %v, %v", reply, err)
+	}
+
+	// report only: nothing changes
+	reply, err = tabletmanager.CheckReplication(ts, mysqlDaemon, slaveAlias, false)
+	if err != nil {
+		t.Fatalf("CheckReplication failed: %v", err)
+	}
+	if reply.ActualParent != masterAlias || reply.Parent != otherAlias || reply.ReplicationParent != otherAlias {
+		t.Errorf("unexpected parents: %v", reply)
+	}
+	if len(reply.Discrepancies) != 1 || reply.Repaired {
+		t.Errorf("want one unrepaired discrepancy: %v", reply)
+	}
+	checkReplicationParents(t, ts, slaveAlias, otherAlias)
+
+	// repair: both records now point at the master
+	reply, err = tabletmanager.CheckReplication(ts, mysqlDaemon, slaveAlias, true)
+	if err != nil {
+		t.Fatalf("CheckReplication(repair) failed: %v", err)
+	}
+	if !reply.Repaired || len(reply.Unresolved) != 0 {
+		t.Errorf("want repaired: %v", reply)
+	}
+	checkReplicationParents(t, ts, slaveAlias, masterAlias)
+	reply, err = tabletmanager.CheckReplication(ts, mysqlDaemon, slaveAlias, false)
+	if err != nil || len(reply.Discrepancies) != 0 {
+		t.Errorf("CheckReplication after repair = %v, %v", reply, err)
+	}
+
+	// missing replication graph entry
+	if err := topo.RemoveShardReplicationRecord(ts, "test_keyspace", "0", slaveAlias); err != nil {
+		t.Fatalf("RemoveShardReplicationRecord failed: %v", err)
+	}
+	reply, err = tabletmanager.CheckReplication(ts, mysqlDaemon, slaveAlias, false)
+	if err != nil {
+		t.Fatalf("CheckReplication failed: %v", err)
+	}
+	if !reply.ReplicationParent.IsZero() || len(reply.Discrepancies) != 1 {
+		t.Errorf("want a missing replication graph entry: %v", reply)
+	}
+	reply, err = tabletmanager.CheckReplication(ts, mysqlDaemon, slaveAlias, true)
+	if err != nil || !reply.Repaired {
+		t.Errorf("CheckReplication(repair) = %v, %v", reply, err)
+	}
+	checkReplicationParents(t, ts, slaveAlias, masterAlias)
+
+	// unknown master address: cannot be repaired
+	mysqlDaemon.MasterAddr = "234.0.0.1:3301"
+	reply, err = tabletmanager.CheckReplication(ts, mysqlDaemon, slaveAlias, true)
+	if err != nil {
+		t.Fatalf("CheckReplication(repair) failed: %v", err)
+	}
+	if !reply.ActualParent.IsZero() || len(reply.Discrepancies) != 1 {
+		t.Errorf("want an unknown master: %v", reply)
+	}
+	if reply.Repaired || len(reply.Unresolved) != 1 {
+		t.Errorf("want one unresolved discrepancy: %v", reply)
+	}
+	checkReplicationParents(t, ts, slaveAlias, masterAlias)
+}
@@ -365,10 +365,12 @@ func (wr *Wrangler) rebuildKeyspace(keyspace string, cells []string) error {
 			}
 
 			srvKeyspaceMap[keyspaceLocation] = &topo.SrvKeyspace{
-				Shards:             make([]topo.SrvShard, 0, 16),
-				ShardingColumnName: ki.ShardingColumnName,
-				ShardingColumnType: ki.ShardingColumnType,
-				ServedFrom:         ki.ServedFrom,
+				Shards:                    make([]topo.SrvShard, 0, 16),
+				ShardingColumnName:        ki.ShardingColumnName,
+				ShardingColumnType:        ki.ShardingColumnType,
+				ServedFrom:                ki.ServedFrom,
+				MigratingTables:           ki.MigratingTables,
+				MigratingTablesExpireTime: ki.MigratingTablesExpireTime,
 			}
 		}
 	}
@@ -79,7 +79,7 @@ func startFakeTabletActionLoop(t *testing.T, wr *Wrangler, tabletAlias topo.Tabl
 			}
 			return nil
 		}
-		wr.ts.ActionEventLoop(tabletAlias, f, done)
+		wr.ts.ActionEventLoop(tabletAlias, f, actionnode.QueueDecoder{}, 1, 0, done)
 	}()
 }
 
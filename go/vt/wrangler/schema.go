@@ -9,10 +9,12 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/vt/concurrency"
 	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/sqlparser"
 	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
 	"github.com/youtube/vitess/go/vt/topo"
 )
@@ -414,17 +416,97 @@ func (wr *Wrangler) applySchemaShardComplex(statusArray []*TabletStatus, shardIn
 // and fail if not (unless force is specified)
 // if simple, we just do it on all masters.
 // if complex, we do the shell game in parallel on all shards
-func (wr *Wrangler) ApplySchemaKeyspace(keyspace string, change string, simple, force bool) (*myproto.SchemaChangeResult, error) {
+// if blockTablesTTL is set, vtgate holds off the queries on the
+// changed tables during the change, for up to blockTablesTTL.
+func (wr *Wrangler) ApplySchemaKeyspace(keyspace string, change string, simple, force bool, blockTablesTTL time.Duration) (*myproto.SchemaChangeResult, error) {
 	actionNode := actionnode.ApplySchemaKeyspace(change, simple)
 	lockPath, err := wr.lockKeyspace(keyspace, actionNode)
 	if err != nil {
 		return nil, err
 	}
 
+	// Have vtgate hold off the queries on the changed tables
+	// while the shards don't all have the same schema. The block
+	// expires by itself if we don't get to clear it.
+	var tables []string
+	if blockTablesTTL > 0 {
+		tables = schemaChangeTables(change)
+	}
+	if len(tables) != 0 {
+		if err := wr.setKeyspaceMigratingTables(keyspace, tables, blockTablesTTL); err != nil {
+			return nil, wr.unlockKeyspace(keyspace, actionNode, lockPath, err)
+		}
+	}
+
 	scr, err := wr.applySchemaKeyspace(keyspace, change, simple, force)
+
+	if len(tables) != 0 {
+		if clearErr := wr.setKeyspaceMigratingTables(keyspace, nil, 0); clearErr != nil {
+			log.Errorf("Cannot clear the migrating tables of %v, use SetKeyspaceMigratingTables: %v", keyspace, clearErr)
+			if err == nil {
+				err = clearErr
+			}
+		}
+	}
 	return scr, wr.unlockKeyspace(keyspace, actionNode, lockPath, err)
 }
 
+// SetKeyspaceMigratingTables records the tables of a keyspace that
+// are going through a schema change, and rebuilds the keyspace
+// serving graph so vtgate rejects the queries using them, for up
+// to ttl (0 for no limit). An empty list clears them.
+func (wr *Wrangler) SetKeyspaceMigratingTables(keyspace string, tables []string, ttl time.Duration) error {
+	actionNode := actionnode.SetKeyspaceMigratingTables()
+	lockPath, err := wr.lockKeyspace(keyspace, actionNode)
+	if err != nil {
+		return err
+	}
+
+	err = wr.setKeyspaceMigratingTables(keyspace, tables, ttl)
+	return wr.unlockKeyspace(keyspace, actionNode, lockPath, err)
+}
+
+func (wr *Wrangler) setKeyspaceMigratingTables(keyspace string, tables []string, ttl time.Duration) error {
+	ki, err := wr.ts.GetKeyspace(keyspace)
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		tables = nil
+	}
+	ki.MigratingTables = tables
+	ki.MigratingTablesExpireTime = 0
+	if tables != nil && ttl > 0 {
+		ki.MigratingTablesExpireTime = time.Now().Add(ttl).UnixNano()
+	}
+	if err := wr.ts.UpdateKeyspace(ki); err != nil {
+		return err
+	}
+	return wr.rebuildKeyspace(keyspace, nil)
+}
+
+// schemaChangeTables returns the tables the statements of a schema
+// change create, alter, drop or rename. The statements that don't
+// parse are skipped.
+func schemaChangeTables(change string) []string {
+	var tables []string
+	for _, statement := range strings.Split(change, ";") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		plan := sqlparser.DDLParse(statement)
+		if plan.Action == 0 {
+			log.Warningf("Cannot find the table of %q, not blocking it", statement)
+			continue
+		}
+		tables = append(tables, plan.TableName)
+		if plan.NewName != plan.TableName {
+			tables = append(tables, plan.NewName)
+		}
+	}
+	return tables
+}
+
 func (wr *Wrangler) applySchemaKeyspace(keyspace string, change string, simple, force bool) (*myproto.SchemaChangeResult, error) {
 	shards, err := wr.ts.GetShardNames(keyspace)
 	if err != nil {
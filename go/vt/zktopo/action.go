@@ -7,6 +7,7 @@ package zktopo
 import (
 	"fmt"
 	"math/rand"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,7 +24,62 @@ func (zkts *Server) WriteTabletAction(tabletAlias topo.TabletAlias, contents str
 	// Action paths end in a trailing slash to that when we create
 	// sequential nodes, they are created as children, not siblings.
 	actionPath := TabletActionPathForAlias(tabletAlias) + "/"
-	return zkts.zconn.Create(actionPath, contents, zookeeper.SEQUENCE, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	return zkts.zconn.Create(actionPath, contents, zookeeper.SEQUENCE, zkts.acl())
+}
+
+// Action priorities are encoded in the action node name, so the
+// action queue can be ordered without reading every node: a
+// prioritized action is named P<priority>-<sequence>, where priority
+// is a single digit and lower priorities run first, and sequence is
+// the ZooKeeper sequence number. Unprefixed actions (named just
+// <sequence>) have topo.ACTION_PRIORITY_DEFAULT. Within the same
+// priority, actions run in sequence order. See actionQueueLess.
+func (zkts *Server) WriteTabletActionWithPriority(tabletAlias topo.TabletAlias, contents string, priority int) (string, error) {
+	if priority < topo.ACTION_PRIORITY_HIGHEST || priority > topo.ACTION_PRIORITY_LOWEST {
+		return "", fmt.Errorf("invalid action priority %v, must be between %v and %v", priority, topo.ACTION_PRIORITY_HIGHEST, topo.ACTION_PRIORITY_LOWEST)
+	}
+	actionPath := fmt.Sprintf("%v/P%v-", TabletActionPathForAlias(tabletAlias), priority)
+	return zkts.zconn.Create(actionPath, contents, zookeeper.SEQUENCE, zkts.acl())
+}
+
+// parseActionName returns the priority and sequence number of an
+// action node name.
+func parseActionName(name string) (priority int, sequence uint64, err error) {
+	priority = topo.ACTION_PRIORITY_DEFAULT
+	if len(name) > 3 && name[0] == 'P' && name[2] == '-' && name[1] >= '0' && name[1] <= '9' {
+		priority = int(name[1] - '0')
+		name = name[3:]
+	}
+	sequence, err = strconv.ParseUint(name, 10, 64)
+	return
+}
+
+// actionQueueLess orders valid action node names by priority first,
+// then by sequence.
+func actionQueueLess(name1, name2 string) bool {
+	p1, s1, _ := parseActionName(name1)
+	p2, s2, _ := parseActionName(name2)
+	if p1 != p2 {
+		return p1 < p2
+	}
+	return s1 < s2
+}
+
+type actionQueue []string
+
+func (aq actionQueue) Len() int           { return len(aq) }
+func (aq actionQueue) Swap(i, j int)      { aq[i], aq[j] = aq[j], aq[i] }
+func (aq actionQueue) Less(i, j int) bool { return actionQueueLess(aq[i], aq[j]) }
+
+// actionsBySequence orders action node names by creation order only.
+type actionsBySequence []string
+
+func (as actionsBySequence) Len() int      { return len(as) }
+func (as actionsBySequence) Swap(i, j int) { as[i], as[j] = as[j], as[i] }
+func (as actionsBySequence) Less(i, j int) bool {
+	_, s1, _ := parseActionName(as[i])
+	_, s2, _ := parseActionName(as[j])
+	return s1 < s2
 }
 
 func (zkts *Server) WaitForTabletAction(actionPath string, waitTime time.Duration, interrupted chan struct{}) (string, error) {
@@ -5,14 +5,18 @@
 package zktopo
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"math/rand"
 	"path"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/zk"
 	"launchpad.net/gozk/zookeeper"
@@ -22,12 +26,79 @@ import (
 This file contains the code to support the local agent process for zktopo.Server
 */
 
+var (
+	watchRetryMinDelay  = flag.Duration("action_watch_retry_min_delay", time.Second, "initial delay before retrying a failed watch on the action queue")
+	watchRetryMaxDelay  = flag.Duration("action_watch_retry_max_delay", time.Minute, "maximum delay before retrying a failed watch on the action queue")
+	actionQueueMaxDepth = flag.Int("action_queue_max_depth", 0, "maximum number of actions the agent takes from its queue at a time, the others wait for the next pass (0 for no limit)")
+	actionQueueVerify   = flag.Bool("action_queue_verify", false, "record the guids of the last completed actions next to the action queue, and on startup log the queue and remove the completed actions still in it without running them again (e.g. left by an agent crash)")
+	actionMaxClockSkew  = flag.Duration("action_max_clock_skew", time.Minute, "how far ahead of the agent the clocks of the clients queuing actions can be: an action only expires that long after its expiry time")
+)
+
+// actionQueueTruncations counts the passes on the action queue that
+// left actions for later because of -action_queue_max_depth.
+var actionQueueTruncations = stats.NewInt("ActionQueueTruncations")
+
+// actionsExpired counts the actions removed from the queue because
+// they expired before the agent got to them.
+var actionsExpired = stats.NewInt("ActionsExpired")
+
+// maxCompletedActions is how many guids of completed actions
+// recordCompletedAction keeps.
+const maxCompletedActions = 100
+
+// retryBackoff computes the delays between attempts to set a watch:
+// they double after each failure up to max, with random jitter so a
+// fleet of agents doesn't reconnect all at once after a zookeeper
+// outage. reset is called once a watch is established.
+type retryBackoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newRetryBackoff() *retryBackoff {
+	return &retryBackoff{min: *watchRetryMinDelay, max: *watchRetryMaxDelay}
+}
+
+// next returns the delay to wait before the next attempt, somewhere
+// between half and all of the current backoff.
+func (rb *retryBackoff) next() time.Duration {
+	if rb.current == 0 {
+		rb.current = rb.min
+	} else {
+		rb.current *= 2
+	}
+	if rb.current > rb.max || rb.current <= 0 {
+		rb.current = rb.max
+	}
+	half := rb.current / 2
+	if half <= 0 {
+		return rb.current
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (rb *retryBackoff) reset() {
+	rb.current = 0
+}
+
+// wait sleeps for the next backoff delay, and returns false if done
+// was closed in the meantime.
+func (rb *retryBackoff) wait(done chan struct{}) bool {
+	select {
+	case <-time.After(rb.next()):
+		return true
+	case <-done:
+		return false
+	}
+}
+
 func (zkts *Server) ValidateTabletActions(tabletAlias topo.TabletAlias) error {
 	actionPath := TabletActionPathForAlias(tabletAlias)
 
 	// Ensure that the action node is there. There is no conflict creating
 	// this node.
-	_, err := zkts.zconn.Create(actionPath, "", 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	_, err := zkts.zconn.Create(actionPath, "", 0, zkts.acl())
 	if err != nil && !zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
 		return err
 	}
@@ -37,7 +108,16 @@ func (zkts *Server) ValidateTabletActions(tabletAlias topo.TabletAlias) error {
 func (zkts *Server) CreateTabletPidNode(tabletAlias topo.TabletAlias, contents string, done chan struct{}) error {
 	zkTabletPath := TabletPathForAlias(tabletAlias)
 	path := path.Join(zkTabletPath, "pid")
-	return zk.CreatePidNode(zkts.zconn, path, contents, done)
+	return zk.CreatePidNode(zkts.zconn, path, contents, zkts.acl(), done)
+}
+
+func (zkts *Server) DeleteTabletPidNode(tabletAlias topo.TabletAlias) error {
+	zkTabletPath := TabletPathForAlias(tabletAlias)
+	err := zkts.zconn.Delete(path.Join(zkTabletPath, "pid"), -1)
+	if err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+		return err
+	}
+	return nil
 }
 
 func (zkts *Server) ValidateTabletPidNode(tabletAlias topo.TabletAlias) error {
@@ -47,30 +127,146 @@ func (zkts *Server) ValidateTabletPidNode(tabletAlias topo.TabletAlias) error {
 	return err
 }
 
+func (zkts *Server) GetTabletPidNode(tabletAlias topo.TabletAlias) (string, error) {
+	zkTabletPath := TabletPathForAlias(tabletAlias)
+	contents, _, err := zkts.zconn.Get(path.Join(zkTabletPath, "pid"))
+	if err != nil {
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			err = topo.ErrNoNode
+		}
+		return "", err
+	}
+	return contents, nil
+}
+
 func (zkts *Server) GetSubprocessFlags() []string {
-	return zk.GetZkSubprocessFlags()
+	// vtaction creates nodes too, with the same ACL, and
+	// records the actions it completes.
+	return append(zk.GetZkSubprocessFlags(), "-zk_acl", *zkACL, fmt.Sprintf("-action_queue_verify=%v", *actionQueueVerify))
+}
+
+// actionLeasePathForAlias returns the path of the node recording
+// which action the agent is dispatching.
+func actionLeasePathForAlias(tabletAlias topo.TabletAlias) string {
+	return path.Join(TabletPathForAlias(tabletAlias), "actionlease")
+}
+
+// checkActionLease looks for a lease left behind by a previous agent,
+// and returns the action path it was dispatching, or "".
+func (zkts *Server) checkActionLease(tabletAlias topo.TabletAlias) string {
+	leasePath := actionLeasePathForAlias(tabletAlias)
+	actionPath, _, err := zkts.zconn.Get(leasePath)
+	if err != nil {
+		if !zookeeper.IsError(err, zookeeper.ZNONODE) {
+			log.Warningf("cannot read action lease %v: %v", leasePath, err)
+		}
+		return ""
+	}
+	if _, _, err := zkts.zconn.Get(actionPath); err != nil {
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			log.Infof("action %v was completed while the agent was down, removing its lease", actionPath)
+			zkts.releaseActionLease(tabletAlias)
+			return ""
+		}
+		log.Warningf("cannot read leased action %v: %v", actionPath, err)
+	}
+	return actionPath
+}
+
+func (zkts *Server) acquireActionLease(tabletAlias topo.TabletAlias, actionPath string) error {
+	_, err := zk.CreateOrUpdate(zkts.zconn, actionLeasePathForAlias(tabletAlias), actionPath, 0, zkts.acl(), false)
+	return err
+}
+
+func (zkts *Server) releaseActionLease(tabletAlias topo.TabletAlias) {
+	leasePath := actionLeasePathForAlias(tabletAlias)
+	if err := zkts.zconn.Delete(leasePath, -1); err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+		log.Warningf("cannot remove action lease %v: %v", leasePath, err)
+	}
+}
+
+// recoverLeasedAction decides what to do with an action a previous
+// agent was dispatching when it died, using the state the vtaction
+// process left in the action node. It returns true if the action
+// should be dispatched, or an error if the queue should stop.
+func (zkts *Server) recoverLeasedAction(actionPath, data string, decoder topo.ActionNodeDecoder) (bool, error) {
+	header, err := decoder.DecodeActionHeader(data)
+	if err != nil {
+		// the dispatcher will complain about it
+		return true, nil
+	}
+	switch {
+	case header.Done:
+		// vtaction stored the result, but didn't get to unblock
+		// the queue. The agent read the tablet record again when
+		// it started, so there is nothing else to do.
+		log.Warningf("action %v was completed while the agent was down, removing it from the queue", actionPath)
+		if err := zkts.UnblockTabletAction(actionPath); err != nil {
+			return false, err
+		}
+		return false, nil
+	case header.Failed:
+		// leave it in the queue for someone to look at, as if
+		// vtaction had reported the failure
+		return false, fmt.Errorf("action %v failed while the agent was down: %v", actionPath, header.Error)
+	}
+	// Running or still queued: vtaction never runs an action twice.
+	// It waits for the previous vtaction if it is still running, or
+	// marks the action as failed if it died. A queued action never
+	// started, so it is safe to run.
+	log.Warningf("agent restarted while dispatching %v, dispatching it again with its current state: %v", actionPath, data)
+	return true, nil
 }
 
 // handleActionQueue will set the watch on the action queue,
 // or return an error if it can't.
 // It will also process all pending actions, until it can't read one
 // or one fails. No error is returned for action failures.
-func (zkts *Server) handleActionQueue(tabletAlias topo.TabletAlias, dispatchAction func(actionPath, data string) error) (<-chan zookeeper.Event, error) {
+//
+// An action lease is held while an action is dispatched. If the
+// agent dies while dispatching an action, the next agent finds the
+// lease on startup, and uses recoverLeasedAction to check the result
+// of the action instead of blindly dispatching it again. A lease for
+// an action that is not in the queue any more is just removed.
+//
+// With a concurrency over 1, ParallelSafe actions are dispatched in
+// their own goroutines, up to concurrency at a time, without a lease
+// (running them again is harmless). The other actions wait for them
+// to finish, so they still run alone and in queue order. All the
+// dispatched actions are done when handleActionQueue returns.
+//
+// Action launches are spaced out by pacer, so a flood of actions
+// doesn't hammer mysql.
+//
+// The expired actions are completed with an Expired result and
+// removed from the queue instead of being dispatched (see
+// expiredAction).
+//
+// Once done is closed, no other action is dispatched, and the reads
+// of the queue return zk.ErrCancelled even if zookeeper hangs.
+func (zkts *Server) handleActionQueue(tabletAlias topo.TabletAlias, dispatchAction func(actionPath, data string) error, decoder topo.ActionNodeDecoder, concurrency int, pacer *dispatchPacer, done <-chan struct{}) (<-chan zookeeper.Event, error) {
 	zkActionPath := TabletActionPathForAlias(tabletAlias)
 
 	// This read may seem a bit pedantic, but it makes it easier
 	// for the system to trend towards consistency if an action
 	// fails or somehow the action queue gets mangled by an errant
 	// process.
-	children, _, watch, err := zkts.zconn.ChildrenW(zkActionPath)
+	children, _, watch, err := zk.ChildrenWCancellable(zkts.zconn, zkActionPath, done)
 	if err != nil {
 		return watch, err
 	}
+	leasedActionPath := zkts.checkActionLease(tabletAlias)
+	parallel := newParallelDispatcher(concurrency)
+	defer parallel.wait()
 	if len(children) > 0 {
-		sort.Strings(children)
+		sort.Sort(actionQueue(children))
+		children = truncateActionQueue(children, *actionQueueMaxDepth, path.Base(leasedActionPath))
 		for _, child := range children {
+			if isClosed(done) {
+				break
+			}
 			actionPath := zkActionPath + "/" + child
-			if _, err := strconv.ParseUint(child, 10, 64); err != nil {
+			if _, _, err := parseActionName(child); err != nil {
 				// This is handy if you want to restart a stuck queue.
 				// FIXME(msolomon) could listen on the queue node for a change
 				// generated by a "touch", but listening on two things is a bit
@@ -80,13 +276,57 @@ func (zkts *Server) handleActionQueue(tabletAlias topo.TabletAlias, dispatchActi
 				continue
 			}
 
-			data, _, err := zkts.zconn.Get(actionPath)
+			data, _, err := zk.GetCancellable(zkts.zconn, actionPath, done)
+			if err == zk.ErrCancelled {
+				break
+			}
 			if err != nil {
 				log.Errorf("cannot read action %v from zk: %v", actionPath, err)
 				break
 			}
 
-			if err := dispatchAction(actionPath, data); err != nil {
+			// The leased action may have started already.
+			if actionPath != leasedActionPath {
+				if header := expiredAction(data, decoder, time.Now()); header != nil {
+					if err := zkts.expireAction(actionPath, data, header, decoder); err != nil {
+						log.Errorf("cannot remove expired action %v: %v", actionPath, err)
+						break
+					}
+					continue
+				}
+			}
+
+			if concurrency > 1 && actionPath != leasedActionPath && actionIsParallelSafe(data, decoder) {
+				pacer.wait()
+				if !parallel.dispatch(actionPath, data, dispatchAction) {
+					break
+				}
+				continue
+			}
+			if err := parallel.wait(); err != nil {
+				break
+			}
+
+			if actionPath == leasedActionPath {
+				leasedActionPath = ""
+				dispatch, err := zkts.recoverLeasedAction(actionPath, data, decoder)
+				if !dispatch {
+					zkts.releaseActionLease(tabletAlias)
+					if err != nil {
+						log.Errorf("stopping the action queue: %v", err)
+						break
+					}
+					continue
+				}
+			}
+			pacer.wait()
+			if err := zkts.acquireActionLease(tabletAlias, actionPath); err != nil {
+				log.Errorf("cannot acquire action lease for %v: %v", actionPath, err)
+				break
+			}
+			err = dispatchAction(actionPath, data)
+			zkts.releaseActionLease(tabletAlias)
+			if err != nil {
 				break
 			}
 		}
@@ -94,16 +334,256 @@ func (zkts *Server) handleActionQueue(tabletAlias topo.TabletAlias, dispatchActi
 	return watch, nil
 }
 
-func (zkts *Server) ActionEventLoop(tabletAlias topo.TabletAlias, dispatchAction func(actionPath, data string) error, done chan struct{}) {
+// expiredAction returns the header of the action in data if it
+// expired, allowing for -action_max_clock_skew, and nil otherwise.
+func expiredAction(data string, decoder topo.ActionNodeDecoder, now time.Time) *topo.ActionHeader {
+	header, err := decoder.DecodeActionHeader(data)
+	if err != nil || !header.Expired(now, *actionMaxClockSkew) {
+		return nil
+	}
+	return header
+}
+
+// actionCompletedPathForAlias returns the path of the node recording
+// the guids of the last completed actions, one per line, next to the
+// action queue.
+func actionCompletedPathForAlias(tabletAlias topo.TabletAlias) string {
+	return path.Join(TabletPathForAlias(tabletAlias), "actioncompleted")
+}
+
+// RecordCompletedAction is part of the topo.Server interface. With
+// -action_queue_verify, it adds actionGuid to the last
+// maxCompletedActions completed ones, see verifyActionQueue.
+func (zkts *Server) RecordCompletedAction(actionPath, actionGuid string) error {
+	if !*actionQueueVerify || actionGuid == "" {
+		return nil
+	}
+	// actionPath is in the action queue, next to the node.
+	completedPath := path.Join(path.Dir(path.Dir(actionPath)), "actioncompleted")
+	return zkts.zconn.RetryChange(completedPath, 0, zkts.acl(), func(oldValue string, oldStat zk.Stat) (string, error) {
+		guids := append(strings.Fields(oldValue), actionGuid)
+		if len(guids) > maxCompletedActions {
+			guids = guids[len(guids)-maxCompletedActions:]
+		}
+		return strings.Join(guids, "\n"), nil
+	})
+}
+
+// verifyActionQueue logs the actions in the queue, and removes the
+// ones that completed successfully without running them again: an
+// agent that crashed after running them, but before they were
+// removed from the queue, left them there. They are the ones
+// RecordCompletedAction recorded, or whose node is Done.
+func (zkts *Server) verifyActionQueue(tabletAlias topo.TabletAlias, decoder topo.ActionNodeDecoder) error {
+	zkActionPath := TabletActionPathForAlias(tabletAlias)
+	children, _, err := zkts.zconn.Children(zkActionPath)
+	if err != nil {
+		return err
+	}
+	completedPath := actionCompletedPathForAlias(tabletAlias)
+	completedData, _, err := zkts.zconn.Get(completedPath)
+	if err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+		return err
+	}
+	completed := make(map[string]bool)
+	for _, guid := range strings.Fields(completedData) {
+		completed[guid] = true
+	}
+
+	sort.Sort(actionQueue(children))
+	log.Infof("verifying action queue %v: %v actions", zkActionPath, len(children))
+	for _, child := range children {
+		actionPath := zkActionPath + "/" + child
+		data, _, err := zkts.zconn.Get(actionPath)
+		if err != nil {
+			if zookeeper.IsError(err, zookeeper.ZNONODE) {
+				continue
+			}
+			return err
+		}
+		log.Infof("queued action %v: %v", actionPath, data)
+		header, err := decoder.DecodeActionHeader(data)
+		if err != nil || !(header.Done || completed[header.ActionGuid]) {
+			continue
+		}
+		log.Warningf("action %v (%v) was already completed, removing it from the queue", actionPath, header.ActionGuid)
+		if err := zkts.UnblockTabletAction(actionPath); err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+			return err
+		}
+	}
+	return nil
+}
+
+// expireAction completes the expired action at actionPath with a
+// failed, Expired result, where its client looks for it, and removes
+// it from the queue.
+func (zkts *Server) expireAction(actionPath, data string, header *topo.ActionHeader, decoder topo.ActionNodeDecoder) error {
+	log.Warningf("action %v expired at %v, removing it from the queue: %v", actionPath, time.Unix(0, header.ExpireTime), header.Action)
+	expired, err := decoder.ExpireActionNode(data, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := zkts.StoreTabletActionResponse(actionPath, expired); err != nil {
+		return err
+	}
+	if err := zkts.UnblockTabletAction(actionPath); err != nil {
+		return err
+	}
+	actionsExpired.Add(1)
+	return nil
+}
+
+// truncateActionQueue returns the first maxDepth actions of the
+// sorted queue children, and logs the ones left for the next pass,
+// which starts when the actions taken are removed from the queue.
+// The leased action, if any, is always kept, so it's recovered
+// before another one takes the lease. A maxDepth of 0 keeps all the
+// actions.
+func truncateActionQueue(children []string, maxDepth int, leasedAction string) []string {
+	if maxDepth <= 0 || len(children) <= maxDepth {
+		return children
+	}
+	log.Warningf("action queue has %v actions, over -action_queue_max_depth, taking the first %v", len(children), maxDepth)
+	actionQueueTruncations.Add(1)
+	taken := make([]string, 0, maxDepth+1)
+	for i, child := range children {
+		if i < maxDepth || child == leasedAction {
+			taken = append(taken, child)
+		}
+	}
+	return taken
+}
+
+// isClosed returns true if done is closed.
+func isClosed(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// dispatchPacer spaces out the action launches by at least interval.
+type dispatchPacer struct {
+	interval time.Duration
+	last     time.Time
+}
+
+// wait returns when the next action can be launched.
+func (dp *dispatchPacer) wait() {
+	if dp.interval <= 0 {
+		return
+	}
+	if delay := dp.last.Add(dp.interval).Sub(time.Now()); delay > 0 {
+		time.Sleep(delay)
+	}
+	dp.last = time.Now()
+}
+
+// actionIsParallelSafe returns true if the action in data can run at
+// the same time as others.
+func actionIsParallelSafe(data string, decoder topo.ActionNodeDecoder) bool {
+	header, err := decoder.DecodeActionHeader(data)
+	if err != nil {
+		return false
+	}
+	return header.ParallelSafe
+}
+
+// parallelDispatcher runs the ParallelSafe actions of the queue, up to
+// a number of them at a time.
+type parallelDispatcher struct {
+	wg     sync.WaitGroup
+	tokens chan struct{}
+
+	mu  sync.Mutex
+	err error // first error returned by dispatchAction
+}
+
+func newParallelDispatcher(concurrency int) *parallelDispatcher {
+	return &parallelDispatcher{tokens: make(chan struct{}, concurrency)}
+}
+
+// dispatch runs dispatchAction in a goroutine, once fewer than
+// concurrency actions are running. It returns false, without running
+// the action, if one of the previous ones failed.
+func (pd *parallelDispatcher) dispatch(actionPath, data string, dispatchAction func(actionPath, data string) error) bool {
+	pd.tokens <- struct{}{}
+	pd.mu.Lock()
+	failed := pd.err != nil
+	pd.mu.Unlock()
+	if failed {
+		<-pd.tokens
+		return false
+	}
+
+	pd.wg.Add(1)
+	go func() {
+		defer func() {
+			<-pd.tokens
+			pd.wg.Done()
+		}()
+		if err := dispatchAction(actionPath, data); err != nil {
+			pd.mu.Lock()
+			if pd.err == nil {
+				pd.err = err
+			}
+			pd.mu.Unlock()
+		}
+	}()
+	return true
+}
+
+// wait waits for the running actions, and returns the first error
+// any dispatched action returned.
+func (pd *parallelDispatcher) wait() error {
+	pd.wg.Wait()
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	return pd.err
+}
+
+// opaqueActionNodes is the topo.ActionNodeDecoder of the queues whose
+// action nodes can't be decoded.
+type opaqueActionNodes struct{}
+
+var errOpaqueActionNode = errors.New("action nodes cannot be decoded")
+
+func (opaqueActionNodes) DecodeActionHeader(data string) (*topo.ActionHeader, error) {
+	return nil, errOpaqueActionNode
+}
+
+func (opaqueActionNodes) ExpireActionNode(data string, now time.Time) (string, error) {
+	return "", errOpaqueActionNode
+}
+
+func (zkts *Server) ActionEventLoop(tabletAlias topo.TabletAlias, dispatchAction func(actionPath, data string) error, decoder topo.ActionNodeDecoder, concurrency int, minInterval time.Duration, done chan struct{}) {
+	if decoder == nil {
+		decoder = opaqueActionNodes{}
+	}
+	backoff := newRetryBackoff()
+	pacer := &dispatchPacer{interval: minInterval}
+	if *actionQueueVerify {
+		if err := zkts.verifyActionQueue(tabletAlias, decoder); err != nil {
+			log.Warningf("cannot verify the action queue: %v", err)
+		}
+	}
 	for {
 		// Process any pending actions when we startup, before
 		// we start listening for events.
-		watch, err := zkts.handleActionQueue(tabletAlias, dispatchAction)
+		watch, err := zkts.handleActionQueue(tabletAlias, dispatchAction, decoder, concurrency, pacer, done)
+		if err == zk.ErrCancelled {
+			return
+		}
 		if err != nil {
-			log.Warningf("failed to set the watch on action queue, will try again in 5 seconds: %v", err)
-			time.Sleep(5 * time.Second)
+			log.Warningf("failed to set the watch on action queue, will try again: %v", err)
+			if !backoff.wait(done) {
+				return
+			}
 			continue
 		}
+		backoff.reset()
 
 		select {
 		case event := <-watch:
@@ -111,8 +591,10 @@ func (zkts *Server) ActionEventLoop(tabletAlias topo.TabletAlias, dispatchAction
 				// NOTE(msolomon) The zk meta conn will
 				// reconnect automatically, or error out.
 				// At this point, there isn't much to do.
-				log.Warningf("zookeeper not OK: %v, will try again in 5 seconds", event)
-				time.Sleep(5 * time.Second)
+				log.Warningf("zookeeper not OK: %v, will try again", event)
+				if !backoff.wait(done) {
+					return
+				}
 			}
 			// Otherwise, just handle the queue above.
 		case <-done:
@@ -121,11 +603,66 @@ func (zkts *Server) ActionEventLoop(tabletAlias topo.TabletAlias, dispatchAction
 	}
 }
 
+// actionCancelPathForAlias returns the path of the node
+// CancelTabletAction writes the guid of the action to cancel to,
+// next to the action queue.
+func actionCancelPathForAlias(tabletAlias topo.TabletAlias) string {
+	return path.Join(TabletPathForAlias(tabletAlias), "cancel")
+}
+
+func (zkts *Server) CancelTabletAction(tabletAlias topo.TabletAlias, actionGuid string) error {
+	_, err := zk.CreateOrUpdate(zkts.zconn, actionCancelPathForAlias(tabletAlias), actionGuid, 0, zkts.acl(), false)
+	return err
+}
+
+// ActionCancelLoop watches the cancel node. Every guid written to
+// it is passed to cancelAction, and the node removed.
+func (zkts *Server) ActionCancelLoop(tabletAlias topo.TabletAlias, cancelAction func(actionGuid string), done chan struct{}) {
+	cancelPath := actionCancelPathForAlias(tabletAlias)
+	backoff := newRetryBackoff()
+	for {
+		actionGuid, _, watch, err := zkts.zconn.GetW(cancelPath)
+		if err == nil {
+			if err := zkts.zconn.Delete(cancelPath, -1); err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+				log.Warningf("cannot remove cancel node %v: %v", cancelPath, err)
+			}
+			if actionGuid != "" {
+				cancelAction(actionGuid)
+			}
+			continue
+		}
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			var stat zk.Stat
+			stat, watch, err = zkts.zconn.ExistsW(cancelPath)
+			if err == nil && stat != nil {
+				// created in the meantime
+				continue
+			}
+		}
+		if err != nil {
+			log.Warningf("failed to set the watch on %v, will try again: %v", cancelPath, err)
+			if !backoff.wait(done) {
+				return
+			}
+			continue
+		}
+		backoff.reset()
+
+		select {
+		case <-watch:
+		case <-done:
+			return
+		}
+	}
+}
+
 // actionPathToTabletAlias parses an actionPath back
 // zkActionPath is /zk/<cell>/vt/tablets/<uid>/action/<number>
+// Finished actions in .../actionlog/<number> are accepted too,
+// so they can be replayed by vtaction -dry-run.
 func actionPathToTabletAlias(actionPath string) (topo.TabletAlias, error) {
 	pathParts := strings.Split(actionPath, "/")
-	if len(pathParts) != 8 || pathParts[0] != "" || pathParts[1] != "zk" || pathParts[3] != "vt" || pathParts[4] != "tablets" || pathParts[6] != "action" {
+	if len(pathParts) != 8 || pathParts[0] != "" || pathParts[1] != "zk" || pathParts[3] != "vt" || pathParts[4] != "tablets" || (pathParts[6] != "action" && pathParts[6] != "actionlog") {
 		return topo.TabletAlias{}, fmt.Errorf("invalid action path: %v", actionPath)
 	}
 	return topo.ParseTabletAliasString(pathParts[2] + "-" + pathParts[5])
@@ -139,6 +676,9 @@ func (zkts *Server) ReadTabletActionPath(actionPath string) (topo.TabletAlias, s
 
 	data, stat, err := zkts.zconn.Get(actionPath)
 	if err != nil {
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			err = topo.ErrNoNode
+		}
 		return topo.TabletAlias{}, "", 0, err
 	}
 
@@ -164,7 +704,18 @@ func (zkts *Server) StoreTabletActionResponse(actionPath, data string) error {
 	}
 
 	actionLogPath := strings.Replace(actionPath, "/action/", "/actionlog/", 1)
-	_, err = zk.CreateRecursive(zkts.zconn, actionLogPath, data, 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	_, err = zk.CreateRecursive(zkts.zconn, actionLogPath, data, 0, zkts.acl())
+	return err
+}
+
+// StoreTabletActionResult updates the actionlog copy of the action:
+// the action node itself is gone once the action is complete.
+func (zkts *Server) StoreTabletActionResult(actionPath, data string) error {
+	actionLogPath := strings.Replace(actionPath, "/action/", "/actionlog/", 1)
+	_, err := zkts.zconn.Set(actionLogPath, data, -1)
+	if zookeeper.IsError(err, zookeeper.ZNONODE) {
+		err = topo.ErrNoNode
+	}
 	return err
 }
 
@@ -39,7 +39,7 @@ func (zkts *Server) CreateKeyspace(keyspace string, value *topo.Keyspace) error
 		if i == 0 {
 			c = jscfg.ToJson(value)
 		}
-		_, err := zk.CreateRecursive(zkts.zconn, zkPath, c, 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+		_, err := zk.CreateRecursive(zkts.zconn, zkPath, c, 0, zkts.acl())
 		if err != nil {
 			if zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
 				alreadyExists = true
@@ -63,7 +63,7 @@ func (zkts *Server) UpdateKeyspace(ki *topo.KeyspaceInfo) error {
 			//   err = topo.ErrNoNode
 			// Temporary code until we have Keyspace object
 			// everywhere:
-			_, err = zkts.zconn.Create(keyspacePath, jscfg.ToJson(ki.Keyspace), 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+			_, err = zkts.zconn.Create(keyspacePath, jscfg.ToJson(ki.Keyspace), 0, zkts.acl())
 			if err != nil {
 				if zookeeper.IsError(err, zookeeper.ZNONODE) {
 					// the directory doesn't even exist
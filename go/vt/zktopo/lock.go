@@ -24,7 +24,7 @@ This file contains the lock management code for zktopo.Server
 // queue lock, displays a nice error message if it cant get it
 func (zkts *Server) lockForAction(actionDir, contents string, timeout time.Duration, interrupted chan struct{}) (string, error) {
 	// create the action path
-	actionPath, err := zkts.zconn.Create(actionDir, contents, zookeeper.SEQUENCE, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	actionPath, err := zkts.zconn.Create(actionDir, contents, zookeeper.SEQUENCE, zkts.acl())
 	if err != nil {
 		return "", err
 	}
@@ -75,7 +75,7 @@ func (zkts *Server) lockForAction(actionDir, contents string, timeout time.Durat
 func (zkts *Server) unlockForAction(lockPath, results string) error {
 	// Write the data to the actionlog
 	actionLogPath := strings.Replace(lockPath, "/action/", "/actionlog/", 1)
-	if _, err := zk.CreateRecursive(zkts.zconn, actionLogPath, results, 0, zookeeper.WorldACL(zookeeper.PERM_ALL)); err != nil {
+	if _, err := zk.CreateRecursive(zkts.zconn, actionLogPath, results, 0, zkts.acl()); err != nil {
 		log.Warningf("Cannot create actionlog path %v (check the permissions with 'zk stat'), will keep the lock, use 'zk rm' to clear the lock", actionLogPath)
 		return err
 	}
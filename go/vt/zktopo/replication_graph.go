@@ -26,7 +26,7 @@ func shardReplicationPath(cell, keyspace, shard string) string {
 func (zkts *Server) CreateShardReplication(cell, keyspace, shard string, sr *topo.ShardReplication) error {
 	data := jscfg.ToJson(sr)
 	zkPath := shardReplicationPath(cell, keyspace, shard)
-	_, err := zk.CreateRecursive(zkts.zconn, zkPath, data, 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	_, err := zk.CreateRecursive(zkts.zconn, zkPath, data, 0, zkts.acl())
 	if err != nil {
 		if zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
 			err = topo.ErrNodeExists
@@ -51,7 +51,7 @@ func (zkts *Server) UpdateShardReplicationFields(cell, keyspace, shard string, u
 		}
 		return jscfg.ToJson(sr), nil
 	}
-	err := zkts.zconn.RetryChange(zkPath, 0, zookeeper.WorldACL(zookeeper.PERM_ALL), f)
+	err := zkts.zconn.RetryChange(zkPath, 0, zkts.acl(), f)
 	if err != nil {
 		if zookeeper.IsError(err, zookeeper.ZNONODE) {
 			err = topo.ErrNoNode
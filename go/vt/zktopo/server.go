@@ -5,20 +5,44 @@
 package zktopo
 
 import (
+	"flag"
 	"fmt"
 	"path"
 	"sort"
+	"sync"
 	"time"
 
+	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/zk"
 	"launchpad.net/gozk/zookeeper"
 )
 
+var zkACL = flag.String("zk_acl", "world:anyone:rwdca", "ACL of the nodes created in the topology, as comma-separated scheme:id:perms entries, perms being some of rwdca")
+
 // Server is the zookeeper topo.Server implementation.
 type Server struct {
 	zconn zk.Conn
+
+	aclOnce sync.Once
+	aclv    []zookeeper.ACL // nil until acl is first called
+}
+
+// acl returns the ACL nodes are created with, from -zk_acl unless
+// aclv was set already.
+func (zkts *Server) acl() []zookeeper.ACL {
+	zkts.aclOnce.Do(func() {
+		if zkts.aclv != nil {
+			return
+		}
+		aclv, err := zk.ParseACL(*zkACL)
+		if err != nil {
+			log.Fatalf("invalid -zk_acl: %v", err)
+		}
+		zkts.aclv = aclv
+	})
+	return zkts.aclv
 }
 
 func (zkts *Server) Close() {
@@ -67,7 +91,7 @@ func (zkts *Server) PurgeActions(zkActionPath string, canBePurged func(data stri
 		return err
 	}
 
-	sort.Strings(children)
+	sort.Sort(actionsBySequence(children))
 	// Purge newer items first so the action queues don't try to process something.
 	for i := len(children) - 1; i >= 0; i-- {
 		actionPath := path.Join(zkActionPath, children[i])
@@ -101,7 +125,7 @@ func (zkts *Server) StaleActions(zkActionPath string, maxStaleness time.Duration
 
 	staleActions := make([]string, 0, 16)
 	// Purge newer items first so the action queues don't try to process something.
-	sort.Strings(children)
+	sort.Sort(actionsBySequence(children))
 	for i := 0; i < len(children); i++ {
 		actionPath := path.Join(zkActionPath, children[i])
 		data, stat, err := zkts.zconn.Get(actionPath)
@@ -133,7 +157,7 @@ func (zkts *Server) PruneActionLogs(zkActionLogPath string, keepCount int) (prun
 	if err != nil {
 		return 0, err
 	}
-	sort.Strings(children)
+	sort.Sort(actionsBySequence(children))
 
 	// see if nothing to do
 	if len(children) <= keepCount {
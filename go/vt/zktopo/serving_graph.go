@@ -10,6 +10,7 @@ import (
 	"path"
 	"sort"
 
+	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/jscfg"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/zk"
@@ -54,7 +55,7 @@ func (zkts *Server) GetSrvTabletTypesPerShard(cell, keyspace, shard string) ([]t
 func (zkts *Server) UpdateEndPoints(cell, keyspace, shard string, tabletType topo.TabletType, addrs *topo.EndPoints) error {
 	path := zkPathForVtName(cell, keyspace, shard, tabletType)
 	data := jscfg.ToJson(addrs)
-	_, err := zk.CreateRecursive(zkts.zconn, path, data, 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	_, err := zk.CreateRecursive(zkts.zconn, path, data, 0, zkts.acl())
 	if err != nil {
 		if zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
 			// Node already exists - just stomp away. Multiple writers shouldn't be here.
@@ -62,7 +63,7 @@ func (zkts *Server) UpdateEndPoints(cell, keyspace, shard string, tabletType top
 			f := func(oldValue string, oldStat zk.Stat) (string, error) {
 				return data, nil
 			}
-			err = zkts.zconn.RetryChange(path, 0, zookeeper.WorldACL(zookeeper.PERM_ALL), f)
+			err = zkts.zconn.RetryChange(path, 0, zkts.acl(), f)
 		}
 	}
 	return err
@@ -77,6 +78,10 @@ func (zkts *Server) GetEndPoints(cell, keyspace, shard string, tabletType topo.T
 		}
 		return nil, err
 	}
+	return unmarshalEndPoints(data)
+}
+
+func unmarshalEndPoints(data string) (*topo.EndPoints, error) {
 	result := &topo.EndPoints{}
 	if len(data) > 0 {
 		if err := json.Unmarshal([]byte(data), result); err != nil {
@@ -86,6 +91,65 @@ func (zkts *Server) GetEndPoints(cell, keyspace, shard string, tabletType topo.T
 	return result, nil
 }
 
+// getEndPointsW returns the end points at path, and a watch on them.
+func (zkts *Server) getEndPointsW(path string) (*topo.EndPoints, <-chan zookeeper.Event, error) {
+	data, _, watch, err := zkts.zconn.GetW(path)
+	if err != nil {
+		if zookeeper.IsError(err, zookeeper.ZNONODE) {
+			err = topo.ErrNoNode
+		}
+		return nil, nil, err
+	}
+	result, err := unmarshalEndPoints(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, watch, nil
+}
+
+// WatchEndPoints sends the end points on the returned channel, then
+// the new ones every time the node changes. The channel is closed when
+// the node is deleted, the watch fails, or stop is closed. It returns
+// topo.ErrWatchNotSupported over zkocc, which can't watch.
+// It's part of the vtgate.EndPointsWatcher interface.
+func (zkts *Server) WatchEndPoints(cell, keyspace, shard string, tabletType topo.TabletType, stop <-chan struct{}) (<-chan *topo.EndPoints, error) {
+	if !zk.CanWatch(zkts.zconn) {
+		return nil, topo.ErrWatchNotSupported
+	}
+	path := zkPathForVtName(cell, keyspace, shard, tabletType)
+	endPoints, watch, err := zkts.getEndPointsW(path)
+	if err != nil {
+		return nil, err
+	}
+	updates := make(chan *topo.EndPoints, 1)
+	updates <- endPoints
+	go func() {
+		defer close(updates)
+		for {
+			select {
+			case event := <-watch:
+				if !event.Ok() {
+					log.Warningf("watch of %v failed: %v", path, event)
+					return
+				}
+			case <-stop:
+				return
+			}
+			endPoints, watch, err = zkts.getEndPointsW(path)
+			if err != nil {
+				log.Warningf("watch of %v stopped: %v", path, err)
+				return
+			}
+			select {
+			case updates <- endPoints:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return updates, nil
+}
+
 func (zkts *Server) DeleteSrvTabletType(cell, keyspace, shard string, tabletType topo.TabletType) error {
 	path := zkPathForVtName(cell, keyspace, shard, tabletType)
 	err := zkts.zconn.Delete(path, -1)
@@ -218,7 +282,40 @@ func (zkts *Server) UpdateTabletEndpoint(cell, keyspace, shard string, tabletTyp
 	f := func(oldValue string, oldStat zk.Stat) (string, error) {
 		return zkts.updateTabletEndpoint(oldValue, oldStat, addr)
 	}
-	err := zkts.zconn.RetryChange(path, 0, zookeeper.WorldACL(zookeeper.PERM_ALL), f)
+	err := zkts.zconn.RetryChange(path, 0, zkts.acl(), f)
+	if err == skipUpdateErr || zookeeper.IsError(err, zookeeper.ZNONODE) {
+		err = nil
+	}
+	return err
+}
+
+func removeTabletEndpoint(oldValue string, oldStat zk.Stat, uid uint32) (newValue string, err error) {
+	if oldStat == nil || oldValue == "" {
+		return "", skipUpdateErr
+	}
+	addrs := &topo.EndPoints{}
+	if err := json.Unmarshal([]byte(oldValue), addrs); err != nil {
+		return "", fmt.Errorf("EndPoints unmarshal failed: %v %v", oldValue, err)
+	}
+	entries := make([]topo.EndPoint, 0, len(addrs.Entries))
+	for _, entry := range addrs.Entries {
+		if entry.Uid != uid {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) == len(addrs.Entries) {
+		return "", skipUpdateErr
+	}
+	addrs.Entries = entries
+	return jscfg.ToJson(addrs), nil
+}
+
+func (zkts *Server) RemoveTabletEndpoint(cell, keyspace, shard string, tabletType topo.TabletType, uid uint32) error {
+	path := zkPathForVtName(cell, keyspace, shard, tabletType)
+	f := func(oldValue string, oldStat zk.Stat) (string, error) {
+		return removeTabletEndpoint(oldValue, oldStat, uid)
+	}
+	err := zkts.zconn.RetryChange(path, 0, zkts.acl(), f)
 	if err == skipUpdateErr || zookeeper.IsError(err, zookeeper.ZNONODE) {
 		err = nil
 	}
@@ -34,7 +34,7 @@ func (zkts *Server) CreateShard(keyspace, shard string, value *topo.Shard) error
 		if i == 0 {
 			c = jscfg.ToJson(value)
 		}
-		_, err := zk.CreateRecursive(zkts.zconn, zkPath, c, 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+		_, err := zk.CreateRecursive(zkts.zconn, zkPath, c, 0, zkts.acl())
 		if err != nil {
 			if zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
 				alreadyExists = true
@@ -53,7 +53,7 @@ func (zkts *Server) CreateTablet(tablet *topo.Tablet) error {
 	zkTabletPath := TabletPathForAlias(tablet.Alias)
 
 	// Create /zk/<cell>/vt/tablets/<uid>
-	_, err := zk.CreateRecursive(zkts.zconn, zkTabletPath, tablet.Json(), 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	_, err := zk.CreateRecursive(zkts.zconn, zkTabletPath, tablet.Json(), 0, zkts.acl())
 	if err != nil {
 		if zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
 			err = topo.ErrNodeExists
@@ -63,14 +63,14 @@ func (zkts *Server) CreateTablet(tablet *topo.Tablet) error {
 
 	// Create /zk/<cell>/vt/tablets/<uid>/action
 	tap := path.Join(zkTabletPath, "action")
-	_, err = zkts.zconn.Create(tap, "", 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	_, err = zkts.zconn.Create(tap, "", 0, zkts.acl())
 	if err != nil {
 		return err
 	}
 
 	// Create /zk/<cell>/vt/tablets/<uid>/actionlog
 	talp := path.Join(zkTabletPath, "actionlog")
-	_, err = zkts.zconn.Create(talp, "", 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+	_, err = zkts.zconn.Create(talp, "", 0, zkts.acl())
 	if err != nil {
 		return err
 	}
@@ -109,7 +109,7 @@ func (zkts *Server) UpdateTabletFields(tabletAlias topo.TabletAlias, update func
 		}
 		return jscfg.ToJson(tablet), nil
 	}
-	err := zkts.zconn.RetryChange(zkTabletPath, 0, zookeeper.WorldACL(zookeeper.PERM_ALL), f)
+	err := zkts.zconn.RetryChange(zkTabletPath, 0, zkts.acl(), f)
 	if err != nil {
 		if zookeeper.IsError(err, zookeeper.ZNONODE) {
 			err = topo.ErrNoNode
@@ -1,9 +1,19 @@
 package zktopo
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/topo/test"
+	"github.com/youtube/vitess/go/zk"
+	"launchpad.net/gozk/zookeeper"
 )
 
 func TestKeyspace(t *testing.T) {
@@ -31,6 +41,15 @@ func TestServingGraph(t *testing.T) {
 	test.CheckServingGraph(t, ts)
 }
 
+func TestWatchEndPointsZkocc(t *testing.T) {
+	for _, zconn := range []zk.Conn{&zk.ZkoccConn{}, zk.NewMetaConn(true)} {
+		zkts := NewServer(zconn)
+		if _, err := zkts.WatchEndPoints("test", "test_keyspace", "0", topo.TYPE_REPLICA, nil); err != topo.ErrWatchNotSupported {
+			t.Errorf("WatchEndPoints over %T: want %v, got %v", zconn, topo.ErrWatchNotSupported, err)
+		}
+	}
+}
+
 func TestKeyspaceLock(t *testing.T) {
 	ts := NewTestServer(t, []string{"test"})
 	test.CheckKeyspaceLock(t, ts)
@@ -50,3 +69,564 @@ func TestActions(t *testing.T) {
 	ts := NewTestServer(t, []string{"test"})
 	test.CheckActions(t, ts)
 }
+
+// pingAction returns the json for a Ping action in the given state.
+func pingAction(state actionnode.ActionState) string {
+	return (&actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING, State: state}).ToJson()
+}
+
+func TestActionLease(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	zkts := ts.(TestServer).Server.(*Server)
+	tabletAlias := topo.TabletAlias{Cell: "test", Uid: 1}
+	if err := ts.CreateTablet(&topo.Tablet{Alias: tabletAlias, Hostname: "localhost", Keyspace: "test_keyspace"}); err != nil {
+		t.Fatalf("CreateTablet: %v", err)
+	}
+	if err := ts.ValidateTabletActions(tabletAlias); err != nil {
+		t.Fatalf("ValidateTabletActions: %v", err)
+	}
+
+	// crash simulates an agent dying while dispatching the first
+	// action, after vtaction left it in the given state.
+	crash := func(state actionnode.ActionState) string {
+		actionPath, err := ts.WriteTabletAction(tabletAlias, pingAction(""))
+		if err != nil {
+			t.Fatalf("WriteTabletAction: %v", err)
+		}
+		func() {
+			defer func() {
+				recover()
+			}()
+			zkts.handleActionQueue(tabletAlias, func(ap, data string) error {
+				if err := ts.StoreTabletActionResponse(ap, pingAction(state)); err != nil {
+					t.Errorf("StoreTabletActionResponse: %v", err)
+				}
+				panic("agent crashed")
+			}, actionnode.QueueDecoder{}, 1, &dispatchPacer{}, nil)
+		}()
+		if got := zkts.checkActionLease(tabletAlias); got != actionPath {
+			t.Errorf("want lease on %v, got %v", actionPath, got)
+		}
+		return actionPath
+	}
+
+	// restart runs the queue once more, and returns what was dispatched.
+	restart := func() []string {
+		var dispatched []string
+		if _, err := zkts.handleActionQueue(tabletAlias, func(ap, data string) error {
+			dispatched = append(dispatched, ap)
+			return ts.UnblockTabletAction(ap)
+		}, actionnode.QueueDecoder{}, 1, &dispatchPacer{}, nil); err != nil {
+			t.Fatalf("handleActionQueue: %v", err)
+		}
+		return dispatched
+	}
+
+	// a finished action is removed from the queue without
+	// being dispatched again, and the queue keeps going
+	crash(actionnode.ACTION_STATE_DONE)
+	nextPath, err := ts.WriteTabletAction(tabletAlias, pingAction(""))
+	if err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	if dispatched := restart(); len(dispatched) != 1 || dispatched[0] != nextPath {
+		t.Errorf("want [%v], got %v", nextPath, dispatched)
+	}
+	if got := zkts.checkActionLease(tabletAlias); got != "" {
+		t.Errorf("want no lease, got %v", got)
+	}
+
+	// a failed action stops the queue, and stays in it
+	failedPath := crash(actionnode.ACTION_STATE_FAILED)
+	if _, err := ts.WriteTabletAction(tabletAlias, pingAction("")); err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	if dispatched := restart(); len(dispatched) != 0 {
+		t.Errorf("want nothing dispatched, got %v", dispatched)
+	}
+	if _, _, err := zkts.zconn.Get(failedPath); err != nil {
+		t.Errorf("failed action was removed from the queue: %v", err)
+	}
+	if got := zkts.checkActionLease(tabletAlias); got != "" {
+		t.Errorf("want no lease, got %v", got)
+	}
+
+	// without a lease, it is dispatched as usual, so vtaction
+	// can report the failure
+	if dispatched := restart(); len(dispatched) != 2 || dispatched[0] != failedPath {
+		t.Errorf("want %v dispatched first, got %v", failedPath, dispatched)
+	}
+
+	// a running action is dispatched again, vtaction will
+	// check on the previous process
+	runningPath := crash(actionnode.ACTION_STATE_RUNNING)
+	if dispatched := restart(); len(dispatched) != 1 || dispatched[0] != runningPath {
+		t.Errorf("want [%v], got %v", runningPath, dispatched)
+	}
+
+	// a lease for an action that was completed is just removed
+	if err := zkts.acquireActionLease(tabletAlias, runningPath); err != nil {
+		t.Fatalf("acquireActionLease: %v", err)
+	}
+	if got := zkts.checkActionLease(tabletAlias); got != "" {
+		t.Errorf("want no lease, got %v", got)
+	}
+	if _, _, err := zkts.zconn.Get(actionLeasePathForAlias(tabletAlias)); err == nil {
+		t.Errorf("lease node was not removed")
+	}
+}
+
+func TestReadActionLogPath(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	tabletAlias := topo.TabletAlias{Cell: "test", Uid: 1}
+	if err := ts.CreateTablet(&topo.Tablet{Alias: tabletAlias, Hostname: "localhost", Keyspace: "test_keyspace"}); err != nil {
+		t.Fatalf("CreateTablet: %v", err)
+	}
+	actionPath, err := ts.WriteTabletAction(tabletAlias, pingAction(""))
+	if err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	done := pingAction(actionnode.ACTION_STATE_FAILED)
+	if err := ts.StoreTabletActionResponse(actionPath, done); err != nil {
+		t.Fatalf("StoreTabletActionResponse: %v", err)
+	}
+	if err := ts.UnblockTabletAction(actionPath); err != nil {
+		t.Fatalf("UnblockTabletAction: %v", err)
+	}
+
+	// the finished action can still be read from the actionlog
+	actionLogPath := strings.Replace(actionPath, "/action/", "/actionlog/", 1)
+	gotAlias, data, _, err := ts.ReadTabletActionPath(actionLogPath)
+	if err != nil {
+		t.Fatalf("ReadTabletActionPath: %v", err)
+	}
+	if gotAlias != tabletAlias || data != done {
+		t.Errorf("want %v %v, got %v %v", tabletAlias, done, gotAlias, data)
+	}
+}
+
+func TestActionPriorities(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	zkts := ts.(TestServer).Server.(*Server)
+	tabletAlias := topo.TabletAlias{Cell: "test", Uid: 1}
+	if err := ts.CreateTablet(&topo.Tablet{Alias: tabletAlias, Hostname: "localhost", Keyspace: "test_keyspace"}); err != nil {
+		t.Fatalf("CreateTablet: %v", err)
+	}
+	if err := ts.ValidateTabletActions(tabletAlias); err != nil {
+		t.Fatalf("ValidateTabletActions: %v", err)
+	}
+
+	// queue actions out of order, mixing prefixed and unprefixed ones
+	for _, action := range []struct {
+		contents string
+		priority int
+	}{
+		{"default1", -1},
+		{"lowest", topo.ACTION_PRIORITY_LOWEST},
+		{"highest", topo.ACTION_PRIORITY_HIGHEST},
+		{"default2", topo.ACTION_PRIORITY_DEFAULT},
+		{"default3", -1},
+		{"high", 2},
+	} {
+		var err error
+		if action.priority == -1 {
+			_, err = ts.WriteTabletAction(tabletAlias, action.contents)
+		} else {
+			_, err = zkts.WriteTabletActionWithPriority(tabletAlias, action.contents, action.priority)
+		}
+		if err != nil {
+			t.Fatalf("cannot write action %v: %v", action.contents, err)
+		}
+	}
+	if _, err := zkts.WriteTabletActionWithPriority(tabletAlias, "invalid", 10); err == nil {
+		t.Errorf("want error for invalid priority")
+	}
+
+	var dispatched []string
+	if _, err := zkts.handleActionQueue(tabletAlias, func(actionPath, data string) error {
+		dispatched = append(dispatched, data)
+		return ts.UnblockTabletAction(actionPath)
+	}, actionnode.QueueDecoder{}, 1, &dispatchPacer{}, nil); err != nil {
+		t.Fatalf("handleActionQueue: %v", err)
+	}
+	want := []string{"highest", "high", "default1", "default2", "default3", "lowest"}
+	if !reflect.DeepEqual(dispatched, want) {
+		t.Errorf("want %v, got %v", want, dispatched)
+	}
+}
+
+func TestTruncateActionQueue(t *testing.T) {
+	children := []string{"a", "b", "c", "d"}
+	for _, c := range []struct {
+		maxDepth int
+		leased   string
+		want     []string
+	}{
+		{0, "", []string{"a", "b", "c", "d"}},
+		{4, "", []string{"a", "b", "c", "d"}},
+		{2, "", []string{"a", "b"}},
+		{2, "b", []string{"a", "b"}},
+		{2, "c", []string{"a", "b", "c"}},
+	} {
+		if got := truncateActionQueue(children, c.maxDepth, c.leased); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("truncateActionQueue(%v, %v, %q) = %v, want %v", children, c.maxDepth, c.leased, got, c.want)
+		}
+	}
+}
+
+func TestParseActionName(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		priority int
+		sequence uint64
+		valid    bool
+	}{
+		{"0000000012", topo.ACTION_PRIORITY_DEFAULT, 12, true},
+		{"P0-0000000013", 0, 13, true},
+		{"P9-0000000001", 9, 1, true},
+		{"P-0000000001", 0, 0, false},
+		{"PA-0000000001", 0, 0, false},
+		{"touch", 0, 0, false},
+	} {
+		priority, sequence, err := parseActionName(c.name)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("parseActionName(%v): want error", c.name)
+			}
+			continue
+		}
+		if err != nil || priority != c.priority || sequence != c.sequence {
+			t.Errorf("parseActionName(%v): want %v %v, got %v %v %v", c.name, c.priority, c.sequence, priority, sequence, err)
+		}
+	}
+}
+
+func TestActionCancelLoop(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	tabletAlias := topo.TabletAlias{Cell: "test", Uid: 1}
+	if err := ts.CreateTablet(&topo.Tablet{Alias: tabletAlias, Hostname: "localhost", Keyspace: "test_keyspace"}); err != nil {
+		t.Fatalf("CreateTablet: %v", err)
+	}
+
+	// A cancel written before the loop starts is picked up too.
+	if err := ts.CancelTabletAction(tabletAlias, "guid1"); err != nil {
+		t.Fatalf("CancelTabletAction: %v", err)
+	}
+	cancelled := make(chan string, 10)
+	done := make(chan struct{})
+	go ts.ActionCancelLoop(tabletAlias, func(actionGuid string) {
+		cancelled <- actionGuid
+	}, done)
+	defer close(done)
+
+	for _, guid := range []string{"guid1", "guid2"} {
+		if guid != "guid1" {
+			if err := ts.CancelTabletAction(tabletAlias, guid); err != nil {
+				t.Fatalf("CancelTabletAction: %v", err)
+			}
+		}
+		select {
+		case got := <-cancelled:
+			if got != guid {
+				t.Errorf("cancelled %v, want %v", got, guid)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v was not cancelled", guid)
+		}
+	}
+}
+
+func TestParallelActions(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	zkts := ts.(TestServer).Server.(*Server)
+	tabletAlias := topo.TabletAlias{Cell: "test", Uid: 1}
+	if err := ts.CreateTablet(&topo.Tablet{Alias: tabletAlias, Hostname: "localhost", Keyspace: "test_keyspace"}); err != nil {
+		t.Fatalf("CreateTablet: %v", err)
+	}
+	if err := ts.ValidateTabletActions(tabletAlias); err != nil {
+		t.Fatalf("ValidateTabletActions: %v", err)
+	}
+
+	// three parallel pings, a serial one, and two more parallel ones
+	parallelPing := (&actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING, ParallelSafe: true}).ToJson()
+	var serialPath string
+	for i := 0; i < 6; i++ {
+		data := parallelPing
+		if i == 3 {
+			data = pingAction("")
+		}
+		actionPath, err := ts.WriteTabletAction(tabletAlias, data)
+		if err != nil {
+			t.Fatalf("WriteTabletAction: %v", err)
+		}
+		if i == 3 {
+			serialPath = actionPath
+		}
+	}
+
+	var mu sync.Mutex
+	running, maxRunning, dispatched := 0, 0, 0
+	if _, err := zkts.handleActionQueue(tabletAlias, func(actionPath, data string) error {
+		mu.Lock()
+		running++
+		dispatched++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		if actionPath == serialPath && running != 1 {
+			t.Errorf("serial action ran with %v others", running-1)
+		}
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return ts.UnblockTabletAction(actionPath)
+	}, actionnode.QueueDecoder{}, 2, &dispatchPacer{}, nil); err != nil {
+		t.Fatalf("handleActionQueue: %v", err)
+	}
+	if dispatched != 6 || running != 0 {
+		t.Errorf("handleActionQueue returned with %v dispatched, %v running", dispatched, running)
+	}
+	if maxRunning != 2 {
+		t.Errorf("want 2 actions running at the same time, got %v", maxRunning)
+	}
+	if got := zkts.checkActionLease(tabletAlias); got != "" {
+		t.Errorf("want no lease, got %v", got)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	rb := &retryBackoff{min: time.Second, max: 10 * time.Second}
+	for i, want := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		want *= time.Second
+		got := rb.next()
+		if rb.current != want {
+			t.Errorf("attempt %v: backoff = %v, want %v", i, rb.current, want)
+		}
+		if got < want/2 || got > want {
+			t.Errorf("attempt %v: delay %v not in [%v, %v]", i, got, want/2, want)
+		}
+	}
+	rb.reset()
+	if got := rb.next(); got > time.Second {
+		t.Errorf("delay after reset = %v, want <= 1s", got)
+	}
+
+	done := make(chan struct{})
+	close(done)
+	if (&retryBackoff{min: time.Hour, max: time.Hour}).wait(done) {
+		t.Errorf("wait returned true after done was closed")
+	}
+}
+
+func TestPacedActions(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	zkts := ts.(TestServer).Server.(*Server)
+	tabletAlias := topo.TabletAlias{Cell: "test", Uid: 1}
+	if err := ts.CreateTablet(&topo.Tablet{Alias: tabletAlias, Hostname: "localhost", Keyspace: "test_keyspace"}); err != nil {
+		t.Fatalf("CreateTablet: %v", err)
+	}
+	if err := ts.ValidateTabletActions(tabletAlias); err != nil {
+		t.Fatalf("ValidateTabletActions: %v", err)
+	}
+	var want []string
+	for i := 0; i < 3; i++ {
+		actionPath, err := ts.WriteTabletAction(tabletAlias, pingAction(""))
+		if err != nil {
+			t.Fatalf("WriteTabletAction: %v", err)
+		}
+		want = append(want, actionPath)
+	}
+
+	interval := 50 * time.Millisecond
+	var dispatched []string
+	var launches []time.Time
+	if _, err := zkts.handleActionQueue(tabletAlias, func(actionPath, data string) error {
+		dispatched = append(dispatched, actionPath)
+		launches = append(launches, time.Now())
+		return ts.UnblockTabletAction(actionPath)
+	}, actionnode.QueueDecoder{}, 1, &dispatchPacer{interval: interval}, nil); err != nil {
+		t.Fatalf("handleActionQueue: %v", err)
+	}
+	if !reflect.DeepEqual(dispatched, want) {
+		t.Errorf("want %v, got %v", want, dispatched)
+	}
+	for i := 1; i < len(launches); i++ {
+		if d := launches[i].Sub(launches[i-1]); d < interval {
+			t.Errorf("actions %v and %v launched %v apart, want at least %v", i-1, i, d, interval)
+		}
+	}
+}
+
+func TestExpiredActions(t *testing.T) {
+	ts := NewTestServer(t, []string{"test"})
+	zkts := ts.(TestServer).Server.(*Server)
+	tabletAlias := topo.TabletAlias{Cell: "test", Uid: 1}
+	if err := ts.CreateTablet(&topo.Tablet{Alias: tabletAlias, Hostname: "localhost", Keyspace: "test_keyspace"}); err != nil {
+		t.Fatalf("CreateTablet: %v", err)
+	}
+	if err := ts.ValidateTabletActions(tabletAlias); err != nil {
+		t.Fatalf("ValidateTabletActions: %v", err)
+	}
+	now := time.Now()
+	var paths []string
+	for _, action := range []*actionnode.ActionNode{
+		{Action: actionnode.TABLET_ACTION_PING, ExpireTime: now.Add(-time.Hour).UnixNano()},
+		// unknown to the agent, but it still expires
+		{Action: "Unknown", ExpireTime: now.Add(-time.Hour).UnixNano()},
+		// within the clock skew
+		{Action: actionnode.TABLET_ACTION_PING, ExpireTime: now.Add(-time.Second).UnixNano()},
+		{Action: actionnode.TABLET_ACTION_PING, ExpireTime: now.Add(time.Hour).UnixNano()},
+		{Action: actionnode.TABLET_ACTION_PING},
+	} {
+		actionPath, err := ts.WriteTabletAction(tabletAlias, action.ToJson())
+		if err != nil {
+			t.Fatalf("WriteTabletAction: %v", err)
+		}
+		paths = append(paths, actionPath)
+	}
+
+	expiredBefore := actionsExpired.Get()
+	var dispatched []string
+	if _, err := zkts.handleActionQueue(tabletAlias, func(actionPath, data string) error {
+		dispatched = append(dispatched, actionPath)
+		return ts.UnblockTabletAction(actionPath)
+	}, actionnode.QueueDecoder{}, 1, &dispatchPacer{}, nil); err != nil {
+		t.Fatalf("handleActionQueue: %v", err)
+	}
+	if want := paths[2:]; !reflect.DeepEqual(dispatched, want) {
+		t.Errorf("want %v, got %v", want, dispatched)
+	}
+	if got := actionsExpired.Get() - expiredBefore; got != 2 {
+		t.Errorf("want 2 expired actions, got %v", got)
+	}
+
+	// the expired actions are out of the queue, with an Expired result
+	for _, actionPath := range paths[:2] {
+		if _, _, _, err := ts.ReadTabletActionPath(actionPath); err != topo.ErrNoNode {
+			t.Errorf("expired action %v is still queued: %v", actionPath, err)
+		}
+		actionLogPath := strings.Replace(actionPath, "/action/", "/actionlog/", 1)
+		_, data, _, err := ts.ReadTabletActionPath(actionLogPath)
+		if err != nil {
+			t.Fatalf("ReadTabletActionPath: %v", err)
+		}
+		actionNode := &actionnode.ActionNode{}
+		if err := json.NewDecoder(strings.NewReader(data)).Decode(actionNode); err != nil || actionNode.State != actionnode.ACTION_STATE_FAILED || actionNode.Result == nil || !actionNode.Result.Expired {
+			t.Errorf("want a failed action with an Expired result, got %v", data)
+		}
+	}
+}
+
+func TestVerifyActionQueue(t *testing.T) {
+	*actionQueueVerify = true
+	defer func() { *actionQueueVerify = false }()
+	ts := NewTestServer(t, []string{"test"})
+	zkts := ts.(TestServer).Server.(*Server)
+	tabletAlias := topo.TabletAlias{Cell: "test", Uid: 1}
+	if err := ts.CreateTablet(&topo.Tablet{Alias: tabletAlias, Hostname: "localhost", Keyspace: "test_keyspace"}); err != nil {
+		t.Fatalf("CreateTablet: %v", err)
+	}
+	if err := ts.ValidateTabletActions(tabletAlias); err != nil {
+		t.Fatalf("ValidateTabletActions: %v", err)
+	}
+	write := func(guid string) string {
+		actionPath, err := ts.WriteTabletAction(tabletAlias, (&actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING, ActionGuid: guid}).ToJson())
+		if err != nil {
+			t.Fatalf("WriteTabletAction: %v", err)
+		}
+		return actionPath
+	}
+
+	// vtaction completes an action, records it, but the agent dies
+	// before it's removed from the queue.
+	recordedPath := write("guid1")
+	if err := zkts.RecordCompletedAction(recordedPath, "guid1"); err != nil {
+		t.Fatalf("RecordCompletedAction: %v", err)
+	}
+	// It dies before recording it, the action is Done though.
+	donePath := write("guid2")
+	done := &actionnode.ActionNode{Action: actionnode.TABLET_ACTION_PING, ActionGuid: "guid2", State: actionnode.ACTION_STATE_DONE}
+	if err := ts.StoreTabletActionResponse(donePath, done.ToJson()); err != nil {
+		t.Fatalf("StoreTabletActionResponse: %v", err)
+	}
+	queuedPath := write("guid3")
+
+	if err := zkts.verifyActionQueue(tabletAlias, actionnode.QueueDecoder{}); err != nil {
+		t.Fatalf("verifyActionQueue: %v", err)
+	}
+	for _, actionPath := range []string{recordedPath, donePath} {
+		if _, _, _, err := ts.ReadTabletActionPath(actionPath); err != topo.ErrNoNode {
+			t.Errorf("completed action %v is still queued: %v", actionPath, err)
+		}
+	}
+	var dispatched []string
+	if _, err := zkts.handleActionQueue(tabletAlias, func(actionPath, data string) error {
+		dispatched = append(dispatched, actionPath)
+		return ts.UnblockTabletAction(actionPath)
+	}, actionnode.QueueDecoder{}, 1, &dispatchPacer{}, nil); err != nil {
+		t.Fatalf("handleActionQueue: %v", err)
+	}
+	if want := []string{queuedPath}; !reflect.DeepEqual(dispatched, want) {
+		t.Errorf("want %v, got %v", want, dispatched)
+	}
+
+	// Only the last maxCompletedActions guids are kept.
+	for i := 0; i < maxCompletedActions+5; i++ {
+		if err := zkts.RecordCompletedAction(queuedPath, fmt.Sprintf("guid%v", i)); err != nil {
+			t.Fatalf("RecordCompletedAction: %v", err)
+		}
+	}
+	data, _, err := zkts.zconn.Get(actionCompletedPathForAlias(tabletAlias))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	guids := strings.Fields(data)
+	if len(guids) != maxCompletedActions || guids[0] != "guid5" {
+		t.Errorf("want %v guids from guid5, got %v from %v", maxCompletedActions, len(guids), guids[0])
+	}
+}
+
+// aclConn records the ACLs nodes are created with.
+type aclConn struct {
+	zk.Conn
+	aclvs [][]zookeeper.ACL
+}
+
+func (conn *aclConn) Create(path, value string, flags int, aclv []zookeeper.ACL) (string, error) {
+	conn.aclvs = append(conn.aclvs, aclv)
+	return conn.Conn.Create(path, value, flags, aclv)
+}
+
+func (conn *aclConn) RetryChange(path string, flags int, aclv []zookeeper.ACL, changeFunc zk.ChangeFunc) error {
+	conn.aclvs = append(conn.aclvs, aclv)
+	return conn.Conn.RetryChange(path, flags, aclv, changeFunc)
+}
+
+func TestACL(t *testing.T) {
+	zconn := &aclConn{Conn: NewTestServer(t, []string{"test"}).(TestServer).Server.(*Server).zconn}
+	aclv := []zookeeper.ACL{{Perms: zookeeper.PERM_ALL, Scheme: "digest", Id: "vt:Z2hhc2g="}}
+	zkts := &Server{zconn: zconn, aclv: aclv}
+
+	tabletAlias := topo.TabletAlias{Cell: "test", Uid: 1}
+	if err := zkts.CreateTablet(&topo.Tablet{Alias: tabletAlias, Hostname: "localhost", Keyspace: "test_keyspace"}); err != nil {
+		t.Fatalf("CreateTablet: %v", err)
+	}
+	if _, err := zkts.WriteTabletAction(tabletAlias, pingAction("")); err != nil {
+		t.Fatalf("WriteTabletAction: %v", err)
+	}
+	if err := zkts.CancelTabletAction(tabletAlias, "guid"); err != nil {
+		t.Fatalf("CancelTabletAction: %v", err)
+	}
+	if len(zconn.aclvs) == 0 {
+		t.Fatalf("no node was created")
+	}
+	for _, got := range zconn.aclvs {
+		if !reflect.DeepEqual(got, aclv) {
+			t.Errorf("node created with acl %v, want %v", got, aclv)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zk
+
+import (
+	"errors"
+
+	"launchpad.net/gozk/zookeeper"
+)
+
+// ErrCancelled is returned by the cancellable calls below when done
+// is closed before zookeeper answers.
+var ErrCancelled = errors.New("zk: call cancelled")
+
+// The cancellable calls return as soon as done is closed, even if a
+// hung connection never answers, so the loops that use them can be
+// stopped. The call itself keeps running in the background, and its
+// result is dropped: a cancelled Create or RetryChange may still be
+// applied. A nil done is never closed.
+
+// callCancellable runs call, and returns ErrCancelled if done is closed
+// before it returns.
+func callCancellable(done <-chan struct{}, call func() error) error {
+	select {
+	case <-done:
+		return ErrCancelled
+	default:
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- call()
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-done:
+		return ErrCancelled
+	}
+}
+
+// The calls below only read the results of the call once it returned,
+// not when it's cancelled, since it may still be writing them.
+
+// GetCancellable is zconn.Get, cancelled when done is closed.
+func GetCancellable(zconn Conn, path string, done <-chan struct{}) (string, Stat, error) {
+	var data string
+	var stat Stat
+	err := callCancellable(done, func() (err error) {
+		data, stat, err = zconn.Get(path)
+		return err
+	})
+	if err == ErrCancelled {
+		return "", nil, err
+	}
+	return data, stat, err
+}
+
+// ChildrenWCancellable is zconn.ChildrenW, cancelled when done is closed.
+func ChildrenWCancellable(zconn Conn, path string, done <-chan struct{}) ([]string, Stat, <-chan zookeeper.Event, error) {
+	var children []string
+	var stat Stat
+	var watch <-chan zookeeper.Event
+	err := callCancellable(done, func() (err error) {
+		children, stat, watch, err = zconn.ChildrenW(path)
+		return err
+	})
+	if err == ErrCancelled {
+		return nil, nil, nil, err
+	}
+	return children, stat, watch, err
+}
+
+// CreateCancellable is zconn.Create, cancelled when done is closed.
+func CreateCancellable(zconn Conn, path, value string, flags int, aclv []zookeeper.ACL, done <-chan struct{}) (string, error) {
+	var pathCreated string
+	err := callCancellable(done, func() (err error) {
+		pathCreated, err = zconn.Create(path, value, flags, aclv)
+		return err
+	})
+	if err == ErrCancelled {
+		return "", err
+	}
+	return pathCreated, err
+}
+
+// RetryChangeCancellable is zconn.RetryChange, cancelled when done is closed.
+func RetryChangeCancellable(zconn Conn, path string, flags int, acl []zookeeper.ACL, changeFunc ChangeFunc, done <-chan struct{}) error {
+	return callCancellable(done, func() error {
+		return zconn.RetryChange(path, flags, acl, changeFunc)
+	})
+}
@@ -0,0 +1,39 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zk
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCallCancellable(t *testing.T) {
+	want := fmt.Errorf("zk error")
+	if err := callCancellable(nil, func() error { return want }); err != want {
+		t.Errorf("want %v, got %v", want, err)
+	}
+
+	// A call that hangs returns when done is closed.
+	done := make(chan struct{})
+	hung := make(chan struct{})
+	defer close(hung)
+	result := make(chan error)
+	go func() {
+		result <- callCancellable(done, func() error {
+			<-hung
+			return nil
+		})
+	}()
+	close(done)
+	if err := <-result; err != ErrCancelled {
+		t.Errorf("want ErrCancelled, got %v", err)
+	}
+
+	// A closed done cancels the call before it starts.
+	called := false
+	if err := callCancellable(done, func() error { called = true; return nil }); err != ErrCancelled || called {
+		t.Errorf("want ErrCancelled without a call, got %v, %v", err, called)
+	}
+}
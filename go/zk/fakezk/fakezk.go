@@ -202,9 +202,9 @@ func (conn *zconn) Create(zkPath, value string, flags int, aclv []zookeeper.ACL)
 
 	zxid := conn.getZxid()
 	name := rest[0]
-	if flags == zookeeper.SEQUENCE && name == "" {
+	if flags == zookeeper.SEQUENCE {
 		sequence := node.nextSequence()
-		name = sequence
+		name = name + sequence
 		zkPath = zkPath + sequence
 	}
 
@@ -313,7 +313,10 @@ func (conn *zconn) Delete(zkPath string, version int) (err error) {
 
 	for _, watch := range parent.childrenWatches {
 		watch <- childrenEvent
+		close(watch)
 	}
+	parent.childrenWatches = nil
+	parent.cversion++
 	return nil
 }
 
@@ -269,6 +269,18 @@ func (conn *MetaConn) String() string {
 	return conn.connCache.String()
 }
 
+// CanWatch returns false if zconn can't watch the nodes: zkocc
+// doesn't implement GetW, ChildrenW and ExistsW.
+func CanWatch(zconn Conn) bool {
+	switch zconn := zconn.(type) {
+	case *ZkoccConn:
+		return false
+	case *MetaConn:
+		return !zconn.connCache.useZkocc
+	}
+	return true
+}
+
 func NewMetaConn(useZkocc bool) *MetaConn {
 	return &MetaConn{NewConnCache(useZkocc)}
 }
@@ -0,0 +1,109 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zk
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"launchpad.net/gozk/zookeeper"
+)
+
+// RetryPolicy controls how RetryChangeWithPolicy retries a change
+// that conflicted with a concurrent one.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts before giving up,
+	// 0 for no limit.
+	MaxAttempts int
+	// MinBackoff is the delay before the first retry. It doubles
+	// with each retry, up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the delay that is random, from 0
+	// to 1, so the conflicting writers don't retry in lockstep.
+	Jitter float64
+}
+
+// DefaultRetryPolicy is a sensible policy for the callers of
+// RetryChangeWithPolicy. RetryChange itself retries forever.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 20,
+	MinBackoff:  10 * time.Millisecond,
+	MaxBackoff:  time.Second,
+	Jitter:      0.5,
+}
+
+// backoff returns the delay before the retry that follows attempt.
+func (policy RetryPolicy) backoff(attempt int) time.Duration {
+	delay := policy.MinBackoff
+	for i := 1; i < attempt && delay < policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	if policy.Jitter > 0 && delay > 0 {
+		delay -= time.Duration(policy.Jitter * rand.Float64() * float64(delay))
+	}
+	return delay
+}
+
+// RetriesExhaustedError is returned by RetryChangeWithPolicy when the
+// change still conflicted with concurrent ones after all its attempts.
+// The other errors are fatal, and returned as is.
+type RetriesExhaustedError struct {
+	Path     string
+	Attempts int
+	// Err is the conflict of the last attempt.
+	Err error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("zk: change of %v still conflicting after %v attempts: %v", e.Path, e.Attempts, e.Err)
+}
+
+// IsRetriesExhausted returns true if err is a RetriesExhaustedError:
+// the change can be tried again later.
+func IsRetriesExhausted(err error) bool {
+	_, ok := err.(*RetriesExhaustedError)
+	return ok
+}
+
+// RetryChangeWithPolicy is RetryChange, retrying the conflicts
+// according to policy: it reads the node, applies changeFunc, and
+// writes the result if it changed, until the write doesn't conflict
+// with a concurrent one. It's for the callers that can give up and
+// try again later, RetryChange never does.
+func RetryChangeWithPolicy(zconn Conn, path string, flags int, acl []zookeeper.ACL, changeFunc ChangeFunc, policy RetryPolicy) error {
+	for attempt := 1; ; attempt++ {
+		oldValue, oldStat, err := zconn.Get(path)
+		if err != nil && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+			return err
+		}
+		newValue, err := changeFunc(oldValue, oldStat)
+		if err != nil {
+			return err
+		}
+		if oldStat == nil {
+			_, err = zconn.Create(path, newValue, flags, acl)
+			if err == nil || !zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
+				return err
+			}
+		} else {
+			if newValue == oldValue {
+				return nil // Nothing to do.
+			}
+			_, err = zconn.Set(path, newValue, oldStat.Version())
+			if err == nil || !zookeeper.IsError(err, zookeeper.ZBADVERSION) && !zookeeper.IsError(err, zookeeper.ZNONODE) {
+				return err
+			}
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return &RetriesExhaustedError{Path: path, Attempts: attempt, Err: err}
+		}
+		time.Sleep(policy.backoff(attempt))
+	}
+}
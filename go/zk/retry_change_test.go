@@ -0,0 +1,72 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package zk
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"launchpad.net/gozk/zookeeper"
+)
+
+// conflictingConn is a Conn whose Sets conflict with a concurrent
+// change, conflicts times before they succeed.
+type conflictingConn struct {
+	TestZkConn
+	conflicts int
+	sets      int
+}
+
+func (conn *conflictingConn) Get(path string) (data string, stat Stat, err error) {
+	return "old", &ZkStat{}, nil
+}
+
+func (conn *conflictingConn) Set(path, value string, version int) (stat Stat, err error) {
+	conn.sets++
+	if conn.sets <= conn.conflicts {
+		return nil, &zookeeper.Error{Op: "set", Code: zookeeper.ZBADVERSION, Path: path}
+	}
+	return &ZkStat{}, nil
+}
+
+func TestRetryChangeWithPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond, Jitter: 0.5}
+	change := func(oldValue string, oldStat Stat) (string, error) {
+		return "new", nil
+	}
+
+	conn := &conflictingConn{conflicts: 2}
+	if err := RetryChangeWithPolicy(conn, "/zk/test", 0, nil, change, policy); err != nil {
+		t.Errorf("want nil, got %v", err)
+	}
+
+	conn = &conflictingConn{conflicts: 3}
+	err := RetryChangeWithPolicy(conn, "/zk/test", 0, nil, change, policy)
+	if !IsRetriesExhausted(err) {
+		t.Errorf("want a RetriesExhaustedError, got %v", err)
+	}
+	if conn.sets != 3 {
+		t.Errorf("want 3 attempts, got %v", conn.sets)
+	}
+
+	// The errors of changeFunc are fatal.
+	fatal := fmt.Errorf("fatal")
+	err = RetryChangeWithPolicy(conn, "/zk/test", 0, nil, func(string, Stat) (string, error) {
+		return "", fatal
+	}, policy)
+	if err != fatal {
+		t.Errorf("want %v, got %v", fatal, err)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for attempt, want := range []time.Duration{10, 20, 40, 50, 50} {
+		if got := policy.backoff(attempt + 1); got != want*time.Millisecond {
+			t.Errorf("backoff(%v): want %v, got %v", attempt+1, want*time.Millisecond, got)
+		}
+	}
+}
@@ -33,6 +33,42 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+var aclPerms = map[rune]uint32{
+	'r': zookeeper.PERM_READ,
+	'w': zookeeper.PERM_WRITE,
+	'd': zookeeper.PERM_DELETE,
+	'c': zookeeper.PERM_CREATE,
+	'a': zookeeper.PERM_ADMIN,
+}
+
+// ParseACL parses a comma-separated list of scheme:id:perms entries,
+// perms being some of the rwdca letters, as in
+// "world:anyone:r,digest:vt:Z2hhc2g=:rwdca". The id is everything
+// between the scheme and the perms, so it can contain colons.
+func ParseACL(value string) ([]zookeeper.ACL, error) {
+	var aclv []zookeeper.ACL
+	for _, entry := range strings.Split(value, ",") {
+		first := strings.Index(entry, ":")
+		last := strings.LastIndex(entry, ":")
+		if first <= 0 || last == first {
+			return nil, fmt.Errorf("zkutil: invalid acl entry %q, expected scheme:id:perms", entry)
+		}
+		acl := zookeeper.ACL{Scheme: entry[:first], Id: entry[first+1 : last]}
+		for _, c := range entry[last+1:] {
+			perm, ok := aclPerms[c]
+			if !ok {
+				return nil, fmt.Errorf("zkutil: invalid permission %q in acl entry %q", c, entry)
+			}
+			acl.Perms |= perm
+		}
+		if acl.Perms == 0 {
+			return nil, fmt.Errorf("zkutil: no permission in acl entry %q", entry)
+		}
+		aclv = append(aclv, acl)
+	}
+	return aclv, nil
+}
+
 // Create a path and any pieces required, think mkdir -p.
 // Intermediate znodes are always created empty.
 func CreateRecursive(zconn Conn, zkPath, value string, flags int, aclv []zookeeper.ACL) (pathCreated string, err error) {
@@ -54,9 +90,9 @@ func CreateRecursive(zconn Conn, zkPath, value string, flags int, aclv []zookeep
 
 func CreateOrUpdate(zconn Conn, zkPath, value string, flags int, aclv []zookeeper.ACL, recursive bool) (pathCreated string, err error) {
 	if recursive {
-		pathCreated, err = CreateRecursive(zconn, zkPath, value, 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+		pathCreated, err = CreateRecursive(zconn, zkPath, value, 0, aclv)
 	} else {
-		pathCreated, err = zconn.Create(zkPath, value, 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
+		pathCreated, err = zconn.Create(zkPath, value, 0, aclv)
 	}
 	if err != nil && zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
 		pathCreated = ""
@@ -364,17 +400,17 @@ trylock:
 }
 
 // Close done when you want to exit cleanly.
-func CreatePidNode(zconn Conn, zkPath string, contents string, done chan struct{}) error {
+func CreatePidNode(zconn Conn, zkPath string, contents string, aclv []zookeeper.ACL, done chan struct{}) error {
 	// On the first try, assume the cluster is up and running, that will
 	// help hunt down any config issues present at startup
-	if _, err := zconn.Create(zkPath, contents, zookeeper.EPHEMERAL, zookeeper.WorldACL(zookeeper.PERM_ALL)); err != nil {
+	if _, err := zconn.Create(zkPath, contents, zookeeper.EPHEMERAL, aclv); err != nil {
 		if zookeeper.IsError(err, zookeeper.ZNODEEXISTS) {
 			err = zconn.Delete(zkPath, -1)
 		}
 		if err != nil {
 			return fmt.Errorf("zkutil: failed deleting pid node: %v: %v", zkPath, err)
 		}
-		_, err = zconn.Create(zkPath, contents, zookeeper.EPHEMERAL, zookeeper.WorldACL(zookeeper.PERM_ALL))
+		_, err = zconn.Create(zkPath, contents, zookeeper.EPHEMERAL, aclv)
 		if err != nil {
 			return fmt.Errorf("zkutil: failed creating pid node: %v: %v", zkPath, err)
 		}
@@ -385,7 +421,14 @@ func CreatePidNode(zconn Conn, zkPath string, contents string, done chan struct{
 			_, _, watch, err := zconn.GetW(zkPath)
 			if err != nil {
 				if zookeeper.IsError(err, zookeeper.ZNONODE) {
-					_, err = zconn.Create(zkPath, contents, zookeeper.EPHEMERAL, zookeeper.WorldACL(zookeeper.PERM_ALL))
+					select {
+					case <-done:
+						// the node was removed on purpose
+						log.Infof("pid watcher stopped on done: %v", zkPath)
+						return
+					default:
+					}
+					_, err = zconn.Create(zkPath, contents, zookeeper.EPHEMERAL, aclv)
 					if err != nil {
 						log.Warningf("failed recreating pid node: %v: %v", zkPath, err)
 					} else {
@@ -262,3 +262,23 @@ func TestResolveWildcardsCell(t *testing.T) {
 	}, "", result, err)
 
 }
+
+func TestParseACL(t *testing.T) {
+	aclv, err := ParseACL("world:anyone:r,digest:vt:Z2hhc2g=:rwdca")
+	if err != nil {
+		t.Fatalf("ParseACL: %v", err)
+	}
+	want := []zookeeper.ACL{
+		{Perms: zookeeper.PERM_READ, Scheme: "world", Id: "anyone"},
+		{Perms: zookeeper.PERM_ALL, Scheme: "digest", Id: "vt:Z2hhc2g="},
+	}
+	if len(aclv) != len(want) || aclv[0] != want[0] || aclv[1] != want[1] {
+		t.Errorf("ParseACL = %v, want %v", aclv, want)
+	}
+
+	for _, value := range []string{"", "world", "world:anyone", ":anyone:r", "world:anyone:", "world:anyone:rx"} {
+		if _, err := ParseACL(value); err == nil {
+			t.Errorf("ParseACL(%q): want an error", value)
+		}
+	}
+}